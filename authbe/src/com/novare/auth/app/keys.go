@@ -0,0 +1,88 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"com/novare/auth/model"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+/*
+keysCommand - edgeauth keys [list|rotate] [companyID]
+list prints the signing keys, rotate activates the next key of the site
+(or of the company) and creates a new next key. A running edgeauth sends
+the KeyRotation event when it notices the change.
+*/
+func keysCommand(args []string) int {
+	cmd := "list"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	companyID := ""
+	if len(args) > 1 {
+		companyID = args[1]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	switch cmd {
+	case "list":
+		keys, err := model.ListSigningKeys(ctx)
+		if err != nil {
+			log.Printf("The signing keys could not be listed: [%s]", err)
+			return 1
+		}
+
+		for _, k := range keys {
+			owner := k.CompanyID
+			if owner == "" {
+				owner = "site"
+			}
+			fmt.Printf("%-24s %-5s v%-3d %-8s %s\n", owner, k.Algorithm, k.Version, k.Status, k.KeyID)
+		}
+		return 0
+
+	case "rotate":
+		rotations, err := model.Keys.Rotate(ctx, companyID)
+		if err != nil {
+			log.Printf("The signing keys could not be rotated: [%s]", err)
+			return 1
+		}
+
+		for _, r := range rotations {
+			fmt.Printf("%s key %s is active, %s is next\n", r.Algorithm, r.Active, r.Next)
+		}
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "usage: %s keys [list|rotate] [companyID]\n", os.Args[0])
+	return 2
+}
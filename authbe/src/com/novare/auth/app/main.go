@@ -25,14 +25,18 @@ package main
 
 import (
 	"com/novare/auth/controller"
+	"com/novare/auth/model"
 	"com/novare/auth/sse"
+	"com/novare/dbs"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 )
 
@@ -43,74 +47,152 @@ func main() {
 
 	http.DefaultClient.CloseIdleConnections()
 
+	log.Printf("Opening the database. AUTH_DB_TYPE selects the backend")
+	store, err := dbs.OpenStore(model.AuthRelayDatabaseName)
+	if err != nil {
+		log.Fatalf("The database could not be opened: [%s]", err)
+	}
+	defer store.Close()
+
+	log.Printf("Creating the indexes and unique constraints")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	err = store.EnsureIndexes(ctx)
+	cancel()
+	if err != nil {
+		log.Fatalf("The database indexes could not be created: [%s]", err)
+	}
+	model.SetStore(store)
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		code := migrateCommand(os.Args[2:])
+		store.Close()
+		os.Exit(code)
+	}
+
+	log.Printf("Applying the pending schema migrations")
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Minute)
+	_, err = model.Migrate(ctx)
+	cancel()
+	if err != nil {
+		log.Fatalf("The schema migrations failed: [%s]", err)
+	}
+
+	//The private signing keys are encrypted in the database with the base64
+	//encoded 32 byte AUTH_KEY_ENCRYPTION_KEY. The keys stored before it was
+	//set are encrypted now.
+	if kek := os.Getenv("AUTH_KEY_ENCRYPTION_KEY"); kek != "" {
+		err = model.SetKeyEncryptionKey(kek)
+		if err != nil {
+			log.Fatalf("The AUTH_KEY_ENCRYPTION_KEY is not valid: [%s]", err)
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+		count, err := model.EncryptSigningKeys(ctx)
+		cancel()
+		if err != nil {
+			log.Fatalf("The signing keys could not be encrypted: [%s]", err)
+		}
+		if count > 0 {
+			log.Printf("Encrypted %d signing keys", count)
+		}
+	} else {
+		log.Printf("*** WARNING *** AUTH_KEY_ENCRYPTION_KEY is not set, the private signing keys are stored unencrypted")
+	}
+
+	//The keys command needs the migrated keys
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		code := keysCommand(os.Args[2:])
+		store.Close()
+		os.Exit(code)
+	}
+
+	//The algorithm of the site signing key, RS256 unless AUTH_JWT_ALG says
+	//ES256
+	if alg := os.Getenv("AUTH_JWT_ALG"); alg != "" {
+		model.SigningAlgorithm = alg
+	}
+	//The HS256 tokens signed in the legacy format are accepted until
+	//AUTH_JWT_LEGACY is set to false
+	if legacy, err := strconv.ParseBool(os.Getenv("AUTH_JWT_LEGACY")); err == nil {
+		model.LegacyJWTCompatibility = legacy
+	}
+	//The iss of the tokens and how far off the clocks of the devices can be.
+	//The tokens issued with AUTHBEE before AUTH_ISSUER was set keep being
+	//accepted, AUTH_LEGACY_ISSUERS (comma separated) replaces that list and
+	//can be emptied once they expired.
+	if iss := os.Getenv("AUTH_ISSUER"); iss != "" {
+		model.TokenIssuer = iss
+		if iss != model.DefaultTokenIssuer {
+			model.LegacyIssuers = []string{model.DefaultTokenIssuer}
+		}
+	}
+	if legacy, ok := os.LookupEnv("AUTH_LEGACY_ISSUERS"); ok {
+		model.LegacyIssuers = nil
+		for _, iss := range strings.Split(legacy, ",") {
+			if iss = strings.TrimSpace(iss); iss != "" {
+				model.LegacyIssuers = append(model.LegacyIssuers, iss)
+			}
+		}
+	}
+	if !strings.HasPrefix(model.TokenIssuer, "https://") {
+		log.Printf("*** WARNING *** The issuer [%s] is not an https URL, the OpenID Connect libraries expect AUTH_ISSUER to be one", model.TokenIssuer)
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUTH_CLOCK_LEEWAY")); err == nil {
+		model.ClockLeeway = d
+	}
+	//The tokens whose claims are over the limits are not issued, 0 is no limit
+	if n, err := strconv.Atoi(os.Getenv("AUTH_MAX_ROLES_CLAIM")); err == nil {
+		model.TokenClaimLimits.MaxRoles = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUTH_MAX_SCOPE_CLAIM")); err == nil {
+		model.TokenClaimLimits.MaxScope = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUTH_MAX_TOKEN_SIZE")); err == nil {
+		model.TokenClaimLimits.MaxTokenSize = n
+	}
+	log.Printf("Preparing the %s site signing key", model.SigningAlgorithm)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	_, err = model.GetSigningKey(ctx, nil)
+	cancel()
+	if err != nil {
+		log.Fatalf("The site signing key is not available: [%s]", err)
+	}
+
 	log.Printf("Initializing the MessageBroker. Server Sent Events Publish/Subscribe")
 	sse.MessageBroker.Run()
 
+	//The signing keys rotate every AUTH_KEY_ROTATION (e.g. 720h) and the
+	//replaced keys verify for AUTH_KEY_OVERLAP
+	if d, err := time.ParseDuration(os.Getenv("AUTH_KEY_ROTATION")); err == nil {
+		model.Keys.RotationInterval = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUTH_KEY_OVERLAP")); err == nil {
+		model.Keys.Overlap = d
+	}
+	model.Keys.Subscribe(controller.PublishKeyRotation)
+	model.Keys.Run()
+	model.SubscribeRevocations(controller.PublishRevocation)
+
 	log.Printf("Initiating the Authorization Service")
 
-	mux := mux.NewRouter().StrictSlash(true)
-
-	//Create Company
-	mux.HandleFunc("/jwt/company", controller.CreateCompany).Methods("POST")
-
-	//Get Company
-	mux.HandleFunc("/jwt/company/{uniqueid}", controller.GetCompanyByUniqueID).Methods("GET")
-
-	//Login
-	mux.HandleFunc("/jwt/company/login", controller.Login).Methods("POST")
-	mux.HandleFunc("/jwt/company/machine_login", controller.LoginBySecret).Methods("POST")
-
-	//Logout
-	mux.HandleFunc("/jwt/company/logout", controller.Logout).Methods("POST")
-
-	//Remote Create Company
-	mux.HandleFunc("/company/remote", controller.CreateCompanyRemote).Methods("POST")
-
-	//These calls below require grants
-
-	//-------------------------------------------------------------------------
-	//Permissions
-	//-------------------------------------------------------------------------
-	mux.Handle("/jwt/permission", controller.CheckAuthorizedMW(http.HandlerFunc(controller.InsertPermission), "ADD_PERMISSION")).Methods("PUT")
-	mux.Handle("/jwt/permission/{permid}", controller.CheckAuthorizedMW(http.HandlerFunc(controller.UpdatePermission), "UPDATE_PERMISSION")).Methods("POST")
-	mux.Handle("/jwt/permission/{permid}", controller.CheckAuthorizedMW(http.HandlerFunc(controller.RemovePermission), "REMOVE_PERMISSION")).Methods("DELETE")
-	mux.Handle("/jwt/permission/{startat}/{endat}", controller.CheckAuthorizedMW(http.HandlerFunc(controller.ListPermissions), "GET_PERMISSION")).Methods("GET")
-
-	//-------------------------------------------------------------------------
-	//Roles
-	//-------------------------------------------------------------------------
-	mux.Handle("/jwt/role", controller.CheckAuthorizedMW(http.HandlerFunc(controller.InsertRole), "ADD_ROLE")).Methods("PUT")
-	mux.Handle("/jwt/role/{roleid}", controller.CheckAuthorizedMW(http.HandlerFunc(controller.UpdateRole), "UPDATE_ROLE")).Methods("POST")
-	mux.Handle("/jwt/role/{roleid}", controller.CheckAuthorizedMW(http.HandlerFunc(controller.RemoveRole), "REMOVE_ROLE")).Methods("DELETE")
-	mux.Handle("/jwt/role/{startat}/{endat}", controller.CheckAuthorizedMW(http.HandlerFunc(controller.ListRoles), "GET_ROLE")).Methods("GET")
-
-	//-------------------------------------------------------------------------
-	//Users
-	//-------------------------------------------------------------------------
-	mux.Handle("/jwt/user", controller.CheckAuthorizedMW(http.HandlerFunc(controller.InsertUser), "ADD_USER")).Methods("PUT")
-	mux.Handle("/jwt/user/{username}", controller.CheckAuthorizedMW(http.HandlerFunc(controller.UpdateUser), "UPDATE_USER")).Methods("POST")
-	mux.Handle("/jwt/user/{username}", controller.CheckAuthorizedMW(http.HandlerFunc(controller.RemoveUser), "REMOVE_USER")).Methods("DELETE")
-	mux.Handle("/jwt/users/{startat}/{endat}", controller.CheckAuthorizedMW(http.HandlerFunc(controller.ListUsers), "GET_USER")).Methods("GET")
-	mux.Handle("/jwt/password", controller.CheckAuthorizedMW(http.HandlerFunc(controller.UpdatePassword), "UPDATE_PASSWORD")).Methods("POST")
-
-	//-------------------------------------------------------------------------
-	//Company
-	//-------------------------------------------------------------------------
-	mux.Handle("/jwt/company/{uniqueid}", controller.CheckAuthorizedMW(http.HandlerFunc(controller.UpdateCompany), "UPDATE_COMPANY")).Methods("POST")
-	mux.Handle("/companies/{grouponwerid}", controller.CheckAuthorizedMW(http.HandlerFunc(controller.GetCompanyByGroupOwnerID), "LIST_GROUP")).Methods("GET")
-	mux.Handle("/company/registration/{companyid}", controller.CheckAuthorizedMW(http.HandlerFunc(controller.EnableRegistration), "ENABLE_REGISTATION")).Methods("POST")
-	//-------------------------------------------------------------------------
-	//Server Sent Events
-	//-------------------------------------------------------------------------
-	mux.Handle("/jwt/events", controller.CheckAuthorizedMW(http.HandlerFunc(controller.ServerSentEvents), "RECEIVE_EVENTS")).Methods("POST")
-
-	grantHandler := http.HandlerFunc(controller.GrantRequest)
-	mux.Handle("/jwt/grant/{ucid}", controller.AuthorizationRequest(grantHandler)).Methods("GET")
+	router := controller.NewRouter()
 
 	//--------------------------------------------------------------------------
-	//This is to handle CORs issues
+	//This is to handle CORs issues. The ETag is exposed so the UI can send
+	//it back in If-Match.
 	//--------------------------------------------------------------------------
-	handler := cors.AllowAll().Handler(mux)
+	handler := cors.New(cors.Options{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{
+			http.MethodHead,
+			http.MethodGet,
+			http.MethodPost,
+			http.MethodPut,
+			http.MethodPatch,
+			http.MethodDelete,
+		},
+		AllowedHeaders: []string{"*"},
+		ExposedHeaders: []string{"ETag"},
+	}).Handler(router)
 
 	cert := false
 	privKey := false
@@ -0,0 +1,86 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"com/novare/auth/model"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+/*
+migrateCommand - edgeauth migrate [list|apply]
+list prints every registered migration and whether it was applied, apply
+runs the pending ones. The store must already be selected.
+*/
+func migrateCommand(args []string) int {
+	cmd := "list"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	switch cmd {
+	case "list":
+		version, err := model.GetSchemaVersion(ctx)
+		if err != nil {
+			log.Printf("The schema version could not be read: [%s]", err)
+			return 1
+		}
+
+		status, err := model.ListMigrations(ctx)
+		if err != nil {
+			log.Printf("The migrations could not be listed: [%s]", err)
+			return 1
+		}
+
+		fmt.Printf("Schema version: %d\n", version)
+		for _, s := range status {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%4d  %-8s %s\n", s.Version, state, s.Description)
+		}
+		return 0
+
+	case "apply":
+		applied, err := model.Migrate(ctx)
+		if err != nil {
+			log.Printf("The migrations stopped after %d were applied: [%s]", applied, err)
+			return 1
+		}
+		fmt.Printf("%d migrations applied\n", applied)
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "usage: %s migrate [list|apply]\n", os.Args[0])
+	return 2
+}
@@ -0,0 +1,197 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Package authtest runs a complete edgeauth on httptest, backed by the
+in-memory store, so other services can write integration tests against
+the real routes without a MongoDB instance.
+*/
+package authtest
+
+import (
+	"bytes"
+	"com/novare/auth/controller"
+	"com/novare/auth/model"
+	"com/novare/auth/sse"
+	"com/novare/dbs"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+var brokerOnce sync.Once
+
+/*
+Server - An edgeauth listening on a local address. The model package
+keeps a single store, so only one Server should run at a time.
+*/
+type Server struct {
+	*httptest.Server
+	Store dbs.Store
+}
+
+//NewServer - Start an edgeauth with an empty in-memory store
+func NewServer() *Server {
+	return NewServerWithStore(dbs.NewMemoryStore())
+}
+
+//NewServerWithStore - Start an edgeauth on top of the given store. The
+//indexes and the migrations are applied the same way the application
+//does at startup.
+func NewServerWithStore(store dbs.Store) *Server {
+	err := store.EnsureIndexes(context.Background())
+	if err != nil {
+		log.Printf("The indexes could not be created: [%s]", err)
+	}
+	model.SetStore(store)
+
+	_, err = model.Migrate(context.Background())
+	if err != nil {
+		log.Printf("The migrations could not be applied: [%s]", err)
+	}
+
+	//Publishing an event blocks until the broker picks it up
+	brokerOnce.Do(sse.MessageBroker.Run)
+
+	srv := new(Server)
+	srv.Store = store
+	srv.Server = httptest.NewServer(controller.NewRouter())
+	return srv
+}
+
+//Close - Stop the server and release the store
+func (srv *Server) Close() {
+	srv.Server.Close()
+	srv.Store.Close()
+}
+
+//Do - Send a JSON request to the server. The body is marshalled when it
+//is not nil, and the token is sent as the bearer when it is not empty.
+func (srv *Server) Do(method string, path string, token string, body interface{}) (*http.Response, error) {
+	var buf bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&buf).Encode(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(method, srv.URL+path, &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return srv.Client().Do(req)
+}
+
+/*
+StatusError - Returned by DoJSON when the server did not reply with 200.
+Code is the machine readable code sent in the body, e.g.
+"InvalidCredentials", empty when the body had none.
+*/
+type StatusError struct {
+	StatusCode int
+	Code       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("UnexpectedStatus %d %s", e.StatusCode, e.Code)
+}
+
+//DoJSON - Same as Do but decodes the JSON response into rsp
+func (srv *Server) DoJSON(method string, path string, token string, body interface{}, rsp interface{}) error {
+	r, err := srv.Do(method, path, token, body)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		var body struct {
+			Code string `json:"code"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		return &StatusError{StatusCode: r.StatusCode, Code: body.Code}
+	}
+
+	return json.NewDecoder(r.Body).Decode(rsp)
+}
+
+type statusResp struct {
+	Status       string `json:"status"`
+	CompanyID    string `json:"companyID"`
+	SessionToken string `json:"sessionToken"`
+}
+
+//CreateCompany - Create a company and its superuser, returns the company ID
+func (srv *Server) CreateCompany(uniqueID string, password string) (string, error) {
+	req := map[string]string{
+		"name":            uniqueID,
+		"uniqueID":        uniqueID,
+		"password":        password,
+		"confirmPassword": password,
+	}
+
+	var rsp statusResp
+	err := srv.DoJSON("POST", "/jwt/company", "", req, &rsp)
+	if err != nil {
+		return "", err
+	}
+
+	if rsp.Status != controller.StatusSuccess {
+		return "", errors.New(rsp.Status)
+	}
+
+	return rsp.CompanyID, nil
+}
+
+//Login - Log in and return the session token
+func (srv *Server) Login(uniqueID string, username string, password string) (string, error) {
+	req := map[string]string{
+		"uniqueID": uniqueID,
+		"username": username,
+		"password": password,
+	}
+
+	var rsp statusResp
+	err := srv.DoJSON("POST", "/jwt/company/login", "", req, &rsp)
+	if err != nil {
+		return "", err
+	}
+
+	if rsp.Status != controller.StatusSuccess {
+		return "", errors.New(rsp.Status)
+	}
+
+	return rsp.SessionToken, nil
+}
@@ -0,0 +1,82 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package authtest
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestServerRoundTrip(t *testing.T) {
+
+	srv := NewServer()
+	defer srv.Close()
+
+	companyID, err := srv.CreateCompany("AUTHTESTCOMPANY", "@123ABC789")
+	if err != nil {
+		t.Errorf("The company was not created: [%s]", err)
+		return
+	}
+
+	var statusErr *StatusError
+	_, err = srv.CreateCompany("AUTHTESTCOMPANY", "@123ABC789")
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusConflict || statusErr.Code != "Duplicate" {
+		t.Errorf("The second company should have been a duplicate: [%v]", err)
+	}
+
+	_, err = srv.Login("AUTHTESTCOMPANY", "superuser", "WRONGPASSWORD")
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized || statusErr.Code != "InvalidCredentials" {
+		t.Errorf("The login should have failed with the wrong password: [%v]", err)
+	}
+
+	token, err := srv.Login("AUTHTESTCOMPANY", "superuser", "@123ABC789")
+	if err != nil {
+		t.Errorf("The login failed: [%s]", err)
+		return
+	}
+
+	perm := map[string]string{"description": "TEST", "permission": "TEST_PERMISSION"}
+	var rsp struct {
+		Status string `json:"status"`
+		ID     string `json:"id"`
+	}
+	err = srv.DoJSON("PUT", "/jwt/permission", token, perm, &rsp)
+	if err != nil || rsp.Status != "Success" {
+		t.Errorf("The permission was not inserted: [%v] [%s]", err, rsp.Status)
+		return
+	}
+
+	r, err := srv.Do("PUT", "/jwt/permission", "", perm)
+	if err != nil {
+		t.Errorf("The request failed: [%s]", err)
+		return
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusUnauthorized {
+		t.Errorf("A request without a token should not be authorized: [%d]", r.StatusCode)
+	}
+
+	t.Logf("Company [%s] and permission [%s] created", companyID, rsp.ID)
+}
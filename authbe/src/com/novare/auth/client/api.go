@@ -0,0 +1,687 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client
+
+import (
+	"com/novare/auth/model"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+//ifMatch - The revision the update applies to, none when it is 0
+func ifMatch(revision int64) http.Header {
+	if revision <= 0 {
+		return nil
+	}
+	return http.Header{"If-Match": []string{fmt.Sprintf(`"%d"`, revision)}}
+}
+
+func pathRange(prefix string, startAt int64, endAt int64) string {
+	return prefix + "/" + strconv.FormatInt(startAt, 10) + "/" + strconv.FormatInt(endAt, 10)
+}
+
+/*
+ListOptions - The order and the name filter of a list, e.g. SortBy "name"
+and NamePrefix "Jo"
+*/
+type ListOptions struct {
+	SortBy     string
+	Descending bool
+	NamePrefix string
+}
+
+func (opts ListOptions) values() url.Values {
+	values := url.Values{}
+	if len(opts.SortBy) > 0 {
+		values.Set("sort", opts.SortBy)
+	}
+	if opts.Descending {
+		values.Set("order", "desc")
+	}
+	if len(opts.NamePrefix) > 0 {
+		values.Set("name", opts.NamePrefix)
+	}
+	return values
+}
+
+//UserListOptions - The user lists can also be filtered by username, by
+//users or things and by status
+type UserListOptions struct {
+	ListOptions
+	Username string
+	IsThing  *bool
+	Status   string
+}
+
+func (opts UserListOptions) values() url.Values {
+	values := opts.ListOptions.values()
+	if len(opts.Username) > 0 {
+		values.Set("username", opts.Username)
+	}
+	if opts.IsThing != nil {
+		values.Set("isThing", strconv.FormatBool(*opts.IsThing))
+	}
+	if len(opts.Status) > 0 {
+		values.Set("status", opts.Status)
+	}
+	return values
+}
+
+//Companies
+
+//CreateCompany - Create a company and its superuser, returns the company ID
+func (c *Client) CreateCompany(ctx context.Context, company Company) (string, error) {
+	var rsp struct {
+		CompanyID string `json:"companyID"`
+	}
+	err := c.do(ctx, &request{method: "POST", path: "/jwt/company", body: company}, &rsp)
+	return rsp.CompanyID, err
+}
+
+//GetCompany - The company with the uniqueID
+func (c *Client) GetCompany(ctx context.Context, uniqueID string) (*CompanyInfo, error) {
+	var rsp CompanyInfo
+	err := c.do(ctx, &request{method: "GET", path: "/jwt/company/" + url.PathEscape(uniqueID)}, &rsp)
+	if err != nil {
+		return nil, err
+	}
+	return &rsp, nil
+}
+
+//UpdateCompany - Update the company, the revision goes in If-Match when it
+//is not 0. The new revision is returned.
+func (c *Client) UpdateCompany(ctx context.Context, company Company, revision int64) (int64, error) {
+	company.Password = ""
+	company.ConfirmPassword = ""
+
+	var rsp struct {
+		Revision int64 `json:"revision"`
+	}
+	req := request{method: "POST", path: "/jwt/company/" + url.PathEscape(company.UniqueID), header: ifMatch(revision), body: company, auth: true}
+	err := c.do(ctx, &req, &rsp)
+	return rsp.Revision, err
+}
+
+//CompaniesByGroupOwner - The companies of the group
+func (c *Client) CompaniesByGroupOwner(ctx context.Context, groupOwnerID string) ([]CompanyInfo, error) {
+	var rsp struct {
+		Companies []CompanyInfo `json:"companies"`
+	}
+	err := c.do(ctx, &request{method: "GET", path: "/companies/" + url.PathEscape(groupOwnerID), auth: true}, &rsp)
+	return rsp.Companies, err
+}
+
+//EnableRegistration - A new registration code for the company
+func (c *Client) EnableRegistration(ctx context.Context, companyID string) (string, error) {
+	var rsp struct {
+		RegisCode string `json:"regisCode"`
+	}
+	err := c.do(ctx, &request{method: "POST", path: "/company/registration/" + url.PathEscape(companyID), auth: true}, &rsp)
+	return rsp.RegisCode, err
+}
+
+func remoteQuery(groupOwnerID string, apiKey string) url.Values {
+	return url.Values{"group": []string{groupOwnerID}, "apikey": []string{apiKey}}
+}
+
+//CreateRemoteCompany - Create a company of the group with its API key,
+//returns the company ID
+func (c *Client) CreateRemoteCompany(ctx context.Context, groupOwnerID string, apiKey string, company Company) (string, error) {
+	var rsp struct {
+		CompanyID string `json:"companyID"`
+	}
+	req := request{method: "POST", path: "/company/remote", query: remoteQuery(groupOwnerID, apiKey), body: company}
+	err := c.do(ctx, &req, &rsp)
+	return rsp.CompanyID, err
+}
+
+//RemoveRemoteCompany - Remove a company of the group with its API key
+func (c *Client) RemoveRemoteCompany(ctx context.Context, groupOwnerID string, apiKey string, company Company) error {
+	req := request{method: "DELETE", path: "/company/remote", query: remoteQuery(groupOwnerID, apiKey), body: company}
+	return c.do(ctx, &req, nil)
+}
+
+//Sessions
+
+func (c *Client) login(ctx context.Context, path string, body interface{}) (*LoginResponse, error) {
+	var rsp LoginResponse
+	err := c.do(ctx, &request{method: "POST", path: path, body: body}, &rsp)
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.Status == StatusSuccess {
+		c.SetTokens(rsp.SessionToken, rsp.RefreshToken, rsp.ExpiresIn)
+	}
+	return &rsp, nil
+}
+
+/*
+Login - Start a session for the user. The Client uses it from now on.
+A user that must reset the password gets the StatusPasswordReset status
+and no session.
+*/
+func (c *Client) Login(ctx context.Context, req LoginRequest) (*LoginResponse, error) {
+	return c.login(ctx, "/jwt/company/login", req)
+}
+
+//MachineLogin - Start a session for a thing with its secret
+func (c *Client) MachineLogin(ctx context.Context, req MachineLoginRequest) (*LoginResponse, error) {
+	return c.login(ctx, "/jwt/company/machine_login", req)
+}
+
+//Refresh - Replace the session token now. The Client also does it on its
+//own before the token expires.
+func (c *Client) Refresh(ctx context.Context) error {
+	return c.refresh(ctx, c.SessionToken())
+}
+
+//Logout - End the session, the tokens are forgotten even when the
+//edgeauth could not be told
+func (c *Client) Logout(ctx context.Context) error {
+	token := c.SessionToken()
+	if len(token) == 0 {
+		return ErrNotLoggedIn
+	}
+
+	r, err := c.send(ctx, &request{method: "POST", path: "/jwt/company/logout"}, nil, "application/json", token)
+	c.SetTokens("", "", 0)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	return decode(r, nil)
+}
+
+//JWKS - The public keys the tokens are signed with
+func (c *Client) JWKS(ctx context.Context) (*model.JSONWebKeySet, error) {
+	var rsp model.JSONWebKeySet
+	err := c.do(ctx, &request{method: "GET", path: "/.well-known/jwks.json"}, &rsp)
+	if err != nil {
+		return nil, err
+	}
+	return &rsp, nil
+}
+
+/*
+Grant - An access token for the permission. The audience is the service
+that will receive it, empty for the company itself. uniqueID is the
+company the session belongs to.
+*/
+func (c *Client) Grant(ctx context.Context, uniqueID string, permission string, audience string) (string, error) {
+	var query url.Values
+	if len(audience) > 0 {
+		query = url.Values{"aud": []string{audience}}
+	}
+
+	var rsp struct {
+		AccessToken string `json:"accessToken"`
+	}
+	req := request{
+		method: "GET",
+		path:   "/jwt/grant/" + url.PathEscape(uniqueID),
+		query:  query,
+		header: http.Header{"Grant-Request": []string{permission}},
+		auth:   true,
+	}
+	err := c.do(ctx, &req, &rsp)
+	return rsp.AccessToken, err
+}
+
+func (c *Client) listSessions(ctx context.Context, path string, query url.Values) ([]Session, error) {
+	var rsp struct {
+		Sessions []Session `json:"sessions"`
+	}
+	err := c.do(ctx, &request{method: "GET", path: path, query: query, auth: true}, &rsp)
+	return rsp.Sessions, err
+}
+
+func (c *Client) terminateSessions(ctx context.Context, path string, query url.Values) (int, error) {
+	var rsp struct {
+		Terminated int `json:"terminated"`
+	}
+	err := c.do(ctx, &request{method: "DELETE", path: path, query: query, auth: true}, &rsp)
+	return rsp.Terminated, err
+}
+
+func userQuery(userID string) url.Values {
+	if len(userID) == 0 {
+		return nil
+	}
+	return url.Values{"userid": []string{userID}}
+}
+
+//ListSessions - The sessions of the user or, with an empty userID, of the
+//whole company
+func (c *Client) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	return c.listSessions(ctx, "/jwt/sessions", userQuery(userID))
+}
+
+//TerminateSessions - End the sessions of the user or, with an empty
+//userID, of the whole company. Returns how many were ended.
+func (c *Client) TerminateSessions(ctx context.Context, userID string) (int, error) {
+	return c.terminateSessions(ctx, "/jwt/sessions", userQuery(userID))
+}
+
+//ListOwnSessions - The sessions of the logged in user
+func (c *Client) ListOwnSessions(ctx context.Context) ([]Session, error) {
+	return c.listSessions(ctx, "/jwt/sessions/self", nil)
+}
+
+//TerminateOwnSessions - End the other sessions of the logged in user
+func (c *Client) TerminateOwnSessions(ctx context.Context) (int, error) {
+	return c.terminateSessions(ctx, "/jwt/sessions/self", nil)
+}
+
+//TerminateSession - End one session
+func (c *Client) TerminateSession(ctx context.Context, sessionID string) error {
+	_, err := c.terminateSessions(ctx, "/jwt/session/"+url.PathEscape(sessionID), nil)
+	return err
+}
+
+//Permissions
+
+func (c *Client) putPermission(ctx context.Context, req *request) (*Permission, error) {
+	var rsp struct {
+		ID       string `json:"id"`
+		Revision int64  `json:"revision"`
+	}
+	err := c.do(ctx, req, &rsp)
+	if err != nil {
+		return nil, err
+	}
+
+	perm := req.body.(Permission)
+	perm.ID = rsp.ID
+	perm.Revision = rsp.Revision
+	return &perm, nil
+}
+
+//InsertPermission - Add a permission to the company
+func (c *Client) InsertPermission(ctx context.Context, perm Permission) (*Permission, error) {
+	return c.putPermission(ctx, &request{method: "PUT", path: "/jwt/permission", body: perm, auth: true})
+}
+
+//UpdatePermission - Update the permission with perm.ID
+func (c *Client) UpdatePermission(ctx context.Context, perm Permission) (*Permission, error) {
+	path := "/jwt/permission/" + url.PathEscape(perm.ID)
+	return c.putPermission(ctx, &request{method: "POST", path: path, header: ifMatch(perm.Revision), body: perm, auth: true})
+}
+
+//RemovePermission - Remove the permission
+func (c *Client) RemovePermission(ctx context.Context, permID string) error {
+	return c.do(ctx, &request{method: "DELETE", path: "/jwt/permission/" + url.PathEscape(permID), auth: true}, nil)
+}
+
+//ListPermissions - The permissions from startAt up to endAt
+func (c *Client) ListPermissions(ctx context.Context, startAt int64, endAt int64, opts ListOptions) (*PermissionList, error) {
+	var rsp PermissionList
+	req := request{method: "GET", path: pathRange("/jwt/permission", startAt, endAt), query: opts.values(), auth: true}
+	err := c.do(ctx, &req, &rsp)
+	if err != nil {
+		return nil, err
+	}
+	return &rsp, nil
+}
+
+//Roles
+
+func (c *Client) putRole(ctx context.Context, req *request) (*Role, error) {
+	var rsp struct {
+		Role Role `json:"role"`
+	}
+	err := c.do(ctx, req, &rsp)
+	if err != nil {
+		return nil, err
+	}
+	return &rsp.Role, nil
+}
+
+//InsertRole - Add a role to the company
+func (c *Client) InsertRole(ctx context.Context, role Role) (*Role, error) {
+	return c.putRole(ctx, &request{method: "PUT", path: "/jwt/role", body: role, auth: true})
+}
+
+//UpdateRole - Update the role with role.ID
+func (c *Client) UpdateRole(ctx context.Context, role Role) (*Role, error) {
+	path := "/jwt/role/" + url.PathEscape(role.ID)
+	return c.putRole(ctx, &request{method: "POST", path: path, header: ifMatch(role.Revision), body: role, auth: true})
+}
+
+//RemoveRole - Remove the role
+func (c *Client) RemoveRole(ctx context.Context, roleID string) error {
+	return c.do(ctx, &request{method: "DELETE", path: "/jwt/role/" + url.PathEscape(roleID), auth: true}, nil)
+}
+
+//ListRoles - The roles from startAt up to endAt
+func (c *Client) ListRoles(ctx context.Context, startAt int64, endAt int64, opts ListOptions) (*RoleList, error) {
+	var rsp RoleList
+	req := request{method: "GET", path: pathRange("/jwt/role", startAt, endAt), query: opts.values(), auth: true}
+	err := c.do(ctx, &req, &rsp)
+	if err != nil {
+		return nil, err
+	}
+	return &rsp, nil
+}
+
+//Users
+
+func (c *Client) putUser(ctx context.Context, req *request) (*User, error) {
+	var rsp struct {
+		User User `json:"user"`
+	}
+	err := c.do(ctx, req, &rsp)
+	if err != nil {
+		return nil, err
+	}
+	return &rsp.User, nil
+}
+
+//InsertUser - Add a user or a thing to the company
+func (c *Client) InsertUser(ctx context.Context, user User) (*User, error) {
+	return c.putUser(ctx, &request{method: "PUT", path: "/jwt/user", body: user, auth: true})
+}
+
+//UpdateUser - Update the user with user.Username
+func (c *Client) UpdateUser(ctx context.Context, user User) (*User, error) {
+	path := "/jwt/user/" + url.PathEscape(user.Username)
+	return c.putUser(ctx, &request{method: "POST", path: path, header: ifMatch(user.Revision), body: user, auth: true})
+}
+
+//RemoveUser - Remove the user
+func (c *Client) RemoveUser(ctx context.Context, username string) error {
+	return c.do(ctx, &request{method: "DELETE", path: "/jwt/user/" + url.PathEscape(username), auth: true}, nil)
+}
+
+//ListUsers - The users from startAt up to endAt
+func (c *Client) ListUsers(ctx context.Context, startAt int64, endAt int64, opts UserListOptions) (*UserList, error) {
+	var rsp UserList
+	req := request{method: "GET", path: pathRange("/jwt/users", startAt, endAt), query: opts.values(), auth: true}
+	err := c.do(ctx, &req, &rsp)
+	if err != nil {
+		return nil, err
+	}
+	return &rsp, nil
+}
+
+//UpdatePassword - Change the password, returns the status, e.g.
+//StatusPasswordMismatch
+func (c *Client) UpdatePassword(ctx context.Context, change PasswordChange) (string, error) {
+	var rsp struct {
+		Status string `json:"status"`
+	}
+	err := c.do(ctx, &request{method: "POST", path: "/jwt/password", body: change, auth: true}, &rsp)
+	return rsp.Status, err
+}
+
+//Tokens
+
+//Introspect - What the edgeauth knows about the token
+func (c *Client) Introspect(ctx context.Context, token string) (*Introspection, error) {
+	var rsp Introspection
+	req := request{method: "POST", path: "/oauth/introspect", form: url.Values{"token": []string{token}}, auth: true}
+	err := c.do(ctx, &req, &rsp)
+	if err != nil {
+		return nil, err
+	}
+	return &rsp, nil
+}
+
+//Revoke - Revoke a session, access or refresh token
+func (c *Client) Revoke(ctx context.Context, token string) error {
+	req := request{method: "POST", path: "/oauth/revoke", form: url.Values{"token": []string{token}}, auth: true}
+	return c.do(ctx, &req, nil)
+}
+
+/*
+token - POST the grant to the token endpoint. The client authenticates
+with HTTP Basic, a public client (no secret) only sends its client_id.
+The Code of the StatusError is the RFC 6749 error, e.g. invalid_client.
+*/
+func (c *Client) token(ctx context.Context, clientID string, secret string, form url.Values) (*TokenResponse, error) {
+	header := http.Header{}
+	if len(secret) > 0 {
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(url.QueryEscape(clientID)+":"+url.QueryEscape(secret))))
+	} else {
+		form.Set("client_id", clientID)
+	}
+
+	r, err := c.open(ctx, &request{method: "POST", path: "/oauth/token", header: header, form: form})
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	var rsp struct {
+		TokenResponse
+		Error string `json:"error"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&rsp)
+	if r.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: r.StatusCode, Code: rsp.Error}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rsp.TokenResponse, nil
+}
+
+//ClientCredentials - An access token for the OAuth client. scope is space
+//separated, empty for every scope of the client, and audience is the
+//company UniqueID when empty.
+func (c *Client) ClientCredentials(ctx context.Context, clientID string, secret string, scope string, audience string) (*TokenResponse, error) {
+	form := url.Values{"grant_type": []string{"client_credentials"}}
+	if len(scope) > 0 {
+		form.Set("scope", scope)
+	}
+	if len(audience) > 0 {
+		form.Set("audience", audience)
+	}
+	return c.token(ctx, clientID, secret, form)
+}
+
+//NewPKCE - A random code verifier and its S256 code challenge, the
+//challenge goes in the AuthorizeURL and the verifier to ExchangeCode
+func NewPKCE() (string, string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", "", err
+	}
+	verifier := base64.RawURLEncoding.EncodeToString(buf)
+	return verifier, model.S256Challenge(verifier), nil
+}
+
+/*
+AuthorizeURL - The login page the app opens in the browser, it comes back
+to the redirectURI with the code and the state. The nonce, empty for
+none, is copied to the ID token of the openid scope.
+*/
+func (c *Client) AuthorizeURL(clientID string, redirectURI string, scope string, state string, nonce string, challenge string) string {
+	query := url.Values{
+		"response_type":         []string{"code"},
+		"client_id":             []string{clientID},
+		"redirect_uri":          []string{redirectURI},
+		"state":                 []string{state},
+		"code_challenge":        []string{challenge},
+		"code_challenge_method": []string{model.CodeChallengeS256},
+	}
+	if len(scope) > 0 {
+		query.Set("scope", scope)
+	}
+	if len(nonce) > 0 {
+		query.Set("nonce", nonce)
+	}
+	return c.BaseURL + "/oauth/authorize?" + query.Encode()
+}
+
+/*
+ExchangeCode - Exchange the code of the redirect for a session limited to
+the scope granted; the Client is logged in with it and refreshes it like
+the session of Login. secret is empty for a public client.
+*/
+func (c *Client) ExchangeCode(ctx context.Context, clientID string, secret string, code string, redirectURI string, verifier string) (*TokenResponse, error) {
+	form := url.Values{
+		"grant_type":    []string{"authorization_code"},
+		"code":          []string{code},
+		"redirect_uri":  []string{redirectURI},
+		"code_verifier": []string{verifier},
+	}
+
+	rsp, err := c.token(ctx, clientID, secret, form)
+	if err != nil {
+		return nil, err
+	}
+
+	c.SetTokens(rsp.AccessToken, rsp.RefreshToken, rsp.ExpiresIn)
+	return rsp, nil
+}
+
+//OpenIDConfiguration - The OpenID Connect discovery document
+func (c *Client) OpenIDConfiguration(ctx context.Context) (*OpenIDConfiguration, error) {
+	var rsp OpenIDConfiguration
+	err := c.do(ctx, &request{method: "GET", path: "/.well-known/openid-configuration"}, &rsp)
+	if err != nil {
+		return nil, err
+	}
+	return &rsp, nil
+}
+
+//UserInfo - The claims of the user of the session, the profile ones need
+//the profile scope when the session came from ExchangeCode
+func (c *Client) UserInfo(ctx context.Context) (*UserInfo, error) {
+	var rsp UserInfo
+	err := c.do(ctx, &request{method: "GET", path: "/userinfo", auth: true}, &rsp)
+	if err != nil {
+		return nil, err
+	}
+	return &rsp, nil
+}
+
+func (c *Client) putClient(ctx context.Context, req *request) (*OAuthClient, string, error) {
+	var rsp struct {
+		Client       OAuthClient `json:"client"`
+		ClientSecret string      `json:"clientSecret"`
+	}
+	err := c.do(ctx, req, &rsp)
+	if err != nil {
+		return nil, "", err
+	}
+	return &rsp.Client, rsp.ClientSecret, nil
+}
+
+//InsertClient - Register an OAuth client for a thing, the secret is
+//returned only once
+func (c *Client) InsertClient(ctx context.Context, client OAuthClient) (*OAuthClient, string, error) {
+	return c.putClient(ctx, &request{method: "PUT", path: "/oauth/client", body: client, auth: true})
+}
+
+//UpdateClient - Change the name, scopes and token duration of the client
+func (c *Client) UpdateClient(ctx context.Context, client OAuthClient) (*OAuthClient, error) {
+	path := "/oauth/client/" + url.PathEscape(client.ID)
+	updated, _, err := c.putClient(ctx, &request{method: "POST", path: path, header: ifMatch(client.Revision), body: client, auth: true})
+	return updated, err
+}
+
+//RotateClientSecret - A new secret for the client, the old one stops
+//working
+func (c *Client) RotateClientSecret(ctx context.Context, clientID string) (string, error) {
+	path := "/oauth/client/" + url.PathEscape(clientID) + "/secret"
+	_, secret, err := c.putClient(ctx, &request{method: "POST", path: path, auth: true})
+	return secret, err
+}
+
+//RemoveClient - Remove the OAuth client
+func (c *Client) RemoveClient(ctx context.Context, clientID string) error {
+	return c.do(ctx, &request{method: "DELETE", path: "/oauth/client/" + url.PathEscape(clientID), auth: true}, nil)
+}
+
+//ListClients - The OAuth clients of the company
+func (c *Client) ListClients(ctx context.Context) ([]OAuthClient, error) {
+	var rsp struct {
+		Clients []OAuthClient `json:"clients"`
+	}
+	err := c.do(ctx, &request{method: "GET", path: "/oauth/clients", auth: true}, &rsp)
+	if err != nil {
+		return nil, err
+	}
+	return rsp.Clients, nil
+}
+
+//Revocations - The tokens revoked since the unix time, 0 for all of them
+func (c *Client) Revocations(ctx context.Context, since int64) (*RevocationList, error) {
+	var query url.Values
+	if since > 0 {
+		query = url.Values{"since": []string{strconv.FormatInt(since, 10)}}
+	}
+
+	var rsp RevocationList
+	err := c.do(ctx, &request{method: "GET", path: "/oauth/revocations", query: query, auth: true}, &rsp)
+	if err != nil {
+		return nil, err
+	}
+	return &rsp, nil
+}
+
+/*
+Events - Listen to the events until the ctx is done or fn returns an
+error, which is then returned. The stream ends with the ctx error.
+*/
+func (c *Client) Events(ctx context.Context, eventIDs []string, fn func(Event) error) error {
+	body := map[string][]string{"events": eventIDs}
+	r, err := c.open(ctx, &request{method: "POST", path: "/jwt/events", body: body, auth: true})
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return decode(r, nil)
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	for {
+		var event Event
+		err = decoder.Decode(&event)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		err = fn(event)
+		if err != nil {
+			return err
+		}
+	}
+}
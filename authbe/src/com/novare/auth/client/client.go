@@ -0,0 +1,302 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Package client is the Go SDK of the edgeauth. A Client logs in, keeps its
+session token fresh with the refresh token and has a method for each
+route. A Verifier checks the tokens offline with the JWKS and keeps the
+grant decisions, so a site goes on authorizing while the edgeauth is
+briefly unreachable.
+*/
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//DefaultRefreshMargin - How long before it expires the session token is
+//refreshed
+const DefaultRefreshMargin = 30 * time.Second
+
+//ErrNotLoggedIn - The route needs a session and the Client has none
+var ErrNotLoggedIn = errors.New("NotLoggedIn")
+
+/*
+StatusError - The edgeauth did not accept the request. Code is the machine
+readable code sent in the body, e.g. "InvalidCredentials", empty when the
+body had none.
+*/
+type StatusError struct {
+	StatusCode int
+	Code       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("UnexpectedStatus %d %s", e.StatusCode, e.Code)
+}
+
+//IsStatusCode - The err is a StatusError with the HTTP status
+func IsStatusCode(err error, statusCode int) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == statusCode
+}
+
+/*
+Client - Talks to one edgeauth. It is safe to use from several goroutines,
+the refreshes are serialized because presenting a refresh token twice
+revokes the whole session.
+*/
+type Client struct {
+	BaseURL       string        //e.g. https://edgeauth.local:9119
+	HTTPClient    *http.Client  //http.DefaultClient when nil
+	RefreshMargin time.Duration //DefaultRefreshMargin, negative never refreshes before a 401
+
+	lock         sync.Mutex
+	sessionToken string
+	refreshToken string
+	expiresAt    time.Time
+
+	refreshLock sync.Mutex
+}
+
+//New - A Client of the edgeauth at baseURL
+func New(baseURL string) *Client {
+	c := new(Client)
+	c.BaseURL = strings.TrimRight(baseURL, "/")
+	c.RefreshMargin = DefaultRefreshMargin
+	return c
+}
+
+/*
+SetTokens - Use a session started somewhere else. expiresIn is how many
+seconds the session token lives, 0 when it is not known; the token is
+then only refreshed after a 401.
+*/
+func (c *Client) SetTokens(sessionToken string, refreshToken string, expiresIn int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.sessionToken = sessionToken
+	c.refreshToken = refreshToken
+	c.expiresAt = time.Time{}
+	if expiresIn > 0 {
+		c.expiresAt = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+}
+
+//Tokens - The current session and refresh tokens
+func (c *Client) Tokens() (string, string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.sessionToken, c.refreshToken
+}
+
+//SessionToken - The current session token, empty when not logged in
+func (c *Client) SessionToken() string {
+	token, _ := c.Tokens()
+	return token
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
+
+//refreshDue - The session token expires within the RefreshMargin
+func (c *Client) refreshDue() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.RefreshMargin < 0 || len(c.refreshToken) == 0 || c.expiresAt.IsZero() {
+		return false
+	}
+	return time.Until(c.expiresAt) < c.RefreshMargin
+}
+
+/*
+refresh - Exchange the refresh token for a new session. stale is the
+session token the caller found wanting, nothing is done when another
+goroutine already replaced it.
+*/
+func (c *Client) refresh(ctx context.Context, stale string) error {
+	c.refreshLock.Lock()
+	defer c.refreshLock.Unlock()
+
+	session, refreshToken := c.Tokens()
+	if session != stale {
+		return nil
+	}
+	if len(refreshToken) == 0 {
+		return ErrNotLoggedIn
+	}
+
+	req := request{method: "POST", path: "/jwt/company/refresh", body: map[string]string{"refreshToken": refreshToken}}
+	var rsp LoginResponse
+	err := c.do(ctx, &req, &rsp)
+	if err != nil {
+		log.Printf("The session could not be refreshed: [%s]", err)
+		return err
+	}
+
+	c.SetTokens(rsp.SessionToken, rsp.RefreshToken, rsp.ExpiresIn)
+	return nil
+}
+
+//request - What is sent to the edgeauth, the body is JSON unless a form
+//is given
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   interface{}
+	form   url.Values
+	auth   bool //Send the session token
+}
+
+func (req *request) encode() ([]byte, string, error) {
+	if req.form != nil {
+		return []byte(req.form.Encode()), "application/x-www-form-urlencoded", nil
+	}
+	if req.body == nil {
+		return nil, "application/json", nil
+	}
+	buf, err := json.Marshal(req.body)
+	return buf, "application/json", err
+}
+
+func (c *Client) send(ctx context.Context, req *request, body []byte, contentType string, token string) (*http.Response, error) {
+	u := c.BaseURL + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+
+	r, err := http.NewRequestWithContext(ctx, req.method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range req.header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Content-Type", contentType)
+	if len(token) > 0 {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return c.httpClient().Do(r)
+}
+
+/*
+open - Send the request. An authorized request refreshes the session token
+when it is about to expire and, when the edgeauth replies 401 anyway,
+refreshes it and tries once more.
+*/
+func (c *Client) open(ctx context.Context, req *request) (*http.Response, error) {
+	body, contentType, err := req.encode()
+	if err != nil {
+		return nil, err
+	}
+
+	if !req.auth {
+		return c.send(ctx, req, body, contentType, "")
+	}
+
+	token := c.SessionToken()
+	if len(token) == 0 {
+		return nil, ErrNotLoggedIn
+	}
+	if c.refreshDue() && c.refresh(ctx, token) == nil {
+		token = c.SessionToken()
+	}
+
+	r, err := c.send(ctx, req, body, contentType, token)
+	if err != nil || r.StatusCode != http.StatusUnauthorized {
+		return r, err
+	}
+
+	if c.refresh(ctx, token) != nil {
+		return r, nil
+	}
+	drain(r.Body)
+
+	return c.send(ctx, req, body, contentType, c.SessionToken())
+}
+
+//decode - A reply other than 200, or with a Failure status, is a
+//StatusError. An empty body leaves rsp alone.
+func decode(r *http.Response, rsp interface{}) error {
+	buf, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	var status struct {
+		Status string `json:"status"`
+		Code   string `json:"code"`
+	}
+	if len(buf) > 0 {
+		json.Unmarshal(buf, &status)
+	}
+
+	if r.StatusCode != http.StatusOK || status.Status == StatusFailure {
+		return &StatusError{StatusCode: r.StatusCode, Code: status.Code}
+	}
+
+	if len(buf) == 0 || rsp == nil {
+		return nil
+	}
+
+	return json.Unmarshal(buf, rsp)
+}
+
+//do - Send the request and decode the JSON reply into rsp
+func (c *Client) do(ctx context.Context, req *request, rsp interface{}) error {
+	r, err := c.open(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	return decode(r, rsp)
+}
+
+//drain - Read what is left of the body so the connection is reused
+func drain(body io.ReadCloser) {
+	io.Copy(ioutil.Discard, body)
+	body.Close()
+}
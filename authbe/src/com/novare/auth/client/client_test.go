@@ -0,0 +1,332 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client
+
+import (
+	"com/novare/auth/authtest"
+	"com/novare/auth/model"
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testPassword = "@123ABC789"
+
+//loginTestClient - A company on a new edgeauth and a Client logged in as
+//its superuser
+func loginTestClient(t *testing.T, uniqueID string) (*authtest.Server, *Client) {
+	srv := authtest.NewServer()
+	c := New(srv.URL)
+
+	company := Company{Name: uniqueID, UniqueID: uniqueID, Password: testPassword, ConfirmPassword: testPassword}
+	_, err := c.CreateCompany(context.Background(), company)
+	if err != nil {
+		t.Fatalf("The company was not created: [%s]", err)
+	}
+
+	rsp, err := c.Login(context.Background(), LoginRequest{UniqueID: uniqueID, Username: "superuser", Password: testPassword, DeviceID: "LANE1"})
+	if err != nil || rsp.Status != StatusSuccess {
+		t.Fatalf("The login failed: [%v]", err)
+	}
+
+	return srv, c
+}
+
+func TestClientRoutes(t *testing.T) {
+	ctx := context.Background()
+	srv, c := loginTestClient(t, "CLIENTROUTES")
+	defer srv.Close()
+
+	_, err := c.Login(ctx, LoginRequest{UniqueID: "CLIENTROUTES", Username: "superuser", Password: "WRONGPASSWORD"})
+	if !IsStatusCode(err, http.StatusUnauthorized) {
+		t.Errorf("The login should have failed with the wrong password: [%v]", err)
+	}
+
+	company, err := c.GetCompany(ctx, "CLIENTROUTES")
+	if err != nil || company.UniqueID != "CLIENTROUTES" {
+		t.Errorf("The company was not returned: [%v]", err)
+		return
+	}
+
+	perm, err := c.InsertPermission(ctx, Permission{Description: "TEST", Permission: "CLIENT_PERMISSION"})
+	if err != nil || len(perm.ID) == 0 {
+		t.Errorf("The permission was not inserted: [%v]", err)
+		return
+	}
+
+	perm.Description = "UPDATED"
+	updated, err := c.UpdatePermission(ctx, *perm)
+	if err != nil || updated.Revision <= perm.Revision {
+		t.Errorf("The permission was not updated: [%v]", err)
+		return
+	}
+
+	_, err = c.UpdatePermission(ctx, *updated)
+	if err != nil {
+		t.Errorf("The permission was not updated again: [%s]", err)
+	}
+
+	_, err = c.UpdatePermission(ctx, *updated)
+	if !IsStatusCode(err, http.StatusPreconditionFailed) {
+		t.Errorf("The update of an old revision should have failed: [%v]", err)
+	}
+
+	perms, err := c.ListPermissions(ctx, 0, 100, ListOptions{NamePrefix: "CLIENT_"})
+	if err != nil || len(perms.Permissions) != 1 {
+		t.Errorf("The permission was not listed: [%v] [%v]", err, perms)
+	}
+
+	user, err := c.InsertUser(ctx, User{Username: "cashier", Name: "Cashier", Password: testPassword, ConfirmPassword: testPassword})
+	if err != nil || user.Username != "cashier" {
+		t.Errorf("The user was not inserted: [%v]", err)
+		return
+	}
+
+	isThing := false
+	users, err := c.ListUsers(ctx, 0, 100, UserListOptions{Username: "cashier", IsThing: &isThing})
+	if err != nil || len(users.Users) != 1 {
+		t.Errorf("The user was not listed: [%v] [%v]", err, users)
+	}
+
+	sessions, err := c.ListOwnSessions(ctx)
+	if err != nil || len(sessions) != 1 || !sessions[0].Current || sessions[0].DeviceID != "LANE1" {
+		t.Errorf("The session was not listed: [%v] [%v]", err, sessions)
+	}
+
+	token := c.SessionToken()
+	introspection, err := c.Introspect(ctx, token)
+	if err != nil || !introspection.Active {
+		t.Errorf("The session token should be active: [%v]", err)
+	}
+
+	err = c.Logout(ctx)
+	if err != nil || len(c.SessionToken()) != 0 {
+		t.Errorf("The logout failed: [%v]", err)
+	}
+
+	_, err = c.ListOwnSessions(ctx)
+	if err != ErrNotLoggedIn {
+		t.Errorf("The client should not be logged in: [%v]", err)
+	}
+}
+
+func TestClientRefresh(t *testing.T) {
+	ctx := context.Background()
+	srv, c := loginTestClient(t, "CLIENTREFRESH")
+	defer srv.Close()
+
+	session, refreshToken := c.Tokens()
+	if len(refreshToken) == 0 {
+		t.Errorf("The login did not return a refresh token")
+		return
+	}
+
+	//A rejected session token is refreshed and the request sent again
+	c.SetTokens(session+"X", refreshToken, 0)
+	_, err := c.ListOwnSessions(ctx)
+	if err != nil {
+		t.Errorf("The request was not sent again with a new session: [%s]", err)
+	}
+	if renewed, _ := c.Tokens(); renewed == session+"X" {
+		t.Errorf("The session token was not refreshed")
+	}
+
+	//A session about to expire is refreshed before the request
+	session, refreshToken = c.Tokens()
+	c.SetTokens(session, refreshToken, 1)
+	_, err = c.ListOwnSessions(ctx)
+	if renewed, _ := c.Tokens(); err != nil || renewed == session {
+		t.Errorf("The session token was not refreshed before it expired: [%v]", err)
+	}
+
+	//The refresh token was used, it cannot refresh again
+	c.SetTokens(session+"X", refreshToken, 0)
+	_, err = c.ListOwnSessions(ctx)
+	if !IsStatusCode(err, http.StatusUnauthorized) {
+		t.Errorf("A used refresh token should not refresh the session: [%v]", err)
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	ctx := context.Background()
+	srv, c := loginTestClient(t, "CLIENTCREDENTIALS")
+	defer srv.Close()
+
+	list, err := c.ListPermissions(ctx, 0, 100, ListOptions{NamePrefix: "INTROSPECT_TOKEN"})
+	if err != nil || len(list.Permissions) != 1 {
+		t.Errorf("The permission was not listed: [%v]", err)
+		return
+	}
+
+	var perm model.Permission
+	perm.ID, _ = primitive.ObjectIDFromHex(list.Permissions[0].ID)
+	perm.Permission = list.Permissions[0].Permission
+	_, err = c.InsertUser(ctx, User{Username: "scale", IsThing: "true", Permissions: []model.Permission{perm}, Password: testPassword, ConfirmPassword: testPassword})
+	if err != nil {
+		t.Errorf("The thing was not inserted: [%v]", err)
+		return
+	}
+
+	client, secret, err := c.InsertClient(ctx, OAuthClient{Name: "Scale", Username: "scale", Scopes: []string{"INTROSPECT_TOKEN"}})
+	if err != nil || len(client.ID) == 0 || len(secret) == 0 {
+		t.Errorf("The client was not registered: [%v]", err)
+		return
+	}
+
+	token, err := c.ClientCredentials(ctx, client.ID, secret, "", "")
+	if err != nil || token.Scope != "INTROSPECT_TOKEN" || token.ExpiresIn == 0 {
+		t.Errorf("The access token was not issued: [%v]", err)
+		return
+	}
+
+	introspection, err := c.Introspect(ctx, token.AccessToken)
+	if err != nil || !introspection.Active || introspection.ClientID != client.ID {
+		t.Errorf("The access token should be active for the client: [%v] %+v", err, introspection)
+	}
+
+	_, err = c.ClientCredentials(ctx, client.ID, "WRONG", "", "")
+	if se, ok := err.(*StatusError); !ok || se.StatusCode != http.StatusUnauthorized || se.Code != "invalid_client" {
+		t.Errorf("A wrong secret should be an invalid client: [%v]", err)
+	}
+
+	client.Name = "Kitchen scale"
+	updated, err := c.UpdateClient(ctx, *client)
+	if err != nil || updated.Name != "Kitchen scale" || updated.Revision <= client.Revision {
+		t.Errorf("The client was not updated: [%v]", err)
+	}
+
+	rotated, err := c.RotateClientSecret(ctx, client.ID)
+	if err != nil || rotated == secret {
+		t.Errorf("The secret was not rotated: [%v]", err)
+	}
+
+	clients, err := c.ListClients(ctx)
+	if err != nil || len(clients) != 1 {
+		t.Errorf("The client was not listed: [%v] [%v]", err, clients)
+	}
+
+	err = c.RemoveClient(ctx, client.ID)
+	if err != nil {
+		t.Errorf("The client was not removed: [%v]", err)
+	}
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	ctx := context.Background()
+	srv, c := loginTestClient(t, "CLIENTAUTHCODE")
+	defer srv.Close()
+
+	const redirectURI = "http://127.0.0.1:8123/callback"
+	app, _, err := c.InsertClient(ctx, OAuthClient{Name: "Back office", Public: true, RedirectURIs: []string{redirectURI}, Scopes: []string{"GET_USER"}})
+	if err != nil {
+		t.Errorf("The app was not registered: [%v]", err)
+		return
+	}
+
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Errorf("The PKCE verifier was not generated: [%s]", err)
+		return
+	}
+
+	//The browser logs in on the page and follows the redirect to the app
+	authorizeURL := c.AuthorizeURL(app.ID, redirectURI, "openid profile GET_USER", "STATE", "NONCE", challenge)
+	browser := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	page, err := browser.Get(authorizeURL)
+	if err != nil || page.StatusCode != http.StatusOK {
+		t.Errorf("The login page was not shown: [%v]", err)
+		return
+	}
+	page.Body.Close()
+
+	u, _ := url.Parse(authorizeURL)
+	form := u.Query()
+	form.Set("username", "superuser")
+	form.Set("password", testPassword)
+	rsp, err := browser.PostForm(srv.URL+"/oauth/authorize", form)
+	if err != nil || rsp.StatusCode != http.StatusFound {
+		t.Errorf("The login did not redirect: [%v]", err)
+		return
+	}
+	rsp.Body.Close()
+
+	location, _ := url.Parse(rsp.Header.Get("Location"))
+	if location.Query().Get("state") != "STATE" {
+		t.Errorf("The redirect lost the state: [%s]", location)
+	}
+
+	appClient := New(srv.URL)
+	token, err := appClient.ExchangeCode(ctx, app.ID, "", location.Query().Get("code"), redirectURI, verifier)
+	if err != nil || token.Scope != "openid profile GET_USER" || len(token.RefreshToken) == 0 {
+		t.Errorf("The code was not exchanged: [%v]", err)
+		return
+	}
+
+	//The app logs the user in with the ID token
+	v := NewVerifier(c)
+	_, err = v.VerifyIDToken(ctx, token.IDToken, app.ID, "OTHER")
+	if !model.IsClaimsReason(err, model.ClaimsInvalidNonce) {
+		t.Errorf("An ID token with another nonce should be rejected: [%v]", err)
+	}
+	idToken, err := v.VerifyIDToken(ctx, token.IDToken, app.ID, "NONCE")
+	if err != nil || idToken.PreferredUsername != "superuser" {
+		t.Errorf("The ID token was not verified: [%v]", err)
+		return
+	}
+
+	//The ID token is not a session or an access token, and the session is
+	//not an ID token
+	_, err = v.Verify(ctx, token.IDToken)
+	if !model.IsClaimsReason(err, model.ClaimsInvalidTokenUse) {
+		t.Errorf("The ID token should not verify as a session: [%v]", err)
+	}
+	_, err = v.VerifyIDToken(ctx, token.AccessToken, app.ID, "")
+	if !model.IsClaimsReason(err, model.ClaimsInvalidTokenUse) {
+		t.Errorf("The session should not verify as an ID token: [%v]", err)
+	}
+
+	info, err := appClient.UserInfo(ctx)
+	if err != nil || info.Subject != idToken.Subject || info.PreferredUsername != "superuser" {
+		t.Errorf("The userinfo does not match the ID token: [%v] %+v", err, info)
+	}
+
+	discovery, err := appClient.OpenIDConfiguration(ctx)
+	if err != nil || discovery.TokenEndpoint != srv.URL+"/oauth/token" {
+		t.Errorf("The discovery document is wrong: [%v] %+v", err, discovery)
+	}
+
+	_, err = appClient.ListUsers(ctx, 0, 10, UserListOptions{})
+	if err != nil {
+		t.Errorf("The app should list the users: [%v]", err)
+	}
+
+	_, err = appClient.ListRoles(ctx, 0, 10, ListOptions{})
+	if !IsStatusCode(err, http.StatusForbidden) {
+		t.Errorf("The app should not list the roles: [%v]", err)
+	}
+}
@@ -0,0 +1,265 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client
+
+import (
+	"com/novare/auth/model"
+	"encoding/json"
+)
+
+//Status values of the responses
+const (
+	StatusSuccess          = "Success"
+	StatusFailure          = "Failure"
+	StatusPasswordMismatch = "PasswordMismatch"
+	StatusPasswordReset    = "PasswordReset"
+)
+
+//Company - The company sent to /jwt/company and /company/remote
+type Company struct {
+	Name            string                `json:"name,omitempty"`
+	Address1        string                `json:"address1,omitempty"`
+	Address2        string                `json:"address2,omitempty"`
+	City            string                `json:"city,omitempty"`
+	State           string                `json:"state,omitempty"`
+	Zip             string                `json:"zip,omitempty"`
+	IsInLocation    string                `json:"isInLocation,omitempty"`
+	RemotelyManaged string                `json:"remotelyManaged,omitempty"`
+	AuthRelay       string                `json:"authRelay,omitempty"`
+	Password        string                `json:"password,omitempty"` //The superuser password, only to create the company
+	ConfirmPassword string                `json:"confirmPassword,omitempty"`
+	UniqueID        string                `json:"uniqueID"`
+	APIKey          string                `json:"apiKey"`
+	GroupOwnerID    string                `json:"groupOwnerID,omitempty"`
+	MemberOfGroups  []string              `json:"memberOfGroups,omitempty"`
+	Settings        model.CompanySettings `json:"settings"`
+	RegisCode       string                `json:"regisCode,omitempty"`
+}
+
+//CompanyInfo - A company as the edgeauth returns it
+type CompanyInfo struct {
+	CompanyID       string                `json:"companyID"`
+	UniqueID        string                `json:"uniqueID"`
+	Name            string                `json:"name"`
+	Address1        string                `json:"address1"`
+	Address2        string                `json:"address2"`
+	City            string                `json:"city"`
+	State           string                `json:"state"`
+	Zip             string                `json:"zip"`
+	IsInLocation    string                `json:"isInLocation,omitempty"`
+	RemotelyManaged string                `json:"remotelyManaged,omitempty"`
+	AuthRelay       string                `json:"authRelay,omitempty"`
+	APIKey          string                `json:"apiKey,omitempty"`
+	Settings        model.CompanySettings `json:"settings"`
+	RegisCode       string                `json:"regisCode"`
+	GroupOwnerID    string                `json:"groupOwnerID,omitempty"`
+	Revision        int64                 `json:"revision"` //Send it back to UpdateCompany
+}
+
+//LoginRequest - The credentials of a user, DeviceID names the terminal
+type LoginRequest struct {
+	UniqueID string `json:"uniqueID"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	DeviceID string `json:"deviceID,omitempty"`
+}
+
+//MachineLoginRequest - The credentials of a thing
+type MachineLoginRequest struct {
+	UniqueID string `json:"uniqueID"`
+	Username string `json:"username"`
+	Secret   string `json:"secret"`
+	APIKey   string `json:"apiKey"`
+	DeviceID string `json:"deviceID,omitempty"`
+}
+
+//LoginResponse - The tokens of a session. The Client keeps them, they
+//are only needed to carry the session to another process.
+type LoginResponse struct {
+	Status       string `json:"status"`
+	SessionToken string `json:"sessionToken"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	ExpiresIn    int64  `json:"expiresIn,omitempty"` //Seconds the session token lives
+	Username     string `json:"userName"`
+	Fullname     string `json:"fullName"`
+	IsThing      bool   `json:"isThing"`
+	Secret       string `json:"secret"`
+	UserStatus   string `json:"userStatus,omitempty"`
+}
+
+//Permission - A permission of the company
+type Permission struct {
+	ID          string `json:"id,omitempty"`
+	Description string `json:"description,omitempty"`
+	Permission  string `json:"permission,omitempty"`
+	Revision    int64  `json:"revision"` //Sent in If-Match by the updates when not 0
+}
+
+//PermissionList - A page of permissions
+type PermissionList struct {
+	Total       int64        `json:"total"`
+	Permissions []Permission `json:"permissions"`
+}
+
+//Role - A role of the company
+type Role struct {
+	ID          string             `json:"id"`
+	Description string             `json:"description"`
+	Permissions []model.Permission `json:"permissions"`
+	Revision    int64              `json:"revision"` //Sent in If-Match by the updates when not 0
+}
+
+//RoleList - A page of roles
+type RoleList struct {
+	Total int64  `json:"total"`
+	Roles []Role `json:"roles"`
+}
+
+//User - A user or a thing of the company
+type User struct {
+	ID              string             `json:"id,omitempty"`
+	Username        string             `json:"username,omitempty"`
+	Name            string             `json:"name,omitempty"`
+	Permissions     []model.Permission `json:"permissions,omitempty"`
+	Roles           []string           `json:"roles,omitempty"`
+	IsThing         string             `json:"isThing,omitempty"` //"true" for a thing
+	Password        string             `json:"password,omitempty"`
+	ConfirmPassword string             `json:"confirmPassword,omitempty"`
+	Secret          string             `json:"secret,omitempty"`
+	Revision        int64              `json:"revision"` //Sent in If-Match by the updates when not 0
+}
+
+//UserList - A page of users
+type UserList struct {
+	Total int64  `json:"total"`
+	Users []User `json:"users"`
+}
+
+//PasswordChange - The password of the user is changed
+type PasswordChange struct {
+	Username        string `json:"username"`
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+	ConfirmPassword string `json:"confirmPassword"`
+}
+
+//Session - A session of a user, the token refreshes do not change the ID
+type Session struct {
+	ID         string `json:"id"`
+	UserID     string `json:"userID"`
+	Username   string `json:"username"`
+	DeviceID   string `json:"deviceID"`
+	IPAddress  string `json:"ipAddress"`
+	UserAgent  string `json:"userAgent"`
+	CreatedAt  int64  `json:"createdAt"`
+	LastUsedAt int64  `json:"lastUsedAt"`
+	ExpiresAt  int64  `json:"expiresAt"`
+	Current    bool   `json:"current"` //The session of the Client
+}
+
+//Introspection - RFC 7662 introspection of a token
+type Introspection struct {
+	Active      bool     `json:"active"`
+	Scope       string   `json:"scope,omitempty"`
+	Username    string   `json:"username,omitempty"`
+	TokenType   string   `json:"token_type,omitempty"`
+	ExpiresAt   int64    `json:"exp,omitempty"`
+	IssuedAt    int64    `json:"iat,omitempty"`
+	NotBefore   int64    `json:"nbf,omitempty"`
+	Subject     string   `json:"sub,omitempty"`
+	Audience    string   `json:"aud,omitempty"`
+	Issuer      string   `json:"iss,omitempty"`
+	ID          string   `json:"jti,omitempty"`
+	CompanyID   string   `json:"company_id,omitempty"`
+	Company     string   `json:"company,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	ClientID    string   `json:"client_id,omitempty"`
+}
+
+//OAuthClient - A client of the client credentials grant, acting as the
+//thing with the username, or an app of the authorization code grant
+//with RedirectURIs
+type OAuthClient struct {
+	ID            string   `json:"clientID,omitempty"`
+	Name          string   `json:"name"`
+	Username      string   `json:"username"`
+	Scopes        []string `json:"scopes"`
+	TokenDuration int64    `json:"tokenDuration"` //Seconds, 0 for the default
+	RedirectURIs  []string `json:"redirectURIs,omitempty"`
+	Public        bool     `json:"public"` //An app without a secret
+	CreatedAt     int64    `json:"createdAt,omitempty"`
+	Revision      int64    `json:"revision"` //Sent in If-Match by the updates when not 0
+}
+
+//TokenResponse - The RFC 6749 response of the token endpoint
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"` //The authorization code sessions only
+	Scope        string `json:"scope"`
+	IDToken      string `json:"id_token,omitempty"` //The codes granted the openid scope only, see VerifyIDToken
+}
+
+//OpenIDConfiguration - The OpenID Connect discovery document
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	ResponseModesSupported            []string `json:"response_modes_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+//UserInfo - The OpenID Connect claims of the user of the session
+type UserInfo struct {
+	Subject           string `json:"sub"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Company           string `json:"company,omitempty"`
+}
+
+//RevocationList - The revoked tokens, pass Now as the since of the next
+//call
+type RevocationList struct {
+	Now     int64                `json:"now"`
+	Revoked []model.RevokedToken `json:"revoked"`
+}
+
+//Event - An event of /jwt/events, Data is the raw JSON of the event
+type Event struct {
+	EventID   string          `json:"eventID"`
+	TimeStamp string          `json:"timeStamp"`
+	Data      json.RawMessage `json:"data"`
+}
@@ -0,0 +1,355 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client
+
+import (
+	"com/novare/auth/model"
+	"com/novare/auth/sse"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Defaults of a Verifier
+const (
+	DefaultKeyRefreshInterval = time.Minute
+	DefaultNegativeGrantTTL   = 10 * time.Second
+	DefaultMaxGrantAge        = 5 * time.Minute
+)
+
+var (
+	//ErrUnknownKey - The token is signed with a key the JWKS does not have
+	ErrUnknownKey = errors.New("UnknownKey")
+	//ErrNotVerifiable - An HS256 token can only be checked by the edgeauth
+	ErrNotVerifiable = errors.New("NotVerifiable")
+	//ErrTokenRevoked - The token is on the revocation list
+	ErrTokenRevoked = errors.New("TokenRevoked")
+)
+
+type grantDecision struct {
+	token      string
+	tokenID    string
+	validUntil time.Time //Until the token expires, zero for a refused grant
+	err        error
+	until      time.Time //The decision is not asked for again before
+}
+
+/*
+Verifier - Checks the tokens of the edgeauth without calling it. The keys
+come from the JWKS and the revoked tokens from /oauth/revocations, both
+are kept when the edgeauth cannot be reached. The grants are kept until
+the access token expires, so Authorize goes on answering from the cache
+while the edgeauth is briefly unreachable.
+*/
+type Verifier struct {
+	Client             *Client
+	Policy             *model.ClaimsPolicy //model.NewClaimsPolicy() by default
+	KeyRefreshInterval time.Duration       //How often an unknown kid can fetch the JWKS
+	NegativeGrantTTL   time.Duration       //How long a refused grant is remembered
+	MaxGrantAge        time.Duration       //How long an access token that never expires is used
+
+	lock         sync.Mutex
+	keys         map[string]model.JSONWebKey
+	keysFetched  time.Time
+	revoked      map[string]int64 //The jti and its exp
+	revokedSince int64
+	grants       map[string]grantDecision
+}
+
+//NewVerifier - A Verifier fetching the keys and the grants with the client
+func NewVerifier(c *Client) *Verifier {
+	v := new(Verifier)
+	v.Client = c
+	v.Policy = model.NewClaimsPolicy()
+	v.KeyRefreshInterval = DefaultKeyRefreshInterval
+	v.NegativeGrantTTL = DefaultNegativeGrantTTL
+	v.MaxGrantAge = DefaultMaxGrantAge
+	v.keys = make(map[string]model.JSONWebKey)
+	v.revoked = make(map[string]int64)
+	v.grants = make(map[string]grantDecision)
+	return v
+}
+
+//RefreshKeys - Fetch the JWKS. The keys already known are kept when the
+//edgeauth cannot be reached.
+func (v *Verifier) RefreshKeys(ctx context.Context) error {
+	v.lock.Lock()
+	v.keysFetched = time.Now()
+	v.lock.Unlock()
+
+	set, err := v.Client.JWKS(ctx)
+	if err != nil {
+		log.Printf("The JWKS could not be fetched, keeping the known keys: [%s]", err)
+		return err
+	}
+
+	keys := make(map[string]model.JSONWebKey)
+	for _, jwk := range set.Keys {
+		keys[jwk.KeyID] = jwk
+	}
+
+	v.lock.Lock()
+	v.keys = keys
+	v.lock.Unlock()
+	return nil
+}
+
+//key - The JWK with the kid. An unknown kid fetches the JWKS again, at
+//most once per KeyRefreshInterval.
+func (v *Verifier) key(ctx context.Context, kid string) (model.JSONWebKey, error) {
+	v.lock.Lock()
+	jwk, ok := v.keys[kid]
+	due := time.Since(v.keysFetched) >= v.KeyRefreshInterval
+	v.lock.Unlock()
+
+	if ok {
+		return jwk, nil
+	}
+	if !due || v.RefreshKeys(ctx) != nil {
+		return jwk, ErrUnknownKey
+	}
+
+	v.lock.Lock()
+	jwk, ok = v.keys[kid]
+	v.lock.Unlock()
+	if !ok {
+		return jwk, ErrUnknownKey
+	}
+	return jwk, nil
+}
+
+//IsRevoked - The jti is on the revocation list the Verifier knows
+func (v *Verifier) IsRevoked(tokenID string) bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	_, ok := v.revoked[tokenID]
+	return ok
+}
+
+//addRevoked - Remember the revoked tokens and forget the expired ones
+func (v *Verifier) addRevoked(revoked []model.RevokedToken) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for _, r := range revoked {
+		v.revoked[r.TokenID] = r.ExpiresAt
+	}
+
+	now := time.Now().Unix()
+	for jti, exp := range v.revoked {
+		if exp != 0 && now >= exp {
+			delete(v.revoked, jti)
+		}
+	}
+}
+
+//SyncRevocations - Fetch the tokens revoked since the last sync
+func (v *Verifier) SyncRevocations(ctx context.Context) error {
+	v.lock.Lock()
+	since := v.revokedSince
+	v.lock.Unlock()
+
+	list, err := v.Client.Revocations(ctx, since)
+	if err != nil {
+		log.Printf("The revocation list could not be fetched: [%s]", err)
+		return err
+	}
+
+	v.addRevoked(list.Revoked)
+
+	v.lock.Lock()
+	v.revokedSince = list.Now
+	v.lock.Unlock()
+	return nil
+}
+
+/*
+HandleEvent - Apply a TokenRevoked or KeyRotation event of /jwt/events, so
+a revocation is known before the next sync and a new key is fetched on
+its first use. The other events are ignored.
+*/
+func (v *Verifier) HandleEvent(event Event) error {
+	switch event.EventID {
+	case sse.EventTokenRevoked:
+		var revoked model.RevokedToken
+		err := json.Unmarshal(event.Data, &revoked)
+		if err != nil {
+			return err
+		}
+		v.addRevoked([]model.RevokedToken{revoked})
+
+	case sse.EventKeyRotation:
+		v.lock.Lock()
+		v.keysFetched = time.Time{}
+		v.lock.Unlock()
+	}
+	return nil
+}
+
+/*
+Verify - Check the token offline: the signature with the JWKS, the claims
+with the Policy and the revocation list. Only the tokens signed with the
+keys can be verified, an HS256 token is ErrNotVerifiable.
+*/
+func (v *Verifier) Verify(ctx context.Context, token string) (*model.JWTPayload, error) {
+	return v.verify(ctx, token, v.Policy)
+}
+
+//verify - Check the token offline against the policy
+func (v *Verifier) verify(ctx context.Context, token string, policy *model.ClaimsPolicy) (*model.JWTPayload, error) {
+	jwt := model.NewJWTToken("", "")
+	err := jwt.ParseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if !jwt.IsSignedWithKey() {
+		return nil, ErrNotVerifiable
+	}
+
+	jwk, err := v.key(ctx, jwt.Header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = jwt.VerifyWithJWK(jwk)
+	if err != nil {
+		return nil, err
+	}
+
+	err = policy.Validate(&jwt.Payload, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if v.IsRevoked(jwt.Payload.ID) {
+		return nil, ErrTokenRevoked
+	}
+
+	return &jwt.Payload, nil
+}
+
+/*
+VerifyIDToken - Check the ID token of ExchangeCode offline. It must be for
+the clientID, expire, and carry the nonce the app passed to AuthorizeURL;
+the issuers and the leeway are those of the Policy.
+*/
+func (v *Verifier) VerifyIDToken(ctx context.Context, token string, clientID string, nonce string) (*model.JWTPayload, error) {
+	policy := model.NewClaimsPolicy(clientID)
+	policy.TokenUse = model.TokenUseID
+	policy.RequireExpiration = true
+	policy.Leeway = v.Policy.Leeway
+	policy.Issuers = v.Policy.Issuers
+
+	payload, err := v.verify(ctx, token, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	if payload.Nonce != nonce {
+		return nil, &model.ClaimsError{Reason: model.ClaimsInvalidNonce}
+	}
+	return payload, nil
+}
+
+//VerifyScope - Verify the access token and check it was granted for the
+//permission
+func (v *Verifier) VerifyScope(ctx context.Context, token string, permission string) (*model.JWTPayload, error) {
+	payload, err := v.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range strings.Fields(payload.Scope) {
+		if s == permission {
+			return payload, nil
+		}
+	}
+	return nil, &model.ClaimsError{Reason: model.ClaimsInsufficientScope}
+}
+
+//isDecision - The edgeauth answered the grant, as opposed to not being
+//reachable or failing
+func isDecision(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode < 500
+}
+
+/*
+Authorize - Ask the edgeauth to grant the permission to the session of the
+Client and return the access token. The token is reused until it expires,
+or for the MaxGrantAge when it does not, and a refusal is remembered for
+the NegativeGrantTTL. When the edgeauth cannot be reached the last token
+granted is returned while it is valid.
+*/
+func (v *Verifier) Authorize(ctx context.Context, uniqueID string, permission string, audience string) (string, error) {
+	cacheKey := uniqueID + "\x00" + permission + "\x00" + audience
+
+	v.lock.Lock()
+	cached, ok := v.grants[cacheKey]
+	v.lock.Unlock()
+
+	now := time.Now()
+	if ok && now.Before(cached.until) && !v.IsRevoked(cached.tokenID) {
+		return cached.token, cached.err
+	}
+
+	token, err := v.Client.Grant(ctx, uniqueID, permission, audience)
+	if err != nil && !isDecision(err) {
+		if ok && cached.err == nil && now.Before(cached.validUntil) && !v.IsRevoked(cached.tokenID) {
+			log.Printf("The edgeauth could not be reached, using the cached grant for [%s]: [%s]", permission, err)
+			return cached.token, nil
+		}
+		return "", err
+	}
+
+	decision := grantDecision{token: token, err: err, until: now.Add(v.NegativeGrantTTL)}
+	if err == nil {
+		decision.validUntil = now.Add(v.MaxGrantAge)
+		jwt := model.NewJWTToken("", "")
+		if jwt.ParseJWT(token) == nil {
+			decision.tokenID = jwt.Payload.ID
+			if jwt.Payload.ExpirationTime != 0 {
+				decision.validUntil = time.Unix(jwt.Payload.ExpirationTime, 0)
+			}
+		}
+
+		margin := v.Client.RefreshMargin
+		if margin < 0 {
+			margin = 0
+		}
+		decision.until = decision.validUntil.Add(-margin)
+	}
+
+	v.lock.Lock()
+	v.grants[cacheKey] = decision
+	v.lock.Unlock()
+
+	return token, err
+}
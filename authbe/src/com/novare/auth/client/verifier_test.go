@@ -0,0 +1,123 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client
+
+import (
+	"com/novare/auth/model"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestVerifier(t *testing.T) {
+	ctx := context.Background()
+	srv, c := loginTestClient(t, "VERIFIER")
+	defer srv.Close()
+
+	v := NewVerifier(c)
+
+	_, err := v.Verify(ctx, c.SessionToken())
+	if err != nil {
+		t.Errorf("The session token was not verified: [%s]", err)
+		return
+	}
+
+	token, err := v.Authorize(ctx, "VERIFIER", "GET_USER", "")
+	if err != nil {
+		t.Errorf("The grant was refused: [%s]", err)
+		return
+	}
+
+	_, err = v.VerifyScope(ctx, token, "GET_USER")
+	if err != nil {
+		t.Errorf("The access token was not verified: [%s]", err)
+	}
+
+	_, err = v.VerifyScope(ctx, token, "ADD_USER")
+	if !model.IsClaimsReason(err, model.ClaimsInsufficientScope) {
+		t.Errorf("The access token was not granted ADD_USER: [%v]", err)
+	}
+
+	_, err = v.Verify(ctx, token+"X")
+	if err == nil {
+		t.Errorf("A changed token should not verify")
+	}
+
+	//The edgeauth cannot be reached, the cached grant is still used
+	baseURL := c.BaseURL
+	c.BaseURL = "http://127.0.0.1:1"
+	decision := v.grants["VERIFIER\x00GET_USER\x00"]
+	decision.until = time.Now()
+	v.grants["VERIFIER\x00GET_USER\x00"] = decision
+
+	cached, err := v.Authorize(ctx, "VERIFIER", "GET_USER", "")
+	if err != nil || cached != token {
+		t.Errorf("The cached grant was not used while the edgeauth is unreachable: [%v]", err)
+	}
+
+	_, err = v.Authorize(ctx, "VERIFIER", "ADD_USER", "")
+	if err == nil {
+		t.Errorf("A grant never made should not be authorized offline")
+	}
+
+	_, err = v.Verify(ctx, token)
+	if err != nil {
+		t.Errorf("The keys should be kept while the edgeauth is unreachable: [%s]", err)
+	}
+
+	//The revocation reaches the verifier with the next sync
+	c.BaseURL = baseURL
+	err = c.Revoke(ctx, token)
+	if err != nil {
+		t.Errorf("The access token was not revoked: [%s]", err)
+		return
+	}
+
+	err = v.SyncRevocations(ctx)
+	if err != nil {
+		t.Errorf("The revocations were not synced: [%s]", err)
+	}
+
+	_, err = v.Verify(ctx, token)
+	if err != ErrTokenRevoked {
+		t.Errorf("The revoked token should not verify: [%v]", err)
+	}
+}
+
+func TestVerifierHandleEvent(t *testing.T) {
+	v := NewVerifier(New("http://127.0.0.1:1"))
+
+	event := Event{EventID: "TokenRevoked", Data: []byte(`{"jti":"ABC","exp":0}`)}
+	err := v.HandleEvent(event)
+	if err != nil || !v.IsRevoked("ABC") {
+		t.Errorf("The revocation event was not applied: [%v]", err)
+	}
+
+	event = Event{EventID: "TokenRevoked", Data: []byte(`{"jti":"DEF","exp":1}`)}
+	v.HandleEvent(event)
+	if v.IsRevoked("DEF") {
+		t.Errorf("The revocation of an expired token should be dropped")
+	}
+}
@@ -0,0 +1,296 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"com/novare/auth/model"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+//The error codes only the authorization endpoint sends back to the app,
+//RFC 6749 section 4.1.2.1
+const (
+	oauthAccessDenied            = "access_denied"
+	oauthUnsupportedResponseType = "unsupported_response_type"
+	//oidcLoginRequired - prompt=none, the page would have to be shown
+	oidcLoginRequired = "login_required"
+)
+
+//What the login page says when it can not log the user in
+const (
+	wrongCredentialsMessage   = "The username or password is wrong."
+	signInUnavailableMessage  = "The sign in is not available right now, try again later."
+	unknownApplicationMessage = "The application is not registered."
+	unknownRedirectMessage    = "The redirect URI is not registered for the application."
+)
+
+//authorizeReq - The parameters of the authorization request, the login
+//page sends them back with the credentials
+type authorizeReq struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string //OpenID Connect, copied to the ID token
+	Prompt              string //OpenID Connect, only none is acted on
+}
+
+//newAuthorizeReq - The authorization request in the query or the form
+func newAuthorizeReq(form url.Values) authorizeReq {
+	var req authorizeReq
+	req.ResponseType = form.Get("response_type")
+	req.ClientID = form.Get("client_id")
+	req.RedirectURI = form.Get("redirect_uri")
+	req.Scope = form.Get("scope")
+	req.State = form.Get("state")
+	req.CodeChallenge = form.Get("code_challenge")
+	req.CodeChallengeMethod = form.Get("code_challenge_method")
+	req.Nonce = form.Get("nonce")
+	req.Prompt = form.Get("prompt")
+	return req
+}
+
+/*
+authorizeResp - Either the login page, with an error when the last try
+failed, or the redirect back to the app. The errors that make the app
+untrustworthy (an unknown client or redirect URI) only show the page, the
+browser is never sent to a URI that was not registered.
+*/
+type authorizeResp struct {
+	Status   int
+	Page     authorizePage
+	Redirect string
+}
+
+//authorizePage - What the login page shows
+type authorizePage struct {
+	Company     string
+	Application string
+	Error       string
+	Username    string
+	ShowForm    bool //False when the request can not go on
+	Request     authorizeReq
+}
+
+//errorPage - The page of a request that can not go on
+func errorPage(status int, message string) *authorizeResp {
+	var rsp authorizeResp
+	rsp.Status = status
+	rsp.Page.Error = message
+	return &rsp
+}
+
+//redirectError - Send the error back to the app, with its state
+func redirectError(req authorizeReq, code string, description string) *authorizeResp {
+	params := url.Values{"error": {code}}
+	if utf8.RuneCountInString(description) > 0 {
+		params.Set("error_description", description)
+	}
+	if utf8.RuneCountInString(req.State) > 0 {
+		params.Set("state", req.State)
+	}
+
+	var rsp authorizeResp
+	rsp.Status = http.StatusFound
+	rsp.Redirect = withQuery(req.RedirectURI, params)
+	return &rsp
+}
+
+//withQuery - The URI with the parameters added to its query
+func withQuery(uri string, params url.Values) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+
+	query := u.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+//unavailableErr - A database failure is a temporary one when it is down
+func unavailableErr(err error) string {
+	if errors.Is(err, model.ErrUnavailable) || errors.Is(err, context.DeadlineExceeded) {
+		return oauthTemporarilyUnavailable
+	}
+	return oauthServerError
+}
+
+/*
+checkAuthorizeReq - The client and the redirect URI are checked first, a
+problem with either is shown on the page. The other problems are sent
+back to the app. Only response_type=code with an S256 PKCE challenge is
+accepted, and only the scopes the client is allowed besides the
+OpenIDScopes.
+*/
+func checkAuthorizeReq(ctx context.Context, req authorizeReq) (*model.OAuthClient, *model.Company, *authorizeResp) {
+
+	client, err := model.FindOAuthClientByID(ctx, req.ClientID)
+	if errors.Is(err, model.ErrNotFound) || errors.Is(err, model.ErrInvalidInput) {
+		log.Printf("The client [%s] of the authorization request is not registered", req.ClientID)
+		return nil, nil, errorPage(http.StatusBadRequest, unknownApplicationMessage)
+	}
+	if err != nil {
+		log.Printf("The client [%s] could not be read: [%s]", req.ClientID, err)
+		return nil, nil, errorPage(http.StatusServiceUnavailable, signInUnavailableMessage)
+	}
+
+	if !client.HasRedirectURI(req.RedirectURI) {
+		log.Printf("The redirect URI [%s] is not registered for the client [%s]", req.RedirectURI, req.ClientID)
+		return nil, nil, errorPage(http.StatusBadRequest, unknownRedirectMessage)
+	}
+
+	if req.ResponseType != "code" {
+		return nil, nil, redirectError(req, oauthUnsupportedResponseType, "Only response_type=code is supported")
+	}
+
+	if req.CodeChallengeMethod != model.CodeChallengeS256 || !model.IsValidCodeChallenge(req.CodeChallenge) {
+		return nil, nil, redirectError(req, oauthInvalidRequest, "An S256 code_challenge is required")
+	}
+
+	if utf8.RuneCountInString(req.Nonce) > model.MaxNonceLength {
+		return nil, nil, redirectError(req, oauthInvalidRequest, "The nonce is too long")
+	}
+
+	allowed := make(map[string]bool)
+	for _, s := range client.Scopes {
+		allowed[s] = true
+	}
+	for _, s := range strings.Fields(req.Scope) {
+		if !allowed[s] && !model.IsOpenIDScope(s) {
+			log.Printf("The scope [%s] is not allowed to the client [%s]", s, req.ClientID)
+			return nil, nil, redirectError(req, oauthInvalidScope, "")
+		}
+	}
+
+	company, err := model.FindCompanyByID(ctx, client.CompanyID)
+	if err != nil {
+		log.Printf("The company of the client [%s] could not be read: [%s]", req.ClientID, err)
+		return nil, nil, redirectError(req, unavailableErr(err), "")
+	}
+
+	return client, company, nil
+}
+
+//loginPage - The login page of the request
+func loginPage(client *model.OAuthClient, company *model.Company, req authorizeReq) *authorizeResp {
+	var rsp authorizeResp
+	rsp.Status = http.StatusOK
+	rsp.Page.Company = company.Name
+	rsp.Page.Application = client.Name
+	rsp.Page.ShowForm = true
+	rsp.Page.Request = req
+	return &rsp
+}
+
+/*
+authorizeBL - The login page of a valid authorization request. The
+edgeauth keeps no browser session, an app asking for prompt=none is told
+the user has to log in.
+*/
+func authorizeBL(ctx context.Context, req authorizeReq) *authorizeResp {
+
+	client, company, rsp := checkAuthorizeReq(ctx, req)
+	if rsp != nil {
+		return rsp
+	}
+
+	if req.Prompt == "none" {
+		return redirectError(req, oidcLoginRequired, "")
+	}
+
+	return loginPage(client, company, req)
+}
+
+/*
+authorizeLoginBL - Log the user of the company in and send the browser
+back to the app with a code. A wrong username or password shows the page
+again. The code carries the scopes the app asked for (all of its scopes
+when it did not ask) that the user holds; a user holding none of them is
+access_denied.
+*/
+func authorizeLoginBL(ctx context.Context, req authorizeReq, username string, password string) *authorizeResp {
+
+	client, company, rsp := checkAuthorizeReq(ctx, req)
+	if rsp != nil {
+		return rsp
+	}
+
+	rsp = loginPage(client, company, req)
+	rsp.Page.Username = username
+
+	user, err := model.FindUserByUsernameCompanyID(ctx, username, company.ID.Hex())
+	if err != nil && !errors.Is(err, model.ErrNotFound) {
+		log.Printf("The user [%s] could not be read: [%s]", username, err)
+		rsp.Status = http.StatusServiceUnavailable
+		rsp.Page.Error = signInUnavailableMessage
+		return rsp
+	}
+
+	//The things and the disabled users can not log in here
+	if err != nil || !user.IsPasswordMatch(password) || user.IsThing || user.UserStatus == model.UserStateDisabled {
+		log.Printf("The user [%s] could not log in for the client [%s]", username, req.ClientID)
+		rsp.Status = http.StatusUnauthorized
+		rsp.Page.Error = wrongCredentialsMessage
+		return rsp
+	}
+
+	scope, err := client.GrantScope(ctx, user, req.Scope)
+	if errors.Is(err, model.ErrInvalidScope) {
+		log.Printf("The user [%s] holds none of the scopes the client [%s] asked for", username, req.ClientID)
+		return redirectError(req, oauthAccessDenied, "")
+	}
+	if err != nil {
+		return redirectError(req, unavailableErr(err), "")
+	}
+
+	code, err := model.CreateAuthorizationCode(ctx, client, user, req.RedirectURI, scope, req.CodeChallenge, req.Nonce)
+	if err != nil {
+		log.Printf("The authorization code could not be created: [%s]", err)
+		return redirectError(req, unavailableErr(err), "")
+	}
+
+	params := url.Values{"code": {code}}
+	if utf8.RuneCountInString(req.State) > 0 {
+		params.Set("state", req.State)
+	}
+
+	rsp = new(authorizeResp)
+	rsp.Status = http.StatusFound
+	rsp.Redirect = withQuery(req.RedirectURI, params)
+	return rsp
+}
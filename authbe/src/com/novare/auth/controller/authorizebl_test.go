@@ -0,0 +1,247 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"com/novare/auth/model"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testRedirectURI = "com.novare.authfe:/callback"
+
+//serveForm - A form request through the router
+func serveForm(method string, path string, form url.Values, token string) *httptest.ResponseRecorder {
+	var req *http.Request
+	if method == "GET" {
+		req = httptest.NewRequest(method, path+"?"+form.Encode(), nil)
+	} else {
+		req = httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	NewRouter().ServeHTTP(rec, req)
+	return rec
+}
+
+//authorizeForm - The authorization request of the client with the S256
+//challenge of the verifier
+func authorizeForm(clientID string, scope string, verifier string) url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {scope},
+		"state":                 {"XYZ"},
+		"code_challenge":        {model.S256Challenge(verifier)},
+		"code_challenge_method": {model.CodeChallengeS256},
+	}
+}
+
+//loginForCode - Log the superuser in on the authorize page, the code of
+//the redirect
+func loginForCode(t *testing.T, clientID string, scope string, verifier string) string {
+	form := authorizeForm(clientID, scope, verifier)
+	form.Set("username", "superuser")
+	form.Set("password", "@123ABC789")
+	rec := serveForm("POST", "/oauth/authorize", form, "")
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if rec.Code != http.StatusFound || err != nil || location.Query().Get("state") != "XYZ" {
+		t.Errorf("The login did not redirect with the state: %d [%s]", rec.Code, rec.Header().Get("Location"))
+		return ""
+	}
+	return location.Query().Get("code")
+}
+
+func TestAuthorizationCode(t *testing.T) {
+	ctx := context.Background()
+
+	company, _, lrsp := loginTestCompany(t, "AUTHCODEID")
+	if lrsp == nil {
+		return
+	}
+	defer performCompanyCleanup(company.ID.Hex(), t)
+
+	crsp := insertClientBL(ctx, company.ID.Hex(), &clientObj{Name: "Admin App", Public: true, RedirectURIs: []string{testRedirectURI}, Scopes: []string{"GET_USER", "GET_ROLE"}})
+	if crsp.Status != StatusSuccess || len(crsp.ClientSecret) != 0 {
+		t.Errorf("The public client was not registered: %+v", crsp)
+		return
+	}
+	clientID := crsp.Client.ID
+	verifier := strings.Repeat("0123456789", 5)
+
+	//The problems with the client or the redirect URI stay on the page
+	rec := serveForm("GET", "/oauth/authorize", authorizeForm("UNKNOWN", "", verifier), "")
+	if rec.Code != http.StatusBadRequest || len(rec.Header().Get("Location")) > 0 {
+		t.Errorf("An unknown client should not be redirected: %d", rec.Code)
+	}
+
+	form := authorizeForm(clientID, "", verifier)
+	form.Set("redirect_uri", "https://attacker.example.com/callback")
+	rec = serveForm("GET", "/oauth/authorize", form, "")
+	if rec.Code != http.StatusBadRequest || len(rec.Header().Get("Location")) > 0 {
+		t.Errorf("An unregistered redirect URI should not be redirected to: %d", rec.Code)
+	}
+
+	//The others go back to the app
+	form = authorizeForm(clientID, "", verifier)
+	form.Del("code_challenge")
+	rec = serveForm("GET", "/oauth/authorize", form, "")
+	if rec.Code != http.StatusFound || !strings.HasPrefix(rec.Header().Get("Location"), testRedirectURI+"?error=invalid_request") {
+		t.Errorf("A request without PKCE should be redirected with invalid_request: %d [%s]", rec.Code, rec.Header().Get("Location"))
+	}
+
+	rec = serveForm("GET", "/oauth/authorize", authorizeForm(clientID, "GET_USER", verifier), "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Admin App") || rec.Header().Get("X-Frame-Options") != "DENY" {
+		t.Errorf("The login page was not shown: %d [%s]", rec.Code, rec.Body.String())
+	}
+
+	form = authorizeForm(clientID, "GET_USER", verifier)
+	form.Set("username", "superuser")
+	form.Set("password", "WRONG")
+	rec = serveForm("POST", "/oauth/authorize", form, "")
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "wrong") {
+		t.Errorf("A wrong password should show the page again: %d", rec.Code)
+	}
+
+	//A wrong verifier uses the code up
+	code := loginForCode(t, clientID, "GET_USER", verifier)
+	tokenForm := url.Values{"grant_type": {GrantTypeAuthorizationCode}, "client_id": {clientID}, "code": {code}, "redirect_uri": {testRedirectURI}, "code_verifier": {strings.Repeat("9", 50)}}
+	rec = serveForm("POST", "/oauth/token", tokenForm, "")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), oauthInvalidGrant) {
+		t.Errorf("A wrong verifier should be an invalid grant: %d [%s]", rec.Code, rec.Body.String())
+	}
+
+	code = loginForCode(t, clientID, "GET_USER", verifier)
+	tokenForm.Set("code", code)
+	tokenForm.Set("code_verifier", verifier)
+	rec = serveForm("POST", "/oauth/token", tokenForm, "")
+	var trsp tokenResp
+	json.Unmarshal(rec.Body.Bytes(), &trsp)
+	if rec.Code != http.StatusOK || len(trsp.RefreshToken) == 0 || trsp.Scope != "GET_USER" {
+		t.Errorf("The code was not exchanged: %d [%s]", rec.Code, rec.Body.String())
+		return
+	}
+
+	//The session is limited to its scope, even for the superuser
+	rec = serveForm("GET", "/jwt/users/0/10", nil, trsp.AccessToken)
+	if rec.Code != http.StatusOK {
+		t.Errorf("The session should list the users: %d [%s]", rec.Code, rec.Body.String())
+	}
+	rec = serveForm("GET", "/jwt/role/0/10", nil, trsp.AccessToken)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("The session should not list the roles: %d [%s]", rec.Code, rec.Body.String())
+	}
+
+	//Nor does it reach the routes of the user's own sessions, or revoke
+	//the tokens of another login
+	rec = serveForm("DELETE", "/jwt/sessions/self", nil, trsp.AccessToken)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("The session should not end the sessions of the user: %d [%s]", rec.Code, rec.Body.String())
+	}
+	rec = serveForm("POST", "/oauth/revoke", url.Values{"token": {lrsp.SessionToken}}, trsp.AccessToken)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("The session should not revoke another login: %d [%s]", rec.Code, rec.Body.String())
+	}
+
+	//Only the client refreshes its session, the scope is kept
+	other := insertClientBL(ctx, company.ID.Hex(), &clientObj{Name: "Other", RedirectURIs: []string{testRedirectURI}, Scopes: []string{"GET_ROLE"}})
+	refreshForm := url.Values{"grant_type": {GrantTypeRefreshToken}, "client_id": {other.Client.ID}, "client_secret": {other.ClientSecret}, "refresh_token": {trsp.RefreshToken}}
+	rec = serveForm("POST", "/oauth/token", refreshForm, "")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), oauthInvalidGrant) {
+		t.Errorf("Another client should not refresh the session: %d [%s]", rec.Code, rec.Body.String())
+	}
+
+	//Nor does the company refresh of the sessions without a client
+	if refreshBL(ctx, refreshReq{RefreshToken: trsp.RefreshToken}).Status != StatusFailure {
+		t.Errorf("The session of the client was refreshed without the client")
+	}
+
+	refreshForm = url.Values{"grant_type": {GrantTypeRefreshToken}, "client_id": {clientID}, "refresh_token": {trsp.RefreshToken}}
+	rec = serveForm("POST", "/oauth/token", refreshForm, "")
+	var refreshed tokenResp
+	json.Unmarshal(rec.Body.Bytes(), &refreshed)
+	if rec.Code != http.StatusOK || refreshed.Scope != "GET_USER" || refreshed.AccessToken == trsp.AccessToken {
+		t.Errorf("The session was not refreshed: %d [%s]", rec.Code, rec.Body.String())
+		return
+	}
+	rec = serveForm("GET", "/jwt/role/0/10", nil, refreshed.AccessToken)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("The refreshed session should keep the scope: %d", rec.Code)
+	}
+
+	irsp := introspectBL(ctx, &model.User{CompanyID: company.ID.Hex()}, refreshed.AccessToken)
+	if !irsp.Active || irsp.ClientID != clientID || irsp.Scope != "GET_USER" || len(irsp.Permissions) != 1 {
+		t.Errorf("The introspection should show the client and the scope: %+v", irsp)
+	}
+
+	//The code exchanged again revokes the session it started
+	rec = serveForm("POST", "/oauth/token", tokenForm, "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("The code should not be exchanged twice: %d [%s]", rec.Code, rec.Body.String())
+	}
+	rec = serveForm("GET", "/jwt/users/0/10", nil, refreshed.AccessToken)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("The session of a reused code should be revoked: %d", rec.Code)
+	}
+
+	//A user holding none of the scopes is denied
+	clerk := model.NewUser()
+	clerk.CompanyID = company.ID.Hex()
+	clerk.Username = "clerk"
+	clerk.SetPassword("@123ABC789")
+	model.InsertUser(ctx, clerk)
+
+	form = authorizeForm(clientID, "", verifier)
+	form.Set("username", "clerk")
+	form.Set("password", "@123ABC789")
+	rec = serveForm("POST", "/oauth/authorize", form, "")
+	if rec.Code != http.StatusFound || !strings.Contains(rec.Header().Get("Location"), "error=access_denied") {
+		t.Errorf("A user without the scopes should be denied: %d [%s]", rec.Code, rec.Header().Get("Location"))
+	}
+
+	//The session of the app revokes its own login
+	tokenForm.Set("code", loginForCode(t, clientID, "GET_USER", verifier))
+	rec = serveForm("POST", "/oauth/token", tokenForm, "")
+	var own tokenResp
+	json.Unmarshal(rec.Body.Bytes(), &own)
+	rec = serveForm("POST", "/oauth/revoke", url.Values{"token": {own.RefreshToken}}, own.AccessToken)
+	if rec.Code != http.StatusOK {
+		t.Errorf("The session should revoke its own login: %d [%s]", rec.Code, rec.Body.String())
+	}
+	rec = serveForm("GET", "/jwt/users/0/10", nil, own.AccessToken)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("The revoked session is still valid: %d", rec.Code)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+)
+
+//loginTemplate - The login page of the authorization code grant. It has no
+//scripts and loads nothing, the parameters of the request are sent back
+//as hidden fields.
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in{{if .Company}} - {{.Company}}{{end}}</title>
+<style>
+body { font-family: sans-serif; background: #f2f2f2; margin: 0; }
+main { max-width: 22rem; margin: 4rem auto; padding: 2rem; background: #fff; border-radius: 4px; }
+h1 { font-size: 1.4rem; margin-top: 0; }
+label { display: block; margin-top: 1rem; }
+input { box-sizing: border-box; width: 100%; padding: .5rem; margin-top: .25rem; }
+button { width: 100%; margin-top: 1.5rem; padding: .6rem; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+<main>
+<h1>{{if .Company}}{{.Company}}{{else}}Sign in{{end}}</h1>
+{{if .Application}}<p>Sign in to continue to {{.Application}}.</p>{{end}}
+{{if .Error}}<p class="error" role="alert">{{.Error}}</p>{{end}}
+{{if .ShowForm}}
+<form method="post" action="authorize">
+<input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+<input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Request.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+<label for="username">Username</label>
+<input id="username" name="username" value="{{.Username}}" autocomplete="username" required autofocus>
+<label for="password">Password</label>
+<input id="password" name="password" type="password" autocomplete="current-password" required>
+<button type="submit">Sign in</button>
+</form>
+{{end}}
+</main>
+</body>
+</html>
+`))
+
+/*
+writeAuthorizeResponse - Show the login page or send the browser back to
+the app. The page can not be framed, cached or leak the request in the
+Referer.
+*/
+func writeAuthorizeResponse(w http.ResponseWriter, r *http.Request, rsp *authorizeResp) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	if len(rsp.Redirect) > 0 {
+		http.Redirect(w, r, rsp.Redirect, http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.WriteHeader(rsp.Status)
+
+	err := loginTemplate.Execute(w, rsp.Page)
+	if err != nil {
+		log.Printf("The login page could not be written: [%s]", err)
+	}
+}
@@ -0,0 +1,275 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"com/novare/auth/model"
+	"context"
+	"errors"
+	"log"
+	"unicode/utf8"
+)
+
+type clientObj struct {
+	ID            string   `json:"clientID"`
+	Name          string   `json:"name"`
+	Username      string   `json:"username"` //The thing the client acts as, it can not be changed
+	Scopes        []string `json:"scopes"`   //The permissions the client can ask for
+	TokenDuration int64    `json:"tokenDuration"`
+	RedirectURIs  []string `json:"redirectURIs"` //Where the authorization codes are sent
+	Public        bool     `json:"public"`       //An app without a secret, it can not be changed
+	CreatedAt     int64    `json:"createdAt"`
+	Revision      int64    `json:"revision"` //Send it back in If-Match to update the client
+}
+
+type clientResp struct {
+	Status string `json:"status"`
+	apiError
+	Client       clientObj `json:"client"`
+	ClientSecret string    `json:"clientSecret,omitempty"` //Only when the client is registered or its secret rotated
+}
+
+type listClientResp struct {
+	Status string `json:"status"`
+	apiError
+	Clients []clientObj `json:"clients"`
+}
+
+//newClientObj - The client as it is sent, with the username of its thing
+func newClientObj(ctx context.Context, client *model.OAuthClient) clientObj {
+	var obj clientObj
+	obj.ID = client.ClientID()
+	obj.Name = client.Name
+	obj.Scopes = client.Scopes
+	obj.TokenDuration = client.TokenDuration
+	obj.RedirectURIs = client.RedirectURIs
+	obj.Public = client.Public
+	obj.CreatedAt = client.CreatedAt
+	obj.Revision = client.Revision
+
+	if utf8.RuneCountInString(client.UserID) == 0 {
+		return obj
+	}
+
+	user, err := model.FindUserByID(ctx, client.UserID)
+	if err == nil {
+		obj.Username = user.Username
+	}
+	return obj
+}
+
+//findCompanyClient - The client of the company, another company's client
+//is not found
+func findCompanyClient(ctx context.Context, companyID string, clientID string) (*model.OAuthClient, error) {
+	client, err := model.FindOAuthClientByID(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client.CompanyID != companyID {
+		log.Printf("The client [%s] belongs to another company", clientID)
+		return nil, model.ErrNotFound
+	}
+	return client, nil
+}
+
+/*
+insertClientBL - Register a client for a thing of the company, an app with
+redirect URIs or both. The secret is in the response, it can not be read
+again; a public app has none.
+*/
+func insertClientBL(ctx context.Context, companyID string, req *clientObj) *clientResp {
+	var rsp clientResp
+	rsp.Status = StatusFailure
+
+	var userID string
+	if utf8.RuneCountInString(req.Username) > 0 {
+		user, err := model.FindUserByUsernameCompanyID(ctx, req.Username, companyID)
+		if errors.Is(err, model.ErrNotFound) {
+			log.Printf("The thing [%s] of the client does not exist", req.Username)
+			rsp.fail(model.NewInputError("UnknownThing"))
+			return &rsp
+		}
+		if err != nil {
+			rsp.fail(err)
+			return &rsp
+		}
+
+		if !user.IsThing {
+			log.Printf("The user [%s] is not a thing, it can not have a client", req.Username)
+			rsp.fail(model.NewInputError("NotAThing"))
+			return &rsp
+		}
+		userID = user.ID.Hex()
+	}
+
+	client := model.NewOAuthClient(companyID, userID)
+	client.Name = req.Name
+	client.Scopes = req.Scopes
+	client.TokenDuration = req.TokenDuration
+	client.RedirectURIs = req.RedirectURIs
+	client.Public = req.Public
+
+	var secret string
+	var err error
+	if !client.Public {
+		secret, err = client.SetSecret()
+		if err != nil {
+			log.Printf("The client secret could not be generated: [%s]", err)
+			rsp.fail(err)
+			return &rsp
+		}
+	}
+
+	err = model.InsertOAuthClient(ctx, client)
+	if err != nil {
+		log.Printf("The client could not be registered: [%s]", err)
+		rsp.fail(err)
+		return &rsp
+	}
+
+	rsp.Status = StatusSuccess
+	rsp.Client = newClientObj(ctx, client)
+	rsp.ClientSecret = secret
+	return &rsp
+}
+
+//updateClientBL - Change the name, the scopes, the token duration and the
+//redirect URIs of the client
+func updateClientBL(ctx context.Context, companyID string, clientID string, ifMatch int64, req *clientObj) *clientResp {
+	var rsp clientResp
+	rsp.Status = StatusFailure
+
+	client, err := findCompanyClient(ctx, companyID, clientID)
+	if err != nil {
+		rsp.fail(err)
+		return &rsp
+	}
+
+	err = checkRevision(ifMatch, client.Revision)
+	if err != nil {
+		log.Printf("The client [%s] is at revision %d, the update expected %d", clientID, client.Revision, ifMatch)
+		rsp.fail(err)
+		return &rsp
+	}
+
+	client.Name = req.Name
+	client.Scopes = req.Scopes
+	client.TokenDuration = req.TokenDuration
+	client.RedirectURIs = req.RedirectURIs
+
+	err = model.SaveOAuthClient(ctx, client)
+	if err != nil {
+		log.Printf("The client [%s] could not be saved: [%s]", clientID, err)
+		rsp.fail(err)
+		return &rsp
+	}
+
+	rsp.Status = StatusSuccess
+	rsp.Client = newClientObj(ctx, client)
+	return &rsp
+}
+
+//rotateClientSecretBL - Replace the secret of the client, the old one
+//stops working right away
+func rotateClientSecretBL(ctx context.Context, companyID string, clientID string) *clientResp {
+	var rsp clientResp
+	rsp.Status = StatusFailure
+
+	client, err := findCompanyClient(ctx, companyID, clientID)
+	if err != nil {
+		rsp.fail(err)
+		return &rsp
+	}
+
+	if client.Public {
+		log.Printf("The client [%s] is public, it has no secret", clientID)
+		rsp.fail(model.NewInputError("PublicClient"))
+		return &rsp
+	}
+
+	secret, err := client.SetSecret()
+	if err != nil {
+		log.Printf("The client secret could not be generated: [%s]", err)
+		rsp.fail(err)
+		return &rsp
+	}
+
+	err = model.SaveOAuthClient(ctx, client)
+	if err != nil {
+		log.Printf("The secret of the client [%s] could not be saved: [%s]", clientID, err)
+		rsp.fail(err)
+		return &rsp
+	}
+
+	rsp.Status = StatusSuccess
+	rsp.Client = newClientObj(ctx, client)
+	rsp.ClientSecret = secret
+	return &rsp
+}
+
+//removeClientBL - Remove the client, the tokens it has live until they
+//expire
+func removeClientBL(ctx context.Context, companyID string, clientID string) *clientResp {
+	var rsp clientResp
+	rsp.Status = StatusFailure
+
+	client, err := findCompanyClient(ctx, companyID, clientID)
+	if err != nil {
+		rsp.fail(err)
+		return &rsp
+	}
+
+	err = model.RemoveOAuthClientByID(ctx, clientID)
+	if err != nil {
+		log.Printf("The client [%s] could not be removed: [%s]", clientID, err)
+		rsp.fail(err)
+		return &rsp
+	}
+
+	rsp.Status = StatusSuccess
+	rsp.Client = newClientObj(ctx, client)
+	return &rsp
+}
+
+//listClientsBL - The clients registered by the company
+func listClientsBL(ctx context.Context, companyID string) *listClientResp {
+	var rsp listClientResp
+	rsp.Status = StatusFailure
+
+	clients, err := model.ListOAuthClientsByCompanyID(ctx, companyID)
+	if err != nil {
+		log.Printf("The clients of the company [%s] could not be listed: [%s]", companyID, err)
+		rsp.fail(err)
+		return &rsp
+	}
+
+	rsp.Clients = []clientObj{}
+	for i := range clients {
+		rsp.Clients = append(rsp.Clients, newClientObj(ctx, &clients[i]))
+	}
+
+	rsp.Status = StatusSuccess
+	return &rsp
+}
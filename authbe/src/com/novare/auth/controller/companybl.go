@@ -29,8 +29,8 @@ import (
 	"com/novare/auth/model"
 	"com/novare/auth/sse"
 	"com/novare/utils"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -38,7 +38,7 @@ import (
 	"strings"
 	"unicode/utf8"
 
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func isValidRemotelyManaged(req createCompanyReq) error {
@@ -51,24 +51,26 @@ func isValidRemotelyManaged(req createCompanyReq) error {
 		log.Printf("The company with ID: %s is supposed to be remotely managed", req.UniqueID)
 		if utf8.RuneCountInString(req.AuthRelay) < 10 {
 			log.Printf("The remote Auth host name is not valid")
-			return errors.New("InvalidAuthRelay")
+			return model.NewInputError("InvalidAuthRelay")
 		}
 
 		if utf8.RuneCountInString(req.APIKey) == 0 {
 			log.Printf("The remote APIKey was not properly populated")
-			return errors.New("InvalidAPIKey")
+			return model.NewInputError("InvalidAPIKey")
 		}
 
 		if utf8.RuneCountInString(req.GroupOwnerID) == 0 {
 			log.Printf("The GroupOwnerID was not defined for the request, the field is required for remote configuration")
-			return errors.New("InvalidGroupOwner")
+			return model.NewInputError("InvalidGroupOwner")
 		}
 	}
 
 	return nil
 }
 
-func requestRemoteCompanyBL(req createCompanyReq) *createCompanyResp {
+//requestRemoteCompanyBL - Register the company with the remote auth relay
+//(POST) or undo the registration (DELETE)
+func requestRemoteCompanyBL(ctx context.Context, method string, req createCompanyReq) *createCompanyResp {
 
 	var resp createCompanyResp
 	resp.Status = StatusFailure
@@ -78,17 +80,29 @@ func requestRemoteCompanyBL(req createCompanyReq) *createCompanyResp {
 	buf, err := json.Marshal(req)
 	if err != nil {
 		log.Printf("An error occurred while processing the error response: [%s]", err)
+		resp.fail(err)
 		return &resp
 	}
 
-	r, err := http.Post(url, "application/json", bytes.NewReader(buf))
+	hreq, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(buf))
 	if err != nil {
 		log.Printf("The request was not properly created! ERROR: [%s]", err)
+		resp.fail(err)
 		return &resp
 	}
+	hreq.Header.Set("Content-Type", "application/json")
+
+	r, err := http.DefaultClient.Do(hreq)
+	if err != nil {
+		log.Printf("The request was not properly created! ERROR: [%s]", err)
+		resp.fail(errRemoteFailure)
+		return &resp
+	}
+	defer r.Body.Close()
 
 	if r.StatusCode != http.StatusOK {
 		log.Printf("The server replied with the status code: %d", r.StatusCode)
+		resp.fail(errRemoteFailure)
 		return &resp
 	}
 
@@ -97,6 +111,7 @@ func requestRemoteCompanyBL(req createCompanyReq) *createCompanyResp {
 	if err != nil {
 		log.Printf("The response did not contain a valid JSON object. [%s]", err)
 		resp.Status = StatusFailure
+		resp.fail(errRemoteFailure)
 		return &resp
 	}
 
@@ -104,27 +119,24 @@ func requestRemoteCompanyBL(req createCompanyReq) *createCompanyResp {
 	return &resp
 }
 
-func createCompanyBL(req createCompanyReq) *createCompanyResp {
+/*
+createCompanyBL - The remote registration, the company, its superuser and
+the default permissions and role are created as one unit of work. When a
+step fails the completed ones are undone, the remote registration
+included, so neither side is left with half a company.
+*/
+func createCompanyBL(ctx context.Context, req createCompanyReq) *createCompanyResp {
 
 	company := model.NewCompany()
 	var r createCompanyResp
 	r.Status = StatusFailure
 
-	if isValidRemotelyManaged(req) != nil {
+	err := isValidRemotelyManaged(req)
+	if err != nil {
+		r.fail(err)
 		return &r
 	}
 
-	flagStr := strings.ToLower(req.RemotelyManaged)
-	if flagStr == "true" || flagStr == "yes" || flagStr == "y" {
-		remRsp := requestRemoteCompanyBL(req)
-		if remRsp.Status != StatusSuccess {
-			return remRsp
-		}
-		//We will use the ID from the remote server
-		log.Printf("Overwritting the ID with the remote server ID")
-		company.ID = bson.ObjectIdHex(remRsp.CompanyID)
-	}
-
 	log.Printf("Parsing the request")
 	company.Name = req.Name
 	company.Address1 = req.Address1
@@ -154,38 +166,37 @@ func createCompanyBL(req createCompanyReq) *createCompanyResp {
 
 	if req.Password != req.ConfirmPassword {
 		r.Status = StatusPasswordMismatch
+		r.fail(model.NewInputError(StatusPasswordMismatch))
 		return &r
 	}
 
-	//First we need to check if the unique ID is found
-	c, err := model.FindCompanyByUniqueID(req.UniqueID)
-	if err == nil {
-		log.Printf("The company with UniqueID:[%s] already exists:[%s]", c.UniqueID, c.ID.Hex())
-		return &r
-	}
-
-	err = model.InsertCompany(company)
-	if err != nil {
-		log.Printf("The Company was not inserted, an error occurred: [%s]", err)
-		return &r
-	}
-
-	//Since creating the company was successful, we need to save a superuser for it now
-	//if saving the account does not work, we will remove the Company
+	//The superuser is validated before anything is created
 	user := model.NewUser()
 	user.Username = "superuser"
-	user.CompanyID = company.ID.Hex()
 	err = user.SetPassword(req.Password)
 	if err != nil {
-		log.Printf("An error occurred while processing the request to insert the superuser. Removing the company with ID:[%s]", company.ID.Hex())
-		model.RemoveCompanyByID(company.ID.Hex())
+		log.Printf("The superuser password is not valid: [%s]", err)
+		r.fail(err)
+		return &r
+	}
+
+	//First we need to check if the unique ID is found
+	c, err := model.FindCompanyByUniqueID(ctx, req.UniqueID)
+	if err == nil {
+		log.Printf("The company with UniqueID:[%s] already exists:[%s]", c.UniqueID, c.ID.Hex())
+		r.fail(model.ErrDuplicate)
 		return &r
 	}
 
-	err = model.InsertUser(user)
+	uow := model.NewUnitOfWork()
+	err = bootstrapCompany(ctx, uow, req, company, user)
 	if err != nil {
-		log.Printf("There was an error saving the superuser, aborting the request and removing the company with ID: [%s]", company.ID.Hex())
-		model.RemoveCompanyByID(company.ID.Hex())
+		log.Printf("The company with UniqueID:[%s] was not created, undoing the completed steps", req.UniqueID)
+		rerr := uow.Rollback()
+		if rerr != nil {
+			log.Printf("ORPHANED DATA: the company with UniqueID:[%s] could not be completely removed: [%s]", req.UniqueID, rerr)
+		}
+		r.fail(err)
 		return &r
 	}
 
@@ -197,13 +208,82 @@ func createCompanyBL(req createCompanyReq) *createCompanyResp {
 	return &r
 }
 
-func getCompanyByUniqueIDOL(uniqueID string) *getCompanyResponse {
+//bootstrapCompany - The steps of createCompanyBL, every one of them
+//registers how to undo it
+func bootstrapCompany(ctx context.Context, uow *model.UnitOfWork, req createCompanyReq, company *model.Company, user *model.User) error {
+
+	if company.RemotelyManaged {
+		err := uow.Do(ctx, "RemoteRegistration", func(ctx context.Context) error {
+			remRsp := requestRemoteCompanyBL(ctx, http.MethodPost, req)
+			if remRsp.Status != StatusSuccess {
+				return errRemoteFailure
+			}
+
+			//We will use the ID from the remote server
+			log.Printf("Overwritting the ID with the remote server ID")
+			remoteID, err := primitive.ObjectIDFromHex(remRsp.CompanyID)
+			if err != nil {
+				log.Printf("The remote server replied with an invalid company ID:[%s]", remRsp.CompanyID)
+				return errRemoteFailure
+			}
+			company.ID = remoteID
+			return nil
+		}, func(ctx context.Context) error {
+			return requestRemoteCompanyBL(ctx, http.MethodDelete, req).failure()
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err := uow.Do(ctx, "InsertCompany", func(ctx context.Context) error {
+		return model.InsertCompany(ctx, company)
+	}, func(ctx context.Context) error {
+		return model.RemoveCompanyByID(ctx, company.ID.Hex())
+	})
+	if err != nil {
+		return err
+	}
+
+	user.CompanyID = company.ID.Hex()
+	err = uow.Do(ctx, "InsertSuperuser", func(ctx context.Context) error {
+		return model.InsertUser(ctx, user)
+	}, func(ctx context.Context) error {
+		return model.RemoveUserByID(ctx, user.ID.Hex())
+	})
+	if err != nil {
+		return err
+	}
+
+	perms := model.NewDefaultPermissions(company.ID.Hex())
+	for i := range perms {
+		perm := perms[i]
+		err = uow.Do(ctx, "InsertPermission "+perm.Permission, func(ctx context.Context) error {
+			return model.InsertPermission(ctx, perm)
+		}, func(ctx context.Context) error {
+			return model.RemovePermissionByID(ctx, perm.ID.Hex())
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	role := model.NewDefaultRole(company.ID.Hex(), perms)
+	return uow.Do(ctx, "InsertDefaultRole", func(ctx context.Context) error {
+		return model.InsertRole(ctx, role)
+	}, func(ctx context.Context) error {
+		return model.RemoveRoleByID(ctx, role.ID.Hex())
+	})
+}
+
+func getCompanyByUniqueIDOL(ctx context.Context, uniqueID string) *getCompanyResponse {
 	var rsp getCompanyResponse
 	rsp.Status = StatusFailure
 
-	company, err := model.FindCompanyByUniqueID(uniqueID)
+	company, err := model.FindCompanyByUniqueID(ctx, uniqueID)
 	if err != nil {
 		log.Printf("Error retrieving the company with unique ID: [%s]", uniqueID)
+		rsp.fail(err)
 		return &rsp
 	}
 
@@ -222,6 +302,7 @@ func getCompanyByUniqueIDOL(uniqueID string) *getCompanyResponse {
 	rsp.CompanyID = company.ID.Hex()
 	rsp.RegisCode = fmt.Sprintf("%06d", company.RegisCode)
 	rsp.GroupOwnerID = company.GroupOwnerID
+	rsp.Revision = company.Revision
 
 	//Set the status
 	rsp.Status = StatusSuccess
@@ -231,9 +312,9 @@ func getCompanyByUniqueIDOL(uniqueID string) *getCompanyResponse {
 	return &rsp
 }
 
-func isCompanyUniqueIDTaken(uniqueID string) bool {
+func isCompanyUniqueIDTaken(ctx context.Context, uniqueID string) bool {
 
-	_, err := model.FindCompanyByUniqueID(uniqueID)
+	_, err := model.FindCompanyByUniqueID(ctx, uniqueID)
 	if err == nil {
 		log.Printf("The company with ID:[%s] already exists", uniqueID)
 		return true
@@ -242,7 +323,7 @@ func isCompanyUniqueIDTaken(uniqueID string) bool {
 	return false
 }
 
-func suggestCompanyUniqueIDBL(uniqueID string) *checkSuggestIDResp {
+func suggestCompanyUniqueIDBL(ctx context.Context, uniqueID string) *checkSuggestIDResp {
 
 	if utf8.RuneCountInString(uniqueID) == 0 {
 		log.Printf("Creating a unique identifier")
@@ -251,7 +332,7 @@ func suggestCompanyUniqueIDBL(uniqueID string) *checkSuggestIDResp {
 
 	tmpID := uniqueID
 	count := 1
-	for isCompanyUniqueIDTaken(tmpID) {
+	for isCompanyUniqueIDTaken(ctx, tmpID) {
 		log.Printf("The uniqueID: %s has already been taken", tmpID)
 		tmpID = fmt.Sprintf("%s%d", uniqueID, count)
 		count++
@@ -265,13 +346,21 @@ func suggestCompanyUniqueIDBL(uniqueID string) *checkSuggestIDResp {
 
 }
 
-func updateCompanyBL(req *updateCompanyReq) *updateCompanyResponse {
+func updateCompanyBL(ctx context.Context, ifMatch int64, req *updateCompanyReq) *updateCompanyResponse {
 
 	var rsp updateCompanyResponse
 	rsp.Status = StatusFailure
 
-	companyModel, err := model.FindCompanyByUniqueID(req.UniqueID)
+	companyModel, err := model.FindCompanyByUniqueID(ctx, req.UniqueID)
+	if err != nil {
+		rsp.fail(err)
+		return &rsp
+	}
+
+	err = checkRevision(ifMatch, companyModel.Revision)
 	if err != nil {
+		log.Printf("The company [%s] is at revision %d, the update expected %d", req.UniqueID, companyModel.Revision, ifMatch)
+		rsp.fail(err)
 		return &rsp
 	}
 
@@ -295,52 +384,49 @@ func updateCompanyBL(req *updateCompanyReq) *updateCompanyResponse {
 	companyModel.Zip = req.Zip
 	companyModel.APIKey = req.APIKey
 
-	err = model.SaveCompany(companyModel)
+	err = model.SaveCompany(ctx, companyModel)
 	if err != nil {
 		log.Printf("Error saving the Company with UniqueID: [%s]", err)
+		rsp.fail(err)
 		return &rsp
 	}
 
 	rsp.Status = StatusSuccess
 	rsp.UpdateCompanyReq = *req
+	rsp.Revision = companyModel.Revision
 
 	publishEvent(sse.EventCompanyUpdate, "Update")
 
 	return &rsp
 }
 
-func remoteCompanyInsertBL(apiKey string, groupOwnerID string, req createCompanyReq) *createCompanyResp {
-	var rsp createCompanyResp
-	rsp.Status = StatusFailure
+//findRemoteCompany - The company a site asks to register or unregister.
+//It must belong to the group owner and the API key and the registration
+//code must match.
+func findRemoteCompany(ctx context.Context, apiKey string, groupOwnerID string, req createCompanyReq) (*model.Company, error) {
 
-	groupOwner, err := model.FindCompanyByID(groupOwnerID)
+	groupOwner, err := model.FindCompanyByID(ctx, groupOwnerID)
 	if err != nil {
 		log.Printf("There was an error retrieving the company with ID:[%s]", groupOwnerID)
-		return &rsp
+		return nil, err
 	}
 
-	ownedCompany, err := model.FindCompanyByUniqueID(req.UniqueID)
+	ownedCompany, err := model.FindCompanyByUniqueID(ctx, req.UniqueID)
 	if err != nil {
 		log.Printf("The company owned by GROUPOWNER: %s and UniqueID %s does not exist", groupOwnerID, req.UniqueID)
-		return &rsp
+		return nil, err
 	}
 
 	//Does the company belong to the group owner.
 	if ownedCompany.GroupOwnerID != groupOwner.ID.Hex() {
 		log.Printf("The group owner ID is not valid, aborting it now!")
-		return &rsp
+		return nil, errForbidden
 	}
 
 	//The APIKey must match
 	if ownedCompany.APIKey != apiKey {
 		log.Printf("The APIKey and the group owner API Key do not match")
-		return &rsp
-	}
-
-	//The client must not be registered
-	if ownedCompany.IsClientRegistered() {
-		log.Printf("The client has already been registered!")
-		return &rsp
+		return nil, errInvalidCredentials
 	}
 
 	//The registration code must match
@@ -350,13 +436,34 @@ func remoteCompanyInsertBL(apiKey string, groupOwnerID string, req createCompany
 	}
 	if ownedCompany.GetRegistrationCode() != int(regisCode) {
 		log.Printf("The registration code stored and the registration code provided don't match!")
+		return nil, errInvalidCredentials
+	}
+
+	return ownedCompany, nil
+}
+
+func remoteCompanyInsertBL(ctx context.Context, apiKey string, groupOwnerID string, req createCompanyReq) *createCompanyResp {
+	var rsp createCompanyResp
+	rsp.Status = StatusFailure
+
+	ownedCompany, err := findRemoteCompany(ctx, apiKey, groupOwnerID, req)
+	if err != nil {
+		rsp.fail(err)
+		return &rsp
+	}
+
+	//The client must not be registered
+	if ownedCompany.IsClientRegistered() {
+		log.Printf("The client has already been registered!")
+		rsp.fail(model.ErrConflict)
 		return &rsp
 	}
 
 	ownedCompany.SetClientRegistered(true)
-	err = model.SaveCompany(ownedCompany)
+	err = model.SaveCompany(ctx, ownedCompany)
 	if err != nil {
 		log.Printf("An error occurred while attempting to save the owned company")
+		rsp.fail(err)
 		return &rsp
 	}
 
@@ -367,19 +474,53 @@ func remoteCompanyInsertBL(apiKey string, groupOwnerID string, req createCompany
 	return &r
 }
 
-func getCompaniesForGroupID(groupIDOwner string, user *model.User) *respCompanyByGroupOwner {
+//remoteCompanyRemoveBL - Undo remoteCompanyInsertBL. The site calls it
+//when it could not finish creating the company after registering it.
+func remoteCompanyRemoveBL(ctx context.Context, apiKey string, groupOwnerID string, req createCompanyReq) *createCompanyResp {
+	var rsp createCompanyResp
+	rsp.Status = StatusFailure
+
+	ownedCompany, err := findRemoteCompany(ctx, apiKey, groupOwnerID, req)
+	if err != nil {
+		rsp.fail(err)
+		return &rsp
+	}
+
+	if !ownedCompany.IsClientRegistered() {
+		log.Printf("The client with UniqueID:[%s] is not registered, nothing to undo", req.UniqueID)
+		rsp.Status = StatusSuccess
+		rsp.CompanyID = ownedCompany.ID.Hex()
+		return &rsp
+	}
+
+	ownedCompany.ReleaseRegistration()
+	err = model.SaveCompany(ctx, ownedCompany)
+	if err != nil {
+		log.Printf("An error occurred while releasing the registration of the owned company: [%s]", err)
+		rsp.fail(err)
+		return &rsp
+	}
+
+	rsp.Status = StatusSuccess
+	rsp.CompanyID = ownedCompany.ID.Hex()
+	return &rsp
+}
+
+func getCompaniesForGroupID(ctx context.Context, groupIDOwner string, user *model.User) *respCompanyByGroupOwner {
 
 	rsp := new(respCompanyByGroupOwner)
 	rsp.Status = StatusFailure
 
 	if user.CompanyID != groupIDOwner {
 		log.Printf("The user is not authorized to access information for this Company")
+		rsp.fail(errForbidden)
 		return rsp
 	}
 
-	companies, err := model.ListCompaniesByGroupID(groupIDOwner)
+	companies, err := model.ListCompaniesByGroupID(ctx, groupIDOwner)
 	if err != nil {
 		log.Printf("The application failed to retrieve the companies for Group ID:[%s] with Error:[%s]", groupIDOwner, err)
+		rsp.fail(err)
 		return rsp
 	}
 
@@ -396,6 +537,13 @@ func getCompaniesForGroupID(groupIDOwner string, user *model.User) *respCompanyB
 		ci.Settings.JWTDuration = c.Settings.JWTDuration
 		ci.Settings.PassExpiration = c.Settings.PassExpiration
 		ci.Settings.PassUnit = c.Settings.PassUnit
+		ci.Settings.SigningKey = c.Settings.SigningKey
+		ci.Settings.SigningAlgorithm = c.Settings.SigningAlgorithm
+		ci.Settings.SessionDuration = c.Settings.SessionDuration
+		ci.Settings.RefreshDuration = c.Settings.RefreshDuration
+		ci.Settings.MaxSessions = c.Settings.MaxSessions
+		ci.Settings.SessionLimit = c.Settings.SessionLimit
+		ci.Settings.IdleTimeout = c.Settings.IdleTimeout
 		ci.State = c.State
 		ci.UniqueID = c.UniqueID
 		ci.Zip = c.Zip
@@ -407,27 +555,30 @@ func getCompaniesForGroupID(groupIDOwner string, user *model.User) *respCompanyB
 	return rsp
 }
 
-func enableRegistrationBL(user *model.User, companyID string) *regResp {
+func enableRegistrationBL(ctx context.Context, user *model.User, companyID string) *regResp {
 	rsp := new(regResp)
 	rsp.Status = StatusFailure
 
 	//Find the company
-	company, err := model.FindCompanyByID(companyID)
+	company, err := model.FindCompanyByID(ctx, companyID)
 	if err != nil {
 		log.Printf("The company with ID: %s was not found!", companyID)
+		rsp.fail(err)
 		return rsp
 	}
 
 	//Both worked!
 	if company.GroupOwnerID != user.CompanyID {
 		log.Printf("The group owner and the user's company ID don't match")
+		rsp.fail(errForbidden)
 		return rsp
 	}
 
 	company.SetClientRegistered(false)
-	err = model.SaveCompany(company)
+	err = model.SaveCompany(ctx, company)
 	if err != nil {
 		log.Printf("The company:[%s] could not be saved, the following error occurred:[%s]", companyID, err)
+		rsp.fail(err)
 		return rsp
 	}
 
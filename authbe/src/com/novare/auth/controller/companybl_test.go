@@ -26,10 +26,16 @@ package controller
 
 import (
 	"com/novare/auth/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
 func TestCreateCompanyBL(t *testing.T) {
+	ctx := context.Background()
 
 	var req createCompanyReq
 	req.Address1 = "My Address"
@@ -45,7 +51,7 @@ func TestCreateCompanyBL(t *testing.T) {
 	req.UniqueID = "THISISUNIQUEID"
 	req.ConfirmPassword = req.Password
 
-	rsp := createCompanyBL(req)
+	rsp := createCompanyBL(ctx, req)
 	if rsp.Status != StatusFailure {
 		t.Errorf("The password is not secure enough, the company should not have been saved")
 		return
@@ -53,26 +59,36 @@ func TestCreateCompanyBL(t *testing.T) {
 
 	req.Password = "@123ABC789"
 	req.ConfirmPassword = req.Password
-	rsp = createCompanyBL(req)
+	rsp = createCompanyBL(ctx, req)
 	if rsp.Status != StatusSuccess {
 		t.Errorf("The company should have been created but it did not!")
 		return
 	}
 
-	users, err := model.ListUsersByCompanyID(rsp.CompanyID)
+	perms, err := model.ListPermissionsByCompanyID(ctx, rsp.CompanyID)
+	if err != nil || len(perms) != len(model.DefaultPermissions) {
+		t.Errorf("The default permissions were not created: %d [%v]", len(perms), err)
+	}
+
+	roles, err := model.ListRolesByCompanyID(ctx, rsp.CompanyID)
+	if err != nil || len(roles) != 1 || roles[0].Description != model.DefaultRoleName || len(roles[0].Permissions) != len(perms) {
+		t.Errorf("The default role was not created: %v [%v]", roles, err)
+	}
+
+	users, err := model.ListUsersByCompanyID(ctx, rsp.CompanyID)
 	if err != nil {
 		for i := range users {
-			model.RemoveUserByID(users[i].ID.Hex())
+			model.RemoveUserByID(ctx, users[i].ID.Hex())
 		}
 	}
 
-	company, err := model.FindCompanyByID(rsp.CompanyID)
+	company, err := model.FindCompanyByID(ctx, rsp.CompanyID)
 	if err != nil {
 		t.Errorf("The following error occurred:[%s]", err)
 		return
 	}
 
-	err = model.RemoveCompanyByID(company.ID.Hex())
+	err = model.RemoveCompanyByID(ctx, company.ID.Hex())
 	if err != nil {
 		t.Errorf("Removing the company failed with error: [%s]", err)
 		return
@@ -81,6 +97,7 @@ func TestCreateCompanyBL(t *testing.T) {
 }
 
 func TestGetCompanyBL(t *testing.T) {
+	ctx := context.Background()
 
 	var req createCompanyReq
 	req.Address1 = "My Address"
@@ -96,19 +113,19 @@ func TestGetCompanyBL(t *testing.T) {
 	req.UniqueID = "THISISTHEUNIQUEID"
 	req.ConfirmPassword = req.Password
 
-	rsp := createCompanyBL(req)
+	rsp := createCompanyBL(ctx, req)
 	if rsp.Status == StatusFailure {
 		t.Errorf("The password is not secure enough, the company should not have been saved")
 		return
 	}
 
-	findCmp := getCompanyByUniqueIDOL(req.UniqueID)
+	findCmp := getCompanyByUniqueIDOL(ctx, req.UniqueID)
 	if findCmp.UniqueID != req.UniqueID {
 		t.Errorf("No company found for unique ID specified")
 		return
 	}
 
-	users, err := model.ListUsersByCompanyID(rsp.CompanyID)
+	users, err := model.ListUsersByCompanyID(ctx, rsp.CompanyID)
 
 	if err != nil {
 		t.Errorf("There was an issue retrieving all the users for company with ID: [%s]", rsp.CompanyID)
@@ -116,14 +133,14 @@ func TestGetCompanyBL(t *testing.T) {
 	}
 
 	for i := range users {
-		err = model.RemoveUserByID(users[i].ID.Hex())
+		err = model.RemoveUserByID(ctx, users[i].ID.Hex())
 		if err != nil {
 			t.Errorf("The following error:[%s] occurred when removing the users for company ID:[%s]", err, rsp.CompanyID)
 			continue
 		}
 	}
 
-	err = model.RemoveCompanyByID(rsp.CompanyID)
+	err = model.RemoveCompanyByID(ctx, rsp.CompanyID)
 	if err != nil {
 		t.Errorf("The company with ID:[%s] should have been removed but it was not:[%s]", err, rsp.CompanyID)
 	}
@@ -131,7 +148,8 @@ func TestGetCompanyBL(t *testing.T) {
 }
 
 func performCompanyCleanup(companyID string, t *testing.T) {
-	users, err := model.ListUsersByCompanyID(companyID)
+	ctx := context.Background()
+	users, err := model.ListUsersByCompanyID(ctx, companyID)
 
 	if err != nil {
 		t.Errorf("There was an issue retrieving all the users for company with ID: [%s]", companyID)
@@ -139,20 +157,21 @@ func performCompanyCleanup(companyID string, t *testing.T) {
 	}
 
 	for i := range users {
-		err = model.RemoveUserByID(users[i].ID.Hex())
+		err = model.RemoveUserByID(ctx, users[i].ID.Hex())
 		if err != nil {
 			t.Errorf("The following error:[%s] occurred when removing the users for company ID:[%s]", err, companyID)
 			continue
 		}
 	}
 
-	err = model.RemoveCompanyByID(companyID)
+	err = model.RemoveCompanyByID(ctx, companyID)
 	if err != nil {
 		t.Errorf("The company with ID:[%s] should have been removed but it was not:[%s]", err, companyID)
 	}
 }
 
 func TestCompanyAlreadyExists(t *testing.T) {
+	ctx := context.Background()
 
 	var req createCompanyReq
 	req.Address1 = "My Address"
@@ -168,13 +187,13 @@ func TestCompanyAlreadyExists(t *testing.T) {
 	req.UniqueID = "THISISTHEUNIQUEID"
 	req.ConfirmPassword = req.Password
 
-	rsp := createCompanyBL(req)
+	rsp := createCompanyBL(ctx, req)
 	if rsp.Status == StatusFailure {
 		t.Errorf("The password is not secure enough, the company should not have been saved")
 		return
 	}
 
-	if !isCompanyUniqueIDTaken("THISISTHEUNIQUEID") {
+	if !isCompanyUniqueIDTaken(ctx, "THISISTHEUNIQUEID") {
 		t.Error("The logic did not detect the company ID was already taken")
 	}
 
@@ -182,6 +201,7 @@ func TestCompanyAlreadyExists(t *testing.T) {
 }
 
 func TestSuggestUniqueID(t *testing.T) {
+	ctx := context.Background()
 	var req createCompanyReq
 	req.Address1 = "My Address"
 	req.Address2 = "My Address line 2"
@@ -196,13 +216,13 @@ func TestSuggestUniqueID(t *testing.T) {
 	req.UniqueID = "THISISTHEUNIQUEID"
 	req.ConfirmPassword = req.Password
 
-	rsp := createCompanyBL(req)
+	rsp := createCompanyBL(ctx, req)
 	if rsp.Status == StatusFailure {
 		t.Errorf("The password is not secure enough, the company should not have been saved")
 		return
 	}
 
-	suggestedID := suggestCompanyUniqueIDBL(req.UniqueID)
+	suggestedID := suggestCompanyUniqueIDBL(ctx, req.UniqueID)
 	if suggestedID.UniqueID == req.UniqueID {
 		t.Error("The suggestedID is the same as the id already inserted.")
 	}
@@ -211,6 +231,7 @@ func TestSuggestUniqueID(t *testing.T) {
 }
 
 func TestUpdateCompanBL(t *testing.T) {
+	ctx := context.Background()
 	var req createCompanyReq
 	req.Address1 = "My Address"
 	req.Address2 = "My Address line 2"
@@ -225,7 +246,7 @@ func TestUpdateCompanBL(t *testing.T) {
 	req.Password = "@123ABC789"
 	req.ConfirmPassword = req.Password
 
-	rsp := createCompanyBL(req)
+	rsp := createCompanyBL(ctx, req)
 	if rsp.Status != StatusSuccess {
 		t.Errorf("The company should have been created but it did not!")
 		return
@@ -246,13 +267,13 @@ func TestUpdateCompanBL(t *testing.T) {
 
 	ureq.Address1 = "300 Nowhere St."
 	ureq.City = "NowhereCity"
-	ursp := updateCompanyBL(&ureq)
+	ursp := updateCompanyBL(ctx, anyRevision, &ureq)
 
 	if ursp.Status != StatusSuccess {
 		t.Errorf("The response to the request to update the company was not successful!")
 	}
 
-	company, err := model.FindCompanyByUniqueID(ursp.UpdateCompanyReq.UniqueID)
+	company, err := model.FindCompanyByUniqueID(ctx, ursp.UpdateCompanyReq.UniqueID)
 	if err != nil {
 		t.Errorf("There was an error retrieving the company with ID: [%s]", err)
 	}
@@ -262,6 +283,7 @@ func TestUpdateCompanBL(t *testing.T) {
 }
 
 func TestListCompanBL(t *testing.T) {
+	ctx := context.Background()
 	var req createCompanyReq
 	req.Address1 = "My Address"
 	req.Address2 = "My Address line 2"
@@ -276,7 +298,7 @@ func TestListCompanBL(t *testing.T) {
 	req.Password = "@123ABC789"
 	req.ConfirmPassword = req.Password
 
-	rsp := createCompanyBL(req)
+	rsp := createCompanyBL(ctx, req)
 	if rsp.Status != StatusSuccess {
 		t.Errorf("The company should have been created but it did not!")
 		return
@@ -301,7 +323,7 @@ func TestListCompanBL(t *testing.T) {
 
 	ureq.Address1 = "300 Nowhere St."
 	ureq.City = "NowhereCity"
-	ursp := createCompanyBL(ureq)
+	ursp := createCompanyBL(ctx, ureq)
 
 	if ursp.Status != StatusSuccess {
 		t.Errorf("The response to the request to update the company was not successful!")
@@ -309,7 +331,7 @@ func TestListCompanBL(t *testing.T) {
 
 	user := model.NewUser()
 	user.CompanyID = rsp.CompanyID
-	cfgi := getCompaniesForGroupID(rsp.CompanyID, user)
+	cfgi := getCompaniesForGroupID(ctx, rsp.CompanyID, user)
 	if cfgi.Status != StatusSuccess {
 		t.Errorf("The response to retrieve the company for the group owner:[%s] was a failure", rsp.CompanyID)
 	}
@@ -318,17 +340,74 @@ func TestListCompanBL(t *testing.T) {
 		t.Error("The companies should have one company")
 	}
 
-	company, err := model.FindCompanyByID(rsp.CompanyID)
+	company, err := model.FindCompanyByID(ctx, rsp.CompanyID)
 	if err != nil {
 		t.Errorf("There was an error retrieving the company with ID: [%s]", err)
 	}
 
 	performCompanyCleanup(company.ID.Hex(), t)
 
-	company, err = model.FindCompanyByID(ursp.CompanyID)
+	company, err = model.FindCompanyByID(ctx, ursp.CompanyID)
 	if err != nil {
 		t.Errorf("There has been error retrieving the company with ID:[%s]", err)
 	}
 	performCompanyCleanup(company.ID.Hex(), t)
 
 }
+
+func TestCreateCompanyBLRollback(t *testing.T) {
+	ctx := context.Background()
+
+	//The company the remote relay wrongly hands out, inserting a second
+	//company with the same ID fails after the registration
+	taken := model.NewCompany()
+	taken.UniqueID = "ROLLBACKTAKENID"
+	err := model.InsertCompany(ctx, taken)
+	if err != nil {
+		t.Errorf("The company was not inserted: [%s]", err)
+		return
+	}
+	defer model.RemoveCompanyByID(ctx, taken.ID.Hex())
+
+	registered := 0
+	released := 0
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			registered++
+		case http.MethodDelete:
+			released++
+		}
+		json.NewEncoder(w).Encode(createCompanyResp{Status: StatusSuccess, CompanyID: taken.ID.Hex()})
+	}))
+	defer relay.Close()
+
+	var req createCompanyReq
+	req.Name = "ROLLBACK"
+	req.UniqueID = "ROLLBACKUNIQUEID"
+	req.RemotelyManaged = "true"
+	req.AuthRelay = relay.URL
+	req.APIKey = "APIKEY"
+	req.GroupOwnerID = "GROUPOWNER"
+	req.Password = "@123ABC789"
+	req.ConfirmPassword = req.Password
+
+	rsp := createCompanyBL(ctx, req)
+	if rsp.Status != StatusFailure || !errors.Is(rsp.failure(), model.ErrDuplicate) {
+		t.Errorf("The company should not have been created: [%s] [%v]", rsp.Status, rsp.failure())
+	}
+
+	if registered != 1 || released != 1 {
+		t.Errorf("The remote registration should have been undone: registered %d, released %d", registered, released)
+	}
+
+	_, err = model.FindCompanyByUniqueID(ctx, req.UniqueID)
+	if !errors.Is(err, model.ErrNotFound) {
+		t.Errorf("No company should be left behind: [%v]", err)
+	}
+
+	users, err := model.ListUsersByCompanyID(ctx, taken.ID.Hex())
+	if err != nil || len(users) != 0 {
+		t.Errorf("No superuser should be left behind: %d [%v]", len(users), err)
+	}
+}
@@ -27,10 +27,13 @@ package controller
 import (
 	"com/novare/auth/model"
 	"encoding/json"
-	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"unicode/utf8"
 
 	"github.com/gorilla/mux"
@@ -59,20 +62,26 @@ type createCompanyReq struct {
 
 //And to create the response
 type createCompanyResp struct {
-	Status    string `json:"status"`
+	Status string `json:"status"`
+	apiError
 	CompanyID string `json:"companyID"`
 }
 
 func writeResponse(rsp interface{}, w http.ResponseWriter) {
 	jbuf, err := json.Marshal(rsp)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, errInternal)
 		return
 	}
 
+	status := http.StatusOK
+	if f, ok := rsp.(interface{ failure() error }); ok && f.failure() != nil {
+		status, _ = errorStatus(f.failure())
+	}
+
 	//Write the response
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 	w.Write(jbuf)
 
 }
@@ -89,11 +98,11 @@ func CreateCompany(w http.ResponseWriter, r *http.Request) {
 	err := jsonDecoder.Decode(&req)
 	if err != nil {
 		log.Printf("The following error occurred: [%s]", err)
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, model.NewInputError("InvalidRequest"))
 		return
 	}
 
-	rsp := createCompanyBL(req)
+	rsp := createCompanyBL(r.Context(), req)
 
 	//Write the response
 	writeResponse(rsp, w)
@@ -116,30 +125,42 @@ type updateCompanyReq struct {
 }
 
 type updateCompanyResponse struct {
-	Status           string           `json:"status"`
+	Status string `json:"status"`
+	apiError
 	UpdateCompanyReq updateCompanyReq `json:"companyInfo"`
+	Revision         int64            `json:"revision"` //Also sent as the ETag
 }
 
 //UpdateCompany - Update the company information
 func UpdateCompany(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
+	ifMatch, err := getIfMatch(w, r)
+	if err != nil {
+		return
+	}
+
 	var req updateCompanyReq
 	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&req)
+	err = decoder.Decode(&req)
 	if err != nil {
 		log.Printf("There was an error unmarshalling the update company request")
+		writeError(w, model.NewInputError("InvalidRequest"))
 		return
 	}
 
-	rsp := updateCompanyBL(&req)
+	rsp := updateCompanyBL(r.Context(), ifMatch, &req)
+	if rsp.Status == StatusSuccess {
+		setETag(w, rsp.Revision)
+	}
 
 	writeResponse(rsp, w)
 
 }
 
 type regResp struct {
-	Status    string `json:"status"`
+	Status string `json:"status"`
+	apiError
 	RegisCode string `json:"regisCode"`
 }
 
@@ -153,18 +174,19 @@ func EnableRegistration(w http.ResponseWriter, r *http.Request) {
 
 	if !ok {
 		log.Printf("There is no companyid in the path")
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, model.NewInputError("InvalidRequest"))
 		return
 	}
 
-	rsp := enableRegistrationBL(user, companyID)
+	rsp := enableRegistrationBL(r.Context(), user, companyID)
 
 	writeResponse(rsp, w)
 
 }
 
 type getCompanyResponse struct {
-	Status          string                `json:"status"`
+	Status string `json:"status"`
+	apiError
 	CompanyID       string                `json:"companyID"`
 	UniqueID        string                `json:"uniqueID"`
 	Name            string                `json:"name"`
@@ -180,6 +202,7 @@ type getCompanyResponse struct {
 	Settings        model.CompanySettings `json:"settings"`                  //We can use the settings directly from the model
 	RegisCode       string                `json:"regisCode"`                 //The code used for a site to register
 	GroupOwnerID    string                `json:"groupOwnerID"`
+	Revision        int64                 `json:"revision"` //Also sent as the ETag
 }
 
 //GetCompanyByUniqueID - The company uniquer ID is specified in the request
@@ -191,25 +214,38 @@ func GetCompanyByUniqueID(w http.ResponseWriter, r *http.Request) {
 	uniqueID, ok := vars["uniqueid"]
 
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, model.NewInputError("InvalidRequest"))
 		return
 	}
 
-	rsp := getCompanyByUniqueIDOL(uniqueID)
+	rsp := getCompanyByUniqueIDOL(r.Context(), uniqueID)
+	if rsp.Status == StatusSuccess {
+		setETag(w, rsp.Revision)
+	}
 
 	//Write the response
 	writeResponse(rsp, w)
 }
 
 type loginReq struct {
-	UniqueID string `json:"uniqueID"`
-	Username string `json:"username"`
-	Password string `json:"password"`
+	UniqueID  string `json:"uniqueID"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	DeviceID  string `json:"deviceID"` //The terminal the session is for, optional
+	IPAddress string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+type refreshReq struct {
+	RefreshToken string `json:"refreshToken"`
 }
 
 type loginResp struct {
-	Status       string `json:"status"`
+	Status string `json:"status"`
+	apiError
 	SessionToken string `json:"sessionToken"`
+	RefreshToken string `json:"refreshToken,omitempty"` //Exchanged for a new session token at /jwt/company/refresh
+	ExpiresIn    int64  `json:"expiresIn,omitempty"`    //Seconds the session token lives
 	Username     string `json:"userName"`
 	Fullname     string `json:"fullName"`
 	IsThing      bool   `json:"isThing"`
@@ -217,6 +253,15 @@ type loginResp struct {
 	UserStatus   string `json:"userStatus,omitempty"`
 }
 
+//clientIP - The address the request came from, without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 //Login ...
 func Login(w http.ResponseWriter, r *http.Request) {
 
@@ -227,16 +272,38 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	err := decoder.Decode(&lr)
 	if err != nil {
 		log.Printf("An issue occurred while performing the login request:[%s]", err)
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, model.NewInputError("InvalidRequest"))
 		return
 	}
 
-	rsp := loginBL(lr)
+	lr.IPAddress = clientIP(r)
+	lr.UserAgent = r.UserAgent()
+	rsp := loginBL(r.Context(), lr)
 
 	writeResponse(rsp, w)
 
 }
 
+//Refresh - Exchange a refresh token for a new session token and a new
+//refresh token
+func Refresh(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	var req refreshReq
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&req)
+	if err != nil {
+		log.Printf("An issue occurred while decoding the refresh request:[%s]", err)
+		writeError(w, model.NewInputError("InvalidRequest"))
+		return
+	}
+
+	rsp := refreshBL(r.Context(), req)
+
+	writeResponse(rsp, w)
+}
+
 //Logout ...
 func Logout(w http.ResponseWriter, r *http.Request) {
 
@@ -246,14 +313,12 @@ func Logout(w http.ResponseWriter, r *http.Request) {
 	auth := r.Header.Get("Authorization")
 
 	//
-	rsp := logOutBL(auth)
+	rsp := logOutBL(r.Context(), auth)
 
 	switch rsp {
 
-	case LogoutFailedNoToken:
-		w.WriteHeader(http.StatusForbidden)
-	case LogoutTokenInvalid:
-		w.WriteHeader(http.StatusBadGateway)
+	case LogoutFailedNoToken, LogoutTokenInvalid:
+		writeError(w, errInvalidToken)
 	case LogoutSuccess:
 		w.WriteHeader(http.StatusOK)
 
@@ -261,12 +326,373 @@ func Logout(w http.ResponseWriter, r *http.Request) {
 
 }
 
+//Introspect - RFC 7662 token introspection. The token is sent as the
+//token form parameter, the token_type_hint is not needed.
+func Introspect(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	err := r.ParseForm()
+	token := r.PostForm.Get("token")
+	if err != nil || utf8.RuneCountInString(token) == 0 {
+		log.Printf("The introspection request does not have a token")
+		writeError(w, model.NewInputError("InvalidRequest"))
+		return
+	}
+
+	usr := r.Context().Value(CtxUser).(*model.User)
+	rsp := introspectBL(r.Context(), usr, token)
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(rsp, w)
+}
+
+//Revoke - RFC 7009 token revocation. The token is sent as the token form
+//parameter, a session, access or refresh token of the caller's company.
+func Revoke(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	err := r.ParseForm()
+	token := r.PostForm.Get("token")
+	if err != nil || utf8.RuneCountInString(token) == 0 {
+		log.Printf("The revocation request does not have a token")
+		writeError(w, model.NewInputError("InvalidRequest"))
+		return
+	}
+
+	usr := r.Context().Value(CtxUser).(*model.User)
+	rsp := revokeBL(r.Context(), usr, token)
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(rsp, w)
+}
+
+//Revocations - The revocation list of the company. The since query
+//parameter is the now of the previous poll, without it the whole list
+//is returned.
+func Revocations(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	var since int64
+	if s := r.URL.Query().Get("since"); utf8.RuneCountInString(s) > 0 {
+		var err error
+		since, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			log.Printf("The since parameter is not a unix time: [%s]", s)
+			writeError(w, model.NewInputError("InvalidSince"))
+			return
+		}
+	}
+
+	usr := r.Context().Value(CtxUser).(*model.User)
+	rsp := revocationsBL(r.Context(), usr, since)
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(rsp, w)
+}
+
+/*
+Token - The RFC 6749 token endpoint. The client authenticates with HTTP
+Basic or with the client_id and client_secret form parameters, never
+both. The errors are the ones of RFC 6749 section 5.2.
+*/
+func Token(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	var rsp tokenResp
+	err := r.ParseForm()
+	if err != nil {
+		log.Printf("The token request could not be parsed: [%s]", err)
+		rsp.fail(http.StatusBadRequest, oauthInvalidRequest, "The body is not a form")
+		writeTokenResponse(w, &rsp, false)
+		return
+	}
+
+	var req tokenReq
+	req.GrantType = r.PostForm.Get("grant_type")
+	req.Scope = r.PostForm.Get("scope")
+	req.Audience = r.PostForm.Get("audience")
+	req.Code = r.PostForm.Get("code")
+	req.RedirectURI = r.PostForm.Get("redirect_uri")
+	req.CodeVerifier = r.PostForm.Get("code_verifier")
+	req.RefreshToken = r.PostForm.Get("refresh_token")
+	req.IPAddress = clientIP(r)
+	req.UserAgent = r.UserAgent()
+
+	clientID, clientSecret, basicAuth := r.BasicAuth()
+	if basicAuth {
+		if _, ok := r.PostForm["client_secret"]; ok {
+			log.Printf("The client sent its credentials twice")
+			rsp.fail(http.StatusBadRequest, oauthInvalidRequest, "Use only one way of authenticating the client")
+			writeTokenResponse(w, &rsp, basicAuth)
+			return
+		}
+
+		//RFC 6749 section 2.3.1, the credentials are form encoded first
+		req.ClientID, err = url.QueryUnescape(clientID)
+		if err == nil {
+			req.ClientSecret, err = url.QueryUnescape(clientSecret)
+		}
+		if err != nil {
+			rsp.fail(http.StatusUnauthorized, oauthInvalidClient, "The client credentials are not encoded properly")
+			writeTokenResponse(w, &rsp, basicAuth)
+			return
+		}
+	} else {
+		req.ClientID = r.PostForm.Get("client_id")
+		req.ClientSecret = r.PostForm.Get("client_secret")
+	}
+
+	writeTokenResponse(w, tokenBL(r.Context(), req), basicAuth)
+}
+
+/*
+Authorize - The RFC 6749 authorization endpoint. GET shows the login page
+of the authorization request, the page POSTs the credentials back with
+the request and the browser is sent to the redirect_uri with a code.
+*/
+func Authorize(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	err := r.ParseForm()
+	if err != nil {
+		log.Printf("The authorization request could not be parsed: [%s]", err)
+		writeAuthorizeResponse(w, r, errorPage(http.StatusBadRequest, unknownApplicationMessage))
+		return
+	}
+
+	var rsp *authorizeResp
+	if r.Method == http.MethodPost {
+		req := newAuthorizeReq(r.PostForm)
+		rsp = authorizeLoginBL(r.Context(), req, r.PostForm.Get("username"), r.PostForm.Get("password"))
+	} else {
+		rsp = authorizeBL(r.Context(), newAuthorizeReq(r.URL.Query()))
+	}
+
+	writeAuthorizeResponse(w, r, rsp)
+}
+
+//InsertClient - Register an OAuth client for a thing. The secret is only
+//in this response.
+func InsertClient(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	usr := r.Context().Value(CtxUser).(*model.User)
+
+	var rq clientObj
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&rq)
+	if err != nil {
+		log.Printf("The following error occurred when decoding the client request: [%s]", err)
+		writeError(w, model.NewInputError("InvalidRequest"))
+		return
+	}
+
+	rsp := insertClientBL(r.Context(), usr.CompanyID, &rq)
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(rsp, w)
+}
+
+//UpdateClient - Change the name, scopes and token duration of a client
+func UpdateClient(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	vars := mux.Vars(r)
+	usr := r.Context().Value(CtxUser).(*model.User)
+
+	clientID, ok := vars["clientid"]
+	if !ok {
+		log.Printf("The client ID was not defined!")
+		writeError(w, model.NewInputError("InvalidRequest"))
+		return
+	}
+
+	ifMatch, err := getIfMatch(w, r)
+	if err != nil {
+		return
+	}
+
+	var rq clientObj
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&rq)
+	if err != nil {
+		log.Printf("The following error occurred when decoding the client request: [%s]", err)
+		writeError(w, model.NewInputError("InvalidRequest"))
+		return
+	}
+
+	rsp := updateClientBL(r.Context(), usr.CompanyID, clientID, ifMatch, &rq)
+	if rsp.Status == StatusSuccess {
+		setETag(w, rsp.Client.Revision)
+	}
+	writeResponse(rsp, w)
+}
+
+//RotateClientSecret - Give the client a new secret
+func RotateClientSecret(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	vars := mux.Vars(r)
+	usr := r.Context().Value(CtxUser).(*model.User)
+
+	clientID, ok := vars["clientid"]
+	if !ok {
+		log.Printf("The client ID was not defined!")
+		writeError(w, model.NewInputError("InvalidRequest"))
+		return
+	}
+
+	rsp := rotateClientSecretBL(r.Context(), usr.CompanyID, clientID)
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(rsp, w)
+}
+
+//RemoveClient ...
+func RemoveClient(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	vars := mux.Vars(r)
+	usr := r.Context().Value(CtxUser).(*model.User)
+
+	clientID, ok := vars["clientid"]
+	if !ok {
+		log.Printf("The client ID was not defined!")
+		writeError(w, model.NewInputError("InvalidRequest"))
+		return
+	}
+
+	rsp := removeClientBL(r.Context(), usr.CompanyID, clientID)
+	writeResponse(rsp, w)
+}
+
+//ListClients - The OAuth clients of the caller's company
+func ListClients(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	usr := r.Context().Value(CtxUser).(*model.User)
+
+	rsp := listClientsBL(r.Context(), usr.CompanyID)
+	writeResponse(rsp, w)
+}
+
+//ListSessions - The sessions of the company, or of one user with the
+//userid query parameter
+func ListSessions(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	usr := r.Context().Value(CtxUser).(*model.User)
+	jwt := r.Context().Value(CtxJWT).(*model.JWTToken)
+	rsp := listSessionsBL(r.Context(), usr, r.URL.Query().Get("userid"), jwt.Signature)
+
+	writeResponse(rsp, w)
+}
+
+//ListOwnSessions - The sessions of the caller
+func ListOwnSessions(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	usr := r.Context().Value(CtxUser).(*model.User)
+	jwt := r.Context().Value(CtxJWT).(*model.JWTToken)
+	rsp := listSessionsBL(r.Context(), usr, usr.ID.Hex(), jwt.Signature)
+
+	writeResponse(rsp, w)
+}
+
+//TerminateSession - End one session of the caller, or of another user
+//with TERMINATE_SESSION
+func TerminateSession(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	var vars = mux.Vars(r)
+	usr := r.Context().Value(CtxUser).(*model.User)
+	rsp := terminateSessionBL(r.Context(), usr, vars["sessionid"])
+
+	writeResponse(rsp, w)
+}
+
+//TerminateSessions - End the sessions of the company, or of one user with
+//the userid query parameter
+func TerminateSessions(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	usr := r.Context().Value(CtxUser).(*model.User)
+	rsp := terminateSessionsBL(r.Context(), usr, r.URL.Query().Get("userid"))
+
+	writeResponse(rsp, w)
+}
+
+//TerminateOwnSessions - End every session of the caller, on every device
+func TerminateOwnSessions(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	usr := r.Context().Value(CtxUser).(*model.User)
+	rsp := terminateSessionsBL(r.Context(), usr, usr.ID.Hex())
+
+	writeResponse(rsp, w)
+}
+
+//JSONWebKeySet - The public keys the tokens can be verified with. Resource
+//servers may cache the response, the keys are never changed in place.
+func JSONWebKeySet(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	set, err := jwksBL(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeResponse(set, w)
+}
+
+//OpenIDConfiguration - The OpenID Connect discovery document
+func OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	rsp := openIDConfigurationBL(issuerBaseURL(r))
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeResponse(rsp, w)
+}
+
+//UserInfo - The OpenID Connect claims of the user of the session
+func UserInfo(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	usr := r.Context().Value(CtxUser).(*model.User)
+	company := r.Context().Value(CtxCompany).(*model.Company)
+	jwt := r.Context().Value(CtxJWT).(*model.JWTToken)
+
+	rsp := userInfoBL(usr, company, &jwt.Payload)
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(rsp, w)
+}
+
 type accessTokenResp struct {
-	Status      string `json:"status"`
+	Status string `json:"status"`
+	apiError
 	AccessToken string `json:"accessToken"`
 }
 
-//GrantRequest - Let's check if a request can be granted
+//GrantRequest - Let's check if a request can be granted. The aud query
+//parameter names the service the access token is meant for.
 func GrantRequest(w http.ResponseWriter, r *http.Request) {
 
 	defer r.Body.Close()
@@ -278,22 +704,24 @@ func GrantRequest(w http.ResponseWriter, r *http.Request) {
 	jwt := r.Context().Value(CtxJWT).(*model.JWTToken)
 	if jwt == nil {
 		log.Printf("Invalid JWT Token, aborting the request")
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, errInvalidToken)
 		return
 	}
 
 	usr := r.Context().Value(CtxUser).(*model.User)
 	if usr == nil {
 		log.Printf("An error occurred while retrieving the company based on the JWT ID")
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, errInvalidToken)
 		return
 	}
 
 	//Call the business logic
-	rsp := grantRequestBL(ucid, jwt, usr)
+	permission := r.Header.Get("grant-request")
+	audience := r.URL.Query().Get("aud")
+	rsp := grantRequestBL(r.Context(), ucid, permission, audience, jwt, usr)
 	if rsp == nil {
 		log.Printf("The response from the grantRequestBL request did not contain a valid response")
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, errInternal)
 		return
 	}
 
@@ -301,7 +729,8 @@ func GrantRequest(w http.ResponseWriter, r *http.Request) {
 }
 
 type checkSuggestIDResp struct {
-	Status   string `json:"status"`
+	Status string `json:"status"`
+	apiError
 	UniqueID string `json:"uniqueID"`
 }
 
@@ -317,7 +746,7 @@ func CheckAndSuggestUniqueID(w http.ResponseWriter, r *http.Request) {
 		uniqueID = ""
 	}
 
-	rsp := suggestCompanyUniqueIDBL(uniqueID)
+	rsp := suggestCompanyUniqueIDBL(r.Context(), uniqueID)
 
 	writeResponse(rsp, w)
 }
@@ -326,11 +755,14 @@ type permObj struct {
 	ID          string `json:"id,omitempty"`
 	Description string `json:"description,omitempty"`
 	Permission  string `json:"permission,omitempty"`
+	Revision    int64  `json:"revision"` //Send it back in If-Match to update the permission
 }
 
 type permResp struct {
 	Status string `json:"status,omitempty"`
-	ID     string `json:"id,omitempty"`
+	apiError
+	ID       string `json:"id,omitempty"`
+	Revision int64  `json:"revision"` //Also sent as the ETag
 }
 
 //InsertPermission ...
@@ -345,16 +777,11 @@ func InsertPermission(w http.ResponseWriter, r *http.Request) {
 	err := decoder.Decode(&rq)
 	if err != nil {
 		log.Printf("The following error occurred when decoding the permission request: [%s]", err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	rp := insertPermissionBL(usr.CompanyID, &rq)
-	if rp == nil || rp.Status == StatusFailure {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, model.NewInputError("InvalidRequest"))
 		return
 	}
 
+	rp := insertPermissionBL(r.Context(), usr.CompanyID, &rq)
 	writeResponse(rp, w)
 
 }
@@ -370,25 +797,28 @@ func UpdatePermission(w http.ResponseWriter, r *http.Request) {
 	permID, ok := vars["permid"]
 	if !ok {
 		log.Printf("The permission ID was not defined!")
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, model.NewInputError("InvalidRequest"))
+		return
+	}
+
+	ifMatch, err := getIfMatch(w, r)
+	if err != nil {
 		return
 	}
 
 	var rq permObj
 	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&rq)
+	err = decoder.Decode(&rq)
 	if err != nil {
 		log.Printf("The following error occurred when decoding the permission request: [%s]", err)
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, model.NewInputError("InvalidRequest"))
 		return
 	}
 
-	rp := updatePermissionBL(permID, usr.CompanyID, &rq)
-	if rp == nil || rp.Status == StatusFailure {
-		w.WriteHeader(http.StatusBadRequest)
-		return
+	rp := updatePermissionBL(r.Context(), permID, usr.CompanyID, ifMatch, &rq)
+	if rp.Status == StatusSuccess {
+		setETag(w, rp.Revision)
 	}
-
 	writeResponse(rp, w)
 
 }
@@ -403,23 +833,20 @@ func RemovePermission(w http.ResponseWriter, r *http.Request) {
 	permID, ok := vars["permid"]
 	if !ok {
 		log.Printf("The permission ID was not defined!")
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, model.NewInputError("InvalidRequest"))
 		return
 	}
 
-	rsp := removePermissionBL(permID, usr.CompanyID)
-
-	if rsp == nil || rsp.Status == StatusFailure {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
+	rsp := removePermissionBL(r.Context(), permID, usr.CompanyID)
 
 	writeResponse(rsp, w)
 }
 
 type listPermResp struct {
-	Status string    `json:"status"`
-	Perms  []permObj `json:"permissions"`
+	Status string `json:"status"`
+	apiError
+	Total int64     `json:"total"`
+	Perms []permObj `json:"permissions"`
 }
 
 func getStartEnd(w http.ResponseWriter, r *http.Request) (int64, int64, error) {
@@ -429,34 +856,116 @@ func getStartEnd(w http.ResponseWriter, r *http.Request) (int64, int64, error) {
 	s, ok := vars["startat"]
 	if !ok {
 		log.Printf("There was an error retrieving the the start from the request")
-		w.WriteHeader(http.StatusBadRequest)
-		return 0, 0, errors.New("InvalidStart")
+		err := model.NewInputError("InvalidStart")
+		writeError(w, err)
+		return 0, 0, err
 	}
 
 	e, ok := vars["endat"]
 	if !ok {
 		log.Printf("There is no end to the requested list of permissions")
-		w.WriteHeader(http.StatusBadRequest)
-		return 0, 0, errors.New("InvalidEnd")
+		err := model.NewInputError("InvalidEnd")
+		writeError(w, err)
+		return 0, 0, err
 	}
 
 	startAt, err := strconv.ParseInt(s, 10, 64)
 	if err != nil {
 		log.Printf("The following error occurred while retrieving the startAt variable: [%s]", err)
-		w.WriteHeader(http.StatusBadRequest)
-		return 0, 0, errors.New("InvalidStart")
+		err = model.NewInputError("InvalidStart")
+		writeError(w, err)
+		return 0, 0, err
 	}
 
 	endAt, err := strconv.ParseInt(e, 10, 64)
 	if err != nil {
 		log.Printf("The following error occurred while retrieving the endAt variable: [%s]", err)
-		w.WriteHeader(http.StatusBadRequest)
-		return 0, 0, errors.New("InvalidEnd")
+		err = model.NewInputError("InvalidEnd")
+		writeError(w, err)
+		return 0, 0, err
 	}
 
 	return startAt, endAt, nil
 }
 
+//anyRevision - No If-Match precondition, the update applies to whatever
+//revision is stored
+const anyRevision int64 = -1
+
+//checkRevision - errPreconditionFailed when the If-Match revision is not
+//the stored one
+func checkRevision(ifMatch int64, stored int64) error {
+	if ifMatch != anyRevision && ifMatch != stored {
+		return errPreconditionFailed
+	}
+	return nil
+}
+
+/*
+getIfMatch - The revision in the If-Match header of an update, e.g.
+If-Match: "3". The update fails with PreconditionFailed when the stored
+revision is different. anyRevision is returned when the header is missing
+or "*".
+*/
+func getIfMatch(w http.ResponseWriter, r *http.Request) (int64, error) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if utf8.RuneCountInString(ifMatch) == 0 || ifMatch == "*" {
+		return anyRevision, nil
+	}
+
+	ifMatch = strings.TrimPrefix(ifMatch, "W/")
+	revision, err := strconv.ParseInt(strings.Trim(ifMatch, `"`), 10, 64)
+	if err != nil || revision < 0 {
+		log.Printf("The If-Match header is not a revision: [%s]", ifMatch)
+		err = model.NewInputError("InvalidIfMatch")
+		writeError(w, err)
+		return anyRevision, err
+	}
+
+	return revision, nil
+}
+
+//setETag - The revision of the entity in the response
+func setETag(w http.ResponseWriter, revision int64) {
+	w.Header().Set("ETag", fmt.Sprintf(`"%d"`, revision))
+}
+
+//getListQuery - The order and the name filter of a list come from the
+//query string, e.g. ?sort=name&order=desc&name=Jo
+func getListQuery(r *http.Request) model.ListQuery {
+	var query model.ListQuery
+	values := r.URL.Query()
+	query.SortBy = values.Get("sort")
+	query.Descending = strings.ToLower(values.Get("order")) == "desc"
+	query.NamePrefix = values.Get("name")
+	return query
+}
+
+//getUserQuery - The user lists can also be filtered by
+//?username=...&isThing=true&status=Enabled
+func getUserQuery(w http.ResponseWriter, r *http.Request) (model.UserQuery, error) {
+	var query model.UserQuery
+	query.ListQuery = getListQuery(r)
+
+	values := r.URL.Query()
+	query.Username = values.Get("username")
+	query.Status = values.Get("status")
+
+	isThing := values.Get("isThing")
+	if utf8.RuneCountInString(isThing) > 0 {
+		b, err := strconv.ParseBool(isThing)
+		if err != nil {
+			log.Printf("The isThing filter is not a boolean: [%s]", isThing)
+			err = model.NewInputError("InvalidIsThing")
+			writeError(w, err)
+			return query, err
+		}
+		query.IsThing = &b
+	}
+
+	return query, nil
+}
+
 //ListPermissions ...
 func ListPermissions(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
@@ -468,7 +977,7 @@ func ListPermissions(w http.ResponseWriter, r *http.Request) {
 
 	usr := r.Context().Value(CtxUser).(*model.User)
 
-	rsp := listPermissionBL(startAt, endAt, usr.CompanyID)
+	rsp := listPermissionBL(r.Context(), startAt, endAt, usr.CompanyID, getListQuery(r))
 
 	writeResponse(rsp, w)
 }
@@ -484,11 +993,13 @@ type usrObj struct {
 	IsThing         string             `json:"isThing,omitempty"`     //This is a thing instead of a user
 	Password        string             `json:"password,omitempty"`
 	ConfirmPassword string             `json:"confirmPassword,omitempty"`
-	Secret          string             `json:"secret"` //A Secret used to login machines
+	Secret          string             `json:"secret"`   //A Secret used to login machines
+	Revision        int64              `json:"revision"` //Send it back in If-Match to update the user
 }
 
 type usrResp struct {
-	Status  string `json:"status,omitempty"`
+	Status string `json:"status,omitempty"`
+	apiError
 	UserObj usrObj `json:"user,omitempty"`
 }
 
@@ -504,16 +1015,11 @@ func InsertUser(w http.ResponseWriter, r *http.Request) {
 	err := decoder.Decode(&rq)
 	if err != nil {
 		log.Printf("The following error occurred when decoding the user request: [%s]", err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	rp := insertUserBL(usr.CompanyID, &rq)
-	if rp == nil || rp.Status == StatusFailure {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, model.NewInputError("InvalidRequest"))
 		return
 	}
 
+	rp := insertUserBL(r.Context(), usr.CompanyID, &rq)
 	writeResponse(rp, w)
 
 }
@@ -528,25 +1034,28 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 	userName, ok := vars["username"]
 	if !ok {
 		log.Printf("The user ID was not defined!")
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, model.NewInputError("InvalidRequest"))
+		return
+	}
+
+	ifMatch, err := getIfMatch(w, r)
+	if err != nil {
 		return
 	}
 
 	var rq usrObj
 	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&rq)
+	err = decoder.Decode(&rq)
 	if err != nil {
 		log.Printf("The following error occurred when decoding the user request: [%s]", err)
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, model.NewInputError("InvalidRequest"))
 		return
 	}
 
-	rp := updateUserBL(userName, usr.CompanyID, &rq)
-	if rp == nil || rp.Status == StatusFailure {
-		w.WriteHeader(http.StatusBadRequest)
-		return
+	rp := updateUserBL(r.Context(), userName, usr.CompanyID, ifMatch, &rq)
+	if rp.Status == StatusSuccess {
+		setETag(w, rp.UserObj.Revision)
 	}
-
 	writeResponse(rp, w)
 
 }
@@ -561,23 +1070,20 @@ func RemoveUser(w http.ResponseWriter, r *http.Request) {
 	userName, ok := vars["username"]
 	if !ok {
 		log.Printf("The userName ID was not defined!")
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, model.NewInputError("InvalidRequest"))
 		return
 	}
 
-	rsp := removeUserBL(userName, usr.CompanyID)
-
-	if rsp == nil || rsp.Status == StatusFailure {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
+	rsp := removeUserBL(r.Context(), userName, usr.CompanyID)
 
 	writeResponse(rsp, w)
 }
 
 type listUserResp struct {
-	Status string   `json:"status"`
-	Users  []usrObj `json:"users"`
+	Status string `json:"status"`
+	apiError
+	Total int64    `json:"total"`
+	Users []usrObj `json:"users"`
 }
 
 //ListUsers ...
@@ -590,9 +1096,14 @@ func ListUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	query, err := getUserQuery(w, r)
+	if err != nil {
+		return
+	}
+
 	usr := r.Context().Value(CtxUser).(*model.User)
 
-	rsp := listUsersBL(startAt, endAt, usr.CompanyID)
+	rsp := listUsersBL(r.Context(), startAt, endAt, usr.CompanyID, query)
 
 	writeResponse(rsp, w)
 }
@@ -601,11 +1112,13 @@ type roleObj struct {
 	ID          string             `json:"id"`          //
 	Description string             `json:"description"` //Role description
 	Permissions []model.Permission `json:"permissions"` //List of permissions for the role
+	Revision    int64              `json:"revision"`    //Send it back in If-Match to update the role
 }
 
 type roleResp struct {
-	Status string  `json:"status"`
-	Role   roleObj `json:"role"`
+	Status string `json:"status"`
+	apiError
+	Role roleObj `json:"role"`
 }
 
 //InsertRole ...
@@ -620,16 +1133,11 @@ func InsertRole(w http.ResponseWriter, r *http.Request) {
 	err := decoder.Decode(&rq)
 	if err != nil {
 		log.Printf("The following error occurred when decoding the role request: [%s]", err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	rp := insertRoleBL(usr.CompanyID, &rq)
-	if rp == nil || rp.Status == StatusFailure {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, model.NewInputError("InvalidRequest"))
 		return
 	}
 
+	rp := insertRoleBL(r.Context(), usr.CompanyID, &rq)
 	writeResponse(rp, w)
 
 }
@@ -645,25 +1153,28 @@ func UpdateRole(w http.ResponseWriter, r *http.Request) {
 	roleID, ok := vars["roleid"]
 	if !ok {
 		log.Printf("The user ID was not defined!")
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, model.NewInputError("InvalidRequest"))
+		return
+	}
+
+	ifMatch, err := getIfMatch(w, r)
+	if err != nil {
 		return
 	}
 
 	var rq roleObj
 	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&rq)
+	err = decoder.Decode(&rq)
 	if err != nil {
 		log.Printf("The following error occurred when decoding the role request: [%s]", err)
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, model.NewInputError("InvalidRequest"))
 		return
 	}
 
-	rp := updateRoleBL(roleID, usr.CompanyID, &rq)
-	if rp == nil || rp.Status == StatusFailure {
-		w.WriteHeader(http.StatusBadRequest)
-		return
+	rp := updateRoleBL(r.Context(), roleID, usr.CompanyID, ifMatch, &rq)
+	if rp.Status == StatusSuccess {
+		setETag(w, rp.Role.Revision)
 	}
-
 	writeResponse(rp, w)
 
 }
@@ -679,23 +1190,20 @@ func RemoveRole(w http.ResponseWriter, r *http.Request) {
 	roleID, ok := vars["roleid"]
 	if !ok {
 		log.Printf("The role ID was not defined!")
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, model.NewInputError("InvalidRequest"))
 		return
 	}
 
-	rsp := removeRoleBL(roleID, usr.CompanyID)
-
-	if rsp == nil || rsp.Status == StatusFailure {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
+	rsp := removeRoleBL(r.Context(), roleID, usr.CompanyID)
 
 	writeResponse(rsp, w)
 }
 
 type listRoleResp struct {
-	Status string    `json:"status"`
-	Roles  []roleObj `json:"roles"`
+	Status string `json:"status"`
+	apiError
+	Total int64     `json:"total"`
+	Roles []roleObj `json:"roles"`
 }
 
 //ListRoles ...
@@ -710,50 +1218,84 @@ func ListRoles(w http.ResponseWriter, r *http.Request) {
 
 	usr := r.Context().Value(CtxUser).(*model.User)
 
-	rsp := listRolesBL(startAt, endAt, usr.CompanyID)
+	rsp := listRolesBL(r.Context(), startAt, endAt, usr.CompanyID, getListQuery(r))
 
 	writeResponse(rsp, w)
 }
 
-//CreateCompanyRemote - Remote requests purposes.
-func CreateCompanyRemote(w http.ResponseWriter, r *http.Request) {
-
-	defer r.Body.Close()
+//getRemoteCompanyReq - The group owner and the API key come from the
+//query string, the company from the JSON payload
+func getRemoteCompanyReq(w http.ResponseWriter, r *http.Request) (string, string, createCompanyReq, error) {
+	var req createCompanyReq
 
 	groupOwnerID := r.URL.Query().Get("group")
 	if utf8.RuneCountInString(groupOwnerID) == 0 {
 		log.Printf("Invalid group owner ID... Not defined")
-		w.WriteHeader(http.StatusBadRequest)
-		return
+		err := model.NewInputError("InvalidRequest")
+		writeError(w, err)
+		return "", "", req, err
 	}
 
 	apiKey := r.URL.Query().Get("apikey")
 	if utf8.RuneCountInString(apiKey) == 0 {
 		log.Printf("The API Key was not provided")
-		w.WriteHeader(http.StatusBadRequest)
-		return
+		err := model.NewInputError("InvalidRequest")
+		writeError(w, err)
+		return "", "", req, err
 	}
 
-	var req createCompanyReq
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&req)
 
 	if err != nil {
 		log.Printf("Invalid request, the JSON payload could not be parsed!")
-		w.WriteHeader(http.StatusBadRequest)
+		err = model.NewInputError("InvalidRequest")
+		writeError(w, err)
+		return "", "", req, err
+	}
+
+	return apiKey, groupOwnerID, req, nil
+}
+
+//CreateCompanyRemote - Remote requests purposes.
+func CreateCompanyRemote(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	apiKey, groupOwnerID, req, err := getRemoteCompanyReq(w, r)
+	if err != nil {
+		return
+	}
+
+	rsp := remoteCompanyInsertBL(r.Context(), apiKey, groupOwnerID, req)
+
+	writeResponse(rsp, w)
+}
+
+//RemoveCompanyRemote - Undo CreateCompanyRemote when the site could not
+//finish creating the company
+func RemoveCompanyRemote(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	apiKey, groupOwnerID, req, err := getRemoteCompanyReq(w, r)
+	if err != nil {
 		return
 	}
 
-	rsp := remoteCompanyInsertBL(apiKey, groupOwnerID, req)
+	rsp := remoteCompanyRemoveBL(r.Context(), apiKey, groupOwnerID, req)
 
 	writeResponse(rsp, w)
 }
 
 type loginSecretReq struct {
-	UniqueID string `json:"uniqueID"`
-	Username string `json:"username"`
-	Secret   string `json:"secret"`
-	APIKey   string `json:"apiKey"`
+	UniqueID  string `json:"uniqueID"`
+	Username  string `json:"username"`
+	Secret    string `json:"secret"`
+	APIKey    string `json:"apiKey"`
+	DeviceID  string `json:"deviceID"` //The terminal the session is for, optional
+	IPAddress string `json:"-"`
+	UserAgent string `json:"-"`
 }
 
 //LoginBySecret ...
@@ -766,11 +1308,13 @@ func LoginBySecret(w http.ResponseWriter, r *http.Request) {
 	err := decoder.Decode(&req)
 	if err != nil {
 		log.Printf("Could not unmarshall the JSON object provided in the request")
-		w.WriteHeader(http.StatusOK)
+		writeError(w, model.NewInputError("InvalidRequest"))
 		return
 	}
 
-	rsp := loginBySecretBL(req)
+	req.IPAddress = clientIP(r)
+	req.UserAgent = r.UserAgent()
+	rsp := loginBySecretBL(r.Context(), req)
 
 	writeResponse(rsp, w)
 
@@ -792,7 +1336,8 @@ type companyInfo struct {
 }
 
 type respCompanyByGroupOwner struct {
-	Status    string        `json:"status"`
+	Status string `json:"status"`
+	apiError
 	Companies []companyInfo `json:"companies,omitempty"`
 }
 
@@ -805,11 +1350,11 @@ func GetCompanyByGroupOwnerID(w http.ResponseWriter, r *http.Request) {
 	groupOwnerID, ok := vars["grouponwerid"]
 	if !ok {
 		log.Printf("The group onwer ID was not found! Cannot retrieve companies!")
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, model.NewInputError("InvalidRequest"))
 		return
 	}
 
-	rsp := getCompaniesForGroupID(groupOwnerID, usr)
+	rsp := getCompaniesForGroupID(r.Context(), groupOwnerID, usr)
 
 	writeResponse(rsp, w)
 }
@@ -823,6 +1368,7 @@ type passReq struct {
 
 type passResp struct {
 	Status string `json:"status"`
+	apiError
 }
 
 //UpdatePassword - Used to update a user's password
@@ -836,11 +1382,11 @@ func UpdatePassword(w http.ResponseWriter, r *http.Request) {
 	err := decoder.Decode(req)
 	if err != nil {
 		log.Printf("Error when updating the user's password:[%s]", err)
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, model.NewInputError("InvalidRequest"))
 		return
 	}
 
-	rsp := updatePasswordBL(user, req)
+	rsp := updatePasswordBL(r.Context(), user, req)
 
 	writeResponse(rsp, w)
 
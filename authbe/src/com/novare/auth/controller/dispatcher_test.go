@@ -71,9 +71,9 @@ func TestCreateCompanyWithoutUniqueID(t *testing.T) {
 
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusOK {
+	if status := rr.Code; status != http.StatusBadRequest {
 		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, http.StatusOK)
+			status, http.StatusBadRequest)
 	}
 
 	var rsp createCompanyResp
@@ -83,8 +83,8 @@ func TestCreateCompanyWithoutUniqueID(t *testing.T) {
 		return
 	}
 
-	if rsp.Status == StatusSuccess {
-		t.Errorf("The following response was received: [%s]", rsp.Status)
+	if rsp.Status == StatusSuccess || len(rsp.Code) == 0 {
+		t.Errorf("The following response was received: [%s] [%s]", rsp.Status, rsp.Code)
 	}
 
 }
@@ -188,7 +188,7 @@ func TestGetCompany(t *testing.T) {
 	}
 
 	urlPath := fmt.Sprintf("/jwt/company/%s", r.UniqueID)
-	req, err = http.NewRequest("GET", urlPath, nil)
+	req, err = http.NewRequest("GET", urlPath, http.NoBody)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -232,6 +232,7 @@ func TestGetCompany(t *testing.T) {
 }
 
 func TestLogin(t *testing.T) {
+	ctx := context.Background()
 	var r createCompanyReq
 	r.Address1 = "My Address"
 	r.Address2 = "My Address line 2"
@@ -316,17 +317,17 @@ func TestLogin(t *testing.T) {
 
 	t.Logf("The value of the  token is: [%s]", lgRsp.SessionToken)
 
-	err = model.RemoveCompanyByID(rsp.CompanyID)
+	err = model.RemoveCompanyByID(ctx, rsp.CompanyID)
 	if err != nil {
 		t.Errorf("The company with ID: [%s] was not removed. The following error occurred:[%s]", rsp.CompanyID, err)
 	}
 
-	user, err := model.FindUserByUsernameCompanyID("superuser", rsp.CompanyID)
+	user, err := model.FindUserByUsernameCompanyID(ctx, "superuser", rsp.CompanyID)
 	if err != nil {
 		t.Errorf("No user was found for company ID: [%s] ", rsp.CompanyID)
 		return
 	}
-	err = model.RemoveUserByID(user.ID.Hex())
+	err = model.RemoveUserByID(ctx, user.ID.Hex())
 	if err != nil {
 		t.Errorf("The user could not be removed from the database. Error:[%s]", err)
 	}
@@ -334,6 +335,7 @@ func TestLogin(t *testing.T) {
 }
 
 func TestLogout(t *testing.T) {
+	ctx := context.Background()
 
 	var r createCompanyReq
 	r.Address1 = "My Address"
@@ -422,7 +424,7 @@ func TestLogout(t *testing.T) {
 
 	t.Logf("The value of the  token is: [%s]", lgRsp.SessionToken)
 
-	loutReq, err := http.NewRequest("POST", "/jwt/company/logout", nil)
+	loutReq, err := http.NewRequest("POST", "/jwt/company/logout", http.NoBody)
 	if err != nil {
 		t.Errorf("Invalid logout request, error:[%s]", err)
 	}
@@ -436,17 +438,17 @@ func TestLogout(t *testing.T) {
 		t.Errorf("The status is not OK! The status was: [%d]", status)
 	}
 
-	err = model.RemoveCompanyByID(rsp.CompanyID)
+	err = model.RemoveCompanyByID(ctx, rsp.CompanyID)
 	if err != nil {
 		t.Errorf("The company with ID: [%s] was not removed. The following error occurred:[%s]", rsp.CompanyID, err)
 	}
 
-	user, err := model.FindUserByUsernameCompanyID("superuser", rsp.CompanyID)
+	user, err := model.FindUserByUsernameCompanyID(ctx, "superuser", rsp.CompanyID)
 	if err != nil {
 		t.Errorf("No user was found for company ID: [%s] ", rsp.CompanyID)
 		return
 	}
-	err = model.RemoveUserByID(user.ID.Hex())
+	err = model.RemoveUserByID(ctx, user.ID.Hex())
 	if err != nil {
 		t.Errorf("The user could not be removed from the database. Error:[%s]", err)
 	}
@@ -454,6 +456,7 @@ func TestLogout(t *testing.T) {
 }
 
 func TestHTTPGrantRequestAccess(t *testing.T) {
+	ctx := context.Background()
 
 	var req createCompanyReq
 	req.Address1 = "My Address"
@@ -472,13 +475,13 @@ func TestHTTPGrantRequestAccess(t *testing.T) {
 	req.Settings.PassExpiration = 10
 	req.Settings.PassUnit = model.PassUnitDay
 
-	rsp := createCompanyBL(req)
+	rsp := createCompanyBL(ctx, req)
 	if rsp.Status != StatusSuccess {
 		t.Errorf("The company should have been created but it did not!")
 		return
 	}
 
-	company, err := model.FindCompanyByID(rsp.CompanyID)
+	company, err := model.FindCompanyByID(ctx, rsp.CompanyID)
 	if err != nil {
 		t.Errorf("The following error occurred when finding the company:[%s]", rsp.CompanyID)
 		return
@@ -489,13 +492,13 @@ func TestHTTPGrantRequestAccess(t *testing.T) {
 	lr.Username = "superuser"
 	lr.Password = "@123ABC789"
 
-	lrsp := loginBL(lr)
+	lrsp := loginBL(ctx, lr)
 	if lrsp.Status != StatusSuccess {
 		t.Error("An error occurred when the login was performed")
 		return
 	}
 
-	users, err := model.ListUsersByCompanyID(company.ID.Hex())
+	users, err := model.ListUsersByCompanyID(ctx, company.ID.Hex())
 	if err != nil {
 		t.Errorf("There was an issue listing all the users for companyID: [%s] Error:[%s]", company.ID.Hex(), err)
 		return
@@ -508,7 +511,7 @@ func TestHTTPGrantRequestAccess(t *testing.T) {
 		return
 	}
 
-	jwtTmp, err := model.FindJWTTokenBySignature(jwt.Signature)
+	jwtTmp, err := model.FindJWTTokenBySignature(ctx, jwt.Signature)
 	if err != nil {
 		t.Errorf("The JWT with signature:[%s] was not found", jwt.Signature)
 		return
@@ -525,7 +528,7 @@ func TestHTTPGrantRequestAccess(t *testing.T) {
 	if !ok {
 		t.Error("The following error occurred")
 		performCompanyCleanup(company.ID.Hex(), t)
-		err = model.RemoveJWTTokenByID(jwtTmp.ID.Hex())
+		err = model.RemoveJWTTokenByID(ctx, jwtTmp.ID.Hex())
 		if err != nil {
 			t.Errorf("The following error occurred: [%s]", err)
 		}
@@ -543,11 +546,11 @@ func TestHTTPGrantRequestAccess(t *testing.T) {
 	}
 
 	for i := range users {
-		model.RemoveUserByID(users[i].ID.Hex())
+		model.RemoveUserByID(ctx, users[i].ID.Hex())
 	}
 
-	model.RemoveCompanyByID(company.ID.Hex())
-	err = model.RemoveJWTTokenByID(jwtTmp.ID.Hex())
+	model.RemoveCompanyByID(ctx, company.ID.Hex())
+	err = model.RemoveJWTTokenByID(ctx, jwtTmp.ID.Hex())
 	if err != nil {
 		t.Errorf("The following error occurred: [%s]", err)
 	}
@@ -555,6 +558,7 @@ func TestHTTPGrantRequestAccess(t *testing.T) {
 }
 
 func TestSuggestNewUniqueID(t *testing.T) {
+	ctx := context.Background()
 
 	company := model.NewCompany()
 	company.Address1 = "ADDR1"
@@ -568,7 +572,7 @@ func TestSuggestNewUniqueID(t *testing.T) {
 	company.UniqueID = "SOMEUNIQUEID"
 	company.Zip = "34683"
 
-	err := model.InsertCompany(company)
+	err := model.InsertCompany(ctx, company)
 	if err != nil {
 		t.Errorf("The following error occurred :[%s]", err)
 		return
@@ -653,7 +657,7 @@ func TestPermissionsInsertUpdateRemove(t *testing.T) {
 		t.Error("The value of the ID is empty!")
 	}
 
-	permission, err := model.FindPermissionByID(permR.ID)
+	permission, err := model.FindPermissionByID(ctx, permR.ID)
 	if err != nil {
 		t.Errorf("There was an error locating the permission object: [%s]", err)
 	}
@@ -682,7 +686,7 @@ func TestPermissionsInsertUpdateRemove(t *testing.T) {
 		t.Errorf("UPDATE -> Expected code: StatusOK but got code: %d instead", code)
 	}
 
-	permission, err = model.FindPermissionByID(permission.ID.Hex())
+	permission, err = model.FindPermissionByID(ctx, permission.ID.Hex())
 	if err != nil {
 		t.Errorf("The permission with ID:[%s] was not located", permR.ID)
 	}
@@ -711,13 +715,14 @@ func TestPermissionsInsertUpdateRemove(t *testing.T) {
 }
 
 func TestListPermissions(t *testing.T) {
+	ctx := context.Background()
 
 	for i := 1; i <= 10; i++ {
 		perm := model.NewPermission()
 		perm.CompanyID = "COMPANYID"
 		perm.Description = fmt.Sprintf("Description %d", i)
 		perm.Permission = fmt.Sprintf("PERMISSION_%d", i)
-		err := model.InsertPermission(perm)
+		err := model.InsertPermission(ctx, perm)
 		if err != nil {
 			t.Errorf("The following error occurred: %s", err)
 		}
@@ -729,7 +734,7 @@ func TestListPermissions(t *testing.T) {
 	usr.CompanyID = "COMPANYID"
 
 	r := httptest.NewRequest("GET", "/jwt/permissions", nil)
-	ctx := r.Context()
+	ctx = r.Context()
 	ctx = context.WithValue(ctx, CtxUser, usr)
 	r = r.WithContext(ctx)
 
@@ -754,13 +759,31 @@ func TestListPermissions(t *testing.T) {
 		t.Errorf("The following error occurred while unmarshalling the list response: [%s]", lst.Status)
 	}
 
-	if len(lst.Perms) != 10 {
-		t.Errorf("The number of objects should have been 10 but, it is %d instead", len(lst.Perms))
+	if len(lst.Perms) != 10 || lst.Total != 10 {
+		t.Errorf("The number of objects should have been 10 but, it is %d instead (total %d)", len(lst.Perms), lst.Total)
+	}
+
+	//The sort order, the name prefix and the page are applied by the database
+	r = httptest.NewRequest("GET", "/jwt/permissions?sort=permission&order=desc&name=PERMISSION_1", nil)
+	r = r.WithContext(ctx)
+	r = mux.SetURLVars(r, map[string]string{"startat": "0", "endat": "1"})
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	var page listPermResp
+	err = json.Unmarshal(w.Body.Bytes(), &page)
+	if err != nil || page.Status != StatusSuccess {
+		t.Errorf("The following error occurred while unmarshalling the filtered list: [%s]", page.Status)
+	}
+
+	if page.Total != 2 || len(page.Perms) != 1 || page.Perms[0].Permission != "PERMISSION_10" {
+		t.Errorf("Only PERMISSION_10 should be on the first page of 2 permissions: total %d, %v", page.Total, page.Perms)
 	}
 
 	//Now as the last step we need to remove all the permissions
 	for i := range lst.Perms {
-		err := model.RemovePermissionByID(lst.Perms[i].ID)
+		err := model.RemovePermissionByID(ctx, lst.Perms[i].ID)
 		if err != nil {
 			t.Errorf("Error removing the permission with ID:[%s]", lst.Perms[i].ID)
 		}
@@ -768,6 +791,7 @@ func TestListPermissions(t *testing.T) {
 }
 
 func TestUserInsertUpdateRemove(t *testing.T) {
+	ctx := context.Background()
 
 	userModel := model.NewUser()
 	userModel.CompanyID = "MyCOMPANY"
@@ -777,7 +801,7 @@ func TestUserInsertUpdateRemove(t *testing.T) {
 	perm.CompanyID = "MyCOMPANY"
 	perm.Description = "Permission"
 	perm.Permission = "PERMISSION_ANY"
-	err := model.InsertPermission(perm)
+	err := model.InsertPermission(ctx, perm)
 	if err != nil {
 		t.Errorf("The following error occurred: <%s>", err)
 		return
@@ -799,7 +823,7 @@ func TestUserInsertUpdateRemove(t *testing.T) {
 
 	r := httptest.NewRequest("POST", "/jwt/user", bytes.NewBuffer(buf))
 	w := httptest.NewRecorder()
-	ctx := r.Context()
+	ctx = r.Context()
 	ctx = context.WithValue(ctx, CtxUser, userModel)
 	r = r.WithContext(ctx)
 
@@ -853,6 +877,7 @@ func TestUserInsertUpdateRemove(t *testing.T) {
 }
 
 func TestUpdateCompany(t *testing.T) {
+	ctx := context.Background()
 
 	var req createCompanyReq
 	req.Address1 = "My Address"
@@ -868,7 +893,7 @@ func TestUpdateCompany(t *testing.T) {
 	req.Password = "@123ABC789"
 	req.ConfirmPassword = req.Password
 
-	rsp := createCompanyBL(req)
+	rsp := createCompanyBL(ctx, req)
 	if rsp.Status != StatusSuccess {
 		t.Errorf("The company should have been created but it did not!")
 		return
@@ -900,7 +925,7 @@ func TestUpdateCompany(t *testing.T) {
 
 	r := httptest.NewRequest("PUT", "/company", bytes.NewReader(buf))
 	w := httptest.NewRecorder()
-	ctx := r.Context()
+	ctx = r.Context()
 	ctx = context.WithValue(ctx, CtxUser, usr)
 	r = r.WithContext(ctx)
 
@@ -915,6 +940,7 @@ func TestUpdateCompany(t *testing.T) {
 }
 
 func TestUpdatingUserPassword(t *testing.T) {
+	ctx := context.Background()
 
 	var req createCompanyReq
 	req.Address1 = "My Address"
@@ -930,7 +956,7 @@ func TestUpdatingUserPassword(t *testing.T) {
 	req.Password = "@123ABC789"
 	req.ConfirmPassword = req.Password
 
-	rsp := createCompanyBL(req)
+	rsp := createCompanyBL(ctx, req)
 	if rsp.Status != StatusSuccess {
 		t.Errorf("The company should have been created but it did not!")
 		return
@@ -943,7 +969,7 @@ func TestUpdatingUserPassword(t *testing.T) {
 	user.Secret = "THISISTHESECRETHIDDEN"
 	user.Username = "testuser"
 	user.SetPassword("A#12345678")
-	err := model.InsertUser(user)
+	err := model.InsertUser(ctx, user)
 	if err != nil {
 		t.Errorf("There is an error inserting the user ERR:[%s]", err)
 	}
@@ -956,7 +982,7 @@ func TestUpdatingUserPassword(t *testing.T) {
 	buf, err := json.Marshal(preq)
 	if err != nil {
 		t.Errorf("There was an error marshalling the object:[%s]", err)
-		model.RemoveUserByID(user.ID.Hex())
+		model.RemoveUserByID(ctx, user.ID.Hex())
 		performCompanyCleanup(rsp.CompanyID, t)
 		return
 	}
@@ -965,7 +991,7 @@ func TestUpdatingUserPassword(t *testing.T) {
 	if err != nil {
 		t.Errorf("The request was not created:ERR[%s]", err)
 	}
-	ctx := context.WithValue(r.Context(), CtxUser, user)
+	ctx = context.WithValue(r.Context(), CtxUser, user)
 	r = r.WithContext(ctx)
 	w := httptest.NewRecorder()
 	h := http.HandlerFunc(UpdatePassword)
@@ -986,7 +1012,7 @@ func TestUpdatingUserPassword(t *testing.T) {
 		t.Errorf("The password update failed")
 	}
 
-	userTemp, err := model.FindUserByID(user.ID.Hex())
+	userTemp, err := model.FindUserByID(ctx, user.ID.Hex())
 	if err != nil {
 		t.Errorf("An error has occurred finding the user with ID:[%s] ERR:[%s]", userTemp.ID.Hex(), err)
 	}
@@ -995,6 +1021,6 @@ func TestUpdatingUserPassword(t *testing.T) {
 		t.Errorf("The password is not matching!")
 	}
 
-	model.RemoveUserByID(user.ID.Hex())
+	model.RemoveUserByID(ctx, user.ID.Hex())
 	performCompanyCleanup(rsp.CompanyID, t)
 }
@@ -0,0 +1,170 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"com/novare/auth/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+//The machine readable codes sent in the "code" field of a failed response
+const (
+	//CodeNotFound - The company, user, role or permission does not exist
+	CodeNotFound = "NotFound"
+
+	//CodeDuplicate - The uniqueID, username, etc. is already taken
+	CodeDuplicate = "Duplicate"
+
+	//CodeConflict - The entity changed since it was read
+	CodeConflict = "Conflict"
+
+	//CodePreconditionFailed - The revision in If-Match is not the stored one
+	CodePreconditionFailed = "PreconditionFailed"
+
+	//CodeUnavailable - The database is down or did not answer in time
+	CodeUnavailable = "Unavailable"
+
+	//CodeInvalidInput - The request is not valid. A more precise code,
+	//e.g. "UnsecurePassword", is sent when the model provides one
+	CodeInvalidInput = "InvalidInput"
+
+	//CodeInvalidCredentials - Wrong username, password, secret or API key
+	CodeInvalidCredentials = "InvalidCredentials"
+
+	//CodeInvalidToken - The bearer token is missing, malformed or expired
+	CodeInvalidToken = "InvalidToken"
+
+	//CodeForbidden - The user is not allowed to perform the request
+	CodeForbidden = "Forbidden"
+
+	//CodeSessionLimit - The user has the maximum number of sessions
+	CodeSessionLimit = "SessionLimit"
+
+	//CodeSessionIdle - The session was not used for the idle timeout of
+	//the company, the user must log in again
+	CodeSessionIdle = "SessionIdle"
+
+	//CodeRemoteFailure - The remote auth relay failed or could not be reached
+	CodeRemoteFailure = "RemoteFailure"
+
+	//CodeInternal - Anything else
+	CodeInternal = "InternalError"
+)
+
+var (
+	errInvalidCredentials = errors.New(CodeInvalidCredentials)
+	errInvalidToken       = errors.New(CodeInvalidToken)
+	errForbidden          = errors.New(CodeForbidden)
+	errRemoteFailure      = errors.New(CodeRemoteFailure)
+	errPreconditionFailed = errors.New(CodePreconditionFailed)
+	errInternal           = errors.New(CodeInternal)
+)
+
+/*
+apiError - Embedded in every response. The business logic records why a
+request failed with fail, writeResponse then picks the HTTP status and
+the code is sent to the client.
+*/
+type apiError struct {
+	Code string `json:"code,omitempty"`
+	err  error
+}
+
+//fail - Record the reason of the failure
+func (e *apiError) fail(err error) {
+	if err == nil {
+		err = errInternal
+	}
+	e.err = err
+	_, e.Code = errorStatus(err)
+}
+
+func (e apiError) failure() error {
+	return e.err
+}
+
+//errorStatus - The HTTP status and the code for an error
+func errorStatus(err error) (int, string) {
+	var inputErr *model.InputError
+	var claimsErr *model.ClaimsError
+
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		return http.StatusNotFound, CodeNotFound
+	case errors.Is(err, model.ErrDuplicate):
+		return http.StatusConflict, CodeDuplicate
+	case errors.Is(err, errPreconditionFailed):
+		return http.StatusPreconditionFailed, CodePreconditionFailed
+	case errors.Is(err, model.ErrConflict):
+		return http.StatusConflict, CodeConflict
+	case errors.Is(err, model.ErrSessionLimit):
+		return http.StatusConflict, CodeSessionLimit
+	case errors.Is(err, model.ErrSessionIdle):
+		return http.StatusUnauthorized, CodeSessionIdle
+	case errors.Is(err, model.ErrUnavailable),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable, CodeUnavailable
+	case errors.As(err, &claimsErr):
+		return http.StatusUnauthorized, claimsErr.Reason
+	case errors.As(err, &inputErr):
+		return http.StatusBadRequest, inputErr.Code
+	case errors.Is(err, model.ErrInvalidInput):
+		return http.StatusBadRequest, CodeInvalidInput
+	case errors.Is(err, errInvalidCredentials):
+		return http.StatusUnauthorized, CodeInvalidCredentials
+	case errors.Is(err, errInvalidToken):
+		return http.StatusUnauthorized, CodeInvalidToken
+	case errors.Is(err, errForbidden):
+		return http.StatusForbidden, CodeForbidden
+	case errors.Is(err, errRemoteFailure):
+		return http.StatusBadGateway, CodeRemoteFailure
+	}
+
+	return http.StatusInternalServerError, CodeInternal
+}
+
+type errorResp struct {
+	Status string `json:"status"`
+	apiError
+}
+
+//writeError - Used when the request fails before the business logic
+//is called, e.g. the JSON could not be decoded
+func writeError(w http.ResponseWriter, err error) {
+	var rsp errorResp
+	rsp.Status = StatusFailure
+	rsp.fail(err)
+
+	status, _ := errorStatus(rsp.err)
+	jbuf, _ := json.Marshal(&rsp)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jbuf)
+}
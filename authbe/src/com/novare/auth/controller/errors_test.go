@@ -0,0 +1,78 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"com/novare/auth/model"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorStatus(t *testing.T) {
+
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{model.ErrNotFound, http.StatusNotFound, CodeNotFound},
+		{fmt.Errorf("wrapped: %w", model.ErrDuplicate), http.StatusConflict, CodeDuplicate},
+		{model.ErrConflict, http.StatusConflict, CodeConflict},
+		{model.ErrUnavailable, http.StatusServiceUnavailable, CodeUnavailable},
+		{context.DeadlineExceeded, http.StatusServiceUnavailable, CodeUnavailable},
+		{model.NewInputError("UnsecurePassword"), http.StatusBadRequest, "UnsecurePassword"},
+		{model.ErrInvalidInput, http.StatusBadRequest, CodeInvalidInput},
+		{errInvalidCredentials, http.StatusUnauthorized, CodeInvalidCredentials},
+		{errInvalidToken, http.StatusUnauthorized, CodeInvalidToken},
+		{errForbidden, http.StatusForbidden, CodeForbidden},
+		{errRemoteFailure, http.StatusBadGateway, CodeRemoteFailure},
+		{fmt.Errorf("Unknown"), http.StatusInternalServerError, CodeInternal},
+	}
+
+	for _, tc := range tests {
+		status, code := errorStatus(tc.err)
+		if status != tc.status || code != tc.code {
+			t.Errorf("[%s] mapped to %d %s, expected %d %s", tc.err, status, code, tc.status, tc.code)
+		}
+	}
+
+	var rsp permResp
+	rsp.Status = StatusFailure
+	rsp.fail(model.ErrNotFound)
+
+	rr := httptest.NewRecorder()
+	writeResponse(&rsp, rr)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("The response status is invalid: [%d]", rr.Code)
+	}
+
+	if !strings.Contains(rr.Body.String(), `"code":"NotFound"`) {
+		t.Errorf("The response does not contain the code: [%s]", rr.Body.String())
+	}
+}
@@ -26,24 +26,30 @@ SOFTWARE.
 
 import (
 	"com/novare/auth/model"
+	"context"
 	"log"
 	"time"
+	"unicode/utf8"
 )
 
-//GrantRequestBL - Let's check if a request can be granted
-func grantRequestBL(ucid string, jwtBearer *model.JWTToken, user *model.User) *accessTokenResp {
+//GrantRequestBL - Let's check if a request can be granted. The access
+//token says what it was granted for: the permission is its scope and the
+//audience, the company UniqueID unless given, who it is meant for.
+func grantRequestBL(ctx context.Context, ucid string, permission string, audience string, jwtBearer *model.JWTToken, user *model.User) *accessTokenResp {
 
 	var atr accessTokenResp
 	atr.Status = StatusFailure
 
-	company, err := model.FindCompanyByID(user.CompanyID)
+	company, err := model.FindCompanyByID(ctx, user.CompanyID)
 	if err != nil {
 		log.Printf("Grant Request: An error occurred while retrieving the company based on the JWT ID")
+		atr.fail(err)
 		return &atr
 	}
 
 	if user.UserStatus == model.UserStateDisabled {
 		log.Printf("The user has status of disabled. No requests will be approved for this user!")
+		atr.fail(errForbidden)
 		return &atr
 	}
 
@@ -56,16 +62,34 @@ func grantRequestBL(ucid string, jwtBearer *model.JWTToken, user *model.User) *a
 	//If the company unique id and the user defined company id do not match, remove the token... It is compromised
 	if company.UniqueID != ucid {
 		log.Printf("The company defined UNIQUEID and the user passed unique ID do not match. Invalidating the token with ID:[%s]", jwtBearer.ID.Hex())
-		model.RemoveJWTTokenByID(jwtBearer.ID.Hex())
+		if stored, err := model.FindJWTTokenBySignature(ctx, jwtBearer.Signature); err == nil {
+			model.RevokeJWTToken(ctx, stored)
+		}
+		atr.fail(errInvalidToken)
+		return &atr
+	}
+
+	//The middleware checked the permission, it is checked again as it
+	//becomes the scope of the token
+	if !model.IsValidPermissionName(permission) || !user.IsGranted(ctx, permission) {
+		log.Printf("The permission [%s] was not granted to the user [%s]", permission, user.ID.Hex())
+		atr.fail(errForbidden)
 		return &atr
 	}
 
 	accessToken := model.NewJWTToken(user.ID.Hex(), company.ID.Hex())
-	accessToken.Payload.Issuer = company.Name
-	accessToken.Payload.SetExpiration(time.Duration(company.Settings.JWTDuration) * time.Minute)
-	encodedToken, ok := accessToken.EncodeJWT()
-	if !ok {
-		log.Printf("There was an error creating the JWT access token")
+	accessToken.Payload.SetExpiration(time.Duration(company.Settings.JWTDuration))
+	accessToken.Payload.Scope = permission
+	accessToken.Payload.Roles = user.Roles
+	accessToken.Payload.Company = company.UniqueID
+	accessToken.Payload.Audience = audience
+	if utf8.RuneCountInString(audience) == 0 {
+		accessToken.Payload.Audience = company.UniqueID
+	}
+	encodedToken, err := model.IssueJWT(ctx, accessToken, company)
+	if err != nil {
+		log.Printf("There was an error signing the JWT access token: [%s]", err)
+		atr.fail(err)
 		return &atr
 	}
 
@@ -25,10 +25,14 @@ package controller
 
 import (
 	"com/novare/auth/model"
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
 func TestGrantRequestBLAccess(t *testing.T) {
+	ctx := context.Background()
 
 	var req createCompanyReq
 	req.Address1 = "My Address"
@@ -47,13 +51,13 @@ func TestGrantRequestBLAccess(t *testing.T) {
 	req.Settings.PassExpiration = 10
 	req.Settings.PassUnit = model.PassUnitDay
 
-	rsp := createCompanyBL(req)
+	rsp := createCompanyBL(ctx, req)
 	if rsp.Status != StatusSuccess {
 		t.Errorf("The company should have been created but it did not!")
 		return
 	}
 
-	company, err := model.FindCompanyByID(rsp.CompanyID)
+	company, err := model.FindCompanyByID(ctx, rsp.CompanyID)
 	if err != nil {
 		t.Errorf("The following error occurred when finding the company:[%s]", rsp.CompanyID)
 		return
@@ -64,13 +68,13 @@ func TestGrantRequestBLAccess(t *testing.T) {
 	lr.Username = "superuser"
 	lr.Password = "@123ABC789"
 
-	lrsp := loginBL(lr)
+	lrsp := loginBL(ctx, lr)
 	if lrsp.Status != StatusSuccess {
 		t.Error("An error occurred when the login was performed")
 		return
 	}
 
-	users, err := model.ListUsersByCompanyID(company.ID.Hex())
+	users, err := model.ListUsersByCompanyID(ctx, company.ID.Hex())
 	if err != nil {
 		t.Errorf("There was an issue listing all the users for companyID: [%s] Error:[%s]", company.ID.Hex(), err)
 		return
@@ -83,26 +87,103 @@ func TestGrantRequestBLAccess(t *testing.T) {
 		return
 	}
 
-	jwtTmp, err := model.FindJWTTokenBySignature(jwt.Signature)
+	jwtTmp, err := model.FindJWTTokenBySignature(ctx, jwt.Signature)
 	if err != nil {
 		t.Errorf("The JWT with signature:[%s] was not found", jwt.Signature)
 		return
 	}
 
-	atr := grantRequestBL(company.UniqueID, jwtTmp, &users[0])
+	atr := grantRequestBL(ctx, company.UniqueID, "GET_USER", "pos-lane", jwtTmp, &users[0])
 	if atr.Status != StatusSuccess {
 		t.Errorf("There was an error retrieving the grant for the request for Access Token")
 		return
 	}
 
+	access := model.NewJWTToken("", "")
+	access.ParseJWT(atr.AccessToken)
+	claims := access.Payload
+	if claims.Scope != "GET_USER" || claims.Audience != "pos-lane" || claims.Company != company.UniqueID ||
+		claims.Subject != users[0].ID.Hex() || len(claims.ID) == 0 || len(claims.Roles) != len(users[0].Roles) {
+		t.Errorf("The access token claims are invalid: %+v", claims)
+	}
+
+	//The claims over the limits are not issued
+	limits := model.TokenClaimLimits
+	model.TokenClaimLimits.MaxRoles = 1
+	withRoles := users[0]
+	withRoles.Roles = []string{"ROLE1", "ROLE2"}
+	atr = grantRequestBL(ctx, company.UniqueID, "GET_USER", "", jwtTmp, &withRoles)
+	if atr.Status != StatusFailure || atr.Code != "TooManyRoles" {
+		t.Errorf("The access token with too many roles should not be issued: %+v", atr)
+	}
+	model.TokenClaimLimits = limits
+
 	for i := range users {
-		model.RemoveUserByID(users[i].ID.Hex())
+		model.RemoveUserByID(ctx, users[i].ID.Hex())
 	}
 
-	model.RemoveCompanyByID(company.ID.Hex())
-	err = model.RemoveJWTTokenByID(jwtTmp.ID.Hex())
+	model.RemoveCompanyByID(ctx, company.ID.Hex())
+	err = model.RemoveJWTTokenByID(ctx, jwtTmp.ID.Hex())
 	if err != nil {
 		t.Errorf("The following error occurred: [%s]", err)
 	}
 
 }
+
+//The grant-request becomes the scope of the access token, "*" is refused
+//even to the superuser, who is granted every permission
+func TestGrantRequestWildcard(t *testing.T) {
+	ctx := context.Background()
+
+	company, superuser, lrsp := loginTestCompany(t, "GRANTWILDCARDID")
+	if lrsp == nil {
+		return
+	}
+	defer performCompanyCleanup(company.ID.Hex(), t)
+
+	clerk := model.NewUser()
+	clerk.CompanyID = company.ID.Hex()
+	clerk.Username = "clerk"
+	clerk.SetPassword("@123ABC789")
+	err := model.InsertUser(ctx, clerk)
+	if err != nil {
+		t.Errorf("The user could not be inserted: [%s]", err)
+		return
+	}
+	clerkLogin := loginBL(ctx, loginReq{UniqueID: company.UniqueID, Username: "clerk", Password: "@123ABC789"})
+	if clerkLogin.Status != StatusSuccess {
+		t.Errorf("The user did not log in: %+v", clerkLogin)
+		return
+	}
+
+	grant := func(token string, permission string) int {
+		req := httptest.NewRequest("GET", "/jwt/grant/"+company.UniqueID, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("grant-request", permission)
+		rec := httptest.NewRecorder()
+		NewRouter().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := grant(clerkLogin.SessionToken, "ADD_USER"); code != http.StatusForbidden {
+		t.Errorf("A permission the user does not hold was granted: %d", code)
+	}
+	for _, permission := range []string{"*", "ADD_USER *", "GET_USER ADD_USER"} {
+		if code := grant(clerkLogin.SessionToken, permission); code != http.StatusBadRequest {
+			t.Errorf("The grant-request [%s] was not refused: %d", permission, code)
+		}
+		if code := grant(lrsp.SessionToken, permission); code != http.StatusBadRequest {
+			t.Errorf("The grant-request [%s] was not refused to the superuser: %d", permission, code)
+		}
+	}
+
+	jwt := model.NewJWTToken("", "")
+	jwt.ParseJWT(lrsp.SessionToken)
+	if grantRequestBL(ctx, company.UniqueID, "*", "", jwt, superuser).Status == StatusSuccess {
+		t.Errorf("An access token was minted for the * scope")
+	}
+	clerk.Scope = nil
+	if grantRequestBL(ctx, company.UniqueID, "ADD_USER", "", jwt, clerk).Status == StatusSuccess {
+		t.Errorf("An access token was minted for a permission the user does not hold")
+	}
+}
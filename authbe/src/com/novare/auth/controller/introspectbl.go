@@ -0,0 +1,197 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"com/novare/auth/model"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	//TokenTypeSession - The token returned by the login, stored by the
+	//edgeauth
+	TokenTypeSession string = "session_token"
+	//TokenTypeAccess - The token returned by the grant request, verified
+	//with the signing keys only
+	TokenTypeAccess string = "access_token"
+)
+
+/*
+introspectResp - The RFC 7662 introspection response. An inactive token
+only says so, nothing else about it is disclosed.
+*/
+type introspectResp struct {
+	Active bool `json:"active"`
+	apiError
+	Scope       string   `json:"scope,omitempty"` //The permissions separated by spaces, the granted one for an access token
+	Username    string   `json:"username,omitempty"`
+	TokenType   string   `json:"token_type,omitempty"` //TokenTypeSession or TokenTypeAccess
+	ExpiresAt   int64    `json:"exp,omitempty"`
+	IssuedAt    int64    `json:"iat,omitempty"`
+	NotBefore   int64    `json:"nbf,omitempty"`
+	Subject     string   `json:"sub,omitempty"` //The user ID
+	Audience    string   `json:"aud,omitempty"`
+	Issuer      string   `json:"iss,omitempty"`
+	ID          string   `json:"jti,omitempty"`
+	CompanyID   string   `json:"company_id,omitempty"`
+	Company     string   `json:"company,omitempty"` //The company UniqueID
+	Permissions []string `json:"permissions,omitempty"`
+	Roles       []string `json:"roles,omitempty"`     //The role IDs the access token carries
+	ClientID    string   `json:"client_id,omitempty"` //The OAuth client the access token was issued to
+}
+
+/*
+introspectBL - Tell the caller whether the token is active, who it belongs
+to and what it can do. The tokens of other companies are reported as
+inactive. Only a database failure is an error, every problem with the
+token makes it inactive.
+*/
+func introspectBL(ctx context.Context, caller *model.User, token string) *introspectResp {
+
+	var rsp introspectResp
+
+	jwt := model.NewJWTToken("", "")
+	err := jwt.ParseJWT(token)
+	if err != nil {
+		log.Printf("The token to introspect could not be parsed: [%s]", err)
+		return &rsp
+	}
+
+	if jwt.IsSignedWithKey() {
+		err = model.VerifyJWTSignature(ctx, jwt)
+		if errors.Is(err, model.ErrInvalidSignature) {
+			return &rsp
+		}
+		if err != nil {
+			rsp.fail(err)
+			return &rsp
+		}
+	}
+
+	revoked, err := model.IsTokenRevoked(ctx, jwt.Payload.ID)
+	if err != nil {
+		rsp.fail(err)
+		return &rsp
+	}
+	if revoked {
+		return &rsp
+	}
+
+	//The session tokens are stored, the access tokens are not
+	tokenType := TokenTypeSession
+	userID := ""
+	payload := jwt.Payload
+	stored, err := model.FindJWTTokenBySignature(ctx, jwt.Signature)
+	switch {
+	case err == nil:
+		if !jwt.IsSignedWithKey() {
+			jwt.Secret = stored.Secret
+			if jwt.IsTampered() {
+				return &rsp
+			}
+		}
+		userID = stored.UserID
+		payload = stored.Payload
+	case errors.Is(err, model.ErrNotFound):
+		if !jwt.IsSignedWithKey() {
+			return &rsp
+		}
+		tokenType = TokenTypeAccess
+		userID = jwt.Payload.Subject
+	default:
+		rsp.fail(err)
+		return &rsp
+	}
+
+	policy := model.SessionClaimsPolicy()
+	if tokenType == TokenTypeAccess {
+		//An access token of a company with a jwtDuration of 0 never expires
+		policy = model.NewClaimsPolicy()
+	}
+	err = policy.Validate(&payload, time.Now())
+	if err != nil {
+		log.Printf("The token to introspect is not valid: [%s]", err)
+		return &rsp
+	}
+
+	user, err := model.FindUserByID(ctx, userID)
+	if errors.Is(err, model.ErrNotFound) || errors.Is(err, model.ErrInvalidInput) {
+		return &rsp
+	}
+	if err != nil {
+		rsp.fail(err)
+		return &rsp
+	}
+
+	if user.UserStatus == model.UserStateDisabled || user.CompanyID != caller.CompanyID {
+		log.Printf("The token of user [%s] is not active for the caller [%s]", user.ID.Hex(), caller.ID.Hex())
+		return &rsp
+	}
+
+	company, err := model.FindCompanyByID(ctx, user.CompanyID)
+	if err != nil {
+		rsp.fail(err)
+		return &rsp
+	}
+
+	if tokenType == TokenTypeSession && model.IsSessionIdle(stored, &company.Settings, time.Now()) {
+		log.Printf("The session [%s] to introspect is idle", stored.SessionID())
+		return &rsp
+	}
+
+	user.Scope = payload.Scopes()
+	perms, err := user.GrantedPermissions(ctx)
+	if err != nil {
+		rsp.fail(err)
+		return &rsp
+	}
+
+	rsp.Active = true
+	rsp.TokenType = tokenType
+	rsp.Username = user.Username
+	rsp.Subject = user.ID.Hex()
+	rsp.CompanyID = company.ID.Hex()
+	rsp.Company = company.UniqueID
+	rsp.ExpiresAt = payload.ExpirationTime
+	rsp.IssuedAt = payload.IssuedAt
+	rsp.NotBefore = payload.NotBefore
+	rsp.Audience = payload.Audience
+	rsp.Issuer = payload.Issuer
+	rsp.ID = payload.ID
+	rsp.Permissions = perms
+	rsp.Scope = strings.Join(perms, " ")
+	if utf8.RuneCountInString(payload.Scope) > 0 {
+		//The access tokens are only good for what they were granted
+		rsp.Scope = payload.Scope
+	}
+	rsp.Roles = payload.Roles
+	rsp.ClientID = payload.ClientID
+	return &rsp
+}
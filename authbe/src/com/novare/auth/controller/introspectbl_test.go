@@ -0,0 +1,146 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"com/novare/auth/model"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+//loginTestCompany - Create a company and log its superuser in. The caller
+//removes the company with performCompanyCleanup.
+func loginTestCompany(t *testing.T, uniqueID string) (*model.Company, *model.User, *loginResp) {
+	ctx := context.Background()
+
+	var req createCompanyReq
+	req.Name = uniqueID
+	req.IsInLocation = "true"
+	req.RemotelyManaged = "false"
+	req.UniqueID = uniqueID
+	req.Password = "@123ABC789"
+	req.ConfirmPassword = req.Password
+
+	rsp := createCompanyBL(ctx, req)
+	if rsp.Status != StatusSuccess {
+		t.Errorf("The company [%s] could not be created: [%s]", uniqueID, rsp.Code)
+		return nil, nil, nil
+	}
+
+	company, err := model.FindCompanyByID(ctx, rsp.CompanyID)
+	if err != nil {
+		t.Errorf("The company [%s] was not found: [%s]", rsp.CompanyID, err)
+		return nil, nil, nil
+	}
+
+	user, err := model.FindUserByUsernameCompanyID(ctx, "superuser", rsp.CompanyID)
+	if err != nil {
+		t.Errorf("The superuser was not found: [%s]", err)
+		return nil, nil, nil
+	}
+
+	var lr loginReq
+	lr.UniqueID = uniqueID
+	lr.Username = "superuser"
+	lr.Password = req.Password
+	lrsp := loginBL(ctx, lr)
+	if lrsp.Status != StatusSuccess {
+		t.Errorf("The superuser of [%s] could not log in: [%s]", uniqueID, lrsp.Code)
+		return nil, nil, nil
+	}
+
+	return company, user, lrsp
+}
+
+func TestIntrospectBL(t *testing.T) {
+	ctx := context.Background()
+
+	company, user, lrsp := loginTestCompany(t, "INTROSPECTID")
+	if lrsp == nil {
+		return
+	}
+	defer performCompanyCleanup(company.ID.Hex(), t)
+
+	rsp := introspectBL(ctx, user, lrsp.SessionToken)
+	if !rsp.Active || rsp.TokenType != TokenTypeSession || rsp.Company != "INTROSPECTID" || rsp.Username != "superuser" {
+		t.Errorf("The session token should be active: %+v", rsp)
+	}
+
+	if !strings.Contains(rsp.Scope, "INTROSPECT_TOKEN") || rsp.Subject != user.ID.Hex() || rsp.ExpiresAt == 0 {
+		t.Errorf("The session token claims are invalid: %+v", rsp)
+	}
+
+	session := model.NewJWTToken("", "")
+	session.ParseJWT(lrsp.SessionToken)
+	stored, _ := model.FindJWTTokenBySignature(ctx, session.Signature)
+	atr := grantRequestBL(ctx, company.UniqueID, "INTROSPECT_TOKEN", "", stored, user)
+	if atr.Status != StatusSuccess {
+		t.Errorf("The access token was not granted: [%s]", atr.Code)
+		return
+	}
+
+	rsp = introspectBL(ctx, user, atr.AccessToken)
+	if !rsp.Active || rsp.TokenType != TokenTypeAccess || rsp.Subject != user.ID.Hex() || rsp.Scope != "INTROSPECT_TOKEN" {
+		t.Errorf("The access token should be active: %+v", rsp)
+	}
+
+	//Another company can not see the token
+	other := model.NewUser()
+	other.CompanyID = "ANOTHERCOMPANY"
+	rsp = introspectBL(ctx, other, atr.AccessToken)
+	if rsp.Active || rsp.Username != "" {
+		t.Errorf("The token of another company should be inactive: %+v", rsp)
+	}
+
+	fields := strings.Split(atr.AccessToken, ".")
+	tampered := fields[0] + "." + fields[1] + "." + strings.Repeat("A", len(fields[2]))
+	for _, token := range []string{"", "not.a.token", tampered} {
+		rsp = introspectBL(ctx, user, token)
+		if rsp.Active {
+			t.Errorf("The token [%s] should be inactive", token)
+		}
+	}
+
+	//Through the route, authenticated with the session token
+	form := url.Values{"token": {atr.AccessToken}}
+	httpReq := httptest.NewRequest("POST", "/oauth/introspect", strings.NewReader(form.Encode()))
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Authorization", "bearer "+lrsp.SessionToken)
+	httpRec := httptest.NewRecorder()
+	NewRouter().ServeHTTP(httpRec, httpReq)
+
+	var body map[string]interface{}
+	json.Unmarshal(httpRec.Body.Bytes(), &body)
+	if httpRec.Code != http.StatusOK || body["active"] != true || body["token_type"] != TokenTypeAccess {
+		t.Errorf("The introspection failed: %d [%s]", httpRec.Code, httpRec.Body.String())
+	}
+
+	model.RemoveJWTTokenByID(ctx, stored.ID.Hex())
+}
@@ -0,0 +1,120 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"com/novare/auth/model"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJSONWebKeySet(t *testing.T) {
+	ctx := context.Background()
+
+	var req createCompanyReq
+	req.Name = "JWKS"
+	req.IsInLocation = "true"
+	req.RemotelyManaged = "false"
+	req.UniqueID = "JWKSUNIQUEID"
+	req.Password = "@123ABC789"
+	req.ConfirmPassword = req.Password
+
+	rsp := createCompanyBL(ctx, req)
+	if rsp.Status != StatusSuccess {
+		t.Errorf("The company should have been created but it did not!")
+		return
+	}
+	defer performCompanyCleanup(rsp.CompanyID, t)
+
+	var lr loginReq
+	lr.UniqueID = req.UniqueID
+	lr.Username = "superuser"
+	lr.Password = req.Password
+	lrsp := loginBL(ctx, lr)
+	if lrsp.Status != StatusSuccess {
+		t.Error("An error occurred when the login was performed")
+		return
+	}
+
+	httpRec := httptest.NewRecorder()
+	NewRouter().ServeHTTP(httpRec, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	if httpRec.Code != http.StatusOK {
+		t.Errorf("The key set was not served: [%d]", httpRec.Code)
+		return
+	}
+
+	var set model.JSONWebKeySet
+	err := json.Unmarshal(httpRec.Body.Bytes(), &set)
+	if err != nil {
+		t.Errorf("The key set could not be decoded: [%s]", err)
+		return
+	}
+
+	//Verify the session token with the published key only
+	jwt := model.NewJWTToken("", "")
+	err = jwt.ParseJWT(lrsp.SessionToken)
+	if err != nil {
+		t.Errorf("The session token could not be parsed: [%s]", err)
+		return
+	}
+
+	var found *model.JSONWebKey
+	for i := range set.Keys {
+		if set.Keys[i].KeyID == jwt.Header.KeyID {
+			found = &set.Keys[i]
+		}
+	}
+	if found == nil || jwt.Header.Algo != model.AlgRS256 {
+		t.Errorf("The key [%s] of the %s token is not published", jwt.Header.KeyID, jwt.Header.Algo)
+		return
+	}
+
+	pub, err := found.PublicKey()
+	if err != nil {
+		t.Errorf("The published key is invalid: [%s]", err)
+		return
+	}
+
+	fields := strings.Split(lrsp.SessionToken, ".")
+	sig, _ := base64.RawURLEncoding.DecodeString(fields[2])
+	hash := sha256.Sum256([]byte(fields[0] + "." + fields[1]))
+	err = rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, hash[:], sig)
+	if err != nil {
+		t.Errorf("The session token does not verify with the published key: [%s]", err)
+	}
+
+	stored, err := model.FindJWTTokenBySignature(ctx, jwt.Signature)
+	if err == nil {
+		model.RemoveJWTTokenByID(ctx, stored.ID.Hex())
+	}
+}
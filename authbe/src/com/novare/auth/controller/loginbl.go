@@ -26,62 +26,219 @@ package controller
 
 import (
 	"com/novare/auth/model"
+	"context"
+	"errors"
 	"log"
+	"time"
 	"unicode/utf8"
 )
 
-func getJWTToken(user *model.User, company *model.Company, lrsp *loginResp) *loginResp {
+//credentialsErr - An unknown company or user is reported the same way as
+//a wrong password, the database errors are kept
+func credentialsErr(err error) error {
+	if errors.Is(err, model.ErrNotFound) {
+		return errInvalidCredentials
+	}
+	return err
+}
 
-	jwtTmp, err := model.FindJWTTokenByUserIDCompanyID(user.ID.Hex(), company.ID.Hex())
-	if err == nil {
-		log.Printf("A JWT already exist for this user and company. Removing it now")
-		model.RemoveJWTTokenByID(jwtTmp.ID.Hex())
+//refreshErr - A refresh token that is unknown, reused or expired is an
+//invalid token, the database errors are kept
+func refreshErr(err error) error {
+	if errors.Is(err, model.ErrRefreshTokenReused) || errors.Is(err, model.ErrRefreshTokenExpired) {
+		return errInvalidToken
 	}
+	return tokenErr(err)
+}
+
+//getJWTToken - Start a new session of the user. The other sessions are
+//kept within the MaxSessions of the company.
+func getJWTToken(ctx context.Context, user *model.User, company *model.Company, info model.SessionInfo, lrsp *loginResp) *loginResp {
+	return getClientJWTToken(ctx, user, company, info, "", "", lrsp)
+}
+
+//getClientJWTToken - Start a new session of the user for the OAuth client,
+//limited to the scope. Without a client the session is not limited.
+func getClientJWTToken(ctx context.Context, user *model.User, company *model.Company, info model.SessionInfo, clientID string, scope string, lrsp *loginResp) *loginResp {
+
+	err := model.AdmitSession(ctx, user, company, info)
+	if err != nil {
+		log.Printf("The session of the user [%s] could not be started: [%s]", user.Username, err)
+		lrsp.fail(err)
+		return lrsp
+	}
+
+	//Every login starts a new refresh token family
+	refresh, refreshValue, err := model.CreateClientRefreshToken(ctx, user, company, clientID, scope)
+	if err != nil {
+		lrsp.fail(err)
+		return lrsp
+	}
+
+	return issueSession(ctx, user, company, refresh, info, refreshValue, lrsp)
+}
+
+//issueSession - Sign and store a session token of the refresh token family,
+//with the client and scope of the family
+func issueSession(ctx context.Context, user *model.User, company *model.Company, refresh *model.RefreshToken, info model.SessionInfo, refreshValue string, lrsp *loginResp) *loginResp {
 
 	//Now we need to create JWT token
 	jwtToken := model.NewJWTToken(user.ID.Hex(), company.ID.Hex())
-	encodedToken, ok := jwtToken.EncodeJWT()
-	if !ok {
-		log.Printf("The token could not be encoded: [%s]", encodedToken)
+	jwtToken.FamilyID = refresh.FamilyID
+	jwtToken.Payload.ClientID = refresh.ClientID
+	jwtToken.Payload.Scope = refresh.Scope
+	jwtToken.Session = info
+	duration := company.Settings.GetSessionDuration()
+	jwtToken.Payload.ExpirationTime = time.Now().Add(duration).Unix()
+	encodedToken, err := model.IssueJWT(ctx, jwtToken, company)
+	if err != nil {
+		log.Printf("The token could not be signed: [%s]", err)
+		lrsp.fail(err)
 		return lrsp
 	}
 
-	err = model.InsertJWTToken(jwtToken)
+	err = model.InsertJWTToken(ctx, jwtToken)
 	if err != nil {
 		log.Printf("There was an error inserting the JWT Token:[%s] ", err)
+		lrsp.fail(err)
 		return lrsp
 	}
 	lrsp.Status = StatusSuccess
 	lrsp.SessionToken = encodedToken
+	lrsp.RefreshToken = refreshValue
+	lrsp.ExpiresIn = int64(duration / time.Second)
 	return lrsp
 }
 
-func loginBL(lreq loginReq) *loginResp {
+/*
+refreshBL - Exchange a refresh token for a new session token and a new
+refresh token. The refresh tokens of the OAuthClients are only refreshed
+by their client at the token endpoint, they are rejected here.
+*/
+func refreshBL(ctx context.Context, req refreshReq) *loginResp {
+
+	var rsp loginResp
+	rsp.Status = StatusFailure
+
+	if utf8.RuneCountInString(req.RefreshToken) == 0 {
+		rsp.fail(model.NewInputError("MissingRefreshToken"))
+		return &rsp
+	}
+
+	stored, err := model.FindRefreshToken(ctx, req.RefreshToken)
+	if err != nil {
+		log.Printf("The refresh token was rejected: [%s]", err)
+		rsp.fail(refreshErr(err))
+		return &rsp
+	}
+
+	if utf8.RuneCountInString(stored.ClientID) > 0 {
+		log.Printf("The refresh token of the client [%s] can only be used at the token endpoint", stored.ClientID)
+		rsp.fail(errInvalidToken)
+		return &rsp
+	}
+
+	return refreshSession(ctx, req.RefreshToken)
+}
+
+/*
+refreshSession - Rotate the refresh token and issue a new session token.
+The session the used token belonged to is replaced. A refresh token
+presented twice revokes its family, whoever holds the newer token has to
+log in again.
+*/
+func refreshSession(ctx context.Context, refreshToken string) *loginResp {
+
+	var rsp loginResp
+	rsp.Status = StatusFailure
+
+	used, _, refreshValue, err := model.RotateRefreshToken(ctx, refreshToken)
+	if err != nil {
+		log.Printf("The refresh token was rejected: [%s]", err)
+		rsp.fail(refreshErr(err))
+		return &rsp
+	}
+
+	user, err := model.FindUserByID(ctx, used.UserID)
+	if err != nil {
+		log.Printf("The user of the refresh token was not found: [%s]", err)
+		rsp.fail(tokenErr(err))
+		return &rsp
+	}
+
+	if user.UserStatus == model.UserStateDisabled {
+		log.Printf("The user [%s] is disabled, revoking the refresh token family", user.Username)
+		model.RevokeTokenFamily(ctx, used.FamilyID)
+		rsp.fail(errForbidden)
+		return &rsp
+	}
+
+	company, err := model.FindCompanyByID(ctx, used.CompanyID)
+	if err != nil {
+		log.Printf("The company of the refresh token was not found: [%s]", err)
+		rsp.fail(tokenErr(err))
+		return &rsp
+	}
+
+	sessions, err := model.ListJWTTokensByFamilyID(ctx, used.FamilyID)
+	if err != nil {
+		rsp.fail(err)
+		return &rsp
+	}
+	//A session idle for too long can not be refreshed, the user must log in
+	if len(sessions) > 0 && model.IsSessionIdle(&sessions[len(sessions)-1], &company.Settings, time.Now()) {
+		log.Printf("The session [%s] is idle, it can not be refreshed", used.FamilyID)
+		model.EndSession(ctx, &sessions[len(sessions)-1])
+		rsp.fail(model.ErrSessionIdle)
+		return &rsp
+	}
+
+	//The new session token stays on the terminal of the login. The refresh
+	//is not a use of the session, the last use is kept.
+	var info model.SessionInfo
+	for i := range sessions {
+		info = sessions[i].Session
+		model.RevokeJWTToken(ctx, &sessions[i])
+	}
+
+	r := issueSession(ctx, user, company, used, info, refreshValue, &rsp)
+	r.Fullname = user.Name
+	r.Username = user.Username
+	r.IsThing = user.IsThing
+	r.UserStatus = user.UserStatus
+	return r
+}
+
+func loginBL(ctx context.Context, lreq loginReq) *loginResp {
 
 	var lrsp loginResp
 	lrsp.Status = StatusFailure
 
 	//Find the company
-	company, err := model.FindCompanyByUniqueID(lreq.UniqueID)
+	company, err := model.FindCompanyByUniqueID(ctx, lreq.UniqueID)
 	if err != nil {
 		log.Printf("The Company was not found, Error:[%s]", err)
+		lrsp.fail(credentialsErr(err))
 		return &lrsp
 	}
 
 	//Find the user for the company. Use the username
-	user, err := model.FindUserByUsernameCompanyID(lreq.Username, company.ID.Hex())
+	user, err := model.FindUserByUsernameCompanyID(ctx, lreq.Username, company.ID.Hex())
 	if err != nil {
 		log.Printf("The user for company ID:[%s] has not been found! Error:[%s]", company.ID.Hex(), err)
+		lrsp.fail(credentialsErr(err))
 		return &lrsp
 	}
 
 	//Is the password correct
 	if !user.IsPasswordMatch(lreq.Password) {
 		log.Printf("The password is invalid, return with failure")
+		lrsp.fail(errInvalidCredentials)
 		return &lrsp
 	}
 
-	r := getJWTToken(user, company, &lrsp)
+	info := model.NewSessionInfo(lreq.DeviceID, lreq.IPAddress, lreq.UserAgent)
+	r := getJWTToken(ctx, user, company, info, &lrsp)
 	r.Fullname = user.Name
 	r.Username = user.Username
 	r.IsThing = user.IsThing
@@ -90,39 +247,45 @@ func loginBL(lreq loginReq) *loginResp {
 	return r
 }
 
-func loginBySecretBL(req loginSecretReq) *loginResp {
+func loginBySecretBL(ctx context.Context, req loginSecretReq) *loginResp {
 
 	var resp loginResp
 	resp.Status = StatusFailure
 
-	company, err := model.FindCompanyByUniqueID(req.UniqueID)
+	company, err := model.FindCompanyByUniqueID(ctx, req.UniqueID)
 	if err != nil {
 		log.Printf("ERROR:[%s] Login not possible", err)
+		resp.fail(credentialsErr(err))
 		return &resp
 	}
 
 	if company.APIKey != req.APIKey {
 		log.Printf("The APIKey is not valid")
+		resp.fail(errInvalidCredentials)
 		return &resp
 	}
 
-	user, err := model.FindUserByUsernameCompanyID(req.Username, company.ID.Hex())
+	user, err := model.FindUserByUsernameCompanyID(ctx, req.Username, company.ID.Hex())
 	if err != nil {
 		log.Printf("Error retrieving the user:[%s]", err)
+		resp.fail(credentialsErr(err))
 		return &resp
 	}
 
 	if utf8.RuneCountInString(req.Secret) == 0 {
 		log.Printf("The Secret is not valid, aborting the request")
+		resp.fail(errInvalidCredentials)
 		return &resp
 	}
 
 	if user.Secret != req.Secret {
 		log.Printf("The secret does not match, the login will be rejected")
+		resp.fail(errInvalidCredentials)
 		return &resp
 	}
 
-	r := getJWTToken(user, company, &resp)
+	info := model.NewSessionInfo(req.DeviceID, req.IPAddress, req.UserAgent)
+	r := getJWTToken(ctx, user, company, info, &resp)
 	r.Fullname = user.Name
 	r.Username = user.Username
 	r.IsThing = user.IsThing
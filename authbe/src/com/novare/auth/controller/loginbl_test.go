@@ -26,10 +26,12 @@ package controller
 
 import (
 	"com/novare/auth/model"
+	"context"
 	"testing"
 )
 
 func TestLoginBL(t *testing.T) {
+	ctx := context.Background()
 
 	var req createCompanyReq
 	req.Address1 = "My Address"
@@ -45,13 +47,13 @@ func TestLoginBL(t *testing.T) {
 	req.Password = "@123ABC789"
 	req.ConfirmPassword = req.Password
 
-	rsp := createCompanyBL(req)
+	rsp := createCompanyBL(ctx, req)
 	if rsp.Status != StatusSuccess {
 		t.Errorf("The company should have been created but it did not!")
 		return
 	}
 
-	company, err := model.FindCompanyByID(rsp.CompanyID)
+	company, err := model.FindCompanyByID(ctx, rsp.CompanyID)
 	if err != nil {
 		t.Errorf("The following error occurred when finding the company:[%s]", rsp.CompanyID)
 		return
@@ -62,23 +64,23 @@ func TestLoginBL(t *testing.T) {
 	lr.Username = "superuser"
 	lr.Password = "@123ABC789"
 
-	lrsp := loginBL(lr)
+	lrsp := loginBL(ctx, lr)
 	if lrsp.Status != StatusSuccess {
 		t.Error("An error occurred when the login was performed")
 		return
 	}
 
-	users, err := model.ListUsersByCompanyID(company.ID.Hex())
+	users, err := model.ListUsersByCompanyID(ctx, company.ID.Hex())
 	if err != nil {
 		t.Errorf("There was an issue listing all the users for companyID: [%s] Error:[%s]", company.ID.Hex(), err)
 		return
 	}
 
 	for i := range users {
-		model.RemoveUserByID(users[i].ID.Hex())
+		model.RemoveUserByID(ctx, users[i].ID.Hex())
 	}
 
-	model.RemoveCompanyByID(company.ID.Hex())
+	model.RemoveCompanyByID(ctx, company.ID.Hex())
 
 	jwt := model.NewJWTToken(users[0].ID.Hex(), company.ID.Hex())
 	err = jwt.ParseJWT(lrsp.SessionToken)
@@ -87,15 +89,140 @@ func TestLoginBL(t *testing.T) {
 		return
 	}
 
-	jwtTmp, err := model.FindJWTTokenBySignature(jwt.Signature)
+	jwtTmp, err := model.FindJWTTokenBySignature(ctx, jwt.Signature)
 	if err != nil {
 		t.Errorf("The JWT with signature:[%s] was not found", jwt.Signature)
 		return
 	}
 
-	err = model.RemoveJWTTokenByID(jwtTmp.ID.Hex())
+	err = model.RemoveJWTTokenByID(ctx, jwtTmp.ID.Hex())
 	if err != nil {
 		t.Errorf("The following error occurred: [%s]", err)
 	}
 
 }
+
+func TestRefreshBL(t *testing.T) {
+	ctx := context.Background()
+
+	var req createCompanyReq
+	req.Name = "REFRESH"
+	req.IsInLocation = "true"
+	req.RemotelyManaged = "false"
+	req.UniqueID = "REFRESHUNIQUEID"
+	req.Password = "@123ABC789"
+	req.ConfirmPassword = req.Password
+
+	rsp := createCompanyBL(ctx, req)
+	if rsp.Status != StatusSuccess {
+		t.Errorf("The company should have been created but it did not!")
+		return
+	}
+	defer performCompanyCleanup(rsp.CompanyID, t)
+
+	var lr loginReq
+	lr.UniqueID = req.UniqueID
+	lr.Username = "superuser"
+	lr.Password = req.Password
+	lrsp := loginBL(ctx, lr)
+	if lrsp.Status != StatusSuccess || len(lrsp.RefreshToken) == 0 {
+		t.Error("The login did not return a refresh token")
+		return
+	}
+
+	if lrsp.ExpiresIn != model.DefaultSessionDuration*60 {
+		t.Errorf("The session should live the default duration: %d", lrsp.ExpiresIn)
+	}
+
+	rrsp := refreshBL(ctx, refreshReq{RefreshToken: lrsp.RefreshToken})
+	if rrsp.Status != StatusSuccess || rrsp.Username != "superuser" {
+		t.Errorf("The refresh failed: [%s]", rrsp.Code)
+		return
+	}
+
+	if rrsp.RefreshToken == lrsp.RefreshToken || rrsp.SessionToken == lrsp.SessionToken {
+		t.Errorf("The refresh should return new tokens")
+	}
+
+	//The session the refresh token belonged to was replaced
+	old := model.NewJWTToken("", "")
+	old.ParseJWT(lrsp.SessionToken)
+	_, err := model.FindJWTTokenBySignature(ctx, old.Signature)
+	if err != model.ErrNotFound {
+		t.Errorf("The replaced session is still valid: [%v]", err)
+	}
+
+	//Presenting the first refresh token again revokes everything
+	reuse := refreshBL(ctx, refreshReq{RefreshToken: lrsp.RefreshToken})
+	if reuse.failure() != errInvalidToken {
+		t.Errorf("The reused refresh token was accepted: [%s]", reuse.Code)
+	}
+
+	revoked := refreshBL(ctx, refreshReq{RefreshToken: rrsp.RefreshToken})
+	if revoked.failure() != errInvalidToken {
+		t.Errorf("The refresh token family should be revoked: [%s]", revoked.Code)
+	}
+
+	current := model.NewJWTToken("", "")
+	current.ParseJWT(rrsp.SessionToken)
+	_, err = model.FindJWTTokenBySignature(ctx, current.Signature)
+	if err != model.ErrNotFound {
+		t.Errorf("The session of the revoked family is still valid: [%v]", err)
+	}
+}
+
+func TestLoginSessions(t *testing.T) {
+	ctx := context.Background()
+
+	company, user, backOffice := loginTestCompany(t, "SESSIONSID")
+	if backOffice == nil {
+		return
+	}
+	defer performCompanyCleanup(company.ID.Hex(), t)
+
+	var lr loginReq
+	lr.UniqueID = company.UniqueID
+	lr.Username = "superuser"
+	lr.Password = "@123ABC789"
+	lr.DeviceID = "LANE1"
+	lr.IPAddress = "10.0.0.21"
+	lr.UserAgent = "lane"
+	lane := loginBL(ctx, lr)
+	if lane.Status != StatusSuccess {
+		t.Errorf("The login on the lane failed: [%s]", lane.Code)
+		return
+	}
+
+	//The back-office session is still valid
+	for _, token := range []string{backOffice.SessionToken, lane.SessionToken} {
+		jwt := model.NewJWTToken("", "")
+		jwt.ParseJWT(token)
+		_, err := model.FindJWTTokenBySignature(ctx, jwt.Signature)
+		if err != nil {
+			t.Errorf("Both sessions should be valid: [%s]", err)
+		}
+	}
+
+	//The refreshed session stays on the lane
+	rrsp := refreshBL(ctx, refreshReq{RefreshToken: lane.RefreshToken})
+	jwt := model.NewJWTToken("", "")
+	jwt.ParseJWT(rrsp.SessionToken)
+	stored, err := model.FindJWTTokenBySignature(ctx, jwt.Signature)
+	if err != nil || stored.Session.DeviceID != "LANE1" || stored.Session.IPAddress != "10.0.0.21" {
+		t.Errorf("The refreshed session lost its device: %+v [%v]", stored, err)
+	}
+
+	company.Settings.MaxSessions = 2
+	company.Settings.SessionLimit = model.SessionLimitReject
+	model.SaveCompany(ctx, company)
+	lr.DeviceID = "LANE2"
+	rejected := loginBL(ctx, lr)
+	if rejected.Status != StatusFailure || rejected.Code != CodeSessionLimit {
+		t.Errorf("The third session should be rejected: %+v", rejected)
+	}
+
+	sessions, _ := model.ListUserSessions(ctx, user.ID.Hex(), company.ID.Hex())
+	for i := range sessions {
+		model.EndSession(ctx, &sessions[i])
+	}
+}
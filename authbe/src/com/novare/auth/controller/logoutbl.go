@@ -26,6 +26,7 @@ package controller
 
 import (
 	"com/novare/auth/model"
+	"context"
 	"log"
 	"unicode/utf8"
 )
@@ -42,7 +43,7 @@ const (
 )
 
 //logOutBL ...
-func logOutBL(auth string) string {
+func logOutBL(ctx context.Context, auth string) string {
 
 	//Check the token
 	jwtStr := ""
@@ -67,17 +68,24 @@ func logOutBL(auth string) string {
 	}
 
 	//Find the token based on the signature
-	jwt, err := model.FindJWTTokenBySignature(jwtToken.Signature)
+	jwt, err := model.FindJWTTokenBySignature(ctx, jwtToken.Signature)
 	if err != nil {
 		log.Printf("The following error occurred: [%s]", err)
 		return LogoutTokenInvalid
 	}
 
-	err = model.RemoveJWTTokenByID(jwt.ID.Hex())
+	//The sites verifying it with the keys learn it from the revocation list
+	err = model.RevokeJWTToken(ctx, jwt)
 	if err != nil {
-		log.Printf("The token was not found: [%s]", err)
+		log.Printf("The token could not be revoked: [%s]", err)
 		return LogoutTokenInvalid
 	}
 
+	//The refresh tokens of the login can not be used anymore
+	err = model.RevokeTokenFamily(ctx, jwt.FamilyID)
+	if err != nil {
+		log.Printf("The refresh tokens of the session could not be revoked: [%s]", err)
+	}
+
 	return LogoutSuccess
 }
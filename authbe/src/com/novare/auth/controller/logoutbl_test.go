@@ -25,10 +25,12 @@ package controller
 
 import (
 	"com/novare/auth/model"
+	"context"
 	"testing"
 )
 
 func TestLogoutBL(t *testing.T) {
+	ctx := context.Background()
 
 	var req createCompanyReq
 	req.Address1 = "My Address"
@@ -44,13 +46,13 @@ func TestLogoutBL(t *testing.T) {
 	req.Password = "@123ABC789"
 	req.ConfirmPassword = req.Password
 
-	rsp := createCompanyBL(req)
+	rsp := createCompanyBL(ctx, req)
 	if rsp.Status != StatusSuccess {
 		t.Errorf("The company should have been created but it did not!")
 		return
 	}
 
-	company, err := model.FindCompanyByID(rsp.CompanyID)
+	company, err := model.FindCompanyByID(ctx, rsp.CompanyID)
 	if err != nil {
 		t.Errorf("The following error occurred when finding the company:[%s]", rsp.CompanyID)
 		return
@@ -61,7 +63,7 @@ func TestLogoutBL(t *testing.T) {
 	lr.Username = "superuser"
 	lr.Password = "@123ABC789"
 
-	lrsp := loginBL(lr)
+	lrsp := loginBL(ctx, lr)
 	if lrsp.Status != StatusSuccess {
 		t.Error("An error occurred when the login was performed")
 		return
@@ -69,7 +71,7 @@ func TestLogoutBL(t *testing.T) {
 
 	jwtStr := "Bearer " + lrsp.SessionToken
 
-	loutRsp := logOutBL(jwtStr)
+	loutRsp := logOutBL(ctx, jwtStr)
 
 	switch loutRsp {
 	case LogoutFailedNoToken:
@@ -80,17 +82,17 @@ func TestLogoutBL(t *testing.T) {
 		t.Error("The logout token invalid!")
 	}
 
-	users, err := model.ListUsersByCompanyID(company.ID.Hex())
+	users, err := model.ListUsersByCompanyID(ctx, company.ID.Hex())
 	if err != nil {
 		t.Errorf("There was an issue listing all the users for companyID: [%s] Error:[%s]", company.ID.Hex(), err)
 		return
 	}
 
 	for i := range users {
-		model.RemoveUserByID(users[i].ID.Hex())
+		model.RemoveUserByID(ctx, users[i].ID.Hex())
 	}
 
-	model.RemoveCompanyByID(company.ID.Hex())
+	model.RemoveCompanyByID(ctx, company.ID.Hex())
 
 	jwt := model.NewJWTToken(users[0].ID.Hex(), company.ID.Hex())
 	err = jwt.ParseJWT(lrsp.SessionToken)
@@ -99,10 +101,10 @@ func TestLogoutBL(t *testing.T) {
 		return
 	}
 
-	jwtTmp, err := model.FindJWTTokenBySignature(jwt.Signature)
+	jwtTmp, err := model.FindJWTTokenBySignature(ctx, jwt.Signature)
 	if err == nil {
 		t.Errorf("The JWT with signature:[%s] was not found", jwt.Signature)
-		err = model.RemoveJWTTokenByID(jwtTmp.ID.Hex())
+		err = model.RemoveJWTTokenByID(ctx, jwtTmp.ID.Hex())
 		if err != nil {
 			t.Errorf("The following error occurred: [%s]", err)
 		}
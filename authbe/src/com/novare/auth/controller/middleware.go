@@ -27,9 +27,11 @@ SOFTWARE.
 import (
 	"com/novare/auth/model"
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 	"unicode/utf8"
 )
 
@@ -48,10 +50,71 @@ const (
 	CtxCompany ContextField = "CTX_COMPANY"
 )
 
+//RequestTimeout - How long a request can take. The database operations
+//receive the request context, they are cancelled when it expires.
+var RequestTimeout = 30 * time.Second
+
+//RequestDeadlineMW - Adds the RequestTimeout deadline to the request context.
+func RequestDeadlineMW(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), RequestTimeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+}
+
+//tokenErr - A token or a user that is gone makes the token invalid, the
+//database errors are kept
+func tokenErr(err error) error {
+	if errors.Is(err, model.ErrNotFound) {
+		return errInvalidToken
+	}
+	return err
+}
+
+//signatureErr - A signature that does not verify makes the token invalid,
+//the database errors are kept
+func signatureErr(err error) error {
+	if errors.Is(err, model.ErrInvalidSignature) || errors.Is(err, model.ErrInvalidInput) {
+		return errInvalidToken
+	}
+	return err
+}
+
+//bearerToken - The token of an Authorization header with the Bearer
+//scheme. The scheme is case insensitive and must start the header, as
+//RFC 6750 section 2.1 says.
+func bearerToken(header string) (string, bool) {
+	const scheme = "Bearer "
+	if len(header) <= len(scheme) || !strings.EqualFold(header[:len(scheme)], scheme) {
+		return "", false
+	}
+
+	token := strings.TrimSpace(header[len(scheme):])
+	return token, utf8.RuneCountInString(token) > 0 && !strings.ContainsAny(token, " \t")
+}
+
 //CheckAuthorizedMW - This is for JSON calls. If the Authorization does
 //not contain a valid token or, if the token is invalid or, if the user
-//does not have enough permission. We will bail out.
+//does not have enough permission. We will bail out. An empty permission
+//lets every user with a valid token through, except the sessions an
+//OAuthClient started: the routes without a permission act on the user's
+//own sessions and those are limited to their scope.
 func CheckAuthorizedMW(next http.Handler, permission string) http.Handler {
+	return checkAuthorized(next, permission, false)
+}
+
+//CheckSessionMW - The routes every valid session reaches, the ones an
+//OAuthClient started included, e.g. /userinfo
+func CheckSessionMW(next http.Handler) http.Handler {
+	return checkAuthorized(next, "", true)
+}
+
+//checkAuthorized - The checks of CheckAuthorizedMW. anyScope lets the
+//sessions limited to a scope reach a route without a permission.
+func checkAuthorized(next http.Handler, permission string, anyScope bool) http.Handler {
 
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
@@ -63,78 +126,127 @@ func CheckAuthorizedMW(next http.Handler, permission string) http.Handler {
 			ln := utf8.RuneCountInString(bearer)
 			if ln == 0 {
 				log.Printf("The Authorization header is missing")
-				w.WriteHeader(http.StatusBadRequest)
+				writeError(w, errInvalidToken)
 				return
 			}
 
 			//----------------------------------------------------------
 			//Do we have a token? it should be bearer JWT
 			//----------------------------------------------------------
-			if !strings.Contains(bearer, "bearer ") {
+			jwtB64, ok := bearerToken(bearer)
+			if !ok {
 				log.Printf("The Authorization object is missing the bearer")
-				w.WriteHeader(http.StatusBadRequest)
+				writeError(w, errInvalidToken)
 				return
 			}
 
 			//----------------------------------------------------------
-			//Retrive the base 64 encoded string and parse it.
+			//Parse the base 64 encoded string.
 			//----------------------------------------------------------
-			runes := []rune(bearer)
-			runes = runes[7:]
-			jwtB64 := string(runes)
-			if !strings.Contains(jwtB64, "bearer ") {
-				log.Printf("The JWT64 token still contains the word bearer:[%s]", jwtB64)
-				w.WriteHeader(http.StatusBadRequest)
-				return
-			}
+			ctx := r.Context()
 			jwt := model.NewJWTToken("", "")
 			err := jwt.ParseJWT(jwtB64)
 			if err != nil {
 				log.Printf("The token received was not valid or, does not follow the JWT format: [%s]", jwtB64)
-				w.WriteHeader(http.StatusBadRequest)
+				writeError(w, errInvalidToken)
+				return
 			}
 
-			storedJWT, err := model.FindJWTTokenBySignature(jwt.Signature)
+			//An ID token only proves who logged in, it is not a session
+			if jwt.Payload.IsIDToken() {
+				log.Printf("An ID token was presented as a session token")
+				writeError(w, errInvalidToken)
+				return
+			}
+
+			//The tokens signed with a key are checked before any lookup
+			if jwt.IsSignedWithKey() {
+				err = model.VerifyJWTSignature(ctx, jwt)
+				if err != nil {
+					log.Printf("The JWT signature could not be verified: [%s]", err)
+					writeError(w, signatureErr(err))
+					return
+				}
+			}
+
+			storedJWT, err := model.FindJWTTokenBySignature(ctx, jwt.Signature)
 			if err != nil {
 				log.Printf("Error retriving the JWT Token: [%s]", err)
-				w.WriteHeader(http.StatusBadRequest)
+				writeError(w, tokenErr(err))
 				return
 			}
 
-			jwt.Secret = storedJWT.Secret
-			if jwt.IsTampered() {
-				log.Printf("The JWT token is compromised, removing it from the database :[%s]", storedJWT.ID.Hex())
-				w.WriteHeader(http.StatusBadRequest)
+			if !jwt.IsSignedWithKey() {
+				jwt.Secret = storedJWT.Secret
+				if jwt.IsTampered() {
+					log.Printf("The JWT token is compromised, removing it from the database :[%s]", storedJWT.ID.Hex())
+					writeError(w, errInvalidToken)
+					return
+				}
+			}
+
+			err = model.SessionClaimsPolicy().Validate(&storedJWT.Payload, time.Now())
+			if err != nil {
+				log.Printf("The JWT Token claims were rejected: [%s] Signature: [%s]", err, storedJWT.Signature)
+				//A refreshable session keeps its token, the refresh needs its last use
+				if model.IsClaimsReason(err, model.ClaimsTokenExpired) && utf8.RuneCountInString(storedJWT.FamilyID) == 0 {
+					model.RemoveJWTTokenByID(ctx, storedJWT.ID.Hex())
+				}
+				writeError(w, err)
 				return
 			}
 
-			if storedJWT.Payload.IsExpired() {
-				log.Printf("Invalid JWT Token, it is expired: Signature: [%s]", storedJWT.Signature)
-				model.RemoveJWTTokenByID(storedJWT.ID.Hex())
-				w.WriteHeader(http.StatusBadRequest)
+			company, err := model.FindCompanyByID(ctx, storedJWT.CompanyID)
+			if err != nil {
+				log.Printf("The company of the session was not found: [%s]", err)
+				writeError(w, tokenErr(err))
 				return
 			}
 
+			//An idle session is ended, the use of the others is saved
+			err = model.KeepSessionAlive(ctx, storedJWT, company)
+			if errors.Is(err, model.ErrSessionIdle) {
+				writeError(w, err)
+				return
+			}
+			if err != nil {
+				log.Printf("The last use of the session [%s] could not be saved: [%s]", storedJWT.SessionID(), err)
+			}
+
 			//-------------------------------------------------------------
 			//We will do all the checks here, we should not need the user
 			//or the token anymore
 			//-------------------------------------------------------------
-			user, err := model.FindUserByID(storedJWT.UserID)
+			user, err := model.FindUserByID(ctx, storedJWT.UserID)
 			if err != nil {
 				log.Printf("The user was not found! Aborting the request now!")
-				w.WriteHeader(http.StatusBadRequest)
+				writeError(w, tokenErr(err))
 				return
 			}
 
-			if !user.IsGranted(permission) {
+			//The session an app started is limited to the scope it was granted,
+			//to nothing when it was granted none
+			user.Scope = storedJWT.Payload.Scopes()
+			if user.Scope == nil && utf8.RuneCountInString(storedJWT.Payload.ClientID) > 0 {
+				user.Scope = []string{}
+			}
+			user.FamilyID = storedJWT.FamilyID
+
+			if utf8.RuneCountInString(permission) == 0 && user.Scope != nil && !anyScope {
+				log.Printf("The session of the client [%s] can not reach a route without a permission", storedJWT.Payload.ClientID)
+				writeError(w, errForbidden)
+				return
+			}
+
+			if utf8.RuneCountInString(permission) > 0 && !user.IsGranted(ctx, permission) {
 				log.Printf("The request for permission: [%s] has been defined", permission)
-				w.WriteHeader(http.StatusBadRequest)
+				writeError(w, errForbidden)
 				return
 			}
 
-			ctx := r.Context()
 			ctx = context.WithValue(ctx, CtxUser, user)
 			ctx = context.WithValue(ctx, CtxJWT, jwt)
+			ctx = context.WithValue(ctx, CtxCompany, company)
 
 			//----------------------------------------------------------
 			//If it passes all checks, then execute the controller
@@ -152,7 +264,15 @@ func AuthorizationRequest(next http.Handler) http.Handler {
 		permReq := r.Header.Get("grant-request")
 		if utf8.RuneCountInString(permReq) == 0 {
 			log.Printf("There is no grant-request present in the header. This request will be dropped with a bad request response")
-			w.WriteHeader(http.StatusBadRequest)
+			writeError(w, model.NewInputError("MissingGrantRequest"))
+			return
+		}
+
+		//The grant-request becomes the scope of the access token, only a
+		//permission name is accepted
+		if !model.IsValidPermissionName(permReq) {
+			log.Printf("The grant-request [%s] is not a permission name", permReq)
+			writeError(w, model.NewInputError("InvalidGrantRequest"))
 			return
 		}
 
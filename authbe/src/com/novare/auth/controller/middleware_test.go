@@ -25,11 +25,14 @@ package controller
 
 import (
 	"com/novare/auth/model"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func doesNothing(w http.ResponseWriter, r *http.Request) {
@@ -44,6 +47,7 @@ func doesNothing(w http.ResponseWriter, r *http.Request) {
 }
 
 func TestMiddlewareAuthorizationWithPermission(t *testing.T) {
+	ctx := context.Background()
 
 	var req createCompanyReq
 	req.Address1 = "My Address"
@@ -59,7 +63,7 @@ func TestMiddlewareAuthorizationWithPermission(t *testing.T) {
 	req.Password = "@123ABC789"
 	req.ConfirmPassword = req.Password
 
-	rsp := createCompanyBL(req)
+	rsp := createCompanyBL(ctx, req)
 
 	if rsp == nil || rsp.Status != StatusSuccess {
 		t.Errorf("The superuser account was not created. The user will not be retrieved")
@@ -70,44 +74,45 @@ func TestMiddlewareAuthorizationWithPermission(t *testing.T) {
 	perm.CompanyID = rsp.CompanyID
 	perm.Description = "TEST PERMISSION"
 	perm.Permission = "PERMISSION_TO_TEST"
-	err := model.InsertPermission(perm)
+	err := model.InsertPermission(ctx, perm)
 	if err != nil {
 		t.Errorf("Error inserting the permission: [%s]", err)
 		return
 	}
 
-	user, err := model.FindUserByUsernameCompanyID("superuser", perm.CompanyID)
+	//The superuser is granted every permission, the check needs a user
+	//holding only the one given to it
+	user := model.NewUser()
+	user.CompanyID = perm.CompanyID
+	user.Username = "cashier"
+	user.SetPassword("@123ABC789")
+	user.AddPermission(ctx, *perm)
+	err = model.InsertUser(ctx, user)
 	if err != nil {
-		log.Printf("Error retrieving the superuser: [%s]", err)
-		return
-	}
-	user.AddPermission(*perm)
-	err = model.SaveUser(user)
-	if err != nil {
-		t.Errorf("The following error occurred while saving the user and the permission: [%s]", err)
-		model.RemoveCompanyByID(rsp.CompanyID)
-		model.RemovePermissionByID(perm.ID.Hex())
-		model.RemoveUserByID(user.ID.Hex())
+		t.Errorf("The following error occurred while inserting the user and the permission: [%s]", err)
+		model.RemoveCompanyByID(ctx, rsp.CompanyID)
+		model.RemovePermissionByID(ctx, perm.ID.Hex())
+		model.RemoveUserByID(ctx, user.ID.Hex())
 		return
 	}
 
 	var lrq loginReq
 	lrq.Password = "@123ABC789"
 	lrq.UniqueID = "THISISUNIQUEID"
-	lrq.Username = "superuser"
+	lrq.Username = "cashier"
 
-	lrs := loginBL(lrq)
+	lrs := loginBL(ctx, lrq)
 	if lrs.Status != StatusSuccess {
-		model.RemoveUserByID(user.ID.Hex())
-		model.RemoveCompanyByID(rsp.CompanyID)
-		model.RemovePermissionByID(perm.ID.Hex())
+		model.RemoveUserByID(ctx, user.ID.Hex())
+		model.RemoveCompanyByID(ctx, rsp.CompanyID)
+		model.RemovePermissionByID(ctx, perm.ID.Hex())
 		jwt := model.NewJWTToken(user.ID.Hex(), rsp.CompanyID)
 		err = jwt.ParseJWT(lrs.SessionToken)
 		if err == nil {
-			jwt, err = model.FindJWTTokenBySignature(jwt.Signature)
+			jwt, err = model.FindJWTTokenBySignature(ctx, jwt.Signature)
 			if err == nil {
 				t.Logf("Removing token with ID: [%s]", jwt.ID.Hex())
-				model.RemoveJWTTokenByID(jwt.ID.Hex())
+				model.RemoveJWTTokenByID(ctx, jwt.ID.Hex())
 			}
 		}
 		return
@@ -123,9 +128,9 @@ func TestMiddlewareAuthorizationWithPermission(t *testing.T) {
 
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("The test failed with error: [%d]", rr.Code)
-		model.RemoveUserByID(user.ID.Hex())
-		model.RemoveCompanyByID(rsp.CompanyID)
-		model.RemovePermissionByID(perm.ID.Hex())
+		model.RemoveUserByID(ctx, user.ID.Hex())
+		model.RemoveCompanyByID(ctx, rsp.CompanyID)
+		model.RemovePermissionByID(ctx, perm.ID.Hex())
 		return
 	}
 
@@ -135,29 +140,30 @@ func TestMiddlewareAuthorizationWithPermission(t *testing.T) {
 	handler = http.Handler(CheckAuthorizedMW(http.HandlerFunc(doesNothing), "UNKNOWNPERM"))
 
 	handler.ServeHTTP(rr, r)
-	if status := rr.Code; status != http.StatusBadRequest {
+	if status := rr.Code; status != http.StatusForbidden {
 		t.Errorf("The Status response is invalid! :[%d]", rr.Code)
-		model.RemoveUserByID(user.ID.Hex())
-		model.RemoveCompanyByID(rsp.CompanyID)
-		model.RemovePermissionByID(perm.ID.Hex())
+		model.RemoveUserByID(ctx, user.ID.Hex())
+		model.RemoveCompanyByID(ctx, rsp.CompanyID)
+		model.RemovePermissionByID(ctx, perm.ID.Hex())
 		return
 	}
 
-	model.RemoveUserByID(user.ID.Hex())
-	model.RemoveCompanyByID(rsp.CompanyID)
-	model.RemovePermissionByID(perm.ID.Hex())
+	model.RemoveUserByID(ctx, user.ID.Hex())
+	model.RemoveCompanyByID(ctx, rsp.CompanyID)
+	model.RemovePermissionByID(ctx, perm.ID.Hex())
 
-	jwt, err := model.FindJWTTokenByUserIDCompanyID(user.ID.Hex(), rsp.CompanyID)
+	jwt, err := model.FindJWTTokenByUserIDCompanyID(ctx, user.ID.Hex(), rsp.CompanyID)
 	if err != nil {
 		t.Error("The JWT Token was not found, it should have been!")
 		return
 	}
 
-	model.RemoveJWTTokenByID(jwt.ID.Hex())
+	model.RemoveJWTTokenByID(ctx, jwt.ID.Hex())
 
 }
 
 func TestMiddlewareAuthorization(t *testing.T) {
+	ctx := context.Background()
 
 	var req createCompanyReq
 	req.Address1 = "My Address"
@@ -173,7 +179,7 @@ func TestMiddlewareAuthorization(t *testing.T) {
 	req.Password = "@123ABC789"
 	req.ConfirmPassword = req.Password
 
-	rsp := createCompanyBL(req)
+	rsp := createCompanyBL(ctx, req)
 
 	if rsp == nil || rsp.Status != StatusSuccess {
 		t.Errorf("The superuser account was not created. The user will not be retrieved")
@@ -184,44 +190,45 @@ func TestMiddlewareAuthorization(t *testing.T) {
 	perm.CompanyID = rsp.CompanyID
 	perm.Description = "TEST PERMISSION"
 	perm.Permission = "PERMISSION_TO_TEST"
-	err := model.InsertPermission(perm)
+	err := model.InsertPermission(ctx, perm)
 	if err != nil {
 		t.Errorf("Error inserting the permission: [%s]", err)
 		return
 	}
 
-	user, err := model.FindUserByUsernameCompanyID("superuser", perm.CompanyID)
+	//The superuser is granted every permission, the check needs a user
+	//holding only the one given to it
+	user := model.NewUser()
+	user.CompanyID = perm.CompanyID
+	user.Username = "cashier"
+	user.SetPassword("@123ABC789")
+	user.AddPermission(ctx, *perm)
+	err = model.InsertUser(ctx, user)
 	if err != nil {
-		log.Printf("Error retrieving the superuser: [%s]", err)
-		return
-	}
-	user.AddPermission(*perm)
-	err = model.SaveUser(user)
-	if err != nil {
-		t.Errorf("The following error occurred while saving the user and the permission: [%s]", err)
-		model.RemoveCompanyByID(rsp.CompanyID)
-		model.RemovePermissionByID(perm.ID.Hex())
-		model.RemoveUserByID(user.ID.Hex())
+		t.Errorf("The following error occurred while inserting the user and the permission: [%s]", err)
+		model.RemoveCompanyByID(ctx, rsp.CompanyID)
+		model.RemovePermissionByID(ctx, perm.ID.Hex())
+		model.RemoveUserByID(ctx, user.ID.Hex())
 		return
 	}
 
 	var lrq loginReq
 	lrq.Password = "@123ABC789"
 	lrq.UniqueID = "THISISUNIQUEID"
-	lrq.Username = "superuser"
+	lrq.Username = "cashier"
 
-	lrs := loginBL(lrq)
+	lrs := loginBL(ctx, lrq)
 	if lrs.Status != StatusSuccess {
-		model.RemoveUserByID(user.ID.Hex())
-		model.RemoveCompanyByID(rsp.CompanyID)
-		model.RemovePermissionByID(perm.ID.Hex())
+		model.RemoveUserByID(ctx, user.ID.Hex())
+		model.RemoveCompanyByID(ctx, rsp.CompanyID)
+		model.RemovePermissionByID(ctx, perm.ID.Hex())
 		jwt := model.NewJWTToken(user.ID.Hex(), rsp.CompanyID)
 		err = jwt.ParseJWT(lrs.SessionToken)
 		if err == nil {
-			jwt, err = model.FindJWTTokenBySignature(jwt.Signature)
+			jwt, err = model.FindJWTTokenBySignature(ctx, jwt.Signature)
 			if err == nil {
 				t.Logf("Removing token with ID: [%s]", jwt.ID.Hex())
-				model.RemoveJWTTokenByID(jwt.ID.Hex())
+				model.RemoveJWTTokenByID(ctx, jwt.ID.Hex())
 			}
 		}
 		return
@@ -238,9 +245,9 @@ func TestMiddlewareAuthorization(t *testing.T) {
 
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("The test failed with error: [%d]", rr.Code)
-		model.RemoveUserByID(user.ID.Hex())
-		model.RemoveCompanyByID(rsp.CompanyID)
-		model.RemovePermissionByID(perm.ID.Hex())
+		model.RemoveUserByID(ctx, user.ID.Hex())
+		model.RemoveCompanyByID(ctx, rsp.CompanyID)
+		model.RemovePermissionByID(ctx, perm.ID.Hex())
 		return
 	}
 
@@ -251,24 +258,144 @@ func TestMiddlewareAuthorization(t *testing.T) {
 
 	r.Header.Set("grant-request", "NOT_PERMISSION_TO_TEST")
 	handler.ServeHTTP(rr, r)
-	if status := rr.Code; status != http.StatusBadRequest {
+	if status := rr.Code; status != http.StatusForbidden {
 		t.Errorf("The Status response is invalid! :[%d]", rr.Code)
-		model.RemoveUserByID(user.ID.Hex())
-		model.RemoveCompanyByID(rsp.CompanyID)
-		model.RemovePermissionByID(perm.ID.Hex())
+		model.RemoveUserByID(ctx, user.ID.Hex())
+		model.RemoveCompanyByID(ctx, rsp.CompanyID)
+		model.RemovePermissionByID(ctx, perm.ID.Hex())
 		return
 	}
 
-	model.RemoveUserByID(user.ID.Hex())
-	model.RemoveCompanyByID(rsp.CompanyID)
-	model.RemovePermissionByID(perm.ID.Hex())
+	model.RemoveUserByID(ctx, user.ID.Hex())
+	model.RemoveCompanyByID(ctx, rsp.CompanyID)
+	model.RemovePermissionByID(ctx, perm.ID.Hex())
 
-	jwt, err := model.FindJWTTokenByUserIDCompanyID(user.ID.Hex(), rsp.CompanyID)
+	jwt, err := model.FindJWTTokenByUserIDCompanyID(ctx, user.ID.Hex(), rsp.CompanyID)
 	if err != nil {
 		t.Error("The JWT Token was not found, it should have been!")
 		return
 	}
 
-	model.RemoveJWTTokenByID(jwt.ID.Hex())
+	model.RemoveJWTTokenByID(ctx, jwt.ID.Hex())
+
+}
+
+func TestMiddlewareClaimsReason(t *testing.T) {
+	ctx := context.Background()
+
+	company, _, lrsp := loginTestCompany(t, "CLAIMSREASONID")
+	if lrsp == nil {
+		return
+	}
+	defer performCompanyCleanup(company.ID.Hex(), t)
+
+	jwt := model.NewJWTToken("", "")
+	jwt.ParseJWT(lrsp.SessionToken)
+	stored, err := model.FindJWTTokenBySignature(ctx, jwt.Signature)
+	if err != nil {
+		t.Errorf("The session was not found: [%s]", err)
+		return
+	}
+
+	//Outside of the leeway the token is expired. It is kept for the refresh,
+	//which needs the last use of the session.
+	stored.Payload.ExpirationTime = time.Now().Add(-model.ClockLeeway - time.Minute).Unix()
+	model.SaveJWTToken(ctx, stored)
+
+	req := httptest.NewRequest("GET", "/jwt/sessions/self", nil)
+	req.Header.Set("Authorization", "bearer "+lrsp.SessionToken)
+	rec := httptest.NewRecorder()
+	CheckAuthorizedMW(http.HandlerFunc(doesNothing), "").ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), model.ClaimsTokenExpired) {
+		t.Errorf("The expired token should be rejected with its reason: %d [%s]", rec.Code, rec.Body.String())
+	}
+
+	_, err = model.FindJWTTokenByID(ctx, stored.ID.Hex())
+	if err != nil {
+		t.Errorf("The expired token of a refreshable session should be kept: [%v]", err)
+	}
+
+	refreshed := refreshBL(ctx, refreshReq{RefreshToken: lrsp.RefreshToken})
+	if refreshed.Status != StatusSuccess {
+		t.Errorf("The expired session should be refreshed: %+v", refreshed)
+	}
+
+	_, err = model.FindJWTTokenByID(ctx, stored.ID.Hex())
+	if err != model.ErrNotFound {
+		t.Errorf("The refresh should remove the expired token: [%v]", err)
+	}
+}
 
+//The superuser is granted every permission, even one that does not exist.
+//The other users get 403, see TestMiddlewareAuthorizationWithPermission.
+func TestMiddlewareSuperuser(t *testing.T) {
+	company, _, lrsp := loginTestCompany(t, "MWSUPERUSERID")
+	if lrsp == nil {
+		return
+	}
+	defer performCompanyCleanup(company.ID.Hex(), t)
+
+	r := httptest.NewRequest("POST", "/test/middleware", nil)
+	r.Header.Add("Authorization", fmt.Sprintf("bearer %s", lrsp.SessionToken))
+
+	rr := httptest.NewRecorder()
+	CheckAuthorizedMW(http.HandlerFunc(doesNothing), "UNKNOWNPERM").ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Errorf("The superuser should be granted any permission: [%d]", rr.Code)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+
+	accepted := map[string]string{
+		"Bearer abc.def.ghi": "abc.def.ghi",
+		"bearer abc.def.ghi": "abc.def.ghi",
+		"BEARER abc.def.ghi": "abc.def.ghi",
+	}
+	for header, want := range accepted {
+		token, ok := bearerToken(header)
+		if !ok || token != want {
+			t.Errorf("The token of [%s] was not accepted: [%s]", header, token)
+		}
+	}
+
+	rejected := []string{"", "Bearer ", "abc.def.ghi", "Basic abc", "Token bearer abc.def.ghi", "Bearerabc.def.ghi", "Bearer abc def"}
+	for _, header := range rejected {
+		if _, ok := bearerToken(header); ok {
+			t.Errorf("The token of [%s] should be rejected", header)
+		}
+	}
+}
+
+//The sessions issued before AUTH_ISSUER was set keep working while the
+//previous issuer is one of the LegacyIssuers
+func TestMiddlewareLegacyIssuer(t *testing.T) {
+	company, _, lrsp := loginTestCompany(t, "MWLEGACYISSUERID")
+	if lrsp == nil {
+		return
+	}
+	defer performCompanyCleanup(company.ID.Hex(), t)
+
+	defer func() {
+		model.TokenIssuer = model.DefaultTokenIssuer
+		model.LegacyIssuers = nil
+	}()
+	model.TokenIssuer = "https://auth.example.com"
+
+	serve := func() int {
+		r := httptest.NewRequest("GET", "/jwt/sessions/self", nil)
+		r.Header.Add("Authorization", "Bearer "+lrsp.SessionToken)
+		rr := httptest.NewRecorder()
+		CheckAuthorizedMW(http.HandlerFunc(doesNothing), "").ServeHTTP(rr, r)
+		return rr.Code
+	}
+
+	if code := serve(); code != http.StatusUnauthorized {
+		t.Errorf("The session of an issuer that is not accepted should be rejected: [%d]", code)
+	}
+
+	model.LegacyIssuers = []string{model.DefaultTokenIssuer}
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("The session of the legacy issuer should be accepted: [%d]", code)
+	}
 }
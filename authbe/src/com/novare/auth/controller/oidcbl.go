@@ -0,0 +1,121 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"com/novare/auth/model"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+/*
+openIDConfiguration - The OpenID Connect discovery document. The libraries
+check that its issuer is the iss of the ID tokens, and most of them that
+it is the URL the document was fetched under.
+*/
+type openIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	ResponseModesSupported            []string `json:"response_modes_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+//userInfoResp - The claims of the user of the session
+type userInfoResp struct {
+	Subject           string `json:"sub"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Company           string `json:"company,omitempty"` //The company UniqueID
+}
+
+/*
+issuerBaseURL - The endpoints are under the TokenIssuer when it is a URL,
+as OpenID Connect wants it. Otherwise they are under the URL the request
+came to, which only the apps that do not check the issuer will accept.
+*/
+func issuerBaseURL(r *http.Request) string {
+	issuer, err := url.Parse(model.TokenIssuer)
+	if err == nil && (issuer.Scheme == "https" || issuer.Scheme == "http") && len(issuer.Host) > 0 {
+		return strings.TrimRight(model.TokenIssuer, "/")
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "https" || proto == "http" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+//openIDConfigurationBL - The discovery document of the endpoints under the
+//baseURL. Only the authorization code flow is offered to the apps.
+func openIDConfigurationBL(baseURL string) *openIDConfiguration {
+	var rsp openIDConfiguration
+	rsp.Issuer = model.TokenIssuer
+	rsp.AuthorizationEndpoint = baseURL + "/oauth/authorize"
+	rsp.TokenEndpoint = baseURL + "/oauth/token"
+	rsp.UserInfoEndpoint = baseURL + "/userinfo"
+	rsp.JWKSURI = baseURL + "/.well-known/jwks.json"
+	rsp.ScopesSupported = model.OpenIDScopes
+	rsp.ResponseTypesSupported = []string{"code"}
+	rsp.ResponseModesSupported = []string{"query"}
+	rsp.GrantTypesSupported = []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken, GrantTypeClientCredentials}
+	rsp.SubjectTypesSupported = []string{"public"}
+	rsp.IDTokenSigningAlgValuesSupported = []string{model.AlgRS256, model.AlgES256}
+	rsp.TokenEndpointAuthMethodsSupported = []string{"client_secret_basic", "client_secret_post", "none"}
+	rsp.CodeChallengeMethodsSupported = []string{model.CodeChallengeS256}
+	rsp.ClaimsSupported = []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "name", "preferred_username", "company"}
+	return &rsp
+}
+
+/*
+userInfoBL - The claims of the user of the session. The session of an app
+only gets the name, preferred_username and company with the profile
+scope; the sessions of the login routes, that have no scope, always do.
+*/
+func userInfoBL(user *model.User, company *model.Company, payload *model.JWTPayload) *userInfoResp {
+	var rsp userInfoResp
+	rsp.Subject = user.ID.Hex()
+
+	if len(payload.Scopes()) == 0 || payload.HasScope(model.ScopeProfile) {
+		rsp.Name = user.Name
+		rsp.PreferredUsername = user.Username
+		rsp.Company = company.UniqueID
+	}
+	return &rsp
+}
@@ -0,0 +1,166 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"com/novare/auth/model"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+//exchangeForTokens - Log the superuser in for the scope and the nonce,
+//then exchange the code
+func exchangeForTokens(t *testing.T, clientID string, scope string, nonce string) *tokenResp {
+	verifier := strings.Repeat("0123456789", 5)
+	form := authorizeForm(clientID, scope, verifier)
+	form.Set("nonce", nonce)
+	form.Set("username", "superuser")
+	form.Set("password", "@123ABC789")
+	rec := serveForm("POST", "/oauth/authorize", form, "")
+	location, _ := url.Parse(rec.Header().Get("Location"))
+
+	tokenForm := url.Values{"grant_type": {GrantTypeAuthorizationCode}, "client_id": {clientID}, "code": {location.Query().Get("code")}, "redirect_uri": {testRedirectURI}, "code_verifier": {verifier}}
+	rec = serveForm("POST", "/oauth/token", tokenForm, "")
+	var trsp tokenResp
+	json.Unmarshal(rec.Body.Bytes(), &trsp)
+	if rec.Code != http.StatusOK {
+		t.Errorf("The code was not exchanged: %d [%s]", rec.Code, rec.Body.String())
+		return nil
+	}
+	return &trsp
+}
+
+//userInfo - The userinfo of the session token
+func userInfo(token string) (int, userInfoResp) {
+	rec := serveForm("GET", "/userinfo", nil, token)
+	var rsp userInfoResp
+	json.Unmarshal(rec.Body.Bytes(), &rsp)
+	return rec.Code, rsp
+}
+
+func TestOpenIDConnect(t *testing.T) {
+	ctx := context.Background()
+
+	company, user, lrsp := loginTestCompany(t, "OIDCID")
+	if lrsp == nil {
+		return
+	}
+	defer performCompanyCleanup(company.ID.Hex(), t)
+
+	rec := serveForm("GET", "/.well-known/openid-configuration", nil, "")
+	var discovery openIDConfiguration
+	json.Unmarshal(rec.Body.Bytes(), &discovery)
+	if rec.Code != http.StatusOK || discovery.Issuer != model.TokenIssuer || discovery.AuthorizationEndpoint != "http://example.com/oauth/authorize" || discovery.UserInfoEndpoint != "http://example.com/userinfo" {
+		t.Errorf("The discovery document is wrong: %d [%s]", rec.Code, rec.Body.String())
+	}
+
+	crsp := insertClientBL(ctx, company.ID.Hex(), &clientObj{Name: "Store App", Public: true, RedirectURIs: []string{testRedirectURI}, Scopes: []string{"GET_USER"}})
+	if crsp.Status != StatusSuccess {
+		t.Errorf("The public client was not registered: %+v", crsp)
+		return
+	}
+	clientID := crsp.Client.ID
+
+	//There is no browser session to log in silently with
+	form := authorizeForm(clientID, "openid", strings.Repeat("0123456789", 5))
+	form.Set("prompt", "none")
+	rec = serveForm("GET", "/oauth/authorize", form, "")
+	if rec.Code != http.StatusFound || !strings.Contains(rec.Header().Get("Location"), "error=login_required") {
+		t.Errorf("prompt=none should redirect with login_required: %d [%s]", rec.Code, rec.Header().Get("Location"))
+	}
+
+	trsp := exchangeForTokens(t, clientID, "openid profile GET_USER", "n-0S6_WzA2Mj")
+	if trsp == nil {
+		return
+	}
+	if len(trsp.IDToken) == 0 || trsp.Scope != "openid profile GET_USER" {
+		t.Errorf("The ID token was not returned: %+v", trsp)
+		return
+	}
+
+	idToken := model.NewJWTToken("", "")
+	err := idToken.ParseJWT(trsp.IDToken)
+	if err == nil {
+		err = model.VerifyJWTSignature(ctx, idToken)
+	}
+	if err != nil {
+		t.Errorf("The ID token is not signed with the keys of the JWKS: [%s]", err)
+		return
+	}
+	claims := idToken.Payload
+	if claims.Subject != user.ID.Hex() || claims.Audience != clientID || claims.Nonce != "n-0S6_WzA2Mj" || claims.PreferredUsername != "superuser" || claims.Company != "OIDCID" || claims.AuthTime == 0 {
+		t.Errorf("The ID token claims are wrong: %+v", claims)
+	}
+
+	//The ID token is not a session
+	code, _ := userInfo(trsp.IDToken)
+	if code != http.StatusUnauthorized {
+		t.Errorf("The ID token should not be accepted as a session: %d", code)
+	}
+
+	//Nor as an access token
+	if !claims.IsIDToken() {
+		t.Errorf("The ID token does not say it is one: %+v", claims)
+	}
+	irsp := introspectBL(ctx, user, trsp.IDToken)
+	if irsp.Active {
+		t.Errorf("The ID token should not introspect as active: %+v", irsp)
+	}
+
+	code, info := userInfo(trsp.AccessToken)
+	if code != http.StatusOK || info.Subject != user.ID.Hex() || info.PreferredUsername != "superuser" || info.Company != "OIDCID" {
+		t.Errorf("The userinfo of the session is wrong: %d %+v", code, info)
+	}
+
+	//openid alone grants no permission and no profile
+	trsp = exchangeForTokens(t, clientID, "openid", "")
+	if trsp == nil {
+		return
+	}
+	code, info = userInfo(trsp.AccessToken)
+	if code != http.StatusOK || info.Subject != user.ID.Hex() || len(info.PreferredUsername) > 0 {
+		t.Errorf("The userinfo without the profile scope should only have the sub: %d %+v", code, info)
+	}
+	rec = serveForm("GET", "/jwt/users/0/10", nil, trsp.AccessToken)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("The openid session should hold no permission: %d", rec.Code)
+	}
+
+	//Without openid there is no ID token
+	trsp = exchangeForTokens(t, clientID, "GET_USER", "")
+	if trsp != nil && len(trsp.IDToken) > 0 {
+		t.Errorf("The ID token needs the openid scope: %+v", trsp)
+	}
+
+	//The sessions of the login routes get the whole profile
+	code, info = userInfo(lrsp.SessionToken)
+	if code != http.StatusOK || info.PreferredUsername != "superuser" {
+		t.Errorf("The userinfo of the login session is wrong: %d %+v", code, info)
+	}
+}
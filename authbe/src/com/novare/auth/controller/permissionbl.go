@@ -3,6 +3,7 @@ package controller
 import (
 	"com/novare/auth/model"
 	"com/novare/auth/sse"
+	"context"
 	"log"
 )
 
@@ -30,7 +31,7 @@ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 SOFTWARE.
 */
 
-func insertPermissionBL(companyID string, req *permObj) *permResp {
+func insertPermissionBL(ctx context.Context, companyID string, req *permObj) *permResp {
 	var rsp permResp
 	rsp.Status = StatusFailure
 
@@ -39,9 +40,10 @@ func insertPermissionBL(companyID string, req *permObj) *permResp {
 	perm.Description = req.Description
 	perm.Permission = req.Permission
 
-	err := model.InsertPermission(perm)
+	err := model.InsertPermission(ctx, perm)
 	if err != nil {
 		log.Printf("There following error occurred when inserting a permission: [%s]", err)
+		rsp.fail(err)
 		return &rsp
 	}
 
@@ -53,56 +55,72 @@ func insertPermissionBL(companyID string, req *permObj) *permResp {
 	return &rsp
 }
 
-func updatePermissionBL(permID string, companyID string, req *permObj) *permResp {
+func updatePermissionBL(ctx context.Context, permID string, companyID string, ifMatch int64, req *permObj) *permResp {
 	var rsp permResp
 	rsp.Status = StatusFailure
 
-	perm, err := model.FindPermissionByID(permID)
+	perm, err := model.FindPermissionByID(ctx, permID)
 	if err != nil {
 		log.Printf("Failed to update the permission: [%s]", err)
+		rsp.fail(err)
 		return &rsp
 	}
 
 	if perm.CompanyID != companyID {
 		log.Printf("There was an error updating the company ID")
+		//The entity belongs to another company
+		rsp.fail(model.ErrNotFound)
+		return &rsp
+	}
+
+	err = checkRevision(ifMatch, perm.Revision)
+	if err != nil {
+		log.Printf("The permission [%s] is at revision %d, the update expected %d", permID, perm.Revision, ifMatch)
+		rsp.fail(err)
 		return &rsp
 	}
 
 	perm.Description = req.Description
 	perm.Permission = req.Permission
 
-	err = model.SavePermission(perm)
+	err = model.SavePermission(ctx, perm)
 	if err != nil {
 		log.Printf("Failed to save the permission with error:[%s]", err)
+		rsp.fail(err)
 		return &rsp
 	}
 
 	rsp.Status = StatusSuccess
 	rsp.ID = perm.ID.Hex()
+	rsp.Revision = perm.Revision
 
 	publishEvent(sse.EventPermissionUpdate, "Update")
 
 	return &rsp
 }
 
-func removePermissionBL(permID string, companyID string) *permResp {
+func removePermissionBL(ctx context.Context, permID string, companyID string) *permResp {
 	var rsp permResp
 	rsp.Status = StatusFailure
 
-	perm, err := model.FindPermissionByID(permID)
+	perm, err := model.FindPermissionByID(ctx, permID)
 	if err != nil {
 		log.Printf("Failed to update the permission: [%s]", err)
+		rsp.fail(err)
 		return &rsp
 	}
 
 	if perm.CompanyID != companyID {
 		log.Printf("There was an error updating the company ID")
+		//The entity belongs to another company
+		rsp.fail(model.ErrNotFound)
 		return &rsp
 	}
 
-	err = model.RemovePermissionByID(permID)
+	err = model.RemovePermissionByID(ctx, permID)
 	if err != nil {
 		log.Printf("Failed to save the permission with error:[%s]", err)
+		rsp.fail(err)
 		return &rsp
 	}
 
@@ -114,45 +132,40 @@ func removePermissionBL(permID string, companyID string) *permResp {
 	return &rsp
 }
 
-//I have to come back here and maybe switch to passing the limits to the database.
-//That said, I am not expecting a lot of permissions, so this might be fast enough
-//for now.
-func listPermissionBL(startAt int64, endAt int64, companyID string) listPermResp {
+//listPermissionBL - The page [startAt, endAt) is read from the database together with
+//the number of permissions matching the query.
+func listPermissionBL(ctx context.Context, startAt int64, endAt int64, companyID string, query model.ListQuery) listPermResp {
 	var perms listPermResp
 	perms.Status = StatusFailure
 
 	//Perform the index checks
 	if startAt < 0 {
 		log.Printf("The starting index is not valid, replying with empty array %d", startAt)
+		perms.fail(model.NewInputError("InvalidStart"))
 		return perms
 	}
 
 	if endAt < 0 {
 		log.Printf("The endAt is invalid: %d ", endAt)
+		perms.fail(model.NewInputError("InvalidEnd"))
 		return perms
 	}
 
 	if endAt <= startAt {
 		log.Printf("The endAt index %d is lower or equal to startAt %d", endAt, startAt)
+		perms.fail(model.NewInputError("InvalidEnd"))
 		return perms
 	}
 
-	permModel, err := model.ListPermissionsByCompanyID(companyID)
+	query.Skip = startAt
+	query.Limit = endAt - startAt
+	permModel, total, err := model.QueryPermissionsByCompanyID(ctx, companyID, query)
 	if err != nil {
 		log.Printf("It was not possible to retrieve the permissions from the database, error:[%s]", err)
+		perms.fail(err)
 		return perms
 	}
-
-	if endAt > int64(len(permModel)) {
-		endAt = int64(len(permModel))
-	}
-
-	//Lat check if there is no elements.
-	if startAt > endAt {
-		return perms
-	}
-
-	permModel = permModel[startAt:endAt]
+	perms.Total = total
 
 	for i := range permModel {
 		p := permModel[i]
@@ -160,6 +173,7 @@ func listPermissionBL(startAt int64, endAt int64, companyID string) listPermResp
 		permission.ID = p.ID.Hex()
 		permission.Description = p.Description
 		permission.Permission = p.Permission
+		permission.Revision = p.Revision
 		perms.Perms = append(perms.Perms, permission)
 	}
 
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"com/novare/auth/model"
+	"context"
 	"testing"
 )
 
@@ -30,17 +31,18 @@ SOFTWARE.
 */
 
 func TestInsertPermission(t *testing.T) {
+	ctx := context.Background()
 
 	var perm permObj
 	perm.Description = "THIS IS JUST A PERMISSION TEST"
 	perm.Permission = "PERMISSION_TEST"
 
-	permRp := insertPermissionBL("UNIQUEIDCOMPANY", &perm)
+	permRp := insertPermissionBL(ctx, "UNIQUEIDCOMPANY", &perm)
 	if permRp.Status != StatusSuccess {
 		t.Errorf("The insertPermissionBL returned with: [%s]", permRp.Status)
 	}
 
-	permTest, err := model.FindPermissionByID(permRp.ID)
+	permTest, err := model.FindPermissionByID(ctx, permRp.ID)
 	if err != nil {
 		t.Errorf("The following error occurred: [%s]", err)
 	}
@@ -49,22 +51,23 @@ func TestInsertPermission(t *testing.T) {
 		t.Errorf("The permission was not properly stored!")
 	}
 
-	model.RemovePermissionByID(permTest.ID.Hex())
+	model.RemovePermissionByID(ctx, permTest.ID.Hex())
 
 }
 
 func TestUpdatePermission(t *testing.T) {
+	ctx := context.Background()
 
 	var perm permObj
 	perm.Description = "THIS IS JUST A PERMISSION TEST"
 	perm.Permission = "PERMISSION_TEST"
 
-	permRp := insertPermissionBL("UNIQUEIDCOMPANY", &perm)
+	permRp := insertPermissionBL(ctx, "UNIQUEIDCOMPANY", &perm)
 	if permRp.Status != StatusSuccess {
 		t.Errorf("The insertPermissionBL returned with: [%s]", permRp.Status)
 	}
 
-	permTest, err := model.FindPermissionByID(permRp.ID)
+	permTest, err := model.FindPermissionByID(ctx, permRp.ID)
 	if err != nil {
 		t.Errorf("The following error occurred: [%s]", err)
 	}
@@ -74,77 +77,85 @@ func TestUpdatePermission(t *testing.T) {
 	req.Description = "THIS IS A NEW DESCRIPTION"
 	req.Permission = permTest.Permission
 
-	rp := updatePermissionBL(permTest.ID.Hex(), "UNIQUEIDCOMPANY", &req)
+	rp := updatePermissionBL(ctx, permTest.ID.Hex(), "UNIQUEIDCOMPANY", anyRevision, &req)
 	if rp.Status != StatusSuccess {
 		t.Errorf("The following error occurred while saving the permission! :[%s - %s]", req.Permission, rp.Status)
 	}
 
-	permTest, _ = model.FindPermissionByID(req.ID)
+	permTest, _ = model.FindPermissionByID(ctx, req.ID)
 	if permTest.Description != req.Description {
 		t.Errorf("The permission was not properly stored! The description does not match!")
 	}
 
-	model.RemovePermissionByID(permTest.ID.Hex())
+	model.RemovePermissionByID(ctx, permTest.ID.Hex())
 
 }
 
 func TestRemovePermissionBL(t *testing.T) {
+	ctx := context.Background()
 	var perm permObj
 	perm.Description = "THIS IS JUST A PERMISSION TEST"
 	perm.Permission = "PERMISSION_TEST"
 
-	permRp := insertPermissionBL("UNIQUEIDCOMPANY", &perm)
+	permRp := insertPermissionBL(ctx, "UNIQUEIDCOMPANY", &perm)
 	if permRp.Status != StatusSuccess {
 		t.Errorf("The insertPermissionBL returned with: [%s]", permRp.Status)
 	}
 
-	permTest, err := model.FindPermissionByID(permRp.ID)
+	permTest, err := model.FindPermissionByID(ctx, permRp.ID)
 	if err != nil {
 		t.Errorf("The following error occurred: [%s]", err)
 	}
 
-	rsp := removePermissionBL(permTest.ID.Hex(), permTest.CompanyID)
+	rsp := removePermissionBL(ctx, permTest.ID.Hex(), permTest.CompanyID)
 	if rsp.Status != StatusSuccess {
 		t.Errorf("The permission with ID:[%s] was not removed because, the response status was: [%s]", permTest.ID.Hex(), rsp.Status)
 	}
 }
 
 func TestListPermissionsBL(t *testing.T) {
+	ctx := context.Background()
 	var perm permObj
 
 	perm.Description = "THIS IS JUST A PERMISSION TEST"
 	perm.Permission = "PERMISSION_TEST"
 
-	permRp := insertPermissionBL("UNIQUEIDCOMPANY", &perm)
+	permRp := insertPermissionBL(ctx, "UNIQUEIDCOMPANY", &perm)
 	if permRp.Status != StatusSuccess {
 		t.Errorf("The insertPermissionBL returned with: [%s]", permRp.Status)
 	}
 
 	//Test bad start
-	lstRsp := listPermissionBL(-1, 10, "UNIQUEIDCOMPANY")
+	lstRsp := listPermissionBL(ctx, -1, 10, "UNIQUEIDCOMPANY", model.ListQuery{})
 	if lstRsp.Status != StatusFailure {
 		t.Errorf("The following error occurred: [%s]", lstRsp.Status)
 	}
 
 	//Test bad end
-	lstRsp = listPermissionBL(0, -10, "UNIQUEIDCOMPANY")
+	lstRsp = listPermissionBL(ctx, 0, -10, "UNIQUEIDCOMPANY", model.ListQuery{})
 	if lstRsp.Status != StatusFailure {
 		t.Errorf("The following error occurred: [%s]", lstRsp.Status)
 	}
 
 	//Test long end
-	lstRsp = listPermissionBL(0, 10, "UNIQUEIDCOMPANY")
+	lstRsp = listPermissionBL(ctx, 0, 10, "UNIQUEIDCOMPANY", model.ListQuery{})
 	if lstRsp.Status != StatusSuccess {
 		t.Errorf("The following error occurred: [%s]", lstRsp.Status)
 	}
 
-	if len(lstRsp.Perms) != 1 {
-		t.Errorf("The number of permissions is: %d", len(lstRsp.Perms))
+	if len(lstRsp.Perms) != 1 || lstRsp.Total != 1 {
+		t.Errorf("The number of permissions is: %d (total %d)", len(lstRsp.Perms), lstRsp.Total)
+	}
+
+	//Sorting by a field that is not exposed
+	lstRsp = listPermissionBL(ctx, 0, 10, "UNIQUEIDCOMPANY", model.ListQuery{SortBy: "companyID"})
+	if lstRsp.Status != StatusFailure {
+		t.Errorf("An unknown sort key should fail but, the status was: [%s]", lstRsp.Status)
 	}
 
 	for i := range lstRsp.Perms {
 		p := lstRsp.Perms[i]
-		r := removePermissionBL(p.ID, "UNIQUEIDCOMPANY")
+		r := removePermissionBL(ctx, p.ID, "UNIQUEIDCOMPANY")
 		if r.Status != StatusSuccess {
 			t.Errorf("The following Permission was not removed: [%s]", p.Description)
 		}
@@ -0,0 +1,224 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"com/novare/auth/model"
+	"context"
+	"errors"
+	"log"
+	"time"
+	"unicode/utf8"
+)
+
+//TokenTypeRefresh - The opaque token exchanged for new session tokens
+const TokenTypeRefresh string = "refresh_token"
+
+//revokeResp - RFC 7009 only needs the status, an unknown token is
+//revoked as far as the caller is concerned
+type revokeResp struct {
+	Status string `json:"status"`
+	apiError
+}
+
+//revocationListResp - The revocation list entries since the last poll.
+//Now is the since of the next poll.
+type revocationListResp struct {
+	Status string `json:"status"`
+	apiError
+	Now     int64                `json:"now"`
+	Revoked []model.RevokedToken `json:"revoked"`
+}
+
+/*
+canRevoke - The callers revoke their own tokens, the tokens of the other
+users of the company need REVOKE_TOKEN. The tokens of other companies
+are never revoked, they are treated as unknown. A session limited to a
+scope only revokes the tokens of its own refresh token family.
+*/
+func canRevoke(ctx context.Context, caller *model.User, userID string, familyID string) (bool, error) {
+
+	if caller.Scope != nil {
+		if utf8.RuneCountInString(familyID) == 0 || familyID != caller.FamilyID {
+			log.Printf("The session of the family [%s] can only revoke its own tokens", caller.FamilyID)
+			return false, errForbidden
+		}
+		return true, nil
+	}
+
+	if userID == caller.ID.Hex() {
+		return true, nil
+	}
+
+	owner, err := model.FindUserByID(ctx, userID)
+	if errors.Is(err, model.ErrNotFound) || errors.Is(err, model.ErrInvalidInput) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if owner.CompanyID != caller.CompanyID {
+		log.Printf("The caller [%s] can not revoke the tokens of the user [%s]", caller.ID.Hex(), userID)
+		return false, nil
+	}
+
+	if !caller.IsGranted(ctx, "REVOKE_TOKEN") {
+		return false, errForbidden
+	}
+
+	return true, nil
+}
+
+/*
+revokeBL - RFC 7009 token revocation. A session token is removed with the
+refresh token family of its login, an access token is added to the
+revocation list until it expires and a refresh token revokes its family.
+The token_type_hint is not needed, the token says what it is. Tokens
+that are invalid or unknown are not an error.
+*/
+func revokeBL(ctx context.Context, caller *model.User, token string) *revokeResp {
+
+	var rsp revokeResp
+	rsp.Status = StatusFailure
+
+	err := revokeToken(ctx, caller, token)
+	if err != nil {
+		log.Printf("The token could not be revoked: [%s]", err)
+		rsp.fail(err)
+		return &rsp
+	}
+
+	rsp.Status = StatusSuccess
+	return &rsp
+}
+
+func revokeToken(ctx context.Context, caller *model.User, token string) error {
+
+	jwt := model.NewJWTToken("", "")
+	if jwt.ParseJWT(token) != nil {
+		return revokeRefreshToken(ctx, caller, token)
+	}
+
+	if jwt.IsSignedWithKey() {
+		err := model.VerifyJWTSignature(ctx, jwt)
+		if errors.Is(err, model.ErrInvalidSignature) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	stored, err := model.FindJWTTokenBySignature(ctx, jwt.Signature)
+	switch {
+	case err == nil:
+		if !jwt.IsSignedWithKey() {
+			jwt.Secret = stored.Secret
+			if jwt.IsTampered() {
+				return nil
+			}
+		}
+		return revokeSessionToken(ctx, caller, stored)
+	case errors.Is(err, model.ErrNotFound):
+		if !jwt.IsSignedWithKey() {
+			return nil
+		}
+		return revokeAccessToken(ctx, caller, jwt)
+	default:
+		return err
+	}
+}
+
+func revokeSessionToken(ctx context.Context, caller *model.User, session *model.JWTToken) error {
+
+	ok, err := canRevoke(ctx, caller, session.UserID, session.FamilyID)
+	if err != nil || !ok {
+		return err
+	}
+
+	err = model.RevokeJWTToken(ctx, session)
+	if err != nil {
+		return err
+	}
+	return model.RevokeTokenFamily(ctx, session.FamilyID)
+}
+
+//isPayloadExpired - An expiration of 0 never expires
+func isPayloadExpired(payload *model.JWTPayload) bool {
+	return payload.ExpirationTime != 0 && payload.IsExpired()
+}
+
+func revokeAccessToken(ctx context.Context, caller *model.User, jwt *model.JWTToken) error {
+
+	if isPayloadExpired(&jwt.Payload) {
+		return nil
+	}
+
+	ok, err := canRevoke(ctx, caller, jwt.Payload.Subject, "")
+	if err != nil || !ok {
+		return err
+	}
+
+	return model.RevokeTokenID(ctx, caller.CompanyID, jwt.Payload.ID, jwt.Payload.ExpirationTime)
+}
+
+func revokeRefreshToken(ctx context.Context, caller *model.User, token string) error {
+
+	refresh, err := model.FindRefreshToken(ctx, token)
+	if errors.Is(err, model.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ok, err := canRevoke(ctx, caller, refresh.UserID, refresh.FamilyID)
+	if err != nil || !ok {
+		return err
+	}
+
+	return model.RevokeTokenFamily(ctx, refresh.FamilyID)
+}
+
+//revocationsBL - The revocation list of the caller's company since the
+//given unix time
+func revocationsBL(ctx context.Context, caller *model.User, since int64) *revocationListResp {
+
+	var rsp revocationListResp
+	rsp.Status = StatusFailure
+
+	//Taken first, what is revoked while listing comes again next poll
+	rsp.Now = time.Now().Unix()
+	list, err := model.ListRevokedTokens(ctx, caller.CompanyID, since)
+	if err != nil {
+		rsp.fail(err)
+		return &rsp
+	}
+
+	rsp.Status = StatusSuccess
+	rsp.Revoked = list
+	return &rsp
+}
@@ -0,0 +1,148 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"com/novare/auth/model"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRevokeBL(t *testing.T) {
+	ctx := context.Background()
+
+	company, user, lrsp := loginTestCompany(t, "REVOKEID")
+	if lrsp == nil {
+		return
+	}
+	defer performCompanyCleanup(company.ID.Hex(), t)
+
+	session := model.NewJWTToken("", "")
+	session.ParseJWT(lrsp.SessionToken)
+	stored, _ := model.FindJWTTokenBySignature(ctx, session.Signature)
+	atr := grantRequestBL(ctx, company.UniqueID, "INTROSPECT_TOKEN", "", stored, user)
+	if atr.Status != StatusSuccess {
+		t.Errorf("The access token was not granted: [%s]", atr.Code)
+		return
+	}
+
+	//A user without REVOKE_TOKEN can not revoke the tokens of others
+	other := model.NewUser()
+	other.CompanyID = company.ID.Hex()
+	rsp := revokeBL(ctx, other, atr.AccessToken)
+	if rsp.Status != StatusFailure || rsp.Code != CodeForbidden {
+		t.Errorf("The access token should not be revoked by another user: %+v", rsp)
+	}
+
+	rsp = revokeBL(ctx, user, atr.AccessToken)
+	if rsp.Status != StatusSuccess {
+		t.Errorf("The access token was not revoked: [%s]", rsp.Code)
+	}
+
+	irsp := introspectBL(ctx, user, atr.AccessToken)
+	if irsp.Active {
+		t.Errorf("The revoked access token should be inactive: %+v", irsp)
+	}
+
+	access := model.NewJWTToken("", "")
+	access.ParseJWT(atr.AccessToken)
+	list := revocationsBL(ctx, user, 0)
+	if list.Status != StatusSuccess || len(list.Revoked) != 1 || list.Revoked[0].TokenID != access.Payload.ID {
+		t.Errorf("The revocation list is invalid: %+v", list)
+	}
+
+	//Unknown and invalid tokens are not an error
+	for _, token := range []string{"unknown-refresh-token", "not.a.token"} {
+		rsp = revokeBL(ctx, user, token)
+		if rsp.Status != StatusSuccess {
+			t.Errorf("Revoking [%s] should succeed: [%s]", token, rsp.Code)
+		}
+	}
+
+	//The refresh token revokes the family, the session of the login too
+	rsp = revokeBL(ctx, user, lrsp.RefreshToken)
+	if rsp.Status != StatusSuccess {
+		t.Errorf("The refresh token was not revoked: [%s]", rsp.Code)
+	}
+
+	_, err := model.FindJWTTokenBySignature(ctx, session.Signature)
+	if err != model.ErrNotFound {
+		t.Errorf("The session of the revoked family should be removed: [%v]", err)
+	}
+
+	revoked, err := model.IsTokenRevoked(ctx, session.Payload.ID)
+	if err != nil || !revoked {
+		t.Errorf("The session should be on the revocation list: [%v]", err)
+	}
+}
+
+func TestHTTPRevoke(t *testing.T) {
+	company, _, lrsp := loginTestCompany(t, "HTTPREVOKEID")
+	if lrsp == nil {
+		return
+	}
+	defer performCompanyCleanup(company.ID.Hex(), t)
+
+	//The session revokes itself, it can not be used afterwards
+	form := url.Values{"token": {lrsp.SessionToken}, "token_type_hint": {TokenTypeSession}}
+	httpReq := httptest.NewRequest("POST", "/oauth/revoke", strings.NewReader(form.Encode()))
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Authorization", "bearer "+lrsp.SessionToken)
+	httpRec := httptest.NewRecorder()
+	NewRouter().ServeHTTP(httpRec, httpReq)
+	if httpRec.Code != http.StatusOK {
+		t.Errorf("The revocation failed: %d [%s]", httpRec.Code, httpRec.Body.String())
+	}
+
+	httpReq = httptest.NewRequest("GET", "/oauth/revocations?since=0", nil)
+	httpReq.Header.Set("Authorization", "bearer "+lrsp.SessionToken)
+	httpRec = httptest.NewRecorder()
+	NewRouter().ServeHTTP(httpRec, httpReq)
+	if httpRec.Code != http.StatusUnauthorized {
+		t.Errorf("The revoked session should be rejected: %d [%s]", httpRec.Code, httpRec.Body.String())
+	}
+
+	//A new login polls the list
+	var lr loginReq
+	lr.UniqueID = company.UniqueID
+	lr.Username = "superuser"
+	lr.Password = "@123ABC789"
+	lrsp = loginBL(context.Background(), lr)
+	httpReq = httptest.NewRequest("GET", "/oauth/revocations?since=0", nil)
+	httpReq.Header.Set("Authorization", "bearer "+lrsp.SessionToken)
+	httpRec = httptest.NewRecorder()
+	NewRouter().ServeHTTP(httpRec, httpReq)
+
+	var body revocationListResp
+	json.Unmarshal(httpRec.Body.Bytes(), &body)
+	if httpRec.Code != http.StatusOK || len(body.Revoked) != 1 || body.Now == 0 {
+		t.Errorf("The revocation list is invalid: %d [%s]", httpRec.Code, httpRec.Body.String())
+	}
+}
@@ -27,10 +27,11 @@ package controller
 import (
 	"com/novare/auth/model"
 	"com/novare/auth/sse"
+	"context"
 	"log"
 )
 
-func insertRoleBL(companyID string, req *roleObj) *roleResp {
+func insertRoleBL(ctx context.Context, companyID string, req *roleObj) *roleResp {
 	var rsp roleResp
 	rsp.Status = StatusFailure
 
@@ -39,9 +40,10 @@ func insertRoleBL(companyID string, req *roleObj) *roleResp {
 	role.Description = req.Description
 	role.Permissions = req.Permissions
 
-	err := model.InsertRole(role)
+	err := model.InsertRole(ctx, role)
 	if err != nil {
 		log.Printf("There following error occurred when inserting a role: [%s]", err)
+		rsp.fail(err)
 		return &rsp
 	}
 
@@ -55,27 +57,38 @@ func insertRoleBL(companyID string, req *roleObj) *roleResp {
 	return &rsp
 }
 
-func updateRoleBL(roleID string, companyID string, req *roleObj) *roleResp {
+func updateRoleBL(ctx context.Context, roleID string, companyID string, ifMatch int64, req *roleObj) *roleResp {
 	var rsp roleResp
 	rsp.Status = StatusFailure
 
-	role, err := model.FindRoleByID(roleID)
+	role, err := model.FindRoleByID(ctx, roleID)
 	if err != nil {
 		log.Printf("Failed to update the role: [%s]", err)
+		rsp.fail(err)
 		return &rsp
 	}
 
 	if role.CompanyID != companyID {
 		log.Printf("There was an error updating the company ID does not match")
+		//The entity belongs to another company
+		rsp.fail(model.ErrNotFound)
+		return &rsp
+	}
+
+	err = checkRevision(ifMatch, role.Revision)
+	if err != nil {
+		log.Printf("The role [%s] is at revision %d, the update expected %d", roleID, role.Revision, ifMatch)
+		rsp.fail(err)
 		return &rsp
 	}
 
 	role.Description = req.Description
 	role.Permissions = req.Permissions
 
-	err = model.SaveRole(role)
+	err = model.SaveRole(ctx, role)
 	if err != nil {
 		log.Printf("Failed to save the role with error:[%s]", err)
+		rsp.fail(err)
 		return &rsp
 	}
 
@@ -83,30 +96,35 @@ func updateRoleBL(roleID string, companyID string, req *roleObj) *roleResp {
 	rsp.Role.ID = role.ID.Hex()
 	rsp.Role.Description = role.Description
 	rsp.Role.Permissions = role.Permissions
+	rsp.Role.Revision = role.Revision
 
 	publishEvent(sse.EventRoleUpdate, "Update")
 
 	return &rsp
 }
 
-func removeRoleBL(roleID string, companyID string) *roleResp {
+func removeRoleBL(ctx context.Context, roleID string, companyID string) *roleResp {
 	var rsp roleResp
 	rsp.Status = StatusFailure
 
-	role, err := model.FindRoleByID(roleID)
+	role, err := model.FindRoleByID(ctx, roleID)
 	if err != nil {
 		log.Printf("Failed to update the role: [%s]", err)
+		rsp.fail(err)
 		return &rsp
 	}
 
 	if role.CompanyID != companyID {
 		log.Printf("There was an error updating the company ID")
+		//The entity belongs to another company
+		rsp.fail(model.ErrNotFound)
 		return &rsp
 	}
 
-	err = model.RemoveRoleByID(roleID)
+	err = model.RemoveRoleByID(ctx, roleID)
 	if err != nil {
 		log.Printf("Failed to save the role with error:[%s]", err)
+		rsp.fail(err)
 		return &rsp
 	}
 
@@ -117,45 +135,40 @@ func removeRoleBL(roleID string, companyID string) *roleResp {
 	return &rsp
 }
 
-//I have to come back here and maybe switch to passing the limits to the database.
-//That said, I am not expecting a lot of permissions, so this might be fast enough
-//for now.
-func listRolesBL(startAt int64, endAt int64, companyID string) listRoleResp {
+//listRolesBL - The page [startAt, endAt) is read from the database together with
+//the number of roles matching the query.
+func listRolesBL(ctx context.Context, startAt int64, endAt int64, companyID string, query model.ListQuery) listRoleResp {
 	var roles listRoleResp
 	roles.Status = StatusFailure
 
 	//Perform the index checks
 	if startAt < 0 {
 		log.Printf("The starting index is not valid, replying with empty array %d", startAt)
+		roles.fail(model.NewInputError("InvalidStart"))
 		return roles
 	}
 
 	if endAt < 0 {
 		log.Printf("The endAt is invalid: %d ", endAt)
+		roles.fail(model.NewInputError("InvalidEnd"))
 		return roles
 	}
 
 	if endAt <= startAt {
 		log.Printf("The endAt index %d is lower or equal to startAt %d", endAt, startAt)
+		roles.fail(model.NewInputError("InvalidEnd"))
 		return roles
 	}
 
-	roleModel, err := model.ListRolesByCompanyID(companyID)
+	query.Skip = startAt
+	query.Limit = endAt - startAt
+	roleModel, total, err := model.QueryRolesByCompanyID(ctx, companyID, query)
 	if err != nil {
-		log.Printf("It was not possible to retrieve the permissions from the database, error:[%s]", err)
-		return roles
-	}
-
-	if endAt > int64(len(roleModel)) {
-		endAt = int64(len(roleModel))
-	}
-
-	//Lat check if there is no elements.
-	if startAt > endAt {
+		log.Printf("It was not possible to retrieve the roles from the database, error:[%s]", err)
+		roles.fail(err)
 		return roles
 	}
-
-	roleModel = roleModel[startAt:endAt]
+	roles.Total = total
 
 	for i := range roleModel {
 		p := roleModel[i]
@@ -163,6 +176,7 @@ func listRolesBL(startAt int64, endAt int64, companyID string) listRoleResp {
 		role.ID = p.ID.Hex()
 		role.Description = p.Description
 		role.Permissions = p.Permissions
+		role.Revision = p.Revision
 		roles.Roles = append(roles.Roles, role)
 	}
 
@@ -25,11 +25,20 @@ SOFTWARE.
 package controller
 
 import (
+	"bytes"
 	"com/novare/auth/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/gorilla/mux"
 )
 
 func TestRolesInsertUpdateRemove(t *testing.T) {
+	ctx := context.Background()
 
 	company := model.NewCompany()
 	company.UniqueID = "UNIQUE"
@@ -41,20 +50,70 @@ func TestRolesInsertUpdateRemove(t *testing.T) {
 	role.Description = "MyRole"
 	role.Permissions = append(role.Permissions, *permission)
 
-	rsp := insertRoleBL("UNIQUE", &role)
+	rsp := insertRoleBL(ctx, "UNIQUE", &role)
 	if rsp.Status != StatusSuccess {
 		t.Errorf("The response was not successful: [%s]", rsp.Status)
 	}
 
 	role.ID = rsp.Role.ID
 	role.Description = "Just another description"
-	rsp = updateRoleBL(role.ID, "UNIQUE", &role)
-	if rsp.Status != StatusSuccess {
-		t.Errorf("The response was not successful: [%s]", rsp.Status)
+	rsp = updateRoleBL(ctx, role.ID, "UNIQUE", anyRevision, &role)
+	if rsp.Status != StatusSuccess || rsp.Role.Revision != 1 {
+		t.Errorf("The response was not successful: [%s] %d", rsp.Status, rsp.Role.Revision)
+	}
+
+	//Somebody else read the role before the update above
+	rsp = updateRoleBL(ctx, role.ID, "UNIQUE", 0, &role)
+	if rsp.Status != StatusFailure || !errors.Is(rsp.failure(), errPreconditionFailed) {
+		t.Errorf("An update of a stale revision should fail: [%s] [%v]", rsp.Status, rsp.failure())
 	}
 
-	rsp = removeRoleBL(role.ID, "UNIQUE")
+	rsp = removeRoleBL(ctx, role.ID, "UNIQUE")
 	if rsp.Status != StatusSuccess {
 		t.Errorf("The following error occurred: [%s]", rsp.Status)
 	}
 }
+
+func TestUpdateRoleIfMatch(t *testing.T) {
+	ctx := context.Background()
+
+	role := model.NewRole()
+	role.CompanyID = "IFMATCHCOMPANY"
+	role.Description = "IfMatch"
+	err := model.InsertRole(ctx, role)
+	if err != nil {
+		t.Errorf("The role was not inserted: [%s]", err)
+		return
+	}
+	defer model.RemoveRoleByID(ctx, role.ID.Hex())
+
+	usr := model.NewUser()
+	usr.CompanyID = role.CompanyID
+
+	update := func(ifMatch string) *httptest.ResponseRecorder {
+		buf, _ := json.Marshal(roleObj{Description: "Updated"})
+		r := httptest.NewRequest("POST", "/jwt/role/"+role.ID.Hex(), bytes.NewBuffer(buf))
+		r.Header.Set("If-Match", ifMatch)
+		r = r.WithContext(context.WithValue(r.Context(), CtxUser, usr))
+		r = mux.SetURLVars(r, map[string]string{"roleid": role.ID.Hex()})
+
+		w := httptest.NewRecorder()
+		UpdateRole(w, r)
+		return w
+	}
+
+	w := update(`"0"`)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"1"` {
+		t.Errorf("The update should have succeeded: %d [%s]", w.Code, w.Header().Get("ETag"))
+	}
+
+	w = update(`"0"`)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("The stale update should have been rejected: %d", w.Code)
+	}
+
+	w = update("NOTAREVISION")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("An invalid If-Match should be rejected: %d", w.Code)
+	}
+}
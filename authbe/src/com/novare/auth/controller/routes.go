@@ -0,0 +1,136 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+//NewRouter - All the routes served by the edgeauth. The application and
+//the authtest package share it so the tests exercise the real routes.
+func NewRouter() *mux.Router {
+
+	router := mux.NewRouter().StrictSlash(true)
+
+	//-------------------------------------------------------------------------
+	//Server Sent Events. The stream stays open so it does not get a deadline.
+	//-------------------------------------------------------------------------
+	router.Handle("/jwt/events", CheckAuthorizedMW(http.HandlerFunc(ServerSentEvents), "RECEIVE_EVENTS")).Methods("POST")
+
+	//-------------------------------------------------------------------------
+	//Every other request runs with the RequestTimeout deadline
+	//-------------------------------------------------------------------------
+	api := router.NewRoute().Subrouter()
+	api.Use(RequestDeadlineMW)
+
+	//Create Company
+	api.HandleFunc("/jwt/company", CreateCompany).Methods("POST")
+
+	//Get Company
+	api.HandleFunc("/jwt/company/{uniqueid}", GetCompanyByUniqueID).Methods("GET")
+
+	//Login
+	api.HandleFunc("/jwt/company/login", Login).Methods("POST")
+	api.HandleFunc("/jwt/company/machine_login", LoginBySecret).Methods("POST")
+	api.HandleFunc("/jwt/company/refresh", Refresh).Methods("POST")
+
+	//Logout
+	api.HandleFunc("/jwt/company/logout", Logout).Methods("POST")
+
+	//The public keys of the token signing keys
+	api.HandleFunc("/.well-known/jwks.json", JSONWebKeySet).Methods("GET")
+
+	//OpenID Connect discovery, the apps log in with the authorization code
+	api.HandleFunc("/.well-known/openid-configuration", OpenIDConfiguration).Methods("GET")
+
+	//Remote Create Company
+	api.HandleFunc("/company/remote", CreateCompanyRemote).Methods("POST")
+	api.HandleFunc("/company/remote", RemoveCompanyRemote).Methods("DELETE")
+
+	//These calls below require grants
+
+	//-------------------------------------------------------------------------
+	//Permissions
+	//-------------------------------------------------------------------------
+	api.Handle("/jwt/permission", CheckAuthorizedMW(http.HandlerFunc(InsertPermission), "ADD_PERMISSION")).Methods("PUT")
+	api.Handle("/jwt/permission/{permid}", CheckAuthorizedMW(http.HandlerFunc(UpdatePermission), "UPDATE_PERMISSION")).Methods("POST")
+	api.Handle("/jwt/permission/{permid}", CheckAuthorizedMW(http.HandlerFunc(RemovePermission), "REMOVE_PERMISSION")).Methods("DELETE")
+	api.Handle("/jwt/permission/{startat}/{endat}", CheckAuthorizedMW(http.HandlerFunc(ListPermissions), "GET_PERMISSION")).Methods("GET")
+
+	//-------------------------------------------------------------------------
+	//Roles
+	//-------------------------------------------------------------------------
+	api.Handle("/jwt/role", CheckAuthorizedMW(http.HandlerFunc(InsertRole), "ADD_ROLE")).Methods("PUT")
+	api.Handle("/jwt/role/{roleid}", CheckAuthorizedMW(http.HandlerFunc(UpdateRole), "UPDATE_ROLE")).Methods("POST")
+	api.Handle("/jwt/role/{roleid}", CheckAuthorizedMW(http.HandlerFunc(RemoveRole), "REMOVE_ROLE")).Methods("DELETE")
+	api.Handle("/jwt/role/{startat}/{endat}", CheckAuthorizedMW(http.HandlerFunc(ListRoles), "GET_ROLE")).Methods("GET")
+
+	//-------------------------------------------------------------------------
+	//Users
+	//-------------------------------------------------------------------------
+	api.Handle("/jwt/user", CheckAuthorizedMW(http.HandlerFunc(InsertUser), "ADD_USER")).Methods("PUT")
+	api.Handle("/jwt/user/{username}", CheckAuthorizedMW(http.HandlerFunc(UpdateUser), "UPDATE_USER")).Methods("POST")
+	api.Handle("/jwt/user/{username}", CheckAuthorizedMW(http.HandlerFunc(RemoveUser), "REMOVE_USER")).Methods("DELETE")
+	api.Handle("/jwt/users/{startat}/{endat}", CheckAuthorizedMW(http.HandlerFunc(ListUsers), "GET_USER")).Methods("GET")
+	api.Handle("/jwt/password", CheckAuthorizedMW(http.HandlerFunc(UpdatePassword), "UPDATE_PASSWORD")).Methods("POST")
+
+	//-------------------------------------------------------------------------
+	//Sessions, every user manages their own
+	//-------------------------------------------------------------------------
+	api.Handle("/jwt/sessions", CheckAuthorizedMW(http.HandlerFunc(ListSessions), "LIST_SESSION")).Methods("GET")
+	api.Handle("/jwt/sessions", CheckAuthorizedMW(http.HandlerFunc(TerminateSessions), "TERMINATE_SESSION")).Methods("DELETE")
+	api.Handle("/jwt/sessions/self", CheckAuthorizedMW(http.HandlerFunc(ListOwnSessions), "")).Methods("GET")
+	api.Handle("/jwt/sessions/self", CheckAuthorizedMW(http.HandlerFunc(TerminateOwnSessions), "")).Methods("DELETE")
+	api.Handle("/jwt/session/{sessionid}", CheckAuthorizedMW(http.HandlerFunc(TerminateSession), "")).Methods("DELETE")
+
+	//-------------------------------------------------------------------------
+	//Company
+	//-------------------------------------------------------------------------
+	api.Handle("/jwt/company/{uniqueid}", CheckAuthorizedMW(http.HandlerFunc(UpdateCompany), "UPDATE_COMPANY")).Methods("POST")
+	api.Handle("/companies/{grouponwerid}", CheckAuthorizedMW(http.HandlerFunc(GetCompanyByGroupOwnerID), "LIST_GROUP")).Methods("GET")
+	api.Handle("/company/registration/{companyid}", CheckAuthorizedMW(http.HandlerFunc(EnableRegistration), "ENABLE_REGISTATION")).Methods("POST")
+
+	//-------------------------------------------------------------------------
+	//OAuth
+	//-------------------------------------------------------------------------
+	api.Handle("/oauth/introspect", CheckAuthorizedMW(http.HandlerFunc(Introspect), "INTROSPECT_TOKEN")).Methods("POST")
+	api.Handle("/oauth/revoke", CheckSessionMW(http.HandlerFunc(Revoke))).Methods("POST")
+	api.Handle("/oauth/revocations", CheckAuthorizedMW(http.HandlerFunc(Revocations), "INTROSPECT_TOKEN")).Methods("GET")
+	api.HandleFunc("/oauth/token", Token).Methods("POST")
+	api.HandleFunc("/oauth/authorize", Authorize).Methods("GET", "POST")
+	api.Handle("/userinfo", CheckSessionMW(http.HandlerFunc(UserInfo))).Methods("GET", "POST")
+	api.Handle("/oauth/client", CheckAuthorizedMW(http.HandlerFunc(InsertClient), "ADD_CLIENT")).Methods("PUT")
+	api.Handle("/oauth/client/{clientid}", CheckAuthorizedMW(http.HandlerFunc(UpdateClient), "UPDATE_CLIENT")).Methods("POST")
+	api.Handle("/oauth/client/{clientid}/secret", CheckAuthorizedMW(http.HandlerFunc(RotateClientSecret), "UPDATE_CLIENT")).Methods("POST")
+	api.Handle("/oauth/client/{clientid}", CheckAuthorizedMW(http.HandlerFunc(RemoveClient), "REMOVE_CLIENT")).Methods("DELETE")
+	api.Handle("/oauth/clients", CheckAuthorizedMW(http.HandlerFunc(ListClients), "GET_CLIENT")).Methods("GET")
+
+	grantHandler := http.HandlerFunc(GrantRequest)
+	api.Handle("/jwt/grant/{ucid}", AuthorizationRequest(grantHandler)).Methods("GET")
+
+	return router
+}
@@ -45,7 +45,7 @@ func ServerSentEvents(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	var events eventsReq
-	usr := r.Context().Value(CtxUser).(model.User)
+	usr := r.Context().Value(CtxUser).(*model.User)
 
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&events)
@@ -98,6 +98,18 @@ func ServerSentEvents(w http.ResponseWriter, r *http.Request) {
 
 }
 
+//PublishKeyRotation - Tell the remote sites the signing keys changed. It
+//is subscribed to the model.Keys at startup.
+func PublishKeyRotation(rotation model.KeyRotation) {
+	publishEvent(sse.EventKeyRotation, rotation)
+}
+
+//PublishRevocation - Tell the remote sites a token was revoked. It is
+//subscribed to the revocation list at startup.
+func PublishRevocation(revoked model.RevokedToken) {
+	publishEvent(sse.EventTokenRevoked, revoked)
+}
+
 //We need to specialize this a little better but, just for
 //a proof of concept, it should work.
 func publishEvent(eventID string, additionalData interface{}) {
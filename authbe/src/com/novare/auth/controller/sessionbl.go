@@ -0,0 +1,176 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"com/novare/auth/model"
+	"context"
+	"log"
+	"unicode/utf8"
+)
+
+//sessionObj - A session as the session management shows it
+type sessionObj struct {
+	ID         string `json:"id"` //The session ID, the token refreshes do not change it
+	UserID     string `json:"userID"`
+	Username   string `json:"username"`
+	DeviceID   string `json:"deviceID"`
+	IPAddress  string `json:"ipAddress"`
+	UserAgent  string `json:"userAgent"`
+	CreatedAt  int64  `json:"createdAt"`
+	LastUsedAt int64  `json:"lastUsedAt"`
+	ExpiresAt  int64  `json:"expiresAt"` //The current session token, the session lasts as long as it can be refreshed
+	Current    bool   `json:"current"`   //The session of the request
+}
+
+type sessionsResp struct {
+	Status string `json:"status"`
+	apiError
+	Sessions []sessionObj `json:"sessions"`
+}
+
+type terminateSessionsResp struct {
+	Status string `json:"status"`
+	apiError
+	Terminated int `json:"terminated"`
+}
+
+//listSessions - The sessions of the user or, without a user, of the
+//whole company
+func listSessions(ctx context.Context, companyID string, userID string) ([]model.JWTToken, error) {
+	if utf8.RuneCountInString(userID) == 0 {
+		return model.ListCompanySessions(ctx, companyID)
+	}
+	return model.ListUserSessions(ctx, userID, companyID)
+}
+
+/*
+listSessionsBL - The live sessions of the caller's company or of one of
+its users. The current session is the one signed with currentSignature.
+*/
+func listSessionsBL(ctx context.Context, caller *model.User, userID string, currentSignature string) *sessionsResp {
+
+	var rsp sessionsResp
+	rsp.Status = StatusFailure
+
+	sessions, err := listSessions(ctx, caller.CompanyID, userID)
+	if err != nil {
+		log.Printf("The sessions of the company [%s] could not be listed: [%s]", caller.CompanyID, err)
+		rsp.fail(err)
+		return &rsp
+	}
+
+	usernames := map[string]string{caller.ID.Hex(): caller.Username}
+	rsp.Sessions = []sessionObj{}
+	for i := range sessions {
+		s := &sessions[i]
+		username, ok := usernames[s.UserID]
+		if !ok {
+			if user, err := model.FindUserByID(ctx, s.UserID); err == nil {
+				username = user.Username
+			}
+			usernames[s.UserID] = username
+		}
+
+		rsp.Sessions = append(rsp.Sessions, sessionObj{
+			ID:         s.SessionID(),
+			UserID:     s.UserID,
+			Username:   username,
+			DeviceID:   s.Session.DeviceID,
+			IPAddress:  s.Session.IPAddress,
+			UserAgent:  s.Session.UserAgent,
+			CreatedAt:  s.Session.StartedAt,
+			LastUsedAt: s.Session.LastUsedAt,
+			ExpiresAt:  s.Payload.ExpirationTime,
+			Current:    s.Signature == currentSignature,
+		})
+	}
+
+	rsp.Status = StatusSuccess
+	return &rsp
+}
+
+/*
+terminateSessionBL - End one session of the company. The users end their
+own sessions, the sessions of the others need TERMINATE_SESSION.
+*/
+func terminateSessionBL(ctx context.Context, caller *model.User, sessionID string) *terminateSessionsResp {
+
+	var rsp terminateSessionsResp
+	rsp.Status = StatusFailure
+
+	session, err := model.FindSession(ctx, sessionID)
+	if err == nil && session.CompanyID != caller.CompanyID {
+		err = model.ErrNotFound
+	}
+	if err != nil {
+		log.Printf("The session [%s] was not found: [%s]", sessionID, err)
+		rsp.fail(err)
+		return &rsp
+	}
+
+	if session.UserID != caller.ID.Hex() && !caller.IsGranted(ctx, "TERMINATE_SESSION") {
+		log.Printf("The user [%s] can not terminate the session [%s]", caller.Username, sessionID)
+		rsp.fail(errForbidden)
+		return &rsp
+	}
+
+	err = model.EndSession(ctx, session)
+	if err != nil {
+		rsp.fail(err)
+		return &rsp
+	}
+
+	rsp.Status = StatusSuccess
+	rsp.Terminated = 1
+	return &rsp
+}
+
+//terminateSessionsBL - End every session of the user or, without a user,
+//of the whole company, the caller's included
+func terminateSessionsBL(ctx context.Context, caller *model.User, userID string) *terminateSessionsResp {
+
+	var rsp terminateSessionsResp
+	rsp.Status = StatusFailure
+
+	sessions, err := listSessions(ctx, caller.CompanyID, userID)
+	if err != nil {
+		rsp.fail(err)
+		return &rsp
+	}
+
+	for i := range sessions {
+		err = model.EndSession(ctx, &sessions[i])
+		if err != nil {
+			log.Printf("The session [%s] could not be terminated: [%s]", sessions[i].SessionID(), err)
+			rsp.fail(err)
+			return &rsp
+		}
+		rsp.Terminated++
+	}
+
+	rsp.Status = StatusSuccess
+	return &rsp
+}
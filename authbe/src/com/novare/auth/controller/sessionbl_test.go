@@ -0,0 +1,187 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"com/novare/auth/model"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionsBL(t *testing.T) {
+	ctx := context.Background()
+
+	company, user, backOffice := loginTestCompany(t, "SESSIONMGMTID")
+	if backOffice == nil {
+		return
+	}
+	defer performCompanyCleanup(company.ID.Hex(), t)
+
+	var lr loginReq
+	lr.UniqueID = company.UniqueID
+	lr.Username = "superuser"
+	lr.Password = "@123ABC789"
+	lr.DeviceID = "LANE1"
+	lane := loginBL(ctx, lr)
+
+	current := model.NewJWTToken("", "")
+	current.ParseJWT(lane.SessionToken)
+	rsp := listSessionsBL(ctx, user, user.ID.Hex(), current.Signature)
+	if rsp.Status != StatusSuccess || len(rsp.Sessions) != 2 {
+		t.Errorf("The user should have two sessions: %+v", rsp)
+		return
+	}
+
+	laneSession, pcSession := rsp.Sessions[0], rsp.Sessions[1]
+	if laneSession.DeviceID != "LANE1" {
+		laneSession, pcSession = pcSession, laneSession
+	}
+	if laneSession.DeviceID != "LANE1" || !laneSession.Current || pcSession.Current ||
+		laneSession.Username != "superuser" || laneSession.CreatedAt == 0 || laneSession.ExpiresAt == 0 {
+		t.Errorf("The lane session is invalid: %+v", laneSession)
+	}
+
+	company2 := listSessionsBL(ctx, user, "", "")
+	if company2.Status != StatusSuccess || len(company2.Sessions) != 2 {
+		t.Errorf("The company should have two sessions: %+v", company2)
+	}
+
+	//Another user without TERMINATE_SESSION
+	other := model.NewUser()
+	other.CompanyID = company.ID.Hex()
+	trsp := terminateSessionBL(ctx, other, laneSession.ID)
+	if trsp.Code != CodeForbidden {
+		t.Errorf("Another user should not terminate the session: %+v", trsp)
+	}
+
+	//Nor can another company see it
+	other.CompanyID = "ANOTHERCOMPANY"
+	trsp = terminateSessionBL(ctx, other, laneSession.ID)
+	if trsp.Code != CodeNotFound {
+		t.Errorf("The session of another company should not be found: %+v", trsp)
+	}
+
+	trsp = terminateSessionBL(ctx, user, laneSession.ID)
+	if trsp.Status != StatusSuccess || trsp.Terminated != 1 {
+		t.Errorf("The lane session was not terminated: %+v", trsp)
+	}
+
+	if refreshBL(ctx, refreshReq{RefreshToken: lane.RefreshToken}).Status != StatusFailure {
+		t.Errorf("The terminated session can still be refreshed")
+	}
+
+	//The back-office session ends itself through the route
+	httpReq := httptest.NewRequest("DELETE", "/jwt/sessions/self", nil)
+	httpReq.Header.Set("Authorization", "bearer "+backOffice.SessionToken)
+	httpRec := httptest.NewRecorder()
+	NewRouter().ServeHTTP(httpRec, httpReq)
+
+	var body terminateSessionsResp
+	json.Unmarshal(httpRec.Body.Bytes(), &body)
+	if httpRec.Code != http.StatusOK || body.Terminated != 1 {
+		t.Errorf("The own sessions were not terminated: %d [%s]", httpRec.Code, httpRec.Body.String())
+	}
+
+	rsp = listSessionsBL(ctx, user, "", "")
+	if len(rsp.Sessions) != 0 {
+		t.Errorf("The company should not have sessions left: %+v", rsp.Sessions)
+	}
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	company, _, lrsp := loginTestCompany(t, "SESSIONIDLEID")
+	if lrsp == nil {
+		return
+	}
+	defer performCompanyCleanup(company.ID.Hex(), t)
+
+	company.Settings.IdleTimeout = 10
+	err := model.SaveCompany(ctx, company)
+	if err != nil {
+		t.Errorf("The idle timeout was not saved: [%s]", err)
+		return
+	}
+
+	listOwn := func(token string) *httptest.ResponseRecorder {
+		httpReq := httptest.NewRequest("GET", "/jwt/sessions/self", nil)
+		httpReq.Header.Set("Authorization", "bearer "+token)
+		httpRec := httptest.NewRecorder()
+		NewRouter().ServeHTTP(httpRec, httpReq)
+		return httpRec
+	}
+
+	//A terminal in use within the timeout stays logged in
+	if rec := listOwn(lrsp.SessionToken); rec.Code != http.StatusOK {
+		t.Errorf("The active session was rejected: %d [%s]", rec.Code, rec.Body.String())
+	}
+
+	jwt := model.NewJWTToken("", "")
+	jwt.ParseJWT(lrsp.SessionToken)
+	session, err := model.FindJWTTokenBySignature(ctx, jwt.Signature)
+	if err != nil {
+		t.Errorf("The session was not found: [%s]", err)
+		return
+	}
+
+	//The refresh is not a use of the session
+	session.Session.LastUsedAt = time.Now().Add(-20 * time.Minute).Unix()
+	model.SaveJWTToken(ctx, session)
+	refreshed := refreshBL(ctx, refreshReq{RefreshToken: lrsp.RefreshToken})
+	if refreshed.Code != CodeSessionIdle {
+		t.Errorf("The idle session should not be refreshed: %+v", refreshed)
+	}
+
+	var lr loginReq
+	lr.UniqueID = company.UniqueID
+	lr.Username = "superuser"
+	lr.Password = "@123ABC789"
+	lrsp = loginBL(ctx, lr)
+
+	jwt.ParseJWT(lrsp.SessionToken)
+	session, _ = model.FindJWTTokenBySignature(ctx, jwt.Signature)
+	session.Session.LastUsedAt = time.Now().Add(-20 * time.Minute).Unix()
+	model.SaveJWTToken(ctx, session)
+
+	rec := listOwn(lrsp.SessionToken)
+	var body sessionsResp
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusUnauthorized || body.Code != CodeSessionIdle {
+		t.Errorf("The idle session should be rejected: %d [%s]", rec.Code, rec.Body.String())
+	}
+
+	//The idle session was ended with its refresh tokens
+	if rec = listOwn(lrsp.SessionToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("The ended session was accepted: %d", rec.Code)
+	}
+	if refreshBL(ctx, refreshReq{RefreshToken: lrsp.RefreshToken}).Status != StatusFailure {
+		t.Errorf("The ended session can still be refreshed")
+	}
+}
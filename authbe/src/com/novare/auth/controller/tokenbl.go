@@ -0,0 +1,386 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"com/novare/auth/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+//The grant_type values of the token endpoint
+const (
+	//GrantTypeClientCredentials - The devices and services with a
+	//registered OAuthClient acting as a thing
+	GrantTypeClientCredentials = "client_credentials"
+	//GrantTypeAuthorizationCode - The apps the users logged in to on the
+	//authorize page
+	GrantTypeAuthorizationCode = "authorization_code"
+	//GrantTypeRefreshToken - The apps refreshing the session the
+	//authorization code started
+	GrantTypeRefreshToken = "refresh_token"
+)
+
+//The error codes of RFC 6749 section 5.2
+const (
+	oauthInvalidRequest         = "invalid_request"
+	oauthInvalidClient          = "invalid_client"
+	oauthInvalidGrant           = "invalid_grant"
+	oauthUnauthorizedClient     = "unauthorized_client"
+	oauthUnsupportedGrantType   = "unsupported_grant_type"
+	oauthInvalidScope           = "invalid_scope"
+	oauthServerError            = "server_error"
+	oauthTemporarilyUnavailable = "temporarily_unavailable"
+)
+
+//oauthError - The error of a token request as RFC 6749 has it, instead of
+//the status and code of the other routes
+type oauthError struct {
+	ErrorCode   string `json:"error,omitempty"`
+	Description string `json:"error_description,omitempty"`
+	status      int
+}
+
+//fail - Record the reason of the failure
+func (e *oauthError) fail(status int, code string, description string) {
+	e.status = status
+	e.ErrorCode = code
+	e.Description = description
+}
+
+//failErr - Record a model or database error
+func (e *oauthError) failErr(err error) {
+	switch {
+	case errors.Is(err, model.ErrInvalidClient):
+		e.fail(http.StatusUnauthorized, oauthInvalidClient, "The client is not registered or the secret is wrong")
+	case errors.Is(err, model.ErrInvalidScope):
+		e.fail(http.StatusBadRequest, oauthInvalidScope, "The scope is not allowed to the client")
+	case errors.Is(err, model.ErrInvalidGrant), errors.Is(err, errInvalidToken), errors.Is(err, errForbidden):
+		e.fail(http.StatusBadRequest, oauthInvalidGrant, "")
+	case errors.Is(err, model.ErrSessionIdle), errors.Is(err, model.ErrSessionLimit):
+		e.fail(http.StatusBadRequest, oauthInvalidGrant, err.Error())
+	case errors.Is(err, model.ErrUnavailable),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, context.Canceled):
+		e.fail(http.StatusServiceUnavailable, oauthTemporarilyUnavailable, "")
+	default:
+		e.fail(http.StatusInternalServerError, oauthServerError, "")
+	}
+}
+
+type tokenReq struct {
+	GrantType    string
+	ClientID     string
+	ClientSecret string //Empty for the public clients
+	Scope        string //Space separated, empty for every scope of the client
+	Audience     string //The service the token is for, the company UniqueID by default
+	Code         string //The authorization_code grant
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string //The refresh_token grant
+	IPAddress    string
+	UserAgent    string
+}
+
+//tokenResp - RFC 6749 section 5.1
+type tokenResp struct {
+	AccessToken  string `json:"access_token,omitempty"`
+	TokenType    string `json:"token_type,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"` //Only for the sessions of the authorization code grant
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"` //Only for the codes granted the openid scope
+	oauthError
+}
+
+/*
+tokenBL - Authenticate the client and hand the request to its grant. A
+public client is only known by its client_id, it can not use the
+client_credentials grant.
+*/
+func tokenBL(ctx context.Context, req tokenReq) *tokenResp {
+
+	var rsp tokenResp
+
+	switch req.GrantType {
+	case GrantTypeClientCredentials, GrantTypeAuthorizationCode, GrantTypeRefreshToken:
+	case "":
+		rsp.fail(http.StatusBadRequest, oauthInvalidRequest, "The grant_type is missing")
+		return &rsp
+	default:
+		log.Printf("The grant type [%s] is not supported", req.GrantType)
+		rsp.fail(http.StatusBadRequest, oauthUnsupportedGrantType, "")
+		return &rsp
+	}
+
+	if utf8.RuneCountInString(req.ClientID) == 0 {
+		rsp.fail(http.StatusUnauthorized, oauthInvalidClient, "The client credentials are missing")
+		return &rsp
+	}
+
+	client, err := model.AuthenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		rsp.failErr(err)
+		return &rsp
+	}
+
+	switch req.GrantType {
+	case GrantTypeAuthorizationCode:
+		return authorizationCodeBL(ctx, client, req, &rsp)
+	case GrantTypeRefreshToken:
+		return refreshTokenBL(ctx, client, req, &rsp)
+	}
+	return clientCredentialsBL(ctx, client, req, &rsp)
+}
+
+/*
+clientCredentialsBL - Issue an access token to a registered client. The
+token names the thing the client acts as in sub and the client in
+client_id, it carries the scopes granted and lives for the token
+duration of the client.
+*/
+func clientCredentialsBL(ctx context.Context, client *model.OAuthClient, req tokenReq, rsp *tokenResp) *tokenResp {
+
+	if utf8.RuneCountInString(client.UserID) == 0 {
+		log.Printf("The client [%s] does not act as a thing", client.ClientID())
+		rsp.fail(http.StatusBadRequest, oauthUnauthorizedClient, "The client has no thing to act as")
+		return rsp
+	}
+
+	user, err := model.FindUserByID(ctx, client.UserID)
+	if errors.Is(err, model.ErrNotFound) {
+		log.Printf("The thing [%s] of the client [%s] is gone", client.UserID, client.ClientID())
+		rsp.fail(http.StatusBadRequest, oauthUnauthorizedClient, "The thing of the client was removed")
+		return rsp
+	}
+	if err != nil {
+		rsp.failErr(err)
+		return rsp
+	}
+
+	if !user.IsThing || user.UserStatus == model.UserStateDisabled {
+		log.Printf("The thing [%s] of the client [%s] can not get tokens", user.Username, client.ClientID())
+		rsp.fail(http.StatusBadRequest, oauthUnauthorizedClient, "The thing of the client is disabled")
+		return rsp
+	}
+
+	company, err := model.FindCompanyByID(ctx, client.CompanyID)
+	if err != nil {
+		rsp.failErr(err)
+		return rsp
+	}
+
+	scope, err := client.GrantScope(ctx, user, req.Scope)
+	if err != nil {
+		rsp.failErr(err)
+		return rsp
+	}
+
+	accessToken := model.NewJWTToken(user.ID.Hex(), company.ID.Hex())
+	accessToken.Payload.ExpirationTime = time.Now().Add(client.GetTokenDuration()).Unix()
+	accessToken.Payload.Scope = scope
+	accessToken.Payload.Roles = user.Roles
+	accessToken.Payload.Company = company.UniqueID
+	accessToken.Payload.ClientID = client.ClientID()
+	accessToken.Payload.Audience = req.Audience
+	if utf8.RuneCountInString(req.Audience) == 0 {
+		accessToken.Payload.Audience = company.UniqueID
+	}
+
+	encoded, err := model.IssueJWT(ctx, accessToken, company)
+	if err != nil {
+		log.Printf("The access token of the client [%s] could not be issued: [%s]", client.ClientID(), err)
+		rsp.failErr(err)
+		return rsp
+	}
+
+	rsp.AccessToken = encoded
+	rsp.TokenType = "Bearer"
+	rsp.ExpiresIn = int64(client.GetTokenDuration() / time.Second)
+	rsp.Scope = scope
+	return rsp
+}
+
+/*
+authorizationCodeBL - Exchange the code of the authorize page for a
+session of the user, limited to the scope granted to the app. The access
+token is a session token, it is refreshed with the refresh_token grant.
+A code granted the openid scope also gets the ID token of the user, it
+lives as long as the session token.
+*/
+func authorizationCodeBL(ctx context.Context, client *model.OAuthClient, req tokenReq, rsp *tokenResp) *tokenResp {
+
+	if len(client.RedirectURIs) == 0 {
+		log.Printf("The client [%s] has no redirect URIs, it can not get codes", client.ClientID())
+		rsp.fail(http.StatusBadRequest, oauthUnauthorizedClient, "The client has no redirect URIs")
+		return rsp
+	}
+
+	if utf8.RuneCountInString(req.Code) == 0 || utf8.RuneCountInString(req.CodeVerifier) == 0 {
+		rsp.fail(http.StatusBadRequest, oauthInvalidRequest, "The code and the code_verifier are required")
+		return rsp
+	}
+
+	code, err := model.RedeemAuthorizationCode(ctx, req.Code, client.ClientID(), req.RedirectURI, req.CodeVerifier)
+	if err != nil {
+		rsp.failErr(err)
+		return rsp
+	}
+
+	user, err := model.FindUserByID(ctx, code.UserID)
+	if errors.Is(err, model.ErrNotFound) {
+		rsp.failErr(model.ErrInvalidGrant)
+		return rsp
+	}
+	if err != nil {
+		rsp.failErr(err)
+		return rsp
+	}
+
+	if user.UserStatus == model.UserStateDisabled {
+		log.Printf("The user [%s] was disabled since the code was issued", user.Username)
+		rsp.failErr(model.ErrInvalidGrant)
+		return rsp
+	}
+
+	company, err := model.FindCompanyByID(ctx, code.CompanyID)
+	if err != nil {
+		rsp.failErr(err)
+		return rsp
+	}
+
+	info := model.NewSessionInfo("", req.IPAddress, req.UserAgent)
+	err = model.AdmitSession(ctx, user, company, info)
+	if err != nil {
+		log.Printf("The session of the user [%s] could not be started: [%s]", user.Username, err)
+		rsp.failErr(err)
+		return rsp
+	}
+
+	refresh, refreshValue, err := model.CreateClientRefreshToken(ctx, user, company, client.ClientID(), code.Scope)
+	if err != nil {
+		rsp.failErr(err)
+		return rsp
+	}
+
+	//A second exchange of the code revokes what it started
+	err = model.BindAuthorizationCode(ctx, code, refresh.FamilyID)
+	if err != nil {
+		log.Printf("The code [%s] could not be bound to its session: [%s]", code.ID.Hex(), err)
+		model.RevokeTokenFamily(ctx, refresh.FamilyID)
+		rsp.failErr(err)
+		return rsp
+	}
+
+	var idToken string
+	if code.IsOpenID() {
+		idToken, err = model.IssueJWT(ctx, model.NewIDToken(user, company, code, company.Settings.GetSessionDuration()), company)
+		if err != nil {
+			log.Printf("The ID token of the user [%s] could not be issued: [%s]", user.Username, err)
+			model.RevokeTokenFamily(ctx, refresh.FamilyID)
+			rsp.failErr(err)
+			return rsp
+		}
+	}
+
+	var lrsp loginResp
+	issueSession(ctx, user, company, refresh, info, refreshValue, &lrsp)
+	rsp = sessionTokenResp(&lrsp, code.Scope, rsp)
+	if utf8.RuneCountInString(rsp.ErrorCode) == 0 {
+		rsp.IDToken = idToken
+	}
+	return rsp
+}
+
+/*
+refreshTokenBL - Refresh a session the authorization code grant started.
+Only the client it was issued to can refresh it, the new session keeps
+the scope.
+*/
+func refreshTokenBL(ctx context.Context, client *model.OAuthClient, req tokenReq, rsp *tokenResp) *tokenResp {
+
+	if utf8.RuneCountInString(req.RefreshToken) == 0 {
+		rsp.fail(http.StatusBadRequest, oauthInvalidRequest, "The refresh_token is required")
+		return rsp
+	}
+
+	stored, err := model.FindRefreshToken(ctx, req.RefreshToken)
+	if errors.Is(err, model.ErrNotFound) {
+		rsp.failErr(model.ErrInvalidGrant)
+		return rsp
+	}
+	if err != nil {
+		rsp.failErr(err)
+		return rsp
+	}
+
+	if stored.ClientID != client.ClientID() {
+		log.Printf("The refresh token was not issued to the client [%s]", client.ClientID())
+		rsp.failErr(model.ErrInvalidGrant)
+		return rsp
+	}
+
+	lrsp := refreshSession(ctx, req.RefreshToken)
+	return sessionTokenResp(lrsp, stored.Scope, rsp)
+}
+
+//sessionTokenResp - The token response of a session the login BLs started
+func sessionTokenResp(lrsp *loginResp, scope string, rsp *tokenResp) *tokenResp {
+	if lrsp.Status != StatusSuccess {
+		rsp.failErr(lrsp.failure())
+		return rsp
+	}
+
+	rsp.AccessToken = lrsp.SessionToken
+	rsp.TokenType = "Bearer"
+	rsp.ExpiresIn = lrsp.ExpiresIn
+	rsp.RefreshToken = lrsp.RefreshToken
+	rsp.Scope = scope
+	return rsp
+}
+
+//writeTokenResponse - The token responses must not be cached. A client
+//that failed the Basic authentication is asked for it again.
+func writeTokenResponse(w http.ResponseWriter, rsp *tokenResp, basicAuth bool) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	status := http.StatusOK
+	if rsp.status != 0 {
+		status = rsp.status
+	}
+	if status == http.StatusUnauthorized && basicAuth {
+		w.Header().Set("WWW-Authenticate", `Basic realm="edgeauth"`)
+	}
+
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rsp)
+}
@@ -0,0 +1,164 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"com/novare/auth/model"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+//postToken - A token request through the route
+func postToken(form url.Values, clientID string, secret string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if len(clientID) > 0 {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(secret))
+	}
+	rec := httptest.NewRecorder()
+	NewRouter().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestClientCredentials(t *testing.T) {
+	ctx := context.Background()
+
+	company, user, lrsp := loginTestCompany(t, "CLIENTCREDID")
+	if lrsp == nil {
+		return
+	}
+	defer performCompanyCleanup(company.ID.Hex(), t)
+
+	thing := model.NewUser()
+	thing.CompanyID = company.ID.Hex()
+	thing.Username = "THERMOSTAT"
+	thing.IsThing = true
+	thing.Permissions = []model.Permission{{Permission: "INTROSPECT_TOKEN"}}
+	err := model.InsertUser(ctx, thing)
+	if err != nil {
+		t.Errorf("The thing could not be inserted: [%s]", err)
+		return
+	}
+
+	//Only things get clients
+	crsp := insertClientBL(ctx, company.ID.Hex(), &clientObj{Username: "superuser", Scopes: []string{"INTROSPECT_TOKEN"}})
+	if crsp.Status != StatusFailure || crsp.Code != "NotAThing" {
+		t.Errorf("A person should not get a client: %+v", crsp)
+	}
+
+	//Through the route, with the session token of the superuser
+	body := strings.NewReader(`{"name":"Thermostat","username":"THERMOSTAT","scopes":["INTROSPECT_TOKEN","REVOKE_TOKEN"],"tokenDuration":120}`)
+	httpReq := httptest.NewRequest("PUT", "/oauth/client", body)
+	httpReq.Header.Set("Authorization", "bearer "+lrsp.SessionToken)
+	httpRec := httptest.NewRecorder()
+	NewRouter().ServeHTTP(httpRec, httpReq)
+
+	var created clientResp
+	json.Unmarshal(httpRec.Body.Bytes(), &created)
+	if httpRec.Code != http.StatusOK || created.Status != StatusSuccess || len(created.ClientSecret) == 0 {
+		t.Errorf("The client was not registered: %d [%s]", httpRec.Code, httpRec.Body.String())
+		return
+	}
+	clientID := created.Client.ID
+	secret := created.ClientSecret
+
+	//Basic authentication, the thing does not hold REVOKE_TOKEN
+	rec := postToken(url.Values{"grant_type": {GrantTypeClientCredentials}}, clientID, secret)
+	var trsp tokenResp
+	json.Unmarshal(rec.Body.Bytes(), &trsp)
+	if rec.Code != http.StatusOK || trsp.TokenType != "Bearer" || trsp.ExpiresIn != 120 || trsp.Scope != "INTROSPECT_TOKEN" {
+		t.Errorf("The token was not issued: %d [%s]", rec.Code, rec.Body.String())
+		return
+	}
+	if rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("The token response should not be cached")
+	}
+
+	irsp := introspectBL(ctx, user, trsp.AccessToken)
+	if !irsp.Active || irsp.ClientID != clientID || irsp.Subject != thing.ID.Hex() || irsp.Audience != "CLIENTCREDID" {
+		t.Errorf("The client token should be active: %+v", irsp)
+	}
+
+	//The credentials in the form
+	form := url.Values{"grant_type": {GrantTypeClientCredentials}, "client_id": {clientID}, "client_secret": {secret}, "scope": {"INTROSPECT_TOKEN"}, "audience": {"thermostats"}}
+	rec = postToken(form, "", "")
+	trsp = tokenResp{}
+	json.Unmarshal(rec.Body.Bytes(), &trsp)
+	if rec.Code != http.StatusOK || len(trsp.AccessToken) == 0 {
+		t.Errorf("The token was not issued with the form credentials: %d [%s]", rec.Code, rec.Body.String())
+	}
+
+	//Both ways at once
+	rec = postToken(form, clientID, secret)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), oauthInvalidRequest) {
+		t.Errorf("The credentials should only be sent once: %d [%s]", rec.Code, rec.Body.String())
+	}
+
+	rec = postToken(url.Values{"grant_type": {GrantTypeClientCredentials}}, clientID, "WRONG")
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), oauthInvalidClient) || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("A wrong secret should be an invalid client: %d [%s]", rec.Code, rec.Body.String())
+	}
+
+	rec = postToken(url.Values{"grant_type": {GrantTypeClientCredentials}, "scope": {"REVOKE_TOKEN"}}, clientID, secret)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), oauthInvalidScope) {
+		t.Errorf("A scope the thing does not hold should be invalid: %d [%s]", rec.Code, rec.Body.String())
+	}
+
+	rec = postToken(url.Values{"grant_type": {"password"}}, clientID, secret)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), oauthUnsupportedGrantType) {
+		t.Errorf("The password grant is not supported: %d [%s]", rec.Code, rec.Body.String())
+	}
+
+	//The rotated secret replaces the old one
+	crsp = rotateClientSecretBL(ctx, company.ID.Hex(), clientID)
+	if crsp.Status != StatusSuccess || crsp.ClientSecret == secret {
+		t.Errorf("The secret was not rotated: %+v", crsp)
+	}
+	rec = postToken(url.Values{"grant_type": {GrantTypeClientCredentials}}, clientID, secret)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("The old secret should not work: %d [%s]", rec.Code, rec.Body.String())
+	}
+
+	//Another company does not see the client
+	crsp = updateClientBL(ctx, "ANOTHERCOMPANY", clientID, anyRevision, &clientObj{Name: "Mine"})
+	if crsp.Status != StatusFailure || crsp.Code != CodeNotFound {
+		t.Errorf("Another company should not update the client: %+v", crsp)
+	}
+
+	lst := listClientsBL(ctx, company.ID.Hex())
+	if lst.Status != StatusSuccess || len(lst.Clients) != 1 || lst.Clients[0].Username != "THERMOSTAT" {
+		t.Errorf("The client should be listed: %+v", lst)
+	}
+
+	crsp = removeClientBL(ctx, company.ID.Hex(), clientID)
+	if crsp.Status != StatusSuccess {
+		t.Errorf("The client was not removed: %+v", crsp)
+	}
+}
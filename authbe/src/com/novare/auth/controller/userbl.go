@@ -27,12 +27,13 @@ SOFTWARE.
 import (
 	"com/novare/auth/model"
 	"com/novare/auth/sse"
+	"context"
 	"errors"
 	"log"
 	"strings"
 )
 
-func setUserInfo(req *usrObj, companyID string, usr *model.User) (*model.User, error) {
+func setUserInfo(ctx context.Context, req *usrObj, companyID string, usr *model.User) (*model.User, error) {
 
 	//We have the company ID
 	usr.CompanyID = companyID
@@ -43,10 +44,10 @@ func setUserInfo(req *usrObj, companyID string, usr *model.User) (*model.User, e
 	//Let's update the permissions
 	if len(req.Permissions) > 0 {
 		for i := range req.Permissions {
-			perm, err := model.FindPermissionByID(req.Permissions[i].ID.Hex())
+			perm, err := model.FindPermissionByID(ctx, req.Permissions[i].ID.Hex())
 			if err != nil {
-				log.Printf("The permission with ID:[%s] cannot be added to user:[%s]", req.Permissions[i], req.Username)
-				return nil, errors.New("InvalidPermission")
+				log.Printf("The permission with ID:[%s] cannot be added to user:[%s]", req.Permissions[i].ID.Hex(), req.Username)
+				return nil, model.NewInputError("InvalidPermission")
 			}
 
 			if perm.CompanyID != companyID {
@@ -54,7 +55,7 @@ func setUserInfo(req *usrObj, companyID string, usr *model.User) (*model.User, e
 				continue
 			}
 
-			usr.AddPermission(*perm)
+			usr.AddPermission(ctx, *perm)
 		}
 
 	}
@@ -69,10 +70,10 @@ func setUserInfo(req *usrObj, companyID string, usr *model.User) (*model.User, e
 
 	if len(req.Roles) > 0 {
 		for i := range req.Roles {
-			role, err := model.FindRoleByID(req.Roles[i])
+			role, err := model.FindRoleByID(ctx, req.Roles[i])
 			if err != nil {
 				log.Printf("The role with ID:[%s] cannot be added to the user [%s]", role.Description, req.Username)
-				return nil, errors.New("InvalidUser")
+				return nil, model.NewInputError("InvalidRole")
 			}
 
 			if role.CompanyID != companyID {
@@ -90,19 +91,21 @@ func setUserInfo(req *usrObj, companyID string, usr *model.User) (*model.User, e
 	return usr, nil
 }
 
-func insertUserBL(companyID string, req *usrObj) *usrResp {
+func insertUserBL(ctx context.Context, companyID string, req *usrObj) *usrResp {
 	var rsp usrResp
 	rsp.Status = StatusFailure
 
 	usr := model.NewUser()
-	usr, err := setUserInfo(req, companyID, usr)
+	usr, err := setUserInfo(ctx, req, companyID, usr)
 
 	if err != nil {
+		rsp.fail(err)
 		return &rsp
 	}
 
-	if model.IsUsernameDefined(usr.Username, companyID) {
+	if model.IsUsernameDefined(ctx, usr.Username, companyID) {
 		log.Printf("The username has already been defined for this company :[%s]", companyID)
+		rsp.fail(model.ErrDuplicate)
 		return &rsp
 	}
 
@@ -111,16 +114,25 @@ func insertUserBL(companyID string, req *usrObj) *usrResp {
 		err := usr.SetPassword(req.Password)
 		if err != nil {
 			log.Printf("The user password does not seem to be valid.")
+			rsp.fail(err)
 			return &rsp
 		}
 	} else {
 		log.Printf("The password and the confirmation passwords don't seem to match")
+		rsp.fail(model.NewInputError(StatusPasswordMismatch))
+		return &rsp
+	}
+
+	err = model.InsertUser(ctx, usr)
+	if errors.Is(err, model.ErrDuplicate) {
+		log.Printf("The username was defined by a concurrent request for this company :[%s]", companyID)
+		rsp.fail(err)
 		return &rsp
 	}
 
-	err = model.InsertUser(usr)
 	if err != nil {
 		log.Printf("There following error occurred when inserting a user: [%s]", err)
+		rsp.fail(err)
 		return &rsp
 	}
 
@@ -134,29 +146,41 @@ func insertUserBL(companyID string, req *usrObj) *usrResp {
 	return &rsp
 }
 
-func updateUserBL(username string, companyID string, req *usrObj) *usrResp {
+func updateUserBL(ctx context.Context, username string, companyID string, ifMatch int64, req *usrObj) *usrResp {
 	var rsp usrResp
 	rsp.Status = StatusFailure
 
-	usr, err := model.FindUserByUsernameCompanyID(username, companyID)
+	usr, err := model.FindUserByUsernameCompanyID(ctx, username, companyID)
 	if err != nil {
 		log.Printf("Failed to update the user: [%s]", err)
+		rsp.fail(err)
 		return &rsp
 	}
 
 	if usr.CompanyID != companyID {
 		log.Printf("There was an error updating the company ID")
+		//The entity belongs to another company
+		rsp.fail(model.ErrNotFound)
+		return &rsp
+	}
+
+	err = checkRevision(ifMatch, usr.Revision)
+	if err != nil {
+		log.Printf("The user [%s] is at revision %d, the update expected %d", username, usr.Revision, ifMatch)
+		rsp.fail(err)
 		return &rsp
 	}
 
-	usr, err = setUserInfo(req, companyID, usr)
+	usr, err = setUserInfo(ctx, req, companyID, usr)
 	if err != nil {
+		rsp.fail(err)
 		return &rsp
 	}
 
-	err = model.SaveUser(usr)
+	err = model.SaveUser(ctx, usr)
 	if err != nil {
 		log.Printf("Failed to save the permission with error:[%s]", err)
+		rsp.fail(err)
 		return &rsp
 	}
 
@@ -164,30 +188,35 @@ func updateUserBL(username string, companyID string, req *usrObj) *usrResp {
 	rsp.UserObj = *req
 	rsp.UserObj.Password = ""
 	rsp.UserObj.ConfirmPassword = ""
+	rsp.UserObj.Revision = usr.Revision
 
 	publishEvent(sse.EventUserUpdate, "Update")
 
 	return &rsp
 }
 
-func removeUserBL(username string, companyID string) *usrResp {
+func removeUserBL(ctx context.Context, username string, companyID string) *usrResp {
 	var rsp usrResp
 	rsp.Status = StatusFailure
 
-	usr, err := model.FindUserByUsernameCompanyID(username, companyID)
+	usr, err := model.FindUserByUsernameCompanyID(ctx, username, companyID)
 	if err != nil {
 		log.Printf("Failed to update the username: [%s]", err)
+		rsp.fail(err)
 		return &rsp
 	}
 
 	if usr.CompanyID != companyID {
 		log.Printf("There was an error updating the company ID")
+		//The entity belongs to another company
+		rsp.fail(model.ErrNotFound)
 		return &rsp
 	}
 
-	err = model.RemoveUserByID(usr.ID.Hex())
+	err = model.RemoveUserByID(ctx, usr.ID.Hex())
 	if err != nil {
 		log.Printf("Failed to save the permission with error:[%s]", err)
+		rsp.fail(err)
 		return &rsp
 	}
 
@@ -198,46 +227,40 @@ func removeUserBL(username string, companyID string) *usrResp {
 	return &rsp
 }
 
-//I have to come back here and maybe switch to passing the limits to the database.
-//That said, I am not expecting a lot of users, so this might be fast enough
-//for now.
-func listUsersBL(startAt int64, endAt int64, companyID string) listUserResp {
+//listUsersBL - The page [startAt, endAt) is read from the database together with
+//the number of users matching the query.
+func listUsersBL(ctx context.Context, startAt int64, endAt int64, companyID string, query model.UserQuery) listUserResp {
 	var users listUserResp
 	users.Status = StatusFailure
 
 	//Perform the index checks
 	if startAt < 0 {
 		log.Printf("The starting index is not valid, replying with empty array %d", startAt)
+		users.fail(model.NewInputError("InvalidStart"))
 		return users
 	}
 
 	if endAt < 0 {
 		log.Printf("The endAt is invalid: %d ", endAt)
+		users.fail(model.NewInputError("InvalidEnd"))
 		return users
 	}
 
 	if endAt <= startAt {
 		log.Printf("The endAt index %d is lower or equal to startAt %d", endAt, startAt)
+		users.fail(model.NewInputError("InvalidEnd"))
 		return users
 	}
 
-	usersModel, err := model.ListUsersByCompanyID(companyID)
+	query.Skip = startAt
+	query.Limit = endAt - startAt
+	usersModel, total, err := model.QueryUsersByCompanyID(ctx, companyID, query)
 	if err != nil {
 		log.Printf("It was not possible to retrieve the users from the database, error:[%s]", err)
+		users.fail(err)
 		return users
 	}
-
-	if endAt > int64(len(usersModel)) {
-		endAt = int64(len(usersModel))
-	}
-
-	//Lat check if there is no elements.
-	if startAt > endAt {
-		log.Printf("The value of startAt:[%d] is greater than endAt:[%d]", startAt, endAt)
-		return users
-	}
-
-	usersModel = usersModel[startAt:endAt]
+	users.Total = total
 
 	for i := range usersModel {
 		ur := usersModel[i]
@@ -253,6 +276,7 @@ func listUsersBL(startAt int64, endAt int64, companyID string) listUserResp {
 		rsp.Permissions = ur.Permissions
 		rsp.Roles = ur.Roles
 		rsp.ID = ur.ID.Hex()
+		rsp.Revision = ur.Revision
 		users.Users = append(users.Users, rsp)
 	}
 
@@ -260,19 +284,21 @@ func listUsersBL(startAt int64, endAt int64, companyID string) listUserResp {
 	return users
 }
 
-func updatePasswordBL(user *model.User, pass *passReq) *passResp {
+func updatePasswordBL(ctx context.Context, user *model.User, pass *passReq) *passResp {
 	rsp := new(passResp)
 	rsp.Status = StatusFailure
 
 	//Check if the user is the same user
 	if user.Username != pass.Username && user.Username != "superuser" {
 		log.Printf("The user is not trying to update itself. The user is trying to update somebody's else account but, it is not logged in as superuser")
+		rsp.fail(errForbidden)
 		return rsp
 	}
 
-	changeUser, err := model.FindUserByUsernameCompanyID(pass.Username, user.CompanyID)
+	changeUser, err := model.FindUserByUsernameCompanyID(ctx, pass.Username, user.CompanyID)
 	if err != nil {
 		log.Printf("There is an error retrieving the user: %s", pass.Username)
+		rsp.fail(err)
 		return rsp
 	}
 
@@ -280,6 +306,7 @@ func updatePasswordBL(user *model.User, pass *passReq) *passResp {
 		//Check if the password match
 		if !changeUser.IsPasswordMatch(pass.CurrentPassword) {
 			log.Printf("The user did not enter a valid password!")
+			rsp.fail(errInvalidCredentials)
 			return rsp
 		}
 	} else {
@@ -289,6 +316,7 @@ func updatePasswordBL(user *model.User, pass *passReq) *passResp {
 	//Check if the password entered and confirmed are the same
 	if pass.NewPassword != pass.ConfirmPassword {
 		log.Printf("The password entered and the confirmation password do not match")
+		rsp.fail(model.NewInputError(StatusPasswordMismatch))
 		return rsp
 	}
 
@@ -296,13 +324,15 @@ func updatePasswordBL(user *model.User, pass *passReq) *passResp {
 	err = changeUser.SetPassword(pass.NewPassword)
 	if err != nil {
 		log.Printf("There was an error setting the new password:ERR: [%s]", err)
+		rsp.fail(err)
 		return rsp
 	}
 
 	//Save the user
-	err = model.SaveUser(changeUser)
+	err = model.SaveUser(ctx, changeUser)
 	if err != nil {
 		log.Printf("Updating the user password failedw ith ERR:[%s]", err)
+		rsp.fail(err)
 		return rsp
 	}
 
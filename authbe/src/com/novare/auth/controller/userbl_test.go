@@ -26,15 +26,17 @@ package controller
 
 import (
 	"com/novare/auth/model"
+	"context"
 	"testing"
 
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestUserUpdates(t *testing.T) {
+	ctx := context.Background()
 
 	var usr usrObj
-	usr.ID = bson.NewObjectId().Hex()
+	usr.ID = primitive.NewObjectID().Hex()
 	usr.ConfirmPassword = "@A123456"
 	usr.Password = "@A123456"
 	usr.IsThing = "false"
@@ -44,7 +46,7 @@ func TestUserUpdates(t *testing.T) {
 	perm.CompanyID = "MyCOMPANY"
 	perm.Description = "MY DESCRIPTION"
 	perm.Permission = "PERMISSION_DENIED"
-	model.InsertPermission(perm)
+	model.InsertPermission(ctx, perm)
 
 	usr.Permissions = append(usr.Permissions, *perm)
 
@@ -52,31 +54,31 @@ func TestUserUpdates(t *testing.T) {
 	role.CompanyID = "MyCOMPANY"
 	role.Description = "MyROLE"
 	role.AddPermission(*perm)
-	model.InsertRole(role)
+	model.InsertRole(ctx, role)
 
 	usr.Roles = append(usr.Roles, role.ID.Hex())
 
-	usrResp := insertUserBL("MyCOMPANY", &usr)
+	usrResp := insertUserBL(ctx, "MyCOMPANY", &usr)
 	if usrResp.Status != StatusSuccess {
 		t.Errorf("There was an error inserting the user into the database")
 	}
 
 	//Let's try to retrieve the user and update it now
-	modelUser, err := model.FindUserByUsernameCompanyID(usr.Username, "MyCOMPANY")
+	modelUser, err := model.FindUserByUsernameCompanyID(ctx, usr.Username, "MyCOMPANY")
 	if err != nil {
 		t.Errorf("There was an error retrieving the user: {%s}", err)
 	}
 
 	usr.Name = "Godzilla"
 
-	usrResp = updateUserBL("BobTheBuilder", "MyCOMPANY", &usr)
+	usrResp = updateUserBL(ctx, "BobTheBuilder", "MyCOMPANY", anyRevision, &usr)
 	if usrResp.Status != StatusSuccess {
 		t.Errorf("An error occurred updating teh User: [%s] - Username:[%s]", usrResp.Status, modelUser.Username)
 	}
 
-	removeUserBL("BobTheBuilder", "MyCOMPANY")
+	removeUserBL(ctx, "BobTheBuilder", "MyCOMPANY")
 
-	model.RemoveRoleByID(role.ID.Hex())
-	model.RemovePermissionByID(perm.ID.Hex())
+	model.RemoveRoleByID(ctx, role.ID.Hex())
+	model.RemovePermissionByID(ctx, perm.ID.Hex())
 
 }
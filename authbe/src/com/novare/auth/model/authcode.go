@@ -0,0 +1,213 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"com/novare/utils"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//AuthorizationCodeDuration - Seconds an authorization code can be
+//exchanged for, the app does it right after the redirect
+const AuthorizationCodeDuration int64 = 60
+
+//CodeChallengeS256 - The only code_challenge_method accepted, plain is not
+const CodeChallengeS256 = "S256"
+
+//ErrInvalidGrant - The authorization code is unknown, used, expired or was
+//issued to another client, redirect URI or code verifier
+var ErrInvalidGrant = errors.New("InvalidGrant")
+
+/*
+AuthorizationCode - The proof that a user logged in on the edgeauth page
+for an OAuthClient. It is exchanged once, by the same client with the
+same redirect URI and the PKCE code verifier of its CodeChallenge (RFC
+7636). Only the hash of the code is stored.
+*/
+type AuthorizationCode struct {
+	ID            primitive.ObjectID `bson:"_id" json:"id"`
+	CodeHash      string             `json:"-"`
+	CompanyID     string             `json:"companyID"`
+	UserID        string             `json:"userID"`
+	ClientID      string             `json:"clientID"`
+	RedirectURI   string             `json:"redirectURI"`
+	Scope         string             `json:"scope"`         //The permissions granted, separated by spaces
+	CodeChallenge string             `json:"codeChallenge"` //The S256 challenge of the code verifier
+	Nonce         string             `json:"nonce"`         //Passed on to the ID token
+	CreatedAt     int64              `json:"createdAt"`
+	ExpiresAt     int64              `json:"expiresAt"`
+	UsedAt        int64              `json:"usedAt"`   //0 until it is exchanged
+	FamilyID      string             `json:"familyID"` //The refresh token family it was exchanged for
+	Revision      int64              `json:"revision"`
+}
+
+func hashAuthorizationCode(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+//S256Challenge - The code_challenge of the code verifier
+func S256Challenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+//IsValidCodeChallenge - An S256 challenge is the unpadded base64url of a
+//SHA-256 hash
+func IsValidCodeChallenge(challenge string) bool {
+	raw, err := base64.RawURLEncoding.DecodeString(challenge)
+	return err == nil && len(raw) == sha256.Size
+}
+
+//isValidCodeVerifier - 43 to 128 of the unreserved characters of RFC 7636
+func isValidCodeVerifier(verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, c := range verifier {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.ContainsRune("-._~", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+CreateAuthorizationCode - A code for the user logged in for the client.
+The value is what the redirect carries, it is not stored. The nonce is
+empty when the app did not pass one.
+*/
+func CreateAuthorizationCode(ctx context.Context, client *OAuthClient, user *User, redirectURI string, scope string, challenge string, nonce string) (string, error) {
+
+	if !IsValidCodeChallenge(challenge) {
+		return "", NewInputError("InvalidCodeChallenge")
+	}
+
+	if utf8.RuneCountInString(nonce) > MaxNonceLength {
+		return "", NewInputError("InvalidNonce")
+	}
+
+	value := strings.TrimRight(utils.GenerateUniqueID(), "=")
+	if utf8.RuneCountInString(value) == 0 {
+		return "", errors.New("NoRandomCode")
+	}
+
+	code := new(AuthorizationCode)
+	code.ID = primitive.NewObjectID()
+	code.CodeHash = hashAuthorizationCode(value)
+	code.CompanyID = client.CompanyID
+	code.UserID = user.ID.Hex()
+	code.ClientID = client.ClientID()
+	code.RedirectURI = redirectURI
+	code.Scope = scope
+	code.CodeChallenge = challenge
+	code.Nonce = nonce
+	code.CreatedAt = time.Now().Unix()
+	code.ExpiresAt = code.CreatedAt + AuthorizationCodeDuration
+
+	err := mStore.AuthorizationCodes().Insert(ctx, code, code.ID.Hex())
+	if err != nil {
+		log.Printf("The authorization code could not be saved: [%s]", err)
+		return "", err
+	}
+
+	return value, nil
+}
+
+/*
+RedeemAuthorizationCode - Exchange the code of the client. The code is
+used up before it is checked, so a failed exchange can not be retried. A
+code presented twice means it leaked: the sessions it started are revoked
+and ErrInvalidGrant returned.
+*/
+func RedeemAuthorizationCode(ctx context.Context, value string, clientID string, redirectURI string, verifier string) (*AuthorizationCode, error) {
+
+	code := new(AuthorizationCode)
+	err := mStore.AuthorizationCodes().FindByCodeHash(ctx, code, hashAuthorizationCode(value))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if code.UsedAt != 0 {
+		log.Printf("The authorization code [%s] was used again, revoking the family [%s]", code.ID.Hex(), code.FamilyID)
+		err = RevokeTokenFamily(ctx, code.FamilyID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidGrant
+	}
+
+	code.UsedAt = time.Now().Unix()
+	err = saveRevision(&code.Revision, func(expected int64) error {
+		return mStore.AuthorizationCodes().SaveRevision(ctx, code, code.ID.Hex(), expected)
+	})
+	if errors.Is(err, ErrConflict) {
+		log.Printf("The authorization code [%s] was used concurrently", code.ID.Hex())
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if code.UsedAt >= code.ExpiresAt {
+		log.Printf("The authorization code [%s] expired", code.ID.Hex())
+		return nil, ErrInvalidGrant
+	}
+
+	if code.ClientID != clientID || code.RedirectURI != redirectURI {
+		log.Printf("The authorization code [%s] was issued to another client or redirect URI", code.ID.Hex())
+		return nil, ErrInvalidGrant
+	}
+
+	challenge := S256Challenge(verifier)
+	if !isValidCodeVerifier(verifier) || subtle.ConstantTimeCompare([]byte(challenge), []byte(code.CodeChallenge)) != 1 {
+		log.Printf("The code verifier of the authorization code [%s] does not match", code.ID.Hex())
+		return nil, ErrInvalidGrant
+	}
+
+	return code, nil
+}
+
+//BindAuthorizationCode - Remember the refresh token family the code
+//started, a second exchange of the code revokes it
+func BindAuthorizationCode(ctx context.Context, code *AuthorizationCode, familyID string) error {
+	code.FamilyID = familyID
+	return saveRevision(&code.Revision, func(expected int64) error {
+		return mStore.AuthorizationCodes().SaveRevision(ctx, code, code.ID.Hex(), expected)
+	})
+}
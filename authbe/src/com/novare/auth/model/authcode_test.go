@@ -0,0 +1,130 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestAuthorizationCode(t *testing.T) {
+	ctx := context.Background()
+
+	company := NewCompany()
+	user := NewUser()
+	client := NewOAuthClient(company.ID.Hex(), "")
+	client.RedirectURIs = []string{"com.novare.authfe:/callback"}
+	client.Public = true
+
+	verifier := strings.Repeat("v", 43)
+	challenge := S256Challenge(verifier)
+
+	_, err := CreateAuthorizationCode(ctx, client, user, "com.novare.authfe:/callback", "GET_USER", "plain", "")
+	if err == nil {
+		t.Errorf("A code challenge that is not S256 should be rejected")
+	}
+
+	value, err := CreateAuthorizationCode(ctx, client, user, "com.novare.authfe:/callback", "GET_USER", challenge, "")
+	if err != nil {
+		t.Errorf("The authorization code could not be created: [%s]", err)
+		return
+	}
+
+	_, err = RedeemAuthorizationCode(ctx, value+"X", client.ClientID(), "com.novare.authfe:/callback", verifier)
+	if err != ErrInvalidGrant {
+		t.Errorf("An unknown code should be an invalid grant: [%v]", err)
+	}
+
+	code, err := RedeemAuthorizationCode(ctx, value, client.ClientID(), "com.novare.authfe:/callback", verifier)
+	if err != nil || code.UserID != user.ID.Hex() || code.Scope != "GET_USER" {
+		t.Errorf("The code was not redeemed: [%v]", err)
+		return
+	}
+
+	//The second exchange revokes the sessions of the first
+	session := startTestSession(t, user, company, "ADMIN", 1)
+	err = BindAuthorizationCode(ctx, code, session.FamilyID)
+	if err != nil {
+		t.Errorf("The code was not bound to the family: [%s]", err)
+	}
+
+	_, err = RedeemAuthorizationCode(ctx, value, client.ClientID(), "com.novare.authfe:/callback", verifier)
+	if err != ErrInvalidGrant {
+		t.Errorf("A used code should be an invalid grant: [%v]", err)
+	}
+	revoked, _ := IsTokenRevoked(ctx, session.Payload.ID)
+	if !revoked {
+		t.Errorf("The session of a reused code should be revoked")
+	}
+
+	//A wrong verifier or redirect URI uses the code up
+	for _, c := range []struct{ redirectURI, verifier string }{
+		{"com.novare.authfe:/callback", strings.Repeat("w", 43)},
+		{"com.novare.authfe:/other", verifier},
+		{"com.novare.authfe:/callback", "short"},
+	} {
+		value, _ = CreateAuthorizationCode(ctx, client, user, "com.novare.authfe:/callback", "GET_USER", challenge, "")
+		_, err = RedeemAuthorizationCode(ctx, value, client.ClientID(), c.redirectURI, c.verifier)
+		if err != ErrInvalidGrant {
+			t.Errorf("The code should not be redeemed with %+v: [%v]", c, err)
+		}
+		_, err = RedeemAuthorizationCode(ctx, value, client.ClientID(), "com.novare.authfe:/callback", verifier)
+		if err != ErrInvalidGrant {
+			t.Errorf("The code should be used up by the failed exchange: [%v]", err)
+		}
+	}
+}
+
+func TestOAuthClientRedirectURIs(t *testing.T) {
+	for uri, valid := range map[string]bool{
+		"https://backoffice.example.com/callback": true,
+		"com.novare.authfe:/callback":             true,
+		"http://127.0.0.1:8123/callback":          true,
+		"http://backoffice.example.com/callback":  false,
+		"https://backoffice.example.com/cb#frag":  false,
+		"/callback":                               false,
+		"javascript:alert(1)":                     false,
+	} {
+		if isValidRedirectURI(uri) != valid {
+			t.Errorf("The redirect URI [%s] should be valid: %v", uri, valid)
+		}
+	}
+}
+
+func TestUserScope(t *testing.T) {
+	ctx := context.Background()
+
+	user := NewUser()
+	user.Username = "superuser"
+	if !user.IsGranted(ctx, "REMOVE_USER") {
+		t.Errorf("The superuser should be granted every permission")
+	}
+
+	user.Scope = []string{"GET_USER"}
+	if user.IsGranted(ctx, "REMOVE_USER") || user.IsGranted(ctx, "UPDATE_PASSWORD") || !user.IsGranted(ctx, "GET_USER") {
+		t.Errorf("The session scope should limit the permissions of the superuser")
+	}
+}
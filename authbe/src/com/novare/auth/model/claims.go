@@ -0,0 +1,232 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//The reasons a ClaimsError gives
+const (
+	ClaimsMissingExpiration   string = "MissingExpiration"
+	ClaimsTokenExpired        string = "TokenExpired"
+	ClaimsTokenNotYetValid    string = "TokenNotYetValid"
+	ClaimsTokenIssuedInFuture string = "TokenIssuedInFuture"
+	ClaimsMissingIssuedAt     string = "MissingIssuedAt"
+	ClaimsTokenTooOld         string = "TokenTooOld"
+	ClaimsInvalidIssuer       string = "InvalidIssuer"
+	ClaimsInvalidAudience     string = "InvalidAudience"
+	ClaimsInsufficientScope   string = "InsufficientScope"
+	ClaimsInvalidNonce        string = "InvalidNonce"
+	ClaimsInvalidTokenUse     string = "InvalidTokenUse"
+)
+
+//ErrInvalidClaims - Every ClaimsError matches it
+var ErrInvalidClaims = errors.New("InvalidClaims")
+
+//ClaimsError - A registered claim of the token is not acceptable. Reason
+//is one of the Claims* reasons, e.g. ClaimsTokenExpired.
+type ClaimsError struct {
+	Reason string
+}
+
+func (e *ClaimsError) Error() string {
+	return e.Reason
+}
+
+//Unwrap - errors.Is(err, ErrInvalidClaims) holds for every ClaimsError
+func (e *ClaimsError) Unwrap() error {
+	return ErrInvalidClaims
+}
+
+func claimsError(reason string) error {
+	return &ClaimsError{Reason: reason}
+}
+
+//IsClaimsReason - The err is a ClaimsError for the reason
+func IsClaimsReason(err error, reason string) bool {
+	var claimsErr *ClaimsError
+	return errors.As(err, &claimsErr) && claimsErr.Reason == reason
+}
+
+//DefaultTokenIssuer - The iss of the tokens when no issuer is configured
+const DefaultTokenIssuer string = "AUTHBEE"
+
+//TokenIssuer - The iss of every token the edgeauth issues
+var TokenIssuer = DefaultTokenIssuer
+
+//LegacyIssuers - The iss of the tokens issued before the TokenIssuer was
+//changed. They are accepted with it so the sessions and access tokens
+//already out keep working until they expire.
+var LegacyIssuers []string
+
+//ClockLeeway - How far the clocks of the edge devices may drift from the
+//edgeauth. The times in the tokens are accepted that much off.
+var ClockLeeway = time.Minute
+
+//AudienceRule - What the tokens for one audience must also satisfy
+type AudienceRule struct {
+	MaxAge time.Duration //How old the token can be by its iat, 0 = no limit
+	Scope  string        //A permission the scope must hold, empty = any
+}
+
+/*
+ClaimsPolicy - The registered claims a token must have. The token_use
+must be the TokenUse, the times are checked with the Leeway, the iss
+against the Issuers and, when there are Audiences, the aud must be one of
+them and satisfy its rule.
+*/
+type ClaimsPolicy struct {
+	Leeway            time.Duration
+	RequireExpiration bool                    //A token without exp is rejected
+	MaxAge            time.Duration           //How old any token can be by its iat, 0 = no limit
+	Issuers           []string                //Empty accepts any iss
+	Audiences         map[string]AudienceRule //Empty does not check the aud
+	TokenUse          string                  //TokenUseID for the ID tokens, empty rejects them
+}
+
+/*
+NewClaimsPolicy - The policy for the tokens of this edgeauth: issued by the
+TokenIssuer or one of the LegacyIssuers, with the ClockLeeway. The
+audiences accepted, if any, have no rule of their own.
+*/
+func NewClaimsPolicy(audiences ...string) *ClaimsPolicy {
+	policy := new(ClaimsPolicy)
+	policy.Leeway = ClockLeeway
+	policy.Issuers = append([]string{TokenIssuer}, LegacyIssuers...)
+	for _, aud := range audiences {
+		policy.ExpectAudience(aud, AudienceRule{})
+	}
+	return policy
+}
+
+//SessionClaimsPolicy - The policy of the session tokens, they always
+//expire
+func SessionClaimsPolicy() *ClaimsPolicy {
+	policy := NewClaimsPolicy()
+	policy.RequireExpiration = true
+	return policy
+}
+
+//ExpectAudience - Accept the tokens for aud that satisfy the rule
+func (policy *ClaimsPolicy) ExpectAudience(aud string, rule AudienceRule) {
+	if policy.Audiences == nil {
+		policy.Audiences = make(map[string]AudienceRule)
+	}
+	policy.Audiences[aud] = rule
+}
+
+//checkAge - The token is not older than maxAge by its iat
+func (policy *ClaimsPolicy) checkAge(payload *JWTPayload, maxAge time.Duration, now int64) error {
+	if maxAge <= 0 {
+		return nil
+	}
+	if payload.IssuedAt == 0 {
+		return claimsError(ClaimsMissingIssuedAt)
+	}
+	if now-payload.IssuedAt > int64((maxAge+policy.Leeway)/time.Second) {
+		return claimsError(ClaimsTokenTooOld)
+	}
+	return nil
+}
+
+//hasScope - The space separated scope holds the permission
+func hasScope(scope string, permission string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == permission {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Validate - Check the registered claims of the payload at the time now. The
+first claim that fails is returned as a ClaimsError, the token_use first,
+then the times, the iss and the aud. An exp or nbf of 0 is not set.
+*/
+func (policy *ClaimsPolicy) Validate(payload *JWTPayload, now time.Time) error {
+
+	if payload.TokenUse != policy.TokenUse {
+		return claimsError(ClaimsInvalidTokenUse)
+	}
+
+	t := now.Unix()
+	leeway := int64(policy.Leeway / time.Second)
+
+	if payload.ExpirationTime == 0 {
+		if policy.RequireExpiration {
+			return claimsError(ClaimsMissingExpiration)
+		}
+	} else if t > payload.ExpirationTime+leeway {
+		return claimsError(ClaimsTokenExpired)
+	}
+
+	if payload.NotBefore != 0 && t+leeway < payload.NotBefore {
+		return claimsError(ClaimsTokenNotYetValid)
+	}
+
+	if payload.IssuedAt > t+leeway {
+		return claimsError(ClaimsTokenIssuedInFuture)
+	}
+
+	err := policy.checkAge(payload, policy.MaxAge, t)
+	if err != nil {
+		return err
+	}
+
+	if len(policy.Issuers) > 0 {
+		found := false
+		for _, iss := range policy.Issuers {
+			found = found || iss == payload.Issuer
+		}
+		if !found {
+			return claimsError(ClaimsInvalidIssuer)
+		}
+	}
+
+	if len(policy.Audiences) == 0 {
+		return nil
+	}
+
+	rule, ok := policy.Audiences[payload.Audience]
+	if !ok || utf8.RuneCountInString(payload.Audience) == 0 {
+		return claimsError(ClaimsInvalidAudience)
+	}
+
+	err = policy.checkAge(payload, rule.MaxAge, t)
+	if err != nil {
+		return err
+	}
+
+	if utf8.RuneCountInString(rule.Scope) > 0 && !hasScope(payload.Scope, rule.Scope) {
+		return claimsError(ClaimsInsufficientScope)
+	}
+
+	return nil
+}
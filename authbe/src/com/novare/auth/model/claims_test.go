@@ -0,0 +1,115 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClaimsPolicy(t *testing.T) {
+	now := time.Now()
+	minutes := func(m int) int64 {
+		return now.Add(time.Duration(m) * time.Minute).Unix()
+	}
+
+	policy := NewClaimsPolicy()
+	policy.Leeway = time.Minute
+	policy.RequireExpiration = true
+	policy.MaxAge = time.Hour
+	policy.ExpectAudience("pos-lane", AudienceRule{Scope: "OPEN_DRAWER"})
+	policy.ExpectAudience("back-office", AudienceRule{MaxAge: 10 * time.Minute})
+
+	valid := JWTPayload{Issuer: TokenIssuer, Audience: "back-office", IssuedAt: minutes(-5), ExpirationTime: minutes(5)}
+
+	tests := []struct {
+		name   string
+		change func(p *JWTPayload)
+		reason string
+	}{
+		{"valid", func(p *JWTPayload) {}, ""},
+		{"missing exp", func(p *JWTPayload) { p.ExpirationTime = 0 }, ClaimsMissingExpiration},
+		{"expired", func(p *JWTPayload) { p.ExpirationTime = minutes(-2) }, ClaimsTokenExpired},
+		{"expired within the leeway", func(p *JWTPayload) { p.ExpirationTime = now.Unix() - 30 }, ""},
+		{"not yet valid", func(p *JWTPayload) { p.NotBefore = minutes(2) }, ClaimsTokenNotYetValid},
+		{"nbf within the leeway", func(p *JWTPayload) { p.NotBefore = now.Unix() + 30 }, ""},
+		{"issued in the future", func(p *JWTPayload) { p.IssuedAt = minutes(2) }, ClaimsTokenIssuedInFuture},
+		{"too old for the policy", func(p *JWTPayload) { p.Audience = "pos-lane"; p.Scope = "OPEN_DRAWER"; p.IssuedAt = minutes(-90) }, ClaimsTokenTooOld},
+		{"id token", func(p *JWTPayload) { p.TokenUse = TokenUseID }, ClaimsInvalidTokenUse},
+		{"too old for the audience", func(p *JWTPayload) { p.IssuedAt = minutes(-20) }, ClaimsTokenTooOld},
+		{"missing iat", func(p *JWTPayload) { p.IssuedAt = 0 }, ClaimsMissingIssuedAt},
+		{"issuer", func(p *JWTPayload) { p.Issuer = "SOMEONE" }, ClaimsInvalidIssuer},
+		{"unknown audience", func(p *JWTPayload) { p.Audience = "kitchen" }, ClaimsInvalidAudience},
+		{"missing audience", func(p *JWTPayload) { p.Audience = "" }, ClaimsInvalidAudience},
+		{"scope", func(p *JWTPayload) { p.Audience = "pos-lane"; p.Scope = "VOID_ITEM" }, ClaimsInsufficientScope},
+		{"scope of many", func(p *JWTPayload) { p.Audience = "pos-lane"; p.Scope = "VOID_ITEM OPEN_DRAWER" }, ""},
+	}
+
+	for _, test := range tests {
+		payload := valid
+		test.change(&payload)
+		err := policy.Validate(&payload, now)
+		if test.reason == "" {
+			if err != nil {
+				t.Errorf("%s: the claims should be valid: [%s]", test.name, err)
+			}
+			continue
+		}
+		if !IsClaimsReason(err, test.reason) || !errors.Is(err, ErrInvalidClaims) {
+			t.Errorf("%s: the reason should be %s: [%v]", test.name, test.reason, err)
+		}
+	}
+
+	//Without audiences and RequireExpiration, exp 0 never expires and any
+	//aud is accepted
+	payload := JWTPayload{Issuer: TokenIssuer, Audience: "anything", IssuedAt: now.Unix()}
+	err := NewClaimsPolicy().Validate(&payload, now)
+	if err != nil {
+		t.Errorf("The access token claims should be valid: [%s]", err)
+	}
+}
+
+func TestClaimsPolicyLegacyIssuers(t *testing.T) {
+	defer func() {
+		TokenIssuer = DefaultTokenIssuer
+		LegacyIssuers = nil
+	}()
+
+	now := time.Now()
+	legacy := JWTPayload{Issuer: DefaultTokenIssuer, IssuedAt: now.Unix()}
+	current := JWTPayload{Issuer: "https://auth.example.com", IssuedAt: now.Unix()}
+
+	TokenIssuer = "https://auth.example.com"
+	if !IsClaimsReason(NewClaimsPolicy().Validate(&legacy, now), ClaimsInvalidIssuer) {
+		t.Errorf("The legacy issuer should only be accepted when it is listed")
+	}
+
+	LegacyIssuers = []string{DefaultTokenIssuer}
+	policy := NewClaimsPolicy()
+	if policy.Validate(&legacy, now) != nil || policy.Validate(&current, now) != nil {
+		t.Errorf("Both the legacy and the current issuer should be accepted: %v", policy.Issuers)
+	}
+}
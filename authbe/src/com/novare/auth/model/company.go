@@ -25,18 +25,15 @@ SOFTWARE.
 package model
 
 import (
-	"com/novare/dbs"
-	"errors"
+	"context"
 	"log"
 	"math/rand"
 	"time"
 	"unicode/utf8"
 
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-var mDBCompany = dbs.NewMongoDB(AuthRelayDatabaseName, "Companies")
-
 //This constant define the valid password duration unit
 const (
 	//PassUnitDay - This comment is to avoid warnings from the IDE.
@@ -57,6 +54,75 @@ type CompanySettings struct {
 	JWTDuration    int64  `json:"jwtDuration"`    //The number of minutes a JWT token should be granted 0 = Never expires
 	PassExpiration int64  `json:"passExpiration"` //Password expiration... 0 means no expiration
 	PassUnit       string `json:"passUnit"`       //Year, Month, Week, Days
+
+	SigningKey       string `json:"signingKey"`       //SigningKeySite (the default) or SigningKeyCompany
+	SigningAlgorithm string `json:"signingAlgorithm"` //AlgRS256 or AlgES256, empty uses the SigningAlgorithm
+
+	SessionDuration int64 `json:"sessionDuration"` //Minutes a session token lives, 0 = DefaultSessionDuration
+	RefreshDuration int64 `json:"refreshDuration"` //Minutes a login can be refreshed for, 0 = DefaultRefreshDuration
+
+	MaxSessions  int64  `json:"maxSessions"`  //Concurrent sessions of a user, 0 = no limit
+	SessionLimit string `json:"sessionLimit"` //SessionLimitEvictOldest (the default) or SessionLimitReject
+	IdleTimeout  int64  `json:"idleTimeout"`  //Minutes a session can go unused before it ends, 0 = no idle timeout
+}
+
+//GetSessionDuration - How long the session tokens live
+func (settings *CompanySettings) GetSessionDuration() time.Duration {
+	if settings.SessionDuration <= 0 {
+		return time.Duration(DefaultSessionDuration) * time.Minute
+	}
+	return time.Duration(settings.SessionDuration) * time.Minute
+}
+
+//GetRefreshDuration - How long after the login the refresh tokens can be
+//used, the rotation does not extend it
+func (settings *CompanySettings) GetRefreshDuration() time.Duration {
+	if settings.RefreshDuration <= 0 {
+		return time.Duration(DefaultRefreshDuration) * time.Minute
+	}
+	return time.Duration(settings.RefreshDuration) * time.Minute
+}
+
+//GetIdleTimeout - How long a session can go unused, 0 when it does not
+//time out
+func (settings *CompanySettings) GetIdleTimeout() time.Duration {
+	if settings.IdleTimeout <= 0 {
+		return 0
+	}
+	return time.Duration(settings.IdleTimeout) * time.Minute
+}
+
+//validate - The signing settings must name a known key scope and
+//algorithm, the session limit a known policy
+func (settings *CompanySettings) validate() error {
+
+	switch settings.SigningKey {
+	case "", SigningKeySite, SigningKeyCompany:
+	default:
+		return NewInputError("InvalidSigningKey")
+	}
+
+	switch settings.SigningAlgorithm {
+	case "", AlgRS256, AlgES256:
+	default:
+		return NewInputError("InvalidSigningAlgorithm")
+	}
+
+	switch settings.SessionLimit {
+	case "", SessionLimitEvictOldest, SessionLimitReject:
+	default:
+		return NewInputError("InvalidSessionLimit")
+	}
+
+	if settings.MaxSessions < 0 {
+		return NewInputError("InvalidMaxSessions")
+	}
+
+	if settings.IdleTimeout < 0 {
+		return NewInputError("InvalidIdleTimeout")
+	}
+
+	return nil
 }
 
 //SetPasswordPolicy - It sets a policy on when the password should expire
@@ -83,23 +149,24 @@ Company -
 Every account starts with a company. Sites can have one or more accounts.
 */
 type Company struct {
-	ID              bson.ObjectId   `bson:"_id" json:"id"`
-	Name            string          `json:"name"`
-	Address1        string          `json:"address1"`
-	Address2        string          `json:"address2"`
-	City            string          `json:"city"`
-	State           string          `json:"state"`
-	Zip             string          `json:"zip"`
-	IsInLocation    bool            `json:"isInLocation"`    //Specifies if a company is also a location. Used with the
-	RemotelyManaged bool            `json:"remotelyManaged"` //Is this Auth system managed remotely
-	AuthRelay       string          `json:"authRelay"`       //If it is remotely managed, we need the path to it.
-	UniqueID        string          `json:"uniqueID"`        //This must be provided in the request
-	APIKey          string          `json:"apiKey"`          //The key required to access remote servers
-	GroupOwnerID    string          `json:"groupOwnerID"`    //Group Owner ID
-	MemberOfGroups  []string        `json:"memberOfGroups"`  //Groups this Company Belongs to
-	Settings        CompanySettings `json:"settings"`        //Settings
-	Registered      bool            `json:"registered"`      // We need to save this information to the database
-	RegisCode       int             `json:"regisCode"`       //Registration code is
+	ID              primitive.ObjectID `bson:"_id" json:"id"`
+	Name            string             `json:"name"`
+	Address1        string             `json:"address1"`
+	Address2        string             `json:"address2"`
+	City            string             `json:"city"`
+	State           string             `json:"state"`
+	Zip             string             `json:"zip"`
+	IsInLocation    bool               `json:"isInLocation"`    //Specifies if a company is also a location. Used with the
+	RemotelyManaged bool               `json:"remotelyManaged"` //Is this Auth system managed remotely
+	AuthRelay       string             `json:"authRelay"`       //If it is remotely managed, we need the path to it.
+	UniqueID        string             `json:"uniqueID"`        //This must be provided in the request
+	APIKey          string             `json:"apiKey"`          //The key required to access remote servers
+	GroupOwnerID    string             `json:"groupOwnerID"`    //Group Owner ID
+	MemberOfGroups  []string           `json:"memberOfGroups"`  //Groups this Company Belongs to
+	Settings        CompanySettings    `json:"settings"`        //Settings
+	Registered      bool               `json:"registered"`      // We need to save this information to the database
+	RegisCode       int                `json:"regisCode"`       //Registration code is
+	Revision        int64              `json:"revision"`        //Incremented by every save, see SaveCompany
 }
 
 //SetClientRegistered ...
@@ -114,6 +181,12 @@ func (company *Company) SetClientRegistered(registered bool) {
 	}
 }
 
+//ReleaseRegistration - Undo a registration. Unlike SetClientRegistered
+//the registration code is kept, so the site can try again with it.
+func (company *Company) ReleaseRegistration() {
+	company.Registered = false
+}
+
 //GetRegistrationCode ...
 func (company *Company) GetRegistrationCode() int {
 	return company.RegisCode
@@ -129,7 +202,7 @@ NewCompany - Constructor for Company.
 */
 func NewCompany() *Company {
 	company := new(Company)
-	company.ID = bson.NewObjectId()
+	company.ID = primitive.NewObjectID()
 	return company
 }
 
@@ -149,83 +222,97 @@ func isValidCompany(company *Company) bool {
 
 //SaveCompany - Given a company, it will save it
 //to the database. Note that the ID must be an existing
-//ID in the database
-func SaveCompany(company *Company) error {
+//ID in the database. ErrConflict when the company was changed
+//since it was read.
+func SaveCompany(ctx context.Context, company *Company) error {
 
 	if !isValidCompany(company) {
-		return errors.New("InvalidCompany")
+		return NewInputError("InvalidCompany")
+	}
+
+	err := company.Settings.validate()
+	if err != nil {
+		return err
 	}
 
-	return mDBCompany.Update(company, bson.M{"_id": company.ID})
+	return saveRevision(&company.Revision, func(expected int64) error {
+		return mStore.Companies().SaveRevision(ctx, company, company.ID.Hex(), expected)
+	})
 }
 
 //InsertCompany - Add a company to the database
-func InsertCompany(company *Company) error {
+func InsertCompany(ctx context.Context, company *Company) error {
 
 	if !isValidCompany(company) {
-		return errors.New("InvalidCompany")
+		return NewInputError("InvalidCompany")
 	}
 
-	return mDBCompany.Insert(company, bson.M{"_id": company.ID})
-}
-
-func findCompanyWithCondition(condition interface{}) (*Company, error) {
-	company := NewCompany()
-
-	err := mDBCompany.Find(company, condition)
+	err := company.Settings.validate()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return company, nil
+	return mStore.Companies().Insert(ctx, company, company.ID.Hex())
 }
 
 //FindCompanyByID - Return a company if it can find it
 //or return an error
-func FindCompanyByID(ID string) (*Company, error) {
+func FindCompanyByID(ctx context.Context, ID string) (*Company, error) {
 
-	if !bson.IsObjectIdHex(ID) {
-		return nil, errors.New("InvalidID")
+	if !primitive.IsValidObjectID(ID) {
+		return nil, NewInputError("InvalidID")
 	}
 
-	return findCompanyWithCondition(bson.M{"_id": bson.ObjectIdHex(ID)})
+	company := NewCompany()
+	err := mStore.Companies().FindByID(ctx, company, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return company, nil
 }
 
 //FindCompanyByUniqueID ...
-func FindCompanyByUniqueID(uniqueID string) (*Company, error) {
+func FindCompanyByUniqueID(ctx context.Context, uniqueID string) (*Company, error) {
 
 	if utf8.RuneCountInString(uniqueID) == 0 {
-		return nil, errors.New("UniqueIDTooShort")
+		return nil, NewInputError("UniqueIDTooShort")
 	}
 
-	return findCompanyWithCondition(bson.M{"uniqueid": uniqueID})
+	company := NewCompany()
+	err := mStore.Companies().FindByUniqueID(ctx, company, uniqueID)
+	if err != nil {
+		return nil, err
+	}
+
+	return company, nil
 }
 
 //RemoveCompanyByID - Remove a company if it can find it
-func RemoveCompanyByID(ID string) error {
+func RemoveCompanyByID(ctx context.Context, ID string) error {
 
-	company, err := FindCompanyByID(ID)
+	company, err := FindCompanyByID(ctx, ID)
 	if err != nil {
 		return err
 	}
 
-	return mDBCompany.Remove(bson.M{"_id": company.ID})
+	return mStore.Companies().RemoveByID(ctx, company.ID.Hex())
 }
 
 //ListCompanies - List all companies.
 //Initially there should not be that many. Eventually we will have to add
 //another function to limit the number of records returned.
-func ListCompanies() ([]Company, error) {
+func ListCompanies(ctx context.Context) ([]Company, error) {
 	var companies []Company
-	err := mDBCompany.List(&companies, bson.M{})
+	err := mStore.Companies().List(ctx, &companies)
 	return companies, err
 }
 
 //ListCompaniesByGroupID ...
-func ListCompaniesByGroupID(groupOwnerID string) ([]Company, error) {
+func ListCompaniesByGroupID(ctx context.Context, groupOwnerID string) ([]Company, error) {
 
 	var companies []Company
-	err := mDBCompany.List(&companies, bson.M{"groupownerid": groupOwnerID})
+	err := mStore.Companies().ListByGroupOwnerID(ctx, &companies, groupOwnerID)
 	if err != nil {
 		return nil, err
 	}
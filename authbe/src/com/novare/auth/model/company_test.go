@@ -24,10 +24,12 @@ SOFTWARE.
 package model
 
 import (
+	"context"
 	"testing"
 )
 
 func TestCompanyFunctions(t *testing.T) {
+	ctx := context.Background()
 
 	company := NewCompany()
 	company.Name = "My Corporation"
@@ -39,19 +41,27 @@ func TestCompanyFunctions(t *testing.T) {
 	company.AuthRelay = ""
 	company.UniqueID = "THISISMYUNIQUEIDENTIFIER"
 
-	err := SaveCompany(company)
+	err := SaveCompany(ctx, company)
 	if err == nil {
 		t.Errorf("The System saved a company but it should not have")
 	} else {
 		t.Logf("The system correctly failed with save with the following response: %s", err)
 	}
 
-	err = InsertCompany(company)
+	err = InsertCompany(ctx, company)
 	if err != nil {
 		t.Errorf("The system should have saved the company.")
 	}
 
-	company2, err := FindCompanyByID(company.ID.Hex())
+	twin := NewCompany()
+	twin.Name = "My Other Corporation"
+	twin.UniqueID = company.UniqueID
+	err = InsertCompany(ctx, twin)
+	if err != ErrDuplicate {
+		t.Errorf("A second company with the UniqueID:[%s] should fail with ErrDuplicate: [%v]", company.UniqueID, err)
+	}
+
+	company2, err := FindCompanyByID(ctx, company.ID.Hex())
 	if err != nil {
 		t.Errorf("The system should have found the company with ID: %s", company.ID.Hex())
 	}
@@ -64,7 +74,7 @@ func TestCompanyFunctions(t *testing.T) {
 		t.Errorf("The company names do not match: %s != %s", company2.Name, company.Name)
 	}
 
-	company3, err := FindCompanyByUniqueID("THISISMYUNIQUEIDENTIFIER")
+	company3, err := FindCompanyByUniqueID(ctx, "THISISMYUNIQUEIDENTIFIER")
 	if err != nil {
 		t.Errorf("The following error occured: [%s]", err)
 		return
@@ -74,7 +84,7 @@ func TestCompanyFunctions(t *testing.T) {
 		return
 	}
 
-	companies, err := ListCompanies()
+	companies, err := ListCompanies(ctx)
 	if err != nil {
 		t.Errorf("The following error occurred %s", err)
 	}
@@ -83,13 +93,14 @@ func TestCompanyFunctions(t *testing.T) {
 		t.Error("The Companies list length is invalid!")
 	}
 
-	err = RemoveCompanyByID(company.ID.Hex())
+	err = RemoveCompanyByID(ctx, company.ID.Hex())
 	if err != nil {
 		t.Errorf("Removing the company with ID: %s", company.ID.Hex())
 	}
 }
 
 func TestAddingCompanyToGroup(t *testing.T) {
+	ctx := context.Background()
 
 	company := NewCompany()
 	company.UniqueID = "SOMEUNIQUEID"
@@ -106,7 +117,7 @@ func TestAddingCompanyToGroup(t *testing.T) {
 	company.Settings.PassExpiration = 12
 	company.Settings.PassUnit = "Month"
 
-	err := InsertCompany(company)
+	err := InsertCompany(ctx, company)
 	if err != nil {
 		t.Errorf("Unable to insert the company with ID: [%s]", company.ID.Hex())
 		return
@@ -127,14 +138,14 @@ func TestAddingCompanyToGroup(t *testing.T) {
 	comp1.Settings.PassExpiration = 12
 	comp1.Settings.PassUnit = "Month"
 	comp1.GroupOwnerID = company.ID.Hex()
-	err = InsertCompany(comp1)
+	err = InsertCompany(ctx, comp1)
 	if err != nil {
-		RemoveCompanyByID(company.ID.Hex())
+		RemoveCompanyByID(ctx, company.ID.Hex())
 		t.Errorf("Error: [%s]", err)
 		return
 	}
 
-	companies, err := ListCompaniesByGroupID(company.ID.Hex())
+	companies, err := ListCompaniesByGroupID(ctx, company.ID.Hex())
 	if err != nil {
 		t.Errorf("The following error occurred: [%s]", err)
 		return
@@ -148,7 +159,7 @@ func TestAddingCompanyToGroup(t *testing.T) {
 		t.Errorf("The company IDs do not match:[%s] != [%s]", companies[0].ID.Hex(), comp1.ID.Hex())
 	}
 
-	RemoveCompanyByID(company.ID.Hex())
-	RemoveCompanyByID(comp1.ID.Hex())
+	RemoveCompanyByID(ctx, company.ID.Hex())
+	RemoveCompanyByID(ctx, comp1.ID.Hex())
 
 }
@@ -25,45 +25,39 @@ SOFTWARE.
 package model
 
 import (
-	"com/novare/dbs"
+	"context"
 	"log"
 
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-var mDBCfg = dbs.NewMongoDB(AuthRelayDatabaseName, "Config")
-
 //Config - Used for global configuration
 type Config struct {
-	ID     bson.ObjectId `json:"id" bson:"_id"`
-	RowID  int           `json:"rowID"`
-	Secret string        `json:"secret"`
+	ID            primitive.ObjectID `json:"id" bson:"_id"`
+	RowID         int                `json:"rowID"`
+	Secret        string             `json:"secret"`
+	SchemaVersion int                `json:"schemaVersion"` //The last migration applied, see Migrate
 }
 
 //NewConfig - Application level configuration
 func NewConfig() *Config {
 	config := new(Config)
-	config.ID = bson.NewObjectId()
+	config.ID = primitive.NewObjectID()
 	//We just need one configuration for the site.
 	config.RowID = 1
 	return config
 }
 
 //SaveConfig ...
-func SaveConfig(config *Config) error {
-
-	err := mDBCfg.Update(config, bson.M{"rowid": config.RowID})
-	if err != nil {
-		err = mDBCfg.Insert(config, bson.M{"rowid": config.RowID})
-	}
+func SaveConfig(ctx context.Context, config *Config) error {
 
-	return err
+	return mStore.Config().Save(ctx, config, config.RowID)
 }
 
 //GetConfig ... It will either retrieve the configuration or a new Config Object
-func GetConfig() *Config {
+func GetConfig(ctx context.Context) *Config {
 	cfg := NewConfig()
-	err := mDBCfg.Find(cfg, bson.M{"rowid": 1})
+	err := mStore.Config().FindByRowID(ctx, cfg, 1)
 	if err != nil {
 		log.Printf("It must be the first instance of the Config object")
 	}
@@ -24,14 +24,18 @@ SOFTWARE.
 
 package model
 
-import "testing"
+import (
+	"context"
+	"testing"
+)
 
 func TestGetAndSaveConfiguration(t *testing.T) {
+	ctx := context.Background()
 
-	cfg := GetConfig()
+	cfg := GetConfig(ctx)
 	cfg.Secret = "Not Really A Secret"
 
-	err := SaveConfig(cfg)
+	err := SaveConfig(ctx, cfg)
 	if err != nil {
 		t.Errorf("The following error occurred while saving the configuration:[%s]", err)
 	}
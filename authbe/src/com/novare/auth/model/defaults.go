@@ -0,0 +1,84 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+//DefaultRoleName - The role every new company gets, it holds all the
+//DefaultPermissions
+const DefaultRoleName = "Administrator"
+
+//DefaultPermissions - The permissions the edgeauth routes check. They are
+//created for every new company so they can be assigned right away.
+var DefaultPermissions = []Permission{
+	{Permission: "ADD_PERMISSION", Description: "Add permissions"},
+	{Permission: "UPDATE_PERMISSION", Description: "Update permissions"},
+	{Permission: "REMOVE_PERMISSION", Description: "Remove permissions"},
+	{Permission: "GET_PERMISSION", Description: "List permissions"},
+	{Permission: "ADD_ROLE", Description: "Add roles"},
+	{Permission: "UPDATE_ROLE", Description: "Update roles"},
+	{Permission: "REMOVE_ROLE", Description: "Remove roles"},
+	{Permission: "GET_ROLE", Description: "List roles"},
+	{Permission: "ADD_USER", Description: "Add users"},
+	{Permission: "UPDATE_USER", Description: "Update users"},
+	{Permission: "REMOVE_USER", Description: "Remove users"},
+	{Permission: "GET_USER", Description: "List users"},
+	{Permission: "UPDATE_PASSWORD", Description: "Update passwords"},
+	{Permission: "UPDATE_COMPANY", Description: "Update the company"},
+	{Permission: "LIST_GROUP", Description: "List the companies of the group"},
+	{Permission: "ENABLE_REGISTATION", Description: "Enable the registration of a site"},
+	{Permission: "RECEIVE_EVENTS", Description: "Receive the server sent events"},
+	{Permission: "INTROSPECT_TOKEN", Description: "Introspect the tokens of the company"},
+	{Permission: "REVOKE_TOKEN", Description: "Revoke the tokens of the other users of the company"},
+	{Permission: "LIST_SESSION", Description: "List the sessions of the company"},
+	{Permission: "TERMINATE_SESSION", Description: "Terminate the sessions of the other users of the company"},
+	{Permission: "ADD_CLIENT", Description: "Register OAuth clients"},
+	{Permission: "UPDATE_CLIENT", Description: "Update OAuth clients and rotate their secrets"},
+	{Permission: "REMOVE_CLIENT", Description: "Remove OAuth clients"},
+	{Permission: "GET_CLIENT", Description: "List OAuth clients"},
+}
+
+//NewDefaultPermissions - New copies of the DefaultPermissions for the
+//company
+func NewDefaultPermissions(companyID string) []*Permission {
+	var perms []*Permission
+	for _, p := range DefaultPermissions {
+		perm := NewPermission()
+		perm.CompanyID = companyID
+		perm.Permission = p.Permission
+		perm.Description = p.Description
+		perms = append(perms, perm)
+	}
+	return perms
+}
+
+//NewDefaultRole - The DefaultRoleName role holding the given permissions
+func NewDefaultRole(companyID string, perms []*Permission) *Role {
+	role := NewRole()
+	role.CompanyID = companyID
+	role.Description = DefaultRoleName
+	for _, p := range perms {
+		role.Permissions = append(role.Permissions, *p)
+	}
+	return role
+}
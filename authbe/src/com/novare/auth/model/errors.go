@@ -0,0 +1,68 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"com/novare/dbs"
+)
+
+//The storage errors, compare them with errors.Is
+var (
+	//ErrNotFound - The entity does not exist
+	ErrNotFound = dbs.ErrNotFound
+
+	//ErrDuplicate - An insert or a save would break a unique constraint,
+	//e.g. the company uniqueID or the username in a company is taken
+	ErrDuplicate = dbs.ErrDuplicate
+
+	//ErrConflict - The entity changed since it was read
+	ErrConflict = dbs.ErrConflict
+
+	//ErrUnavailable - The database is down or did not answer in time
+	ErrUnavailable = dbs.ErrUnavailable
+
+	//ErrInvalidInput - Every InputError matches it
+	ErrInvalidInput = dbs.ErrInvalidInput
+)
+
+//InputError - The data given to the model is not valid. Code is the
+//machine readable reason, e.g. "InvalidID" or "UnsecurePassword".
+type InputError struct {
+	Code string
+}
+
+func (e *InputError) Error() string {
+	return e.Code
+}
+
+//Unwrap - errors.Is(err, ErrInvalidInput) holds for every InputError
+func (e *InputError) Unwrap() error {
+	return ErrInvalidInput
+}
+
+//NewInputError - An InputError with the given code
+func NewInputError(code string) error {
+	return &InputError{Code: code}
+}
@@ -25,8 +25,10 @@ SOFTWARE.
 package model
 
 import (
-	"com/novare/dbs"
 	"com/novare/utils"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -35,22 +37,23 @@ import (
 	"time"
 	"unicode/utf8"
 
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-var mDBjwt = dbs.NewMongoDB(AuthRelayDatabaseName, "JWTs")
-
 //JWTHeader ...
 type JWTHeader struct {
-	Algo string `json:"alg,omitempty"` //The algorithm used to hash the token
-	Type string `json:"typ,omitempty"` //This should always be JWT
+	Algo  string `json:"alg,omitempty"` //The algorithm used to hash the token
+	Type  string `json:"typ,omitempty"` //This should always be JWT
+	KeyID string `json:"kid,omitempty"` //The SigningKey of the RS256 and ES256 tokens
+
+	Critical []string `json:"crit,omitempty" bson:"-"` //Rejected, no extension is supported
 }
 
 //NewJWTHeader - Constructor for JWT
 func NewJWTHeader() *JWTHeader {
 	header := new(JWTHeader)
 	header.Type = "JWT"
-	header.Algo = "HS256"
+	header.Algo = AlgHS256
 	return header
 }
 
@@ -65,6 +68,33 @@ type JWTPayload struct {
 	NotBefore      int64  `json:"nbf,omitempty"`
 	IssuedAt       int64  `json:"iat,omitempty"`
 	ID             string `json:"jti,omitempty"`
+
+	//The access tokens say what they were granted for, as do the sessions
+	//an OAuthClient started with the authorization code grant
+	Scope    string   `json:"scope,omitempty"`     //The granted permissions separated by spaces
+	Roles    []string `json:"roles,omitempty"`     //The role IDs of the user
+	Company  string   `json:"company,omitempty"`   //The company UniqueID
+	ClientID string   `json:"client_id,omitempty"` //The OAuthClient the token was issued to
+
+	//The ID tokens of OpenID Connect
+	TokenUse          string `json:"token_use,omitempty"`          //TokenUseID, empty for the sessions and access tokens
+	Nonce             string `json:"nonce,omitempty"`              //What the app passed to the authorize page
+	AuthTime          int64  `json:"auth_time,omitempty"`          //When the user logged in
+	PreferredUsername string `json:"preferred_username,omitempty"` //The username, with the profile scope
+}
+
+//Scopes - The granted permissions, nil when the token is not limited to a
+//scope
+func (jwtp *JWTPayload) Scopes() []string {
+	if utf8.RuneCountInString(jwtp.Scope) == 0 {
+		return nil
+	}
+	return strings.Fields(jwtp.Scope)
+}
+
+//HasScope - The token was granted the scope
+func (jwtp *JWTPayload) HasScope(scope string) bool {
+	return hasScope(jwtp.Scope, scope)
 }
 
 //SetExpiration - Set the JWT expiration. This can be used for resets as well
@@ -91,6 +121,31 @@ func (jwtp *JWTPayload) IsExpired() bool {
 	return now.Unix() > jwtp.ExpirationTime
 }
 
+/*
+ClaimLimits - How large the claims of an issued token can get. The tokens
+travel in the Authorization header and most servers limit the size of
+the headers, so a token over the limits is not issued. 0 is no limit.
+*/
+type ClaimLimits struct {
+	MaxRoles     int //Role IDs in the roles claim
+	MaxScope     int //Characters of the scope claim
+	MaxTokenSize int //Characters of the encoded token
+}
+
+//TokenClaimLimits - The limits IssueJWT enforces, set at startup
+var TokenClaimLimits = ClaimLimits{MaxRoles: 64, MaxScope: 1024, MaxTokenSize: 8192}
+
+//check - The claims of the payload are within the limits
+func (limits ClaimLimits) check(payload *JWTPayload) error {
+	if limits.MaxRoles > 0 && len(payload.Roles) > limits.MaxRoles {
+		return NewInputError("TooManyRoles")
+	}
+	if limits.MaxScope > 0 && utf8.RuneCountInString(payload.Scope) > limits.MaxScope {
+		return NewInputError("ScopeTooLong")
+	}
+	return nil
+}
+
 //NewJWTPayload ..
 func NewJWTPayload() *JWTPayload {
 	payload := new(JWTPayload)
@@ -99,104 +154,387 @@ func NewJWTPayload() *JWTPayload {
 
 //JWTToken - Contains both a header and payload
 type JWTToken struct {
-	ID        bson.ObjectId `bson:"_id"`
-	Header    JWTHeader     //Header
-	Payload   JWTPayload    //Payload
-	UserID    string        //This will be saved to the database
-	CompanyID string        //This is the companyID
-	Secret    string        //The secret stored in the database
-	Signature string        //Save the signature for fast lookup
+	ID        primitive.ObjectID `bson:"_id"`
+	Header    JWTHeader          //Header
+	Payload   JWTPayload         //Payload
+	UserID    string             //This will be saved to the database
+	CompanyID string             //This is the companyID
+	Secret    string             //The secret stored in the database
+	Signature string             //Save the signature for fast lookup
+	FamilyID  string             //The RefreshToken family the session belongs to
+	Session   SessionInfo        //Where the session was started
+
+	signingInput string //The encoded header and payload ParseJWT received
 }
 
-//EncodeJWT - Encoded the token
-func (jwt *JWTToken) EncodeJWT() (string, bool) {
+//AllowedAlgorithms - The alg headers ParseJWT accepts. "none" and every
+//algorithm the edgeauth does not sign with are rejected.
+var AllowedAlgorithms = []string{AlgRS256, AlgES256, AlgHS256}
 
-	//Now let's serialize
-	buf, err := json.Marshal(jwt.Header)
-	if err != nil {
-		log.Printf("There was an issue marshalling the JWT header:[%s]", err)
-		return "", false
+/*
+LegacyJWTCompatibility - Keep accepting the HS256 tokens issued before the
+signatures followed RFC 7515. Their signature is the base64url of the
+base64 encoded HMAC instead of the HMAC itself. Turn it off once those
+tokens have expired.
+*/
+var LegacyJWTCompatibility = true
+
+func isAllowedAlgorithm(alg string) bool {
+	for _, a := range AllowedAlgorithms {
+		if a == alg {
+			return true
+		}
 	}
-	header := strings.TrimRight(base64.URLEncoding.EncodeToString(buf), "=")
+	return false
+}
 
-	buf, err = json.Marshal(jwt.Payload)
+//validate - The strict RFC 7515 header checks
+func (header *JWTHeader) validate() error {
+
+	if !isAllowedAlgorithm(header.Algo) {
+		log.Printf("The JWT algorithm [%s] is not allowed", header.Algo)
+		return NewInputError("UnsupportedAlgorithm")
+	}
+
+	//The typ is optional but it must say JWT when present
+	if header.Type != "" && !strings.EqualFold(header.Type, "JWT") {
+		return NewInputError("InvalidTokenType")
+	}
+
+	//We do not understand any extension, so none can be critical
+	if len(header.Critical) > 0 {
+		return NewInputError("UnsupportedCriticalHeader")
+	}
+
+	if header.Algo != AlgHS256 && header.KeyID == "" {
+		return NewInputError("MissingKeyID")
+	}
+
+	return nil
+}
+
+func hmacSHA256(input string, secret string) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(input))
+	return h.Sum(nil)
+}
+
+//legacyHMACSignature - The signature EncodeJWT produced before it was RFC
+//7515 compliant
+func legacyHMACSignature(input string, secret string) string {
+	sig := base64.StdEncoding.EncodeToString(hmacSHA256(input, secret))
+	return base64.RawURLEncoding.EncodeToString([]byte(sig))
+}
+
+//EncodeJWT - Encode the token and sign it with its Secret (HS256)
+func (jwt *JWTToken) EncodeJWT() (string, bool) {
+
+	jwt.Header.Algo = AlgHS256
+	jwt.Header.KeyID = ""
+	input, err := jwt.encodeSigningInput()
 	if err != nil {
-		log.Printf("There was an error marshalling the JWT payload:[%s]", err)
 		return "", false
 	}
-	payload := strings.TrimRight(base64.URLEncoding.EncodeToString(buf), "=")
 
-	signature := utils.EncodeHMACHash(header+"."+payload, jwt.Secret)
-	signature = strings.TrimRight(base64.URLEncoding.EncodeToString([]byte(signature)), "=")
-	jwt.Signature = signature
+	jwt.Signature = base64.RawURLEncoding.EncodeToString(hmacSHA256(input, jwt.Secret))
+	jwt.signingInput = input
 
-	//Once the signature is calculated, let's encode it
-	encodeJWT := header + "." + payload + "." + signature
+	encodeJWT := input + "." + jwt.Signature
 	return encodeJWT, true
 }
 
-//IsTampered if the
-func (jwt *JWTToken) IsTampered() bool {
-	rsp := false
+/*
+VerifyHMAC - Check the HS256 signature with the secret. A token that was
+not parsed is encoded again. The legacy signatures are accepted while
+LegacyJWTCompatibility is on.
+*/
+func (jwt *JWTToken) VerifyHMAC(secret string) error {
 
-	signature := jwt.Signature
-	jwt.EncodeJWT()
+	if jwt.Header.Algo != AlgHS256 {
+		return ErrInvalidSignature
+	}
 
-	if jwt.Signature != signature {
+	input := jwt.signingInput
+	if input == "" {
+		var err error
+		input, err = jwt.encodeSigningInput()
+		if err != nil {
+			return err
+		}
+	}
+
+	expected := hmacSHA256(input, secret)
+	sig, err := base64.RawURLEncoding.DecodeString(jwt.Signature)
+	if err == nil && hmac.Equal(sig, expected) {
+		return nil
+	}
+
+	if LegacyJWTCompatibility && hmac.Equal([]byte(jwt.Signature), []byte(legacyHMACSignature(input, secret))) {
+		log.Printf("The token is signed in the legacy format")
+		return nil
+	}
+
+	return ErrInvalidSignature
+}
+
+//IsTampered - The HS256 signature does not match the Secret
+func (jwt *JWTToken) IsTampered() bool {
+
+	err := jwt.VerifyHMAC(jwt.Secret)
+	if err != nil {
 		log.Printf("The signature provided and the signature calculated do not match")
-		rsp = true
+		return true
 	}
 
-	return rsp
+	return false
 }
 
-//ParseJWT - Parse a JWT Token
+/*
+ParseJWT - Parse a JWT in the RFC 7519 compact form. The segments must be
+unpadded base64url and the header must pass the strict checks, the
+signature is verified separately.
+*/
 func (jwt *JWTToken) ParseJWT(encodedJWT string) error {
 
 	fields := strings.Split(encodedJWT, ".")
-	if len(fields) < 3 {
+	if len(fields) != 3 {
 		log.Printf("There was an error parsing the encoded data")
-		return errors.New("InvalidJWT")
+		return NewInputError("InvalidJWT")
 	}
 
-	fields[0] = utils.PerformB64Padding(fields[0])
 	//Header
-	headBytes, err := base64.URLEncoding.DecodeString(fields[0])
+	headBytes, err := base64.RawURLEncoding.DecodeString(fields[0])
 	if err != nil {
-		return err
+		return NewInputError("InvalidJWTHeader")
 	}
 
-	fields[1] = utils.PerformB64Padding(fields[1])
 	//Payload
-	payloadBytes, err := base64.URLEncoding.DecodeString(fields[1])
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(fields[1])
 	if err != nil {
-		return err
+		return NewInputError("InvalidJWTPayload")
 	}
 
+	//Nothing of the token the struct was created with may remain
+	jwt.Header = JWTHeader{}
+	jwt.Payload = JWTPayload{}
+
 	err = json.Unmarshal(headBytes, &jwt.Header)
+	if err != nil {
+		return NewInputError("InvalidJWTHeader")
+	}
+
+	err = jwt.Header.validate()
 	if err != nil {
 		return err
 	}
 
 	err = json.Unmarshal(payloadBytes, &jwt.Payload)
 	if err != nil {
-		return err
+		return NewInputError("InvalidJWTPayload")
+	}
+
+	_, err = base64.RawURLEncoding.DecodeString(fields[2])
+	if err != nil {
+		return NewInputError("InvalidJWTSignature")
 	}
 
 	jwt.Signature = fields[2]
+	jwt.signingInput = fields[0] + "." + fields[1]
 	return nil
 }
 
+//encodeSigningInput - The encoded header and payload the signature covers
+func (jwt *JWTToken) encodeSigningInput() (string, error) {
+
+	buf, err := json.Marshal(jwt.Header)
+	if err != nil {
+		log.Printf("There was an issue marshalling the JWT header:[%s]", err)
+		return "", err
+	}
+	header := base64.RawURLEncoding.EncodeToString(buf)
+
+	buf, err = json.Marshal(jwt.Payload)
+	if err != nil {
+		log.Printf("There was an error marshalling the JWT payload:[%s]", err)
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(buf)
+
+	return header + "." + payload, nil
+}
+
+/*
+SignJWT - Sign the token with an asymmetric key. The header names the
+algorithm and the key so the token can be verified with the public key
+the JWKS publishes, without calling the edgeauth.
+*/
+func (jwt *JWTToken) SignJWT(key *SigningKey) (string, error) {
+
+	jwt.Header.Algo = key.Algorithm
+	jwt.Header.KeyID = key.KeyID
+	input, err := jwt.encodeSigningInput()
+	if err != nil {
+		return "", err
+	}
+
+	sig, err := key.Sign([]byte(input))
+	if err != nil {
+		log.Printf("The JWT could not be signed with the key [%s]: [%s]", key.KeyID, err)
+		return "", err
+	}
+
+	jwt.Signature = base64.RawURLEncoding.EncodeToString(sig)
+	jwt.signingInput = input
+	return input + "." + jwt.Signature, nil
+}
+
+//VerifyJWT - Check the signature of a parsed token with the key it names
+func (jwt *JWTToken) VerifyJWT(key *SigningKey) error {
+
+	if jwt.Header.Algo != key.Algorithm || jwt.Header.KeyID != key.KeyID {
+		log.Printf("The token algorithm [%s] and key [%s] do not match the key", jwt.Header.Algo, jwt.Header.KeyID)
+		return ErrInvalidSignature
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(jwt.Signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	return key.Verify([]byte(jwt.signingInput), sig)
+}
+
+/*
+VerifyWithJWK - Check the signature of a parsed token with a public key of
+the JWKS. This is how a site verifies the tokens without the edgeauth.
+*/
+func (jwt *JWTToken) VerifyWithJWK(jwk JSONWebKey) error {
+
+	if jwt.Header.Algo != jwk.Algorithm || jwt.Header.KeyID != jwk.KeyID {
+		log.Printf("The token algorithm [%s] and key [%s] do not match the key", jwt.Header.Algo, jwt.Header.KeyID)
+		return ErrInvalidSignature
+	}
+
+	publicKey, err := jwk.PublicKey()
+	if err != nil {
+		return err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(jwt.Signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	return verifySignature(publicKey, []byte(jwt.signingInput), sig)
+}
+
+//IsSignedWithKey - RS256 and ES256 tokens are signed with a SigningKey,
+//the others with the per token Secret
+func (jwt *JWTToken) IsSignedWithKey() bool {
+	return jwt.Header.Algo == AlgRS256 || jwt.Header.Algo == AlgES256
+}
+
+//IssueJWT - Sign the token with the signing key of the company. The
+//claims must be within the TokenClaimLimits.
+func IssueJWT(ctx context.Context, jwt *JWTToken, company *Company) (string, error) {
+
+	err := TokenClaimLimits.check(&jwt.Payload)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := GetSigningKey(ctx, company)
+	if err != nil {
+		return "", err
+	}
+
+	//The tokens signed with a company key say which company, it is checked
+	//when they are verified
+	if utf8.RuneCountInString(key.CompanyID) > 0 && utf8.RuneCountInString(jwt.Payload.Company) == 0 {
+		jwt.Payload.Company = company.UniqueID
+	}
+
+	encoded, err := jwt.SignJWT(key)
+	if err != nil {
+		return "", err
+	}
+
+	if TokenClaimLimits.MaxTokenSize > 0 && len(encoded) > TokenClaimLimits.MaxTokenSize {
+		log.Printf("The token is %d characters long, the limit is %d", len(encoded), TokenClaimLimits.MaxTokenSize)
+		return "", NewInputError("TokenTooLarge")
+	}
+
+	return encoded, nil
+}
+
+/*
+VerifyJWTSignature - Check a parsed token against the signing key named
+by its kid. An unknown key, or the key of a company the token was not
+issued for, is an ErrInvalidSignature.
+*/
+func VerifyJWTSignature(ctx context.Context, jwt *JWTToken) error {
+
+	key, err := FindSigningKeyByKeyID(ctx, jwt.Header.KeyID)
+	if errors.Is(err, ErrNotFound) {
+		log.Printf("The token was signed with the unknown key [%s]", jwt.Header.KeyID)
+		return ErrInvalidSignature
+	}
+	if err != nil {
+		return err
+	}
+
+	if !key.CanVerify(time.Now()) {
+		log.Printf("The token was signed with the %s key [%s]", key.Status, key.KeyID)
+		return ErrInvalidSignature
+	}
+
+	if utf8.RuneCountInString(key.CompanyID) > 0 {
+		companyID, err := tokenCompanyID(ctx, jwt)
+		if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrInvalidInput) {
+			return err
+		}
+		if companyID != key.CompanyID {
+			log.Printf("The token was signed with the key [%s] of another company", key.KeyID)
+			return ErrInvalidSignature
+		}
+	}
+
+	return jwt.VerifyJWT(key)
+}
+
+//tokenCompanyID - The ID of the company the token was issued for. The
+//sessions issued before the company claim was added are looked up.
+func tokenCompanyID(ctx context.Context, jwt *JWTToken) (string, error) {
+
+	if utf8.RuneCountInString(jwt.Payload.Company) > 0 {
+		company, err := FindCompanyByUniqueID(ctx, jwt.Payload.Company)
+		if err != nil {
+			return "", err
+		}
+		return company.ID.Hex(), nil
+	}
+
+	stored, err := FindJWTTokenBySignature(ctx, jwt.Signature)
+	if err != nil {
+		return "", err
+	}
+	return stored.CompanyID, nil
+}
+
 //NewJWTToken -
 func NewJWTToken(userID string, companyID string) *JWTToken {
 	jwtToken := new(JWTToken)
-	jwtToken.ID = bson.NewObjectId()
+	jwtToken.ID = primitive.NewObjectID()
 	jwtToken.Header = *NewJWTHeader()
 	jwtToken.Secret = utils.GenerateUniqueID()
 	//Assume 30
 	jwtToken.Payload.ExpirationTime = time.Now().Add(300 * time.Minute).Unix()
-	jwtToken.Payload.Issuer = "AUTHBEE"
+	jwtToken.Payload.Issuer = TokenIssuer
 	jwtToken.Payload.IssuedAt = time.Now().Unix()
+	//The RS256 signature is deterministic, the jti keeps two tokens issued
+	//in the same second apart
+	jwtToken.Payload.ID = jwtToken.ID.Hex()
+	jwtToken.Payload.Subject = userID
 	jwtToken.UserID = userID
 	jwtToken.CompanyID = companyID
 	return jwtToken
@@ -220,31 +558,31 @@ func isJWTTokenValid(jwt *JWTToken) bool {
 }
 
 //SaveJWTToken -
-func SaveJWTToken(jwt *JWTToken) error {
+func SaveJWTToken(ctx context.Context, jwt *JWTToken) error {
 
 	if !isJWTTokenValid(jwt) {
-		return errors.New("InvalidJWTToken")
+		return NewInputError("InvalidJWTToken")
 	}
 
-	return mDBjwt.Update(jwt, bson.M{"_id": jwt.ID})
+	return mStore.JWTs().Save(ctx, jwt, jwt.ID.Hex())
 }
 
 //InsertJWTToken -
-func InsertJWTToken(jwt *JWTToken) error {
+func InsertJWTToken(ctx context.Context, jwt *JWTToken) error {
 
 	if !isJWTTokenValid(jwt) {
 		log.Printf("The token passwed in is not valid!")
-		return errors.New("InvalidJWTToken")
+		return NewInputError("InvalidJWTToken")
 	}
 
-	return mDBjwt.Insert(jwt, bson.M{"_id": jwt.ID})
+	return mStore.JWTs().Insert(ctx, jwt, jwt.ID.Hex())
 }
 
 //Intern
-func findJWTToken(condition interface{}) (*JWTToken, error) {
+func findJWTToken(find func(jwt *JWTToken) error) (*JWTToken, error) {
 
 	jwt := NewJWTToken("", "")
-	err := mDBjwt.Find(jwt, condition)
+	err := find(jwt)
 	if err != nil {
 		return nil, err
 	}
@@ -253,39 +591,52 @@ func findJWTToken(condition interface{}) (*JWTToken, error) {
 }
 
 //FindJWTTokenByID ...
-func FindJWTTokenByID(ID string) (*JWTToken, error) {
+func FindJWTTokenByID(ctx context.Context, ID string) (*JWTToken, error) {
 
-	if !bson.IsObjectIdHex(ID) {
-		return nil, errors.New("InvalidID")
+	if !primitive.IsValidObjectID(ID) {
+		return nil, NewInputError("InvalidID")
 	}
 
-	return findJWTToken(bson.M{"_id": bson.ObjectIdHex(ID)})
+	return findJWTToken(func(jwt *JWTToken) error {
+		return mStore.JWTs().FindByID(ctx, jwt, ID)
+	})
 }
 
 //FindJWTTokenBySignature - Given a signature, try  and find the correspondent
 //token
-func FindJWTTokenBySignature(signature string) (*JWTToken, error) {
-	return findJWTToken(bson.M{"signature": signature})
+func FindJWTTokenBySignature(ctx context.Context, signature string) (*JWTToken, error) {
+	return findJWTToken(func(jwt *JWTToken) error {
+		return mStore.JWTs().FindBySignature(ctx, jwt, signature)
+	})
 }
 
 //FindJWTTokenByUserIDCompanyID -
-func FindJWTTokenByUserIDCompanyID(userID string, companyID string) (*JWTToken, error) {
-	return findJWTToken(bson.M{"$and": []bson.M{{"userid": userID}, {"companyid": companyID}}})
+func FindJWTTokenByUserIDCompanyID(ctx context.Context, userID string, companyID string) (*JWTToken, error) {
+	return findJWTToken(func(jwt *JWTToken) error {
+		return mStore.JWTs().FindByUserIDCompanyID(ctx, jwt, userID, companyID)
+	})
 }
 
 //RemoveJWTTokenByID ...
-func RemoveJWTTokenByID(ID string) error {
+func RemoveJWTTokenByID(ctx context.Context, ID string) error {
 
-	if !bson.IsObjectIdHex(ID) {
-		return errors.New("InvalidID")
+	if !primitive.IsValidObjectID(ID) {
+		return NewInputError("InvalidID")
 	}
 
-	return mDBjwt.Remove(bson.M{"_id": bson.ObjectIdHex(ID)})
+	return mStore.JWTs().RemoveByID(ctx, ID)
+}
+
+//ListJWTTokensByFamilyID - The sessions of a refresh token family
+func ListJWTTokensByFamilyID(ctx context.Context, familyID string) ([]JWTToken, error) {
+	var jwtTokens []JWTToken
+	err := mStore.JWTs().ListByFamilyID(ctx, &jwtTokens, familyID)
+	return jwtTokens, err
 }
 
 //ListJWTTokensByCompanyID ...
-func ListJWTTokensByCompanyID(companyID string) ([]JWTToken, error) {
+func ListJWTTokensByCompanyID(ctx context.Context, companyID string) ([]JWTToken, error) {
 	var jwtTokens []JWTToken
-	err := mDBjwt.List(&jwtTokens, bson.M{"companyid": companyID})
+	err := mStore.JWTs().ListByCompanyID(ctx, &jwtTokens, companyID)
 	return jwtTokens, err
 }
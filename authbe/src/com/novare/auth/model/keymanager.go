@@ -0,0 +1,455 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	//KeyStatusNext - Published in the JWKS but not signing yet, so the
+	//remote sites know it before the first token signed with it
+	KeyStatusNext string = "next"
+	//KeyStatusActive - Signs the new tokens
+	KeyStatusActive string = "active"
+	//KeyStatusPrevious - Replaced by a rotation, it keeps verifying the
+	//tokens it signed until RetiresAt
+	KeyStatusPrevious string = "previous"
+	//KeyStatusRetired - Not published, the tokens it signed are rejected
+	KeyStatusRetired string = "retired"
+)
+
+//CanVerify - The tokens signed with the key are accepted at the time
+func (key *SigningKey) CanVerify(now time.Time) bool {
+	switch key.Status {
+	case KeyStatusNext, KeyStatusActive:
+		return true
+	case KeyStatusPrevious:
+		return now.Unix() < key.RetiresAt
+	}
+	return false
+}
+
+//KeyRotation - The keys of a company and algorithm after they changed.
+//The subscribers get one for every rotation and retirement.
+type KeyRotation struct {
+	CompanyID string   `json:"companyID"` //Empty for the site keys
+	Algorithm string   `json:"alg"`
+	Active    string   `json:"active"`             //The kid signing the new tokens
+	Next      string   `json:"next"`               //The kid that will sign after the next rotation
+	Previous  []string `json:"previous,omitempty"` //The kids still verifying
+	Retired   []string `json:"retired,omitempty"`  //The kids retired by this change
+}
+
+/*
+KeyManager - Holds the versioned signing keys of the site and of the
+companies. Every company and algorithm has one active key, one next key
+and the previous keys that still verify the tokens they signed. A rotation
+activates the next key, creates a new one and retires the previous keys
+once the Overlap has passed.
+*/
+type KeyManager struct {
+	RotationInterval time.Duration //How long a key signs, 0 only rotates on demand
+	Overlap          time.Duration //How long a replaced key keeps verifying
+	CheckInterval    time.Duration //How often Run looks for the keys due
+	ActiveKeyTTL     time.Duration //How long ActiveKey trusts the key it read
+
+	mutex       sync.Mutex
+	subscribers []func(KeyRotation)
+	pending     []KeyRotation        //The rotations the subscribers get once the mutex is released
+	seen        map[string]string    //The active kid of every ring Run saw last
+	active      map[string]cachedKey //The active key of every ring ActiveKey read
+}
+
+//NewKeyManager - A manager that rotates on demand. The replaced keys
+//verify for a day, longer than the tokens live by default.
+func NewKeyManager() *KeyManager {
+	km := new(KeyManager)
+	km.Overlap = 24 * time.Hour
+	km.CheckInterval = time.Minute
+	km.ActiveKeyTTL = keyCacheTTL
+	km.seen = make(map[string]string)
+	km.active = make(map[string]cachedKey)
+	return km
+}
+
+//Keys - The KeyManager of the edgeauth
+var Keys = NewKeyManager()
+
+//Subscribe - fn is called after every change of the keys, e.g. to tell
+//the remote sites to fetch the new public keys
+func (km *KeyManager) Subscribe(fn func(KeyRotation)) {
+	km.mutex.Lock()
+	km.subscribers = append(km.subscribers, fn)
+	km.mutex.Unlock()
+}
+
+//notify - Must be called with the mutex held. The active key of the ring
+//is read again on the next ActiveKey, the subscribers get the rotation
+//when unlock releases the mutex.
+func (km *KeyManager) notify(rotation KeyRotation) {
+	name := rotation.CompanyID + "/" + rotation.Algorithm
+	km.seen[name] = rotation.Active
+	delete(km.active, name)
+	km.pending = append(km.pending, rotation)
+}
+
+//unlock - Release the mutex, then call the subscribers with the rotations
+//made while it was held. A slow subscriber, e.g. an SSE client that stopped
+//reading, does not hold up the tokens waiting for ActiveKey.
+func (km *KeyManager) unlock() {
+	pending := km.pending
+	subscribers := km.subscribers
+	km.pending = nil
+	km.mutex.Unlock()
+
+	for _, rotation := range pending {
+		for _, fn := range subscribers {
+			fn(rotation)
+		}
+	}
+}
+
+//forget - Drop the cached active keys
+func (km *KeyManager) forget() {
+	km.mutex.Lock()
+	km.active = make(map[string]cachedKey)
+	km.mutex.Unlock()
+}
+
+//keyRing - The keys of one company and algorithm
+type keyRing struct {
+	companyID string
+	algorithm string
+	keys      []*SigningKey
+}
+
+func (ring *keyRing) withStatus(status string) []*SigningKey {
+	var keys []*SigningKey
+	for _, k := range ring.keys {
+		if k.Status == status {
+			keys = append(keys, k)
+		}
+	}
+	//The newest first
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Version > keys[j].Version })
+	return keys
+}
+
+func (ring *keyRing) lastVersion() int {
+	version := 0
+	for _, k := range ring.keys {
+		if k.Version > version {
+			version = k.Version
+		}
+	}
+	return version
+}
+
+//rotation - The state of the ring for the subscribers
+func (ring *keyRing) rotation(retired []string) KeyRotation {
+	r := KeyRotation{CompanyID: ring.companyID, Algorithm: ring.algorithm, Retired: retired}
+	if active := ring.withStatus(KeyStatusActive); len(active) > 0 {
+		r.Active = active[0].KeyID
+	}
+	if next := ring.withStatus(KeyStatusNext); len(next) > 0 {
+		r.Next = next[0].KeyID
+	}
+	for _, k := range ring.withStatus(KeyStatusPrevious) {
+		r.Previous = append(r.Previous, k.KeyID)
+	}
+	return r
+}
+
+//newKey - Create and store the next version of the ring
+func (ring *keyRing) newKey(ctx context.Context, status string) (*SigningKey, error) {
+	key, err := NewSigningKey(ring.companyID, ring.algorithm)
+	if err != nil {
+		return nil, err
+	}
+	key.Version = ring.lastVersion() + 1
+	key.Status = status
+	if status == KeyStatusActive {
+		key.ActivatedAt = key.CreatedAt
+	}
+
+	err = InsertSigningKey(ctx, key)
+	if err != nil {
+		log.Printf("The signing key could not be saved: [%s]", err)
+		return nil, err
+	}
+
+	ring.keys = append(ring.keys, key)
+	return key, nil
+}
+
+//loadRings - The keys of the company grouped by algorithm, an empty
+//algorithm loads all of them
+func loadRings(ctx context.Context, companyID string) (map[string]*keyRing, error) {
+	keys, err := ListSigningKeysByCompanyID(ctx, companyID)
+	if err != nil {
+		log.Printf("The signing keys of [%s] could not be listed: [%s]", companyID, err)
+		return nil, err
+	}
+	return groupRings(keys), nil
+}
+
+func groupRings(keys []SigningKey) map[string]*keyRing {
+	rings := make(map[string]*keyRing)
+	for i := range keys {
+		k := &keys[i]
+		name := k.CompanyID + "/" + k.Algorithm
+		ring, ok := rings[name]
+		if !ok {
+			ring = &keyRing{companyID: k.CompanyID, algorithm: k.Algorithm}
+			rings[name] = ring
+		}
+		ring.keys = append(ring.keys, k)
+	}
+	return rings
+}
+
+/*
+ActiveKey - The key signing the tokens of the company (the site for an
+empty companyID) with the algorithm. The first call creates the active
+and the next key. The key is cached for the ActiveKeyTTL or until the
+ring rotates, the rotations of other processes are seen by RotateDue.
+*/
+func (km *KeyManager) ActiveKey(ctx context.Context, companyID string, algorithm string) (*SigningKey, error) {
+
+	km.mutex.Lock()
+	defer km.unlock()
+
+	name := companyID + "/" + algorithm
+	cached, ok := km.active[name]
+	if ok && time.Since(cached.loaded) < km.ActiveKeyTTL {
+		return cached.key, nil
+	}
+
+	key, err := km.loadActiveKey(ctx, companyID, algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	km.active[name] = cachedKey{key: key, loaded: time.Now()}
+	return key, nil
+}
+
+//loadActiveKey - Read the ring and create its keys if there are none.
+//Must be called with the mutex held.
+func (km *KeyManager) loadActiveKey(ctx context.Context, companyID string, algorithm string) (*SigningKey, error) {
+
+	rings, err := loadRings(ctx, companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	ring, ok := rings[companyID+"/"+algorithm]
+	if ok {
+		if active := ring.withStatus(KeyStatusActive); len(active) > 0 {
+			return FindSigningKeyByKeyID(ctx, active[0].KeyID)
+		}
+	} else {
+		ring = &keyRing{companyID: companyID, algorithm: algorithm}
+	}
+
+	log.Printf("Creating the %s signing keys for [%s]", algorithm, companyID)
+	_, err = km.rotate(ctx, ring)
+	if err != nil {
+		return nil, err
+	}
+
+	active := ring.withStatus(KeyStatusActive)
+	return FindSigningKeyByKeyID(ctx, active[0].KeyID)
+}
+
+/*
+rotate - Activate the next key (a new one if there is none), create the
+new next key and turn the active one into a previous key. The keys are
+saved in that order so there is an active key at every step. Must be
+called with the mutex held.
+*/
+func (km *KeyManager) rotate(ctx context.Context, ring *keyRing) (KeyRotation, error) {
+
+	now := time.Now()
+	replaced := ring.withStatus(KeyStatusActive)
+
+	var active *SigningKey
+	if next := ring.withStatus(KeyStatusNext); len(next) > 0 {
+		active = next[0]
+		active.Status = KeyStatusActive
+		active.ActivatedAt = now.Unix()
+		err := SaveSigningKey(ctx, active)
+		if err != nil {
+			log.Printf("The signing key [%s] could not be activated: [%s]", active.KeyID, err)
+			return KeyRotation{}, err
+		}
+	} else {
+		var err error
+		active, err = ring.newKey(ctx, KeyStatusActive)
+		if err != nil {
+			return KeyRotation{}, err
+		}
+	}
+
+	_, err := ring.newKey(ctx, KeyStatusNext)
+	if err != nil {
+		return KeyRotation{}, err
+	}
+
+	for _, k := range replaced {
+		k.Status = KeyStatusPrevious
+		k.RetiresAt = now.Add(km.Overlap).Unix()
+		err = SaveSigningKey(ctx, k)
+		if err != nil {
+			log.Printf("The signing key [%s] could not be replaced: [%s]", k.KeyID, err)
+			return KeyRotation{}, err
+		}
+	}
+
+	retired, err := retireExpired(ctx, ring, now)
+	if err != nil {
+		return KeyRotation{}, err
+	}
+
+	log.Printf("The %s signing key of [%s] is now [%s] version %d", ring.algorithm, ring.companyID, active.KeyID, active.Version)
+	rotation := ring.rotation(retired)
+	km.notify(rotation)
+	return rotation, nil
+}
+
+//retireExpired - Retire the previous keys past their RetiresAt
+func retireExpired(ctx context.Context, ring *keyRing, now time.Time) ([]string, error) {
+	var retired []string
+	for _, k := range ring.withStatus(KeyStatusPrevious) {
+		if k.CanVerify(now) {
+			continue
+		}
+		k.Status = KeyStatusRetired
+		err := SaveSigningKey(ctx, k)
+		if err != nil {
+			log.Printf("The signing key [%s] could not be retired: [%s]", k.KeyID, err)
+			return retired, err
+		}
+		retired = append(retired, k.KeyID)
+	}
+	return retired, nil
+}
+
+//Rotate - Rotate every algorithm the company (the site for an empty
+//companyID) signs with. This is the admin command.
+func (km *KeyManager) Rotate(ctx context.Context, companyID string) ([]KeyRotation, error) {
+
+	km.mutex.Lock()
+	defer km.unlock()
+
+	rings, err := loadRings(ctx, companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	var rotations []KeyRotation
+	for _, ring := range rings {
+		if len(ring.withStatus(KeyStatusActive)) == 0 {
+			continue
+		}
+		rotation, err := km.rotate(ctx, ring)
+		if err != nil {
+			return rotations, err
+		}
+		rotations = append(rotations, rotation)
+	}
+
+	if len(rotations) == 0 {
+		return nil, ErrNotFound
+	}
+	return rotations, nil
+}
+
+/*
+RotateDue - Rotate the keys that signed for longer than the
+RotationInterval and retire the previous keys past their RetiresAt. The
+changes other processes made, e.g. the keys command, are sent to the
+subscribers as well.
+*/
+func (km *KeyManager) RotateDue(ctx context.Context) error {
+
+	km.mutex.Lock()
+	defer km.unlock()
+
+	keys, err := ListSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for name, ring := range groupRings(keys) {
+		active := ring.withStatus(KeyStatusActive)
+		if len(active) == 0 {
+			continue
+		}
+
+		due := time.Unix(active[0].ActivatedAt, 0).Add(km.RotationInterval)
+		if km.RotationInterval > 0 && now.After(due) {
+			_, err = km.rotate(ctx, ring)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		retired, err := retireExpired(ctx, ring, now)
+		if err != nil {
+			return err
+		}
+
+		seen, ok := km.seen[name]
+		if len(retired) > 0 || (ok && seen != active[0].KeyID) {
+			km.notify(ring.rotation(retired))
+		}
+		km.seen[name] = active[0].KeyID
+	}
+
+	return nil
+}
+
+//Run - Call RotateDue every CheckInterval in the background
+func (km *KeyManager) Run() {
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			err := km.RotateDue(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("The signing keys could not be rotated: [%s]", err)
+			}
+			time.Sleep(km.CheckInterval)
+		}
+	}()
+}
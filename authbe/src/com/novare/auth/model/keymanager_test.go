@@ -0,0 +1,253 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestKeyManagerRotate(t *testing.T) {
+	ctx := context.Background()
+
+	company := NewCompany()
+	company.Name = "KEY MANAGER COMPANY"
+	company.UniqueID = "KEYMANAGERCOMPANY"
+	err := InsertCompany(ctx, company)
+	if err != nil {
+		t.Errorf("The company could not be inserted: [%s]", err)
+		return
+	}
+	defer RemoveCompanyByID(ctx, company.ID.Hex())
+	companyID := company.ID.Hex()
+
+	km := NewKeyManager()
+	var rotations []KeyRotation
+	km.Subscribe(func(r KeyRotation) {
+		rotations = append(rotations, r)
+	})
+
+	first, err := km.ActiveKey(ctx, companyID, AlgES256)
+	if err != nil {
+		t.Errorf("The keys could not be created: [%s]", err)
+		return
+	}
+
+	if first.Status != KeyStatusActive || first.Version != 1 || len(rotations) != 1 || rotations[0].Next == "" {
+		t.Errorf("The first key is invalid: [%s] %d %+v", first.Status, first.Version, rotations)
+		return
+	}
+	next := rotations[0].Next
+
+	jwt := NewJWTToken("USERID", companyID)
+	jwt.Payload.Company = company.UniqueID
+	encoded, err := jwt.SignJWT(first)
+	if err != nil {
+		t.Errorf("The token could not be signed: [%s]", err)
+		return
+	}
+
+	_, err = km.Rotate(ctx, companyID)
+	if err != nil {
+		t.Errorf("The keys could not be rotated: [%s]", err)
+		return
+	}
+
+	active, err := km.ActiveKey(ctx, companyID, AlgES256)
+	if err != nil || active.KeyID != next {
+		t.Errorf("The next key should be active: [%v]", err)
+		return
+	}
+
+	last := rotations[len(rotations)-1]
+	if last.Active != next || len(last.Previous) != 1 || last.Previous[0] != first.KeyID {
+		t.Errorf("The rotation event is invalid: %+v", last)
+	}
+
+	//The token signed before the rotation keeps verifying
+	parsed := NewJWTToken("", "")
+	parsed.ParseJWT(encoded)
+	err = VerifyJWTSignature(ctx, parsed)
+	if err != nil {
+		t.Errorf("The token of the previous key did not verify: [%s]", err)
+	}
+
+	set, err := GetJSONWebKeySet(ctx)
+	if err != nil {
+		t.Errorf("The key set could not be read: [%s]", err)
+		return
+	}
+
+	published := map[string]bool{}
+	for _, k := range set.Keys {
+		published[k.KeyID] = true
+	}
+	if !published[first.KeyID] || !published[next] || !published[last.Next] {
+		t.Errorf("The previous, active and next keys must be published")
+	}
+
+	//Once the overlap has passed the previous key is retired
+	keys, _ := ListSigningKeysByCompanyID(ctx, companyID)
+	for i := range keys {
+		if keys[i].KeyID == first.KeyID {
+			keys[i].RetiresAt = time.Now().Add(-time.Minute).Unix()
+			SaveSigningKey(ctx, &keys[i])
+		}
+	}
+
+	err = km.RotateDue(ctx)
+	if err != nil {
+		t.Errorf("The expired keys could not be retired: [%s]", err)
+		return
+	}
+
+	last = rotations[len(rotations)-1]
+	if len(last.Retired) != 1 || last.Retired[0] != first.KeyID || last.Active != next {
+		t.Errorf("The first key should have been retired: %+v", last)
+	}
+
+	if VerifyJWTSignature(ctx, parsed) != ErrInvalidSignature {
+		t.Errorf("The token of a retired key was verified")
+	}
+
+	_, err = km.Rotate(ctx, primitive.NewObjectID().Hex())
+	if err != ErrNotFound {
+		t.Errorf("A company without keys was rotated: [%v]", err)
+	}
+}
+
+func TestKeyManagerRotateDue(t *testing.T) {
+	ctx := context.Background()
+	companyID := primitive.NewObjectID().Hex()
+
+	km := NewKeyManager()
+	first, err := km.ActiveKey(ctx, companyID, AlgRS256)
+	if err != nil {
+		t.Errorf("The keys could not be created: [%s]", err)
+		return
+	}
+
+	//Not due yet
+	km.RotationInterval = time.Hour
+	err = km.RotateDue(ctx)
+	if err != nil {
+		t.Errorf("The due keys could not be rotated: [%s]", err)
+	}
+
+	active, _ := km.ActiveKey(ctx, companyID, AlgRS256)
+	if active.KeyID != first.KeyID {
+		t.Errorf("The key was rotated before it was due")
+	}
+
+	km.RotationInterval = time.Nanosecond
+	err = km.RotateDue(ctx)
+	if err != nil {
+		t.Errorf("The due keys could not be rotated: [%s]", err)
+	}
+
+	active, _ = km.ActiveKey(ctx, companyID, AlgRS256)
+	if active.KeyID == first.KeyID || active.Version != 2 {
+		t.Errorf("The key should have been rotated: %d", active.Version)
+	}
+}
+
+func TestKeyManagerActiveKeyCache(t *testing.T) {
+	ctx := context.Background()
+	companyID := primitive.NewObjectID().Hex()
+
+	km := NewKeyManager()
+	first, err := km.ActiveKey(ctx, companyID, AlgES256)
+	if err != nil {
+		t.Errorf("The keys could not be created: [%s]", err)
+		return
+	}
+
+	//The keys command of another process rotates the keys
+	other := NewKeyManager()
+	_, err = other.Rotate(ctx, companyID)
+	if err != nil {
+		t.Errorf("The keys could not be rotated: [%s]", err)
+		return
+	}
+
+	active, _ := km.ActiveKey(ctx, companyID, AlgES256)
+	if active.KeyID != first.KeyID {
+		t.Errorf("The active key should be cached")
+	}
+
+	//RotateDue sees the rotation and the cached key is dropped
+	err = km.RotateDue(ctx)
+	if err != nil {
+		t.Errorf("The due keys could not be checked: [%s]", err)
+	}
+	active, _ = km.ActiveKey(ctx, companyID, AlgES256)
+	if active.KeyID == first.KeyID {
+		t.Errorf("The rotated key is still cached")
+	}
+
+	//The cache expires after the ActiveKeyTTL
+	_, err = other.Rotate(ctx, companyID)
+	if err != nil {
+		t.Errorf("The keys could not be rotated: [%s]", err)
+		return
+	}
+	km.ActiveKeyTTL = 0
+	again, _ := km.ActiveKey(ctx, companyID, AlgES256)
+	if again.KeyID == active.KeyID {
+		t.Errorf("The expired key is still cached")
+	}
+}
+
+//The subscribers are called without the mutex, a slow one does not hold
+//up ActiveKey
+func TestKeyManagerSubscriberUnlocked(t *testing.T) {
+	ctx := context.Background()
+	companyID := primitive.NewObjectID().Hex()
+
+	km := NewKeyManager()
+	done := make(chan error, 1)
+	km.Subscribe(func(r KeyRotation) {
+		go func() {
+			_, err := km.ActiveKey(ctx, companyID, AlgES256)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("The active key could not be read: [%s]", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("ActiveKey waited for the subscriber")
+		}
+	})
+
+	_, err := km.ActiveKey(ctx, companyID, AlgES256)
+	if err != nil {
+		t.Errorf("The keys could not be created: [%s]", err)
+	}
+}
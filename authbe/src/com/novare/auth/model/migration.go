@@ -0,0 +1,393 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+)
+
+/*
+Migration - One step of the schema. Up brings the documents written by
+the previous version to this Version. It must be idempotent: a migration
+interrupted half way runs again from the start on the next attempt.
+*/
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context) error
+}
+
+//MigrationStatus - A registered migration and whether it already ran
+type MigrationStatus struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+	Applied     bool   `json:"applied"`
+}
+
+//migrations - The registry, kept ordered by version
+var migrations []Migration
+
+/*
+RegisterMigration - Add a migration to the registry. The versions must be
+unique, registering the same version twice is a programming error.
+*/
+func RegisterMigration(m Migration) {
+	for i := range migrations {
+		if migrations[i].Version == m.Version {
+			panic(fmt.Sprintf("The migration version %d is already registered", m.Version))
+		}
+	}
+
+	migrations = append(migrations, m)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+}
+
+//findConfig - Like GetConfig but the database errors are returned. A
+//site without a configuration gets a new one.
+func findConfig(ctx context.Context) (*Config, error) {
+	cfg := NewConfig()
+	err := mStore.Config().FindByRowID(ctx, cfg, cfg.RowID)
+	if errors.Is(err, ErrNotFound) {
+		return cfg, nil
+	}
+	return cfg, err
+}
+
+//GetSchemaVersion - The version of the stored documents, 0 when no
+//migration ran yet
+func GetSchemaVersion(ctx context.Context) (int, error) {
+	cfg, err := findConfig(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return cfg.SchemaVersion, nil
+}
+
+//ListMigrations - Every registered migration in the order they run
+func ListMigrations(ctx context.Context) ([]MigrationStatus, error) {
+	return listMigrations(ctx, migrations)
+}
+
+func listMigrations(ctx context.Context, migs []Migration) ([]MigrationStatus, error) {
+	version, err := GetSchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var status []MigrationStatus
+	for _, m := range migs {
+		status = append(status, MigrationStatus{
+			Version:     m.Version,
+			Description: m.Description,
+			Applied:     m.Version <= version,
+		})
+	}
+
+	return status, nil
+}
+
+/*
+Migrate - Apply the pending migrations in order. The schema version is
+saved after every migration, so a failure leaves the site at the last
+version that completed. It returns how many migrations were applied.
+*/
+func Migrate(ctx context.Context) (int, error) {
+	return runMigrations(ctx, migrations)
+}
+
+func runMigrations(ctx context.Context, migs []Migration) (int, error) {
+	cfg, err := findConfig(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, m := range migs {
+		if m.Version <= cfg.SchemaVersion {
+			continue
+		}
+
+		log.Printf("Applying the migration %d: %s", m.Version, m.Description)
+		err = m.Up(ctx)
+		if err != nil {
+			log.Printf("The migration %d failed: [%s]", m.Version, err)
+			return applied, err
+		}
+
+		cfg.SchemaVersion = m.Version
+		err = SaveConfig(ctx, cfg)
+		if err != nil {
+			log.Printf("The schema version %d could not be saved: [%s]", m.Version, err)
+			return applied, err
+		}
+		applied++
+	}
+
+	return applied, nil
+}
+
+func init() {
+	RegisterMigration(Migration{
+		Version:     1,
+		Description: "Users stored without a status are enabled",
+		Up:          migrateUserStatus,
+	})
+
+	RegisterMigration(Migration{
+		Version:     2,
+		Description: "Companies stored without a password unit expire passwords in years",
+		Up:          migratePassUnit,
+	})
+
+	RegisterMigration(Migration{
+		Version:     3,
+		Description: "Companies, users, roles and permissions get a revision",
+		Up:          migrateRevision,
+	})
+
+	RegisterMigration(Migration{
+		Version:     4,
+		Description: "Signing keys stored without a status are the active keys",
+		Up:          migrateSigningKeyStatus,
+	})
+
+	RegisterMigration(Migration{
+		Version:     5,
+		Description: "Companies get the INTROSPECT_TOKEN permission",
+		Up:          migrateDefaultPermissions,
+	})
+
+	RegisterMigration(Migration{
+		Version:     6,
+		Description: "Companies get the REVOKE_TOKEN permission",
+		Up:          migrateDefaultPermissions,
+	})
+
+	RegisterMigration(Migration{
+		Version:     7,
+		Description: "Companies get the LIST_SESSION and TERMINATE_SESSION permissions",
+		Up:          migrateDefaultPermissions,
+	})
+
+	RegisterMigration(Migration{
+		Version:     8,
+		Description: "Companies get the OAuth client permissions",
+		Up:          migrateDefaultPermissions,
+	})
+}
+
+func migrateUserStatus(ctx context.Context) error {
+	companies, err := ListCompanies(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range companies {
+		users, err := ListUsersByCompanyID(ctx, companies[i].ID.Hex())
+		if err != nil {
+			return err
+		}
+
+		for j := range users {
+			if len(users[j].UserStatus) > 0 {
+				continue
+			}
+
+			users[j].UserStatus = UserStateEnable
+			err = mStore.Users().Save(ctx, &users[j], users[j].ID.Hex())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func migratePassUnit(ctx context.Context) error {
+	companies, err := ListCompanies(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range companies {
+		if len(companies[i].Settings.PassUnit) > 0 {
+			continue
+		}
+
+		companies[i].Settings.PassUnit = PassUnitYear
+		err = mStore.Companies().Save(ctx, &companies[i], companies[i].ID.Hex())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//migrateRevision - SaveRevision matches the stored revision, so the
+//documents written before it existed must have one. Saving them as read
+//stores a 0 and keeps the revisions that are already there.
+func migrateRevision(ctx context.Context) error {
+	companies, err := ListCompanies(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range companies {
+		companyID := companies[i].ID.Hex()
+		err = mStore.Companies().Save(ctx, &companies[i], companyID)
+		if err != nil {
+			return err
+		}
+
+		users, err := ListUsersByCompanyID(ctx, companyID)
+		if err != nil {
+			return err
+		}
+		for j := range users {
+			err = mStore.Users().Save(ctx, &users[j], users[j].ID.Hex())
+			if err != nil {
+				return err
+			}
+		}
+
+		roles, err := ListRolesByCompanyID(ctx, companyID)
+		if err != nil {
+			return err
+		}
+		for j := range roles {
+			err = mStore.Roles().Save(ctx, &roles[j], roles[j].ID.Hex())
+			if err != nil {
+				return err
+			}
+		}
+
+		perms, err := ListPermissionsByCompanyID(ctx, companyID)
+		if err != nil {
+			return err
+		}
+		for j := range perms {
+			err = mStore.Permissions().Save(ctx, &perms[j], perms[j].ID.Hex())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//migrateSigningKeyStatus - The keys created before the KeyManager signed
+//the tokens, they become version 1 of their company and algorithm
+func migrateSigningKeyStatus(ctx context.Context) error {
+	keys, err := ListSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range keys {
+		if len(keys[i].Status) > 0 {
+			continue
+		}
+
+		keys[i].Status = KeyStatusActive
+		keys[i].Version = 1
+		keys[i].ActivatedAt = keys[i].CreatedAt
+		err = SaveSigningKey(ctx, &keys[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+migrateDefaultPermissions - The companies created before a permission was
+added to the DefaultPermissions get it, and so does their DefaultRoleName
+role. Every migration adding a default permission runs it again.
+*/
+func migrateDefaultPermissions(ctx context.Context) error {
+	companies, err := ListCompanies(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range companies {
+		companyID := companies[i].ID.Hex()
+		perms, err := ListPermissionsByCompanyID(ctx, companyID)
+		if err != nil {
+			return err
+		}
+
+		defined := make(map[string]bool)
+		for _, p := range perms {
+			defined[p.Permission] = true
+		}
+
+		var added []*Permission
+		for _, p := range NewDefaultPermissions(companyID) {
+			if defined[p.Permission] {
+				continue
+			}
+			err = InsertPermission(ctx, p)
+			if err != nil {
+				return err
+			}
+			added = append(added, p)
+		}
+
+		if len(added) == 0 {
+			continue
+		}
+
+		roles, err := ListRolesByCompanyID(ctx, companyID)
+		if err != nil {
+			return err
+		}
+
+		for j := range roles {
+			if roles[j].Description != DefaultRoleName {
+				continue
+			}
+			for _, p := range added {
+				roles[j].AddPermission(*p)
+			}
+			err = SaveRole(ctx, &roles[j])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRunMigrations(t *testing.T) {
+	ctx := context.Background()
+
+	start, err := GetSchemaVersion(ctx)
+	if err != nil {
+		t.Errorf("The schema version could not be read: [%s]", err)
+		return
+	}
+
+	calls := 0
+	fail := true
+	migs := []Migration{
+		{Version: start + 1, Description: "First", Up: func(ctx context.Context) error {
+			calls++
+			return nil
+		}},
+		{Version: start + 2, Description: "Second", Up: func(ctx context.Context) error {
+			if fail {
+				return errors.New("Failed")
+			}
+			calls++
+			return nil
+		}},
+	}
+
+	applied, err := runMigrations(ctx, migs)
+	if err == nil || applied != 1 {
+		t.Errorf("The second migration should have failed: %d [%v]", applied, err)
+	}
+
+	version, _ := GetSchemaVersion(ctx)
+	if version != start+1 {
+		t.Errorf("The schema version should be %d, it is %d", start+1, version)
+	}
+
+	status, err := listMigrations(ctx, migs)
+	if err != nil || len(status) != 2 || !status[0].Applied || status[1].Applied {
+		t.Errorf("The migration status is not valid: %v [%v]", status, err)
+	}
+
+	fail = false
+	applied, err = runMigrations(ctx, migs)
+	if err != nil || applied != 1 || calls != 2 {
+		t.Errorf("Only the pending migration should have run: %d %d [%v]", applied, calls, err)
+	}
+
+	applied, err = runMigrations(ctx, migs)
+	if err != nil || applied != 0 {
+		t.Errorf("There should be nothing left to apply: %d [%v]", applied, err)
+	}
+}
+
+func TestMigrateUserStatus(t *testing.T) {
+	ctx := context.Background()
+
+	company := NewCompany()
+	company.UniqueID = "MIGRATIONCOMPANY"
+	err := InsertCompany(ctx, company)
+	if err != nil {
+		t.Errorf("The company was not inserted: [%s]", err)
+		return
+	}
+	defer RemoveCompanyByID(ctx, company.ID.Hex())
+
+	user := NewUser()
+	user.Username = "MIGRATIONUSER"
+	user.CompanyID = company.ID.Hex()
+	user.UserStatus = ""
+	err = mStore.Users().Insert(ctx, user, user.ID.Hex())
+	if err != nil {
+		t.Errorf("The user was not inserted: [%s]", err)
+		return
+	}
+	defer RemoveUserByID(ctx, user.ID.Hex())
+
+	err = migrateUserStatus(ctx)
+	if err != nil {
+		t.Errorf("The migration failed: [%s]", err)
+	}
+
+	err = migratePassUnit(ctx)
+	if err != nil {
+		t.Errorf("The migration failed: [%s]", err)
+	}
+
+	stored, err := FindUserByID(ctx, user.ID.Hex())
+	if err != nil || stored.UserStatus != UserStateEnable {
+		t.Errorf("The user status was not migrated: [%v]", err)
+	}
+
+	storedCompany, err := FindCompanyByID(ctx, company.ID.Hex())
+	if err != nil || storedCompany.Settings.PassUnit != PassUnitYear {
+		t.Errorf("The password unit was not migrated: [%v]", err)
+	}
+}
+
+func TestMigrateDefaultPermissions(t *testing.T) {
+	ctx := context.Background()
+
+	company := NewCompany()
+	company.UniqueID = "MIGRATIONPERMISSIONS"
+	err := InsertCompany(ctx, company)
+	if err != nil {
+		t.Errorf("The company was not inserted: [%s]", err)
+		return
+	}
+	defer RemoveCompanyByID(ctx, company.ID.Hex())
+
+	//A company created when only the first permission existed
+	perms := NewDefaultPermissions(company.ID.Hex())[:1]
+	InsertPermission(ctx, perms[0])
+	role := NewDefaultRole(company.ID.Hex(), perms)
+	InsertRole(ctx, role)
+
+	err = migrateDefaultPermissions(ctx)
+	if err != nil {
+		t.Errorf("The migration failed: [%s]", err)
+		return
+	}
+
+	stored, err := ListPermissionsByCompanyID(ctx, company.ID.Hex())
+	if err != nil || len(stored) != len(DefaultPermissions) {
+		t.Errorf("The permissions were not added: %d [%v]", len(stored), err)
+	}
+
+	storedRole, err := FindRoleByID(ctx, role.ID.Hex())
+	if err != nil || !storedRole.IsGranted("INTROSPECT_TOKEN") || len(storedRole.Permissions) != len(DefaultPermissions) {
+		t.Errorf("The role did not get the new permissions: [%v]", err)
+	}
+
+	for i := range stored {
+		RemovePermissionByID(ctx, stored[i].ID.Hex())
+	}
+	RemoveRoleByID(ctx, role.ID.Hex())
+}
@@ -0,0 +1,334 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"com/novare/utils"
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	//DefaultClientTokenDuration - Seconds the access tokens of a client
+	//live when it does not say otherwise
+	DefaultClientTokenDuration int64 = 300
+	//MaxClientTokenDuration - The longest the access tokens of a client can
+	//live, they are meant to be short lived
+	MaxClientTokenDuration int64 = 3600
+)
+
+var (
+	//ErrInvalidClient - No client has the client ID and secret
+	ErrInvalidClient = errors.New("InvalidClient")
+	//ErrInvalidScope - The scope asked for is not allowed to the client
+	ErrInvalidScope = errors.New("InvalidScope")
+)
+
+/*
+OAuthClient - A device or service getting access tokens with the
+client_credentials grant, or an app signing users in with the
+authorization code grant. With the first it acts as a thing of the
+company: the tokens carry the thing as their subject and only the Scopes
+the client is allowed. With the second the users log in on the edgeauth
+page and the app gets a session limited to the Scopes; the codes are
+only sent to the RedirectURIs. The ID is the client_id, only the hash of
+the secret is stored. A Public client (a native or browser app) can not
+keep a secret and has none, PKCE protects its codes.
+*/
+type OAuthClient struct {
+	ID            primitive.ObjectID `bson:"_id" json:"id"`
+	CompanyID     string             `json:"companyID"`
+	UserID        string             `json:"userID"` //The thing the client acts as, empty for the apps
+	Name          string             `json:"name"`
+	SecretHash    []byte             `json:"-"`
+	Scopes        []string           `json:"scopes"`        //The permissions the client can ask for
+	TokenDuration int64              `json:"tokenDuration"` //Seconds the access tokens live, 0 = DefaultClientTokenDuration
+	RedirectURIs  []string           `json:"redirectURIs"`  //Where the authorization codes can be sent
+	Public        bool               `json:"public"`        //No secret, only the authorization code grant
+	CreatedAt     int64              `json:"createdAt"`
+	Revision      int64              `json:"revision"` //Incremented by every save, see SaveOAuthClient
+}
+
+//NewOAuthClient - A client acting as the thing of the company
+func NewOAuthClient(companyID string, userID string) *OAuthClient {
+	client := new(OAuthClient)
+	client.ID = primitive.NewObjectID()
+	client.CompanyID = companyID
+	client.UserID = userID
+	client.CreatedAt = time.Now().Unix()
+	return client
+}
+
+//ClientID - The client_id the client authenticates with
+func (client *OAuthClient) ClientID() string {
+	return client.ID.Hex()
+}
+
+//SetSecret - Generate a new secret for the client. The secret is returned
+//once, only its hash is kept.
+func (client *OAuthClient) SetSecret() (string, error) {
+	secret := strings.TrimRight(utils.GenerateUniqueID(), "=")
+	if utf8.RuneCountInString(secret) == 0 {
+		return "", errors.New("NoRandomSecret")
+	}
+
+	hash, ok := utils.GetPassword(secret, client.ClientID())
+	if !ok {
+		return "", errors.New("SecretNotHashed")
+	}
+
+	client.SecretHash = hash
+	return secret, nil
+}
+
+//IsSecretMatch - The secret is the one of the client
+func (client *OAuthClient) IsSecretMatch(secret string) bool {
+	return len(client.SecretHash) > 0 && utils.IsValidPassword(secret, client.ClientID(), client.SecretHash)
+}
+
+//HasRedirectURI - The redirect_uri is one the client registered, the
+//comparison is exact
+func (client *OAuthClient) HasRedirectURI(uri string) bool {
+	for _, registered := range client.RedirectURIs {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+isValidRedirectURI - An absolute URI without a fragment. Plain http is
+only accepted on the loopback, for the native apps and development; the
+custom schemes of the native apps are accepted.
+*/
+func isValidRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || strings.Contains(uri, "#") {
+		return false
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "javascript", "data", "vbscript", "file":
+		return false
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return true
+}
+
+//GetTokenDuration - How long the access tokens of the client live
+func (client *OAuthClient) GetTokenDuration() time.Duration {
+	if client.TokenDuration <= 0 {
+		return time.Duration(DefaultClientTokenDuration) * time.Second
+	}
+	return time.Duration(client.TokenDuration) * time.Second
+}
+
+/*
+validate - The client belongs to a company and a thing or has redirect
+URIs, its token duration is within the MaxClientTokenDuration and its
+scopes are permissions of the company.
+*/
+func (client *OAuthClient) validate(ctx context.Context) error {
+
+	if utf8.RuneCountInString(client.CompanyID) == 0 {
+		return NewInputError("InvalidClient")
+	}
+
+	//A thing, an app or both, a public client can not act as a thing
+	hasThing := utf8.RuneCountInString(client.UserID) > 0
+	if (!hasThing && len(client.RedirectURIs) == 0) || (hasThing && client.Public) {
+		return NewInputError("InvalidClient")
+	}
+
+	for _, uri := range client.RedirectURIs {
+		if !isValidRedirectURI(uri) {
+			log.Printf("The redirect URI [%s] of the client is not valid", uri)
+			return NewInputError("InvalidRedirectURI")
+		}
+	}
+
+	if client.TokenDuration < 0 || client.TokenDuration > MaxClientTokenDuration {
+		return NewInputError("InvalidTokenDuration")
+	}
+
+	perms, err := ListPermissionsByCompanyID(ctx, client.CompanyID)
+	if err != nil {
+		return err
+	}
+
+	catalog := make(map[string]bool)
+	for _, p := range perms {
+		catalog[p.Permission] = true
+	}
+
+	var scopes []string
+	seen := make(map[string]bool)
+	for _, s := range client.Scopes {
+		if !catalog[s] {
+			log.Printf("The scope [%s] is not a permission of the company [%s]", s, client.CompanyID)
+			return NewInputError("UnknownScope")
+		}
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	client.Scopes = scopes
+
+	return nil
+}
+
+//InsertOAuthClient - Register the client
+func InsertOAuthClient(ctx context.Context, client *OAuthClient) error {
+
+	err := client.validate(ctx)
+	if err != nil {
+		return err
+	}
+
+	return mStore.OAuthClients().Insert(ctx, client, client.ID.Hex())
+}
+
+//SaveOAuthClient - Save the client. ErrConflict when it was changed since
+//it was read.
+func SaveOAuthClient(ctx context.Context, client *OAuthClient) error {
+
+	err := client.validate(ctx)
+	if err != nil {
+		return err
+	}
+
+	return saveRevision(&client.Revision, func(expected int64) error {
+		return mStore.OAuthClients().SaveRevision(ctx, client, client.ID.Hex(), expected)
+	})
+}
+
+//FindOAuthClientByID - The client with the client_id
+func FindOAuthClientByID(ctx context.Context, clientID string) (*OAuthClient, error) {
+
+	if !primitive.IsValidObjectID(clientID) {
+		return nil, NewInputError("InvalidID")
+	}
+
+	client := new(OAuthClient)
+	err := mStore.OAuthClients().FindByID(ctx, client, clientID)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+//ListOAuthClientsByCompanyID - The clients registered by the company
+func ListOAuthClientsByCompanyID(ctx context.Context, companyID string) ([]OAuthClient, error) {
+
+	clients := []OAuthClient{}
+	err := mStore.OAuthClients().ListByCompanyID(ctx, &clients, companyID)
+	return clients, err
+}
+
+//RemoveOAuthClientByID - Remove the client, its access tokens live on
+//until they expire
+func RemoveOAuthClientByID(ctx context.Context, clientID string) error {
+
+	if !primitive.IsValidObjectID(clientID) {
+		return NewInputError("InvalidID")
+	}
+
+	return mStore.OAuthClients().RemoveByID(ctx, clientID)
+}
+
+/*
+AuthenticateClient - The client with the client_id and secret. An unknown
+client and a wrong secret are both ErrInvalidClient. A Public client has
+no secret, the one sent is not checked.
+*/
+func AuthenticateClient(ctx context.Context, clientID string, secret string) (*OAuthClient, error) {
+
+	client, err := FindOAuthClientByID(ctx, clientID)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidInput) {
+		log.Printf("The client [%s] is not registered", clientID)
+		return nil, ErrInvalidClient
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !client.Public && !client.IsSecretMatch(secret) {
+		log.Printf("The secret of the client [%s] does not match", clientID)
+		return nil, ErrInvalidClient
+	}
+
+	return client, nil
+}
+
+/*
+GrantScope - The scope of an access token for the client acting as the
+user. requested is space separated, empty asks for every scope of the
+client. A scope is granted when the client is allowed it and the thing
+still holds the permission; asking for one that is not is
+ErrInvalidScope, as is ending up with no scope at all. The OpenIDScopes
+are not permissions, they are always granted, and asking only for them
+grants no permission.
+*/
+func (client *OAuthClient) GrantScope(ctx context.Context, user *User, requested string) (string, error) {
+
+	allowed := make(map[string]bool)
+	for _, s := range client.Scopes {
+		allowed[s] = true
+	}
+
+	explicit := utf8.RuneCountInString(strings.TrimSpace(requested)) > 0
+	scopes := client.Scopes
+	if explicit {
+		scopes = strings.Fields(requested)
+	}
+
+	var granted []string
+	for _, s := range scopes {
+		if IsOpenIDScope(s) || allowed[s] && user.IsGranted(ctx, s) {
+			granted = append(granted, s)
+			continue
+		}
+		if explicit {
+			log.Printf("The scope [%s] is not allowed to the client [%s]", s, client.ClientID())
+			return "", ErrInvalidScope
+		}
+	}
+
+	if len(granted) == 0 {
+		return "", ErrInvalidScope
+	}
+	return strings.Join(granted, " "), nil
+}
@@ -0,0 +1,124 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestOAuthClient(t *testing.T) {
+	ctx := context.Background()
+
+	companyID := primitive.NewObjectID().Hex()
+	for _, name := range []string{"READ_SENSOR", "WRITE_SENSOR"} {
+		perm := NewPermission()
+		perm.CompanyID = companyID
+		perm.Permission = name
+		err := InsertPermission(ctx, perm)
+		if err != nil {
+			t.Errorf("The permission [%s] could not be inserted: [%s]", name, err)
+			return
+		}
+		defer RemovePermissionByID(ctx, perm.ID.Hex())
+	}
+
+	thing := NewUser()
+	thing.CompanyID = companyID
+	thing.Username = "SENSOR01"
+	thing.IsThing = true
+	thing.Permissions = []Permission{{Permission: "READ_SENSOR"}}
+
+	client := NewOAuthClient(companyID, thing.ID.Hex())
+	client.Scopes = []string{"READ_SENSOR", "UNKNOWN_SENSOR"}
+	err := InsertOAuthClient(ctx, client)
+	if err == nil || err.Error() != "UnknownScope" {
+		t.Errorf("A scope outside of the company permissions should be rejected: [%v]", err)
+	}
+
+	client.Scopes = []string{"READ_SENSOR", "WRITE_SENSOR", "READ_SENSOR"}
+	client.TokenDuration = MaxClientTokenDuration + 1
+	err = InsertOAuthClient(ctx, client)
+	if err == nil || err.Error() != "InvalidTokenDuration" {
+		t.Errorf("The token duration should be limited: [%v]", err)
+	}
+
+	client.TokenDuration = 0
+	secret, err := client.SetSecret()
+	if err != nil || len(secret) == 0 {
+		t.Errorf("The secret was not generated: [%v]", err)
+		return
+	}
+	err = InsertOAuthClient(ctx, client)
+	if err != nil {
+		t.Errorf("The client could not be inserted: [%s]", err)
+		return
+	}
+	defer RemoveOAuthClientByID(ctx, client.ClientID())
+
+	if len(client.Scopes) != 2 || client.GetTokenDuration() != time.Duration(DefaultClientTokenDuration)*time.Second {
+		t.Errorf("The duplicated scopes should be removed and the duration defaulted: %+v", client)
+	}
+
+	found, err := AuthenticateClient(ctx, client.ClientID(), secret)
+	if err != nil || found.UserID != thing.ID.Hex() {
+		t.Errorf("The client should authenticate with its secret: [%v]", err)
+	}
+
+	_, err = AuthenticateClient(ctx, client.ClientID(), secret+"X")
+	if !errors.Is(err, ErrInvalidClient) {
+		t.Errorf("A wrong secret should be an invalid client: [%v]", err)
+	}
+
+	_, err = AuthenticateClient(ctx, primitive.NewObjectID().Hex(), secret)
+	if !errors.Is(err, ErrInvalidClient) {
+		t.Errorf("An unknown client should be an invalid client: [%v]", err)
+	}
+
+	//The thing only holds READ_SENSOR
+	scope, err := client.GrantScope(ctx, thing, "")
+	if err != nil || scope != "READ_SENSOR" {
+		t.Errorf("Only the scopes the thing holds should be granted: [%s] [%v]", scope, err)
+	}
+
+	_, err = client.GrantScope(ctx, thing, "READ_SENSOR WRITE_SENSOR")
+	if !errors.Is(err, ErrInvalidScope) {
+		t.Errorf("Asking for a scope the thing does not hold should fail: [%v]", err)
+	}
+
+	_, err = client.GrantScope(ctx, thing, "ADD_USER")
+	if !errors.Is(err, ErrInvalidScope) {
+		t.Errorf("Asking for a scope the client is not allowed should fail: [%v]", err)
+	}
+
+	clients, err := ListOAuthClientsByCompanyID(ctx, companyID)
+	if err != nil || len(clients) != 1 {
+		t.Errorf("The client should be listed: [%v] %d", err, len(clients))
+	}
+}
@@ -0,0 +1,93 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"time"
+)
+
+//The scopes of OpenID Connect. They are not permissions: openid asks for
+//the ID token, profile for the name of the user in it and in the userinfo.
+const (
+	ScopeOpenID  string = "openid"
+	ScopeProfile string = "profile"
+)
+
+//OpenIDScopes - The OpenID Connect scopes an app can ask for besides the
+//permissions
+var OpenIDScopes = []string{ScopeOpenID, ScopeProfile}
+
+//MaxNonceLength - The longest nonce the authorize page takes, it is
+//copied to the ID token
+const MaxNonceLength = 255
+
+//TokenUseID - The token_use of the ID tokens. They are signed with the
+//same keys as the sessions and access tokens, the claim keeps them from
+//being accepted as one.
+const TokenUseID string = "id"
+
+//IsIDToken - The payload is the one of an ID token
+func (jwtp *JWTPayload) IsIDToken() bool {
+	return jwtp.TokenUse == TokenUseID
+}
+
+//IsOpenIDScope - The scope is one of the OpenIDScopes, not a permission
+func IsOpenIDScope(scope string) bool {
+	for _, s := range OpenIDScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+//IsOpenID - The code was granted the openid scope, its exchange returns an
+//ID token
+func (code *AuthorizationCode) IsOpenID() bool {
+	return hasScope(code.Scope, ScopeOpenID)
+}
+
+/*
+NewIDToken - The OpenID Connect ID token of the user who logged in for the
+code. Its aud is the client, the nonce and the auth_time come from the
+code, and the name, preferred_username and company are only set when the
+code was granted the profile scope. It only proves who logged in, it is
+not stored and can not be used as a session.
+*/
+func NewIDToken(user *User, company *Company, code *AuthorizationCode, duration time.Duration) *JWTToken {
+	idToken := NewJWTToken(user.ID.Hex(), company.ID.Hex())
+	idToken.Payload.TokenUse = TokenUseID
+	idToken.Payload.Audience = code.ClientID
+	idToken.Payload.ExpirationTime = time.Now().Add(duration).Unix()
+	idToken.Payload.Nonce = code.Nonce
+	idToken.Payload.AuthTime = code.CreatedAt
+
+	if hasScope(code.Scope, ScopeProfile) {
+		idToken.Payload.Name = user.Name
+		idToken.Payload.PreferredUsername = user.Username
+		idToken.Payload.Company = company.UniqueID
+	}
+	return idToken
+}
@@ -0,0 +1,68 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestOpenIDScopes(t *testing.T) {
+	ctx := context.Background()
+
+	company := NewCompany()
+	company.UniqueID = "OIDCID"
+	user := NewUser()
+	user.Username = "superuser"
+	user.Name = "Super User"
+	client := NewOAuthClient(company.ID.Hex(), "")
+	client.Scopes = []string{"GET_USER"}
+
+	scope, err := client.GrantScope(ctx, user, "openid profile GET_USER")
+	if err != nil || scope != "openid profile GET_USER" {
+		t.Errorf("The OpenID scopes should be granted with the permissions: [%s] [%v]", scope, err)
+	}
+
+	scope, err = client.GrantScope(ctx, user, "openid")
+	if err != nil || scope != "openid" {
+		t.Errorf("Asking only for openid should grant no permission: [%s] [%v]", scope, err)
+	}
+
+	code := &AuthorizationCode{ClientID: client.ClientID(), Scope: "openid GET_USER", Nonce: "N-0S6", CreatedAt: time.Now().Unix()}
+	idToken := NewIDToken(user, company, code, time.Minute)
+	if !code.IsOpenID() || idToken.Payload.Audience != client.ClientID() || idToken.Payload.Nonce != "N-0S6" || idToken.Payload.AuthTime != code.CreatedAt {
+		t.Errorf("The ID token should be for the client with the nonce of the code: %+v", idToken.Payload)
+	}
+	if len(idToken.Payload.PreferredUsername) > 0 || len(idToken.Payload.Name) > 0 {
+		t.Errorf("The profile claims need the profile scope: %+v", idToken.Payload)
+	}
+
+	code.Scope = "openid profile"
+	idToken = NewIDToken(user, company, code, time.Minute)
+	if idToken.Payload.Name != "Super User" || idToken.Payload.PreferredUsername != "superuser" || idToken.Payload.Company != "OIDCID" {
+		t.Errorf("The profile claims were not set: %+v", idToken.Payload)
+	}
+}
@@ -25,32 +25,56 @@ SOFTWARE.
 package model
 
 import (
-	"com/novare/dbs"
-	"errors"
+	"context"
 	"log"
+	"unicode"
 	"unicode/utf8"
 
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-var mDBPerm = dbs.NewMongoDB(AuthRelayDatabaseName, "Permissions")
-
 //Permission - Definition of a permission within the system
 type Permission struct {
-	ID          bson.ObjectId `json:"id" bson:"_id"` //
-	Description string        `json:"description"`   //
-	Permission  string        `json:"permission"`    // This is the actual permission name. It can be any string
-	CompanyID   string        `json:"companyID"`     //It must be associated with a company
+	ID          primitive.ObjectID `json:"id" bson:"_id"` //
+	Description string             `json:"description"`   //
+	Permission  string             `json:"permission"`    // This is the actual permission name. It can be any string
+	CompanyID   string             `json:"companyID"`     //It must be associated with a company
+	Revision    int64              `json:"revision"`      //Incremented by every save, see SavePermission
 }
 
 //NewPermission - Constructor for the permission structure
 func NewPermission() *Permission {
 	perm := new(Permission)
-	perm.ID = bson.NewObjectId()
+	perm.ID = primitive.NewObjectID()
 	perm.CompanyID = ""
 	return perm
 }
 
+//MaxPermissionNameLength - The longest permission name a grant request
+//can ask for
+const MaxPermissionNameLength = 255
+
+/*
+IsValidPermissionName - The name can be asked for as a permission. The
+permissions are the scopes of the tokens, which are separated by spaces,
+so a name has no spaces or control characters, and "*" is never one:
+the services reading the scope could take it as every permission.
+*/
+func IsValidPermissionName(name string) bool {
+
+	length := utf8.RuneCountInString(name)
+	if length == 0 || length > MaxPermissionNameLength || !utf8.ValidString(name) {
+		return false
+	}
+
+	for _, r := range name {
+		if r == '*' || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
 func isPermissionValid(perm *Permission) bool {
 
 	//If the permission is invalid
@@ -67,55 +91,81 @@ func isPermissionValid(perm *Permission) bool {
 	return true
 }
 
-//SavePermission - Save the permission to the proper collection
-func SavePermission(perm *Permission) error {
+//SavePermission - Save the permission to the proper collection.
+//ErrConflict when it was changed since it was read.
+func SavePermission(ctx context.Context, perm *Permission) error {
 
 	if !isPermissionValid(perm) {
-		return errors.New("InvalidPermission")
+		return NewInputError("InvalidPermission")
 	}
 
-	return mDBPerm.Update(perm, bson.M{"_id": perm.ID})
+	return saveRevision(&perm.Revision, func(expected int64) error {
+		return mStore.Permissions().SaveRevision(ctx, perm, perm.ID.Hex(), expected)
+	})
 }
 
 //InsertPermission - Insert the permission into the database
-func InsertPermission(perm *Permission) error {
+func InsertPermission(ctx context.Context, perm *Permission) error {
 
 	if !isPermissionValid(perm) {
-		return errors.New("InvalidPermission")
+		return NewInputError("InvalidPermission")
 	}
 
-	return mDBPerm.Insert(perm, bson.M{"_id": perm.ID})
+	return mStore.Permissions().Insert(ctx, perm, perm.ID.Hex())
 }
 
 //FindPermissionByID - Given an ID, find the permission
-func FindPermissionByID(ID string) (*Permission, error) {
+func FindPermissionByID(ctx context.Context, ID string) (*Permission, error) {
 
-	if !bson.IsObjectIdHex(ID) {
-		return nil, errors.New("InvalidID")
+	if !primitive.IsValidObjectID(ID) {
+		return nil, NewInputError("InvalidID")
 	}
 
 	perm := NewPermission()
-	err := mDBPerm.Find(perm, bson.M{"_id": bson.ObjectIdHex(ID)})
+	err := mStore.Permissions().FindByID(ctx, perm, ID)
 	return perm, err
 }
 
 //RemovePermissionByID - Given an ID, remove the permission
-func RemovePermissionByID(ID string) error {
+func RemovePermissionByID(ctx context.Context, ID string) error {
 
-	if !bson.IsObjectIdHex(ID) {
-		return errors.New("InvalidID")
+	if !primitive.IsValidObjectID(ID) {
+		return NewInputError("InvalidID")
 	}
 
-	return mDBPerm.Remove(bson.M{"_id": bson.ObjectIdHex(ID)})
+	return mStore.Permissions().RemoveByID(ctx, ID)
 }
 
 //ListPermissionsByCompanyID ... List all permissions given a company ID
-func ListPermissionsByCompanyID(companyID string) ([]Permission, error) {
+func ListPermissionsByCompanyID(ctx context.Context, companyID string) ([]Permission, error) {
 
 	var permissions []Permission
-	err := mDBPerm.List(&permissions, bson.M{"companyid": companyID})
+	err := mStore.Permissions().ListByCompanyID(ctx, &permissions, companyID)
 	if err != nil {
 		log.Printf("There was an database error:[%s]", err)
 	}
 	return permissions, err
 }
+
+//permissionSortFields - The fields the permission lists can be sorted by
+var permissionSortFields = map[string]string{
+	"permission":  "permission",
+	"description": "description",
+}
+
+//QueryPermissionsByCompanyID - One page of the company's permissions and the
+//number of permissions matching the query. The name prefix applies to the
+//permission itself.
+func QueryPermissionsByCompanyID(ctx context.Context, companyID string, query ListQuery) ([]Permission, int64, error) {
+	opts, err := query.toOptions(permissionSortFields, "permission")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var permissions []Permission
+	total, err := mStore.Permissions().QueryByCompanyID(ctx, &permissions, companyID, nil, opts)
+	if err != nil {
+		log.Printf("There was an database error:[%s]", err)
+	}
+	return permissions, total, err
+}
@@ -24,31 +24,33 @@ SOFTWARE.
 package model
 
 import (
+	"context"
 	"testing"
 
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestPermissionFunctions(t *testing.T) {
+	ctx := context.Background()
 
 	perm := NewPermission()
-	perm.CompanyID = bson.NewObjectId().Hex()
+	perm.CompanyID = primitive.NewObjectID().Hex()
 
 	//Test a false save/update
-	err := SavePermission(perm)
+	err := SavePermission(ctx, perm)
 	if err == nil {
 		t.Errorf("The following error has occurred: [%s]", err)
 		return
 	}
 
 	//Test insert
-	err = InsertPermission(perm)
+	err = InsertPermission(ctx, perm)
 	if err != nil {
 		t.Errorf("The following error occurred: [%s]", err)
 		return
 	}
 
-	perm1, err := FindPermissionByID(perm.ID.Hex())
+	perm1, err := FindPermissionByID(ctx, perm.ID.Hex())
 	if err != nil {
 		t.Errorf("There was an issue finding the Permission: [%s]", err)
 		return
@@ -61,19 +63,19 @@ func TestPermissionFunctions(t *testing.T) {
 
 	perm1.Description = "Temporary Permission Description"
 	perm1.Permission = "MY_PERMISSION"
-	err = SavePermission(perm1)
+	err = SavePermission(ctx, perm1)
 	if err != nil {
 		t.Errorf("The Permission could not be saved, the following error occurred: [%s]", err)
 		return
 	}
 
-	err = InsertPermission(perm1)
+	err = InsertPermission(ctx, perm1)
 	if err == nil {
 		t.Errorf("The record was inserted again, taht is not correct, the request should have failed")
 		return
 	}
 
-	permissions, err := ListPermissionsByCompanyID(perm.CompanyID)
+	permissions, err := ListPermissionsByCompanyID(ctx, perm.CompanyID)
 	if err != nil {
 		t.Errorf("The following error occurred: [%s]", err)
 		return
@@ -81,7 +83,7 @@ func TestPermissionFunctions(t *testing.T) {
 
 	for i := range permissions {
 		p := permissions[i]
-		err = RemovePermissionByID(p.ID.Hex())
+		err = RemovePermissionByID(ctx, p.ID.Hex())
 		if err != nil {
 			t.Errorf("The following error has occurred: [%s]", err)
 		}
@@ -0,0 +1,74 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"com/novare/dbs"
+	"unicode/utf8"
+)
+
+/*
+ListQuery - The page, order and name filter of a list. Skip and Limit
+select the page, a Limit of 0 returns everything after Skip. SortBy uses
+the JSON field names, e.g. "name" or "username".
+*/
+type ListQuery struct {
+	Skip       int64
+	Limit      int64
+	SortBy     string
+	Descending bool
+	NamePrefix string
+}
+
+//UserQuery - The user lists can also be filtered by username, by
+//users or things and by status.
+type UserQuery struct {
+	ListQuery
+	Username string
+	IsThing  *bool
+	Status   string
+}
+
+//toOptions - Translate the query to the storage options. sortFields maps
+//the JSON field names to the stored field names.
+func (query ListQuery) toOptions(sortFields map[string]string, nameField string) (dbs.ListOptions, error) {
+	var opts dbs.ListOptions
+	opts.Skip = query.Skip
+	opts.Limit = query.Limit
+
+	if utf8.RuneCountInString(query.SortBy) > 0 {
+		field, ok := sortFields[query.SortBy]
+		if !ok {
+			return opts, NewInputError("InvalidSortKey")
+		}
+		opts.Sort = []dbs.SortKey{{Field: field, Descending: query.Descending}}
+	}
+
+	if utf8.RuneCountInString(query.NamePrefix) > 0 {
+		opts.Prefix = map[string]string{nameField: query.NamePrefix}
+	}
+
+	return opts, nil
+}
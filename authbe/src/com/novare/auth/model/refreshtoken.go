@@ -0,0 +1,230 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"com/novare/utils"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	//DefaultSessionDuration - Minutes a session token lives when the company
+	//does not say otherwise. It is the lifetime the sessions had before the
+	//refresh tokens, the clients that never refresh keep working.
+	DefaultSessionDuration int64 = 300
+	//DefaultRefreshDuration - Minutes a login can be refreshed for, a shift
+	DefaultRefreshDuration int64 = 24 * 60
+)
+
+var (
+	//ErrRefreshTokenReused - The refresh token was used already, the
+	//whole family was revoked
+	ErrRefreshTokenReused = errors.New("RefreshTokenReused")
+
+	//ErrRefreshTokenExpired - The login can not be refreshed anymore
+	ErrRefreshTokenExpired = errors.New("RefreshTokenExpired")
+)
+
+/*
+RefreshToken - A long lived opaque token exchanged for a new session
+token. Every use replaces it with a new token of the same family, the
+family is what a login started and it shares one expiration. Only the
+hash of the token is stored.
+*/
+type RefreshToken struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	FamilyID   string             `json:"familyID"` //The ID of the token the login created
+	UserID     string             `json:"userID"`
+	CompanyID  string             `json:"companyID"`
+	TokenHash  string             `json:"-"`
+	CreatedAt  int64              `json:"createdAt"`
+	ExpiresAt  int64              `json:"expiresAt"`
+	UsedAt     int64              `json:"usedAt"`     //0 until it is exchanged
+	ReplacedBy string             `json:"replacedBy"` //The token it was exchanged for
+	ClientID   string             `json:"clientID"`   //The OAuthClient of an authorization code login
+	Scope      string             `json:"scope"`      //What the sessions of the family are limited to, empty for everything
+	Revision   int64              `json:"revision"`
+}
+
+func hashRefreshToken(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+//newRefreshToken - A token and the value handed to the client
+func newRefreshToken(userID string, companyID string, familyID string, expiresAt int64) (*RefreshToken, string) {
+	value := utils.GenerateUniqueID()
+	token := new(RefreshToken)
+	token.ID = primitive.NewObjectID()
+	token.FamilyID = familyID
+	if utf8.RuneCountInString(familyID) == 0 {
+		token.FamilyID = token.ID.Hex()
+	}
+	token.UserID = userID
+	token.CompanyID = companyID
+	token.TokenHash = hashRefreshToken(value)
+	token.CreatedAt = time.Now().Unix()
+	token.ExpiresAt = expiresAt
+	return token, value
+}
+
+//IsExpired - The family can not be refreshed anymore
+func (token *RefreshToken) IsExpired() bool {
+	return time.Now().Unix() >= token.ExpiresAt
+}
+
+//CreateRefreshToken - Start a new family for a login. The value is what
+//the client presents, it is not stored.
+func CreateRefreshToken(ctx context.Context, user *User, company *Company) (*RefreshToken, string, error) {
+	return CreateClientRefreshToken(ctx, user, company, "", "")
+}
+
+//CreateClientRefreshToken - Start a new family for the login of an
+//OAuthClient, every session of the family is limited to the scope
+func CreateClientRefreshToken(ctx context.Context, user *User, company *Company, clientID string, scope string) (*RefreshToken, string, error) {
+
+	expiresAt := time.Now().Add(company.Settings.GetRefreshDuration()).Unix()
+	token, value := newRefreshToken(user.ID.Hex(), company.ID.Hex(), "", expiresAt)
+	token.ClientID = clientID
+	token.Scope = scope
+	err := mStore.RefreshTokens().Insert(ctx, token, token.ID.Hex())
+	if err != nil {
+		log.Printf("The refresh token could not be saved: [%s]", err)
+		return nil, "", err
+	}
+
+	return token, value, nil
+}
+
+/*
+RotateRefreshToken - Exchange the refresh token for a new one of the same
+family. A token that was used before means it leaked, the family is
+revoked and ErrRefreshTokenReused returned. The used token is returned
+with the new one and its value.
+*/
+func RotateRefreshToken(ctx context.Context, value string) (*RefreshToken, *RefreshToken, string, error) {
+
+	used := new(RefreshToken)
+	err := mStore.RefreshTokens().FindByTokenHash(ctx, used, hashRefreshToken(value))
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	if used.UsedAt != 0 {
+		log.Printf("The refresh token [%s] was used again, revoking the family [%s]", used.ID.Hex(), used.FamilyID)
+		return nil, nil, "", revokeReused(ctx, used.FamilyID)
+	}
+
+	if used.IsExpired() {
+		return nil, nil, "", ErrRefreshTokenExpired
+	}
+
+	next, nextValue := newRefreshToken(used.UserID, used.CompanyID, used.FamilyID, used.ExpiresAt)
+	next.ClientID = used.ClientID
+	next.Scope = used.Scope
+	used.UsedAt = time.Now().Unix()
+	used.ReplacedBy = next.ID.Hex()
+	err = saveRevision(&used.Revision, func(expected int64) error {
+		return mStore.RefreshTokens().SaveRevision(ctx, used, used.ID.Hex(), expected)
+	})
+	if errors.Is(err, ErrConflict) {
+		//Two clients presented it at the same time
+		log.Printf("The refresh token [%s] was used concurrently, revoking the family [%s]", used.ID.Hex(), used.FamilyID)
+		return nil, nil, "", revokeReused(ctx, used.FamilyID)
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	err = mStore.RefreshTokens().Insert(ctx, next, next.ID.Hex())
+	if err != nil {
+		log.Printf("The new refresh token could not be saved: [%s]", err)
+		return nil, nil, "", err
+	}
+
+	return used, next, nextValue, nil
+}
+
+func revokeReused(ctx context.Context, familyID string) error {
+	err := RevokeTokenFamily(ctx, familyID)
+	if err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+//FindRefreshToken - The refresh token the client holds the value of
+func FindRefreshToken(ctx context.Context, value string) (*RefreshToken, error) {
+	token := new(RefreshToken)
+	err := mStore.RefreshTokens().FindByTokenHash(ctx, token, hashRefreshToken(value))
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+//RevokeTokenFamily - Remove the refresh tokens of the family and revoke
+//the sessions they created
+func RevokeTokenFamily(ctx context.Context, familyID string) error {
+
+	if utf8.RuneCountInString(familyID) == 0 {
+		return nil
+	}
+
+	var tokens []RefreshToken
+	err := mStore.RefreshTokens().ListByFamilyID(ctx, &tokens, familyID)
+	if err != nil {
+		return err
+	}
+
+	for i := range tokens {
+		err = mStore.RefreshTokens().RemoveByID(ctx, tokens[i].ID.Hex())
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+
+	sessions, err := ListJWTTokensByFamilyID(ctx, familyID)
+	if err != nil {
+		return err
+	}
+
+	for i := range sessions {
+		err = RevokeJWTToken(ctx, &sessions[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
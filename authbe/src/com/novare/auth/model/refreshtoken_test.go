@@ -0,0 +1,101 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokenRotation(t *testing.T) {
+	ctx := context.Background()
+
+	user := NewUser()
+	company := NewCompany()
+	first, value, err := CreateRefreshToken(ctx, user, company)
+	if err != nil {
+		t.Errorf("The refresh token could not be created: [%s]", err)
+		return
+	}
+
+	if first.FamilyID != first.ID.Hex() || first.ExpiresAt <= time.Now().Unix() {
+		t.Errorf("The refresh token is invalid: %+v", first)
+	}
+
+	session := NewJWTToken(user.ID.Hex(), company.ID.Hex())
+	session.FamilyID = first.FamilyID
+	session.EncodeJWT()
+	InsertJWTToken(ctx, session)
+
+	used, next, nextValue, err := RotateRefreshToken(ctx, value)
+	if err != nil {
+		t.Errorf("The refresh token could not be rotated: [%s]", err)
+		return
+	}
+
+	if used.ID != first.ID || used.ReplacedBy != next.ID.Hex() || next.FamilyID != first.FamilyID || next.ExpiresAt != first.ExpiresAt {
+		t.Errorf("The rotated tokens are invalid: %+v %+v", used, next)
+	}
+
+	//The first token was used, presenting it again revokes the family
+	_, _, _, err = RotateRefreshToken(ctx, value)
+	if err != ErrRefreshTokenReused {
+		t.Errorf("The reuse was not detected: [%v]", err)
+	}
+
+	_, _, _, err = RotateRefreshToken(ctx, nextValue)
+	if err != ErrNotFound {
+		t.Errorf("The newest token of the family should be revoked: [%v]", err)
+	}
+
+	_, err = FindJWTTokenByID(ctx, session.ID.Hex())
+	if err != ErrNotFound {
+		t.Errorf("The session of the family should be removed: [%v]", err)
+	}
+}
+
+func TestRefreshTokenExpired(t *testing.T) {
+	ctx := context.Background()
+
+	user := NewUser()
+	company := NewCompany()
+	company.Settings.RefreshDuration = 1
+	token, value := newRefreshToken(user.ID.Hex(), company.ID.Hex(), "", time.Now().Add(-time.Minute).Unix())
+	err := mStore.RefreshTokens().Insert(ctx, token, token.ID.Hex())
+	if err != nil {
+		t.Errorf("The refresh token could not be saved: [%s]", err)
+		return
+	}
+
+	_, _, _, err = RotateRefreshToken(ctx, value)
+	if err != ErrRefreshTokenExpired {
+		t.Errorf("The expired token was accepted: [%v]", err)
+	}
+
+	if company.Settings.GetRefreshDuration() != time.Minute {
+		t.Errorf("The refresh duration is invalid: %s", company.Settings.GetRefreshDuration())
+	}
+}
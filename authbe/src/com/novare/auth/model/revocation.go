@@ -0,0 +1,181 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+RevokedToken - An entry of the revocation list. A token signed with the
+keys is verified anywhere the public keys are known, the list is how
+those sites learn that a token they would accept was revoked. An entry
+is dropped once the token it names expires.
+*/
+type RevokedToken struct {
+	ID        primitive.ObjectID `bson:"_id" json:"-"`
+	TokenID   string             `json:"jti"`
+	CompanyID string             `json:"companyID"`
+	ExpiresAt int64              `json:"exp"` //0 when the token does not expire
+	RevokedAt int64              `json:"revokedAt"`
+}
+
+//IsExpired - The token expired, it is rejected without the entry
+func (revoked *RevokedToken) IsExpired() bool {
+	return revoked.ExpiresAt != 0 && time.Now().Unix() >= revoked.ExpiresAt
+}
+
+var (
+	revocationMutex       sync.Mutex
+	revocationSubscribers []func(RevokedToken)
+)
+
+//SubscribeRevocations - fn is called for every token added to the
+//revocation list, e.g. to tell the remote sites right away
+func SubscribeRevocations(fn func(RevokedToken)) {
+	revocationMutex.Lock()
+	revocationSubscribers = append(revocationSubscribers, fn)
+	revocationMutex.Unlock()
+}
+
+func notifyRevocation(revoked RevokedToken) {
+	revocationMutex.Lock()
+	subscribers := revocationSubscribers
+	revocationMutex.Unlock()
+	for _, fn := range subscribers {
+		fn(revoked)
+	}
+}
+
+/*
+RevokeTokenID - Add the jti to the revocation list of the company until
+expiresAt. Revoking a token twice is not an error, the subscribers only
+hear about it once.
+*/
+func RevokeTokenID(ctx context.Context, companyID string, tokenID string, expiresAt int64) error {
+
+	if utf8.RuneCountInString(tokenID) == 0 {
+		return NewInputError("MissingTokenID")
+	}
+
+	revoked := new(RevokedToken)
+	revoked.ID = primitive.NewObjectID()
+	revoked.TokenID = tokenID
+	revoked.CompanyID = companyID
+	revoked.ExpiresAt = expiresAt
+	revoked.RevokedAt = time.Now().Unix()
+	if revoked.IsExpired() {
+		return nil
+	}
+
+	err := mStore.RevokedTokens().Insert(ctx, revoked, revoked.ID.Hex())
+	if errors.Is(err, ErrDuplicate) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("The token [%s] could not be added to the revocation list: [%s]", tokenID, err)
+		return err
+	}
+
+	notifyRevocation(*revoked)
+	return nil
+}
+
+/*
+RevokeJWTToken - Remove the stored session token and add its jti to the
+revocation list. The tokens issued before they carried a jti are only
+removed, they can not be verified without the store.
+*/
+func RevokeJWTToken(ctx context.Context, jwt *JWTToken) error {
+
+	if utf8.RuneCountInString(jwt.Payload.ID) > 0 {
+		err := RevokeTokenID(ctx, jwt.CompanyID, jwt.Payload.ID, jwt.Payload.ExpirationTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := RemoveJWTTokenByID(ctx, jwt.ID.Hex())
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+//IsTokenRevoked - The jti is on the revocation list
+func IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+
+	if utf8.RuneCountInString(tokenID) == 0 {
+		return false, nil
+	}
+
+	revoked := new(RevokedToken)
+	err := mStore.RevokedTokens().FindByTokenID(ctx, revoked, tokenID)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+/*
+ListRevokedTokens - The revocation list of the company, the entries
+revoked at or after since. A site polls with the time of its last poll.
+The entries of the expired tokens are removed.
+*/
+func ListRevokedTokens(ctx context.Context, companyID string, since int64) ([]RevokedToken, error) {
+
+	var entries []RevokedToken
+	err := mStore.RevokedTokens().ListByCompanyID(ctx, &entries, companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []RevokedToken{}
+	for i := range entries {
+		if entries[i].IsExpired() {
+			err = mStore.RevokedTokens().RemoveByID(ctx, entries[i].ID.Hex())
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				log.Printf("The expired revocation [%s] could not be removed: [%s]", entries[i].TokenID, err)
+			}
+			continue
+		}
+		if entries[i].RevokedAt >= since {
+			list = append(list, entries[i])
+		}
+	}
+
+	return list, nil
+}
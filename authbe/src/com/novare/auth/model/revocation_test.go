@@ -0,0 +1,103 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRevocationList(t *testing.T) {
+	ctx := context.Background()
+
+	company := NewCompany()
+	companyID := company.ID.Hex()
+	since := time.Now().Unix()
+
+	var heard []RevokedToken
+	SubscribeRevocations(func(revoked RevokedToken) {
+		if revoked.CompanyID == companyID {
+			heard = append(heard, revoked)
+		}
+	})
+
+	session := NewJWTToken(NewUser().ID.Hex(), companyID)
+	session.Payload.SetExpiration(5)
+	session.EncodeJWT()
+	err := InsertJWTToken(ctx, session)
+	if err != nil {
+		t.Errorf("The session could not be saved: [%s]", err)
+		return
+	}
+
+	err = RevokeJWTToken(ctx, session)
+	if err != nil {
+		t.Errorf("The session could not be revoked: [%s]", err)
+	}
+
+	_, err = FindJWTTokenByID(ctx, session.ID.Hex())
+	if err != ErrNotFound {
+		t.Errorf("The revoked session should be removed: [%v]", err)
+	}
+
+	revoked, err := IsTokenRevoked(ctx, session.Payload.ID)
+	if err != nil || !revoked {
+		t.Errorf("The jti [%s] should be revoked: [%v]", session.Payload.ID, err)
+	}
+
+	//Revoking it again is not an error and is not published again
+	err = RevokeTokenID(ctx, companyID, session.Payload.ID, session.Payload.ExpirationTime)
+	if err != nil {
+		t.Errorf("Revoking the jti again failed: [%s]", err)
+	}
+
+	//The entries of expired tokens are dropped from the list
+	expired := RevokedToken{TokenID: "expired-" + companyID, CompanyID: companyID, ExpiresAt: time.Now().Add(-time.Minute).Unix()}
+	expired.ID = session.ID
+	err = mStore.RevokedTokens().Insert(ctx, &expired, expired.ID.Hex())
+	if err != nil {
+		t.Errorf("The expired entry could not be saved: [%s]", err)
+	}
+
+	list, err := ListRevokedTokens(ctx, companyID, since)
+	if err != nil || len(list) != 1 || list[0].TokenID != session.Payload.ID {
+		t.Errorf("The revocation list is invalid: %+v [%v]", list, err)
+	}
+
+	list, err = ListRevokedTokens(ctx, companyID, time.Now().Add(time.Minute).Unix())
+	if err != nil || len(list) != 0 {
+		t.Errorf("Nothing was revoked after the last poll: %+v [%v]", list, err)
+	}
+
+	if len(heard) != 1 || heard[0].TokenID != session.Payload.ID {
+		t.Errorf("The subscribers should hear about the revocation once: %+v", heard)
+	}
+
+	revoked, err = IsTokenRevoked(ctx, "not-revoked")
+	if err != nil || revoked {
+		t.Errorf("An unknown jti is not revoked: [%v]", err)
+	}
+}
@@ -25,22 +25,20 @@ SOFTWARE.
 */
 
 import (
-	"com/novare/dbs"
-	"errors"
+	"context"
 	"log"
 	"unicode/utf8"
 
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-var mDBRole = dbs.NewMongoDB(AuthRelayDatabaseName, "Roles")
-
 //Role ...
 type Role struct {
-	ID          bson.ObjectId `json:"id" bson:"_id"` //
-	Description string        `json:"name"`          //Role description
-	Permissions []Permission  `json:"permissions"`   //List of permissions for the role
-	CompanyID   string        `json:"companyID"`     //Every role belongs to a company
+	ID          primitive.ObjectID `json:"id" bson:"_id"` //
+	Description string             `json:"name"`          //Role description
+	Permissions []Permission       `json:"permissions"`   //List of permissions for the role
+	CompanyID   string             `json:"companyID"`     //Every role belongs to a company
+	Revision    int64              `json:"revision"`      //Incremented by every save, see SaveRole
 }
 
 //IsGranted will return true if the permission is granted to the role or false otherwise
@@ -82,7 +80,7 @@ func (role *Role) RemovePermission(permission string) {
 //NewRole - Role constructor
 func NewRole() *Role {
 	role := new(Role)
-	role.ID = bson.NewObjectId()
+	role.ID = primitive.NewObjectID()
 	role.CompanyID = ""
 	return role
 }
@@ -103,52 +101,75 @@ func isRoleValid(role *Role) bool {
 	return true
 }
 
-//SaveRole - Save the role to the proper collection
-func SaveRole(role *Role) error {
+//SaveRole - Save the role to the proper collection.
+//ErrConflict when it was changed since it was read.
+func SaveRole(ctx context.Context, role *Role) error {
 
 	if !isRoleValid(role) {
-		return errors.New("InvalidRole")
+		return NewInputError("InvalidRole")
 	}
 
-	return mDBRole.Update(role, bson.M{"_id": role.ID})
+	return saveRevision(&role.Revision, func(expected int64) error {
+		return mStore.Roles().SaveRevision(ctx, role, role.ID.Hex(), expected)
+	})
 }
 
 //InsertRole - Insert the role into the database
-func InsertRole(role *Role) error {
+func InsertRole(ctx context.Context, role *Role) error {
 
 	if !isRoleValid(role) {
-		return errors.New("InvalidRole")
+		return NewInputError("InvalidRole")
 	}
 
-	return mDBRole.Insert(role, bson.M{"_id": role.ID})
+	return mStore.Roles().Insert(ctx, role, role.ID.Hex())
 }
 
 //FindRoleByID - Given an ID, find the role
-func FindRoleByID(ID string) (*Role, error) {
+func FindRoleByID(ctx context.Context, ID string) (*Role, error) {
 
-	if !bson.IsObjectIdHex(ID) {
-		return nil, errors.New("InvalidID")
+	if !primitive.IsValidObjectID(ID) {
+		return nil, NewInputError("InvalidID")
 	}
 
 	role := NewRole()
-	err := mDBRole.Find(role, bson.M{"_id": bson.ObjectIdHex(ID)})
+	err := mStore.Roles().FindByID(ctx, role, ID)
 	return role, err
 }
 
 //RemoveRoleByID - Given an ID, remove the role
-func RemoveRoleByID(ID string) error {
+func RemoveRoleByID(ctx context.Context, ID string) error {
 
-	if !bson.IsObjectIdHex(ID) {
-		return errors.New("InvalidID")
+	if !primitive.IsValidObjectID(ID) {
+		return NewInputError("InvalidID")
 	}
 
-	return mDBRole.Remove(bson.M{"_id": bson.ObjectIdHex(ID)})
+	return mStore.Roles().RemoveByID(ctx, ID)
 }
 
 //ListRolesByCompanyID ... List all roles given a company ID
-func ListRolesByCompanyID(companyID string) ([]Role, error) {
+func ListRolesByCompanyID(ctx context.Context, companyID string) ([]Role, error) {
 
 	var roles []Role
-	err := mDBRole.List(&roles, bson.M{"companyid": companyID})
+	err := mStore.Roles().ListByCompanyID(ctx, &roles, companyID)
 	return roles, err
 }
+
+//roleSortFields - The fields the role lists can be sorted by. The
+//description is exposed as the name of the role.
+var roleSortFields = map[string]string{
+	"name":        "description",
+	"description": "description",
+}
+
+//QueryRolesByCompanyID - One page of the company's roles and the number of
+//roles matching the query. The name prefix applies to the description.
+func QueryRolesByCompanyID(ctx context.Context, companyID string, query ListQuery) ([]Role, int64, error) {
+	opts, err := query.toOptions(roleSortFields, "description")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var roles []Role
+	total, err := mStore.Roles().QueryByCompanyID(ctx, &roles, companyID, nil, opts)
+	return roles, total, err
+}
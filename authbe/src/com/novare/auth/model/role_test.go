@@ -25,14 +25,17 @@ SOFTWARE.
 package model
 
 import (
+	"context"
+	"errors"
 	"testing"
 
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestRoleFunctions(t *testing.T) {
+	ctx := context.Background()
 
-	ID := bson.NewObjectId().Hex()
+	ID := primitive.NewObjectID().Hex()
 
 	perm := NewPermission()
 	perm.CompanyID = ID
@@ -49,13 +52,13 @@ func TestRoleFunctions(t *testing.T) {
 	role.AddPermission(*perm1)
 
 	role.CompanyID = ID
-	err := SaveRole(role)
+	err := SaveRole(ctx, role)
 	if err == nil {
 		t.Errorf("Invalid ROLE! Error: [%s]", err)
 		return
 	}
 
-	err = InsertRole(role)
+	err = InsertRole(ctx, role)
 	if err != nil {
 		t.Errorf("The role could not be inserted: [%s]", err)
 		return
@@ -87,7 +90,7 @@ func TestRoleFunctions(t *testing.T) {
 		return
 	}
 
-	role1, err := FindRoleByID(role.ID.Hex())
+	role1, err := FindRoleByID(ctx, role.ID.Hex())
 	if err != nil {
 		t.Errorf("The role ID was not found! Error: [%s]", err)
 		return
@@ -98,16 +101,50 @@ func TestRoleFunctions(t *testing.T) {
 		return
 	}
 
-	roles, err := ListRolesByCompanyID(ID)
+	roles, err := ListRolesByCompanyID(ctx, ID)
 	if err != nil {
 		t.Errorf("Invalid roles: [%s]", err)
 	}
 
 	for i := range roles {
-		err = RemoveRoleByID(roles[i].ID.Hex())
+		err = RemoveRoleByID(ctx, roles[i].ID.Hex())
 		if err != nil {
 			t.Errorf("The Role could not be removed! Error:[%s]", err)
 		}
 
 	}
 }
+
+func TestSaveRoleConflict(t *testing.T) {
+	ctx := context.Background()
+
+	role := NewRole()
+	role.CompanyID = primitive.NewObjectID().Hex()
+	role.Description = "CONFLICT"
+	err := InsertRole(ctx, role)
+	if err != nil {
+		t.Errorf("The role was not inserted: [%s]", err)
+		return
+	}
+	defer RemoveRoleByID(ctx, role.ID.Hex())
+
+	first, _ := FindRoleByID(ctx, role.ID.Hex())
+	second, _ := FindRoleByID(ctx, role.ID.Hex())
+
+	first.Description = "FIRST"
+	err = SaveRole(ctx, first)
+	if err != nil || first.Revision != 1 {
+		t.Errorf("The first save should have succeeded: %d [%v]", first.Revision, err)
+	}
+
+	second.Description = "SECOND"
+	err = SaveRole(ctx, second)
+	if !errors.Is(err, ErrConflict) || second.Revision != 0 {
+		t.Errorf("The second save should have been a conflict: %d [%v]", second.Revision, err)
+	}
+
+	stored, _ := FindRoleByID(ctx, role.ID.Hex())
+	if stored.Description != "FIRST" {
+		t.Errorf("The first save should have been kept: [%s]", stored.Description)
+	}
+}
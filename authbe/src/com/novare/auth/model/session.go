@@ -0,0 +1,337 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	//SessionLimitEvictOldest - A login over the MaxSessions ends the
+	//oldest sessions of the user
+	SessionLimitEvictOldest string = "evictOldest"
+	//SessionLimitReject - A login over the MaxSessions is rejected
+	SessionLimitReject string = "reject"
+)
+
+//ErrSessionLimit - The user has the MaxSessions of the company already
+var ErrSessionLimit = errors.New("SessionLimit")
+
+//ErrSessionIdle - The session was not used for the IdleTimeout of the
+//company, it was ended
+var ErrSessionIdle = errors.New("SessionIdle")
+
+/*
+SessionInfo - Where a login happened. It is kept by the session tokens the
+refresh tokens issue, so a session stays tied to its terminal.
+*/
+type SessionInfo struct {
+	DeviceID   string `json:"deviceID"` //The terminal, lane or PC, given by the client
+	IPAddress  string `json:"ipAddress"`
+	UserAgent  string `json:"userAgent"`
+	StartedAt  int64  `json:"startedAt"`  //The login, the refreshes do not change it
+	LastUsedAt int64  `json:"lastUsedAt"` //The last request, within the SessionTouchInterval
+}
+
+//SessionTouchInterval - How often TouchSession saves the last use of a
+//session. A session in use is not written on every request.
+var SessionTouchInterval int64 = 60
+
+//lastUsed - The last use saved, the login for the sessions that have none
+func (jwt *JWTToken) lastUsed() int64 {
+	if jwt.Session.LastUsedAt > 0 {
+		return jwt.Session.LastUsedAt
+	}
+	if jwt.Session.StartedAt > 0 {
+		return jwt.Session.StartedAt
+	}
+	return jwt.Payload.IssuedAt
+}
+
+//touchInterval - How often the last use is saved. With an idle timeout
+//it is saved at least ten times within the timeout.
+func touchInterval(idle int64) int64 {
+	if idle > 0 && idle/10 < SessionTouchInterval {
+		return idle / 10
+	}
+	return SessionTouchInterval
+}
+
+/*
+IsSessionIdle - The session was not used for the IdleTimeout of the
+company. The last use is only saved every touch interval, so the session
+is idle that much later; a terminal in use is never taken for idle.
+*/
+func IsSessionIdle(session *JWTToken, settings *CompanySettings, now time.Time) bool {
+	idle := int64(settings.GetIdleTimeout() / time.Second)
+	if idle <= 0 {
+		return false
+	}
+	return now.Unix()-session.lastUsed() > idle+touchInterval(idle)
+}
+
+//SessionID - The ID the session is managed by: the refresh token family
+//of the login, the token itself for the sessions started without one
+func (jwt *JWTToken) SessionID() string {
+	if utf8.RuneCountInString(jwt.FamilyID) > 0 {
+		return jwt.FamilyID
+	}
+	return jwt.ID.Hex()
+}
+
+//isSessionLive - The session token has not expired or its refresh token
+//family can still issue a new one
+func isSessionLive(ctx context.Context, session *JWTToken) (bool, error) {
+
+	if !session.Payload.IsExpired() {
+		return true, nil
+	}
+
+	if utf8.RuneCountInString(session.FamilyID) == 0 {
+		return false, nil
+	}
+
+	var tokens []RefreshToken
+	err := mStore.RefreshTokens().ListByFamilyID(ctx, &tokens, session.FamilyID)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range tokens {
+		if tokens[i].UsedAt == 0 && !tokens[i].IsExpired() {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+/*
+ListUserSessions - The live sessions of the user in the company, the
+oldest first. The sessions that can not be used anymore are removed.
+*/
+func ListUserSessions(ctx context.Context, userID string, companyID string) ([]JWTToken, error) {
+
+	var stored []JWTToken
+	err := mStore.JWTs().ListByUserIDCompanyID(ctx, &stored, userID, companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return liveSessions(ctx, stored)
+}
+
+//ListCompanySessions - The live sessions of every user of the company,
+//the oldest first
+func ListCompanySessions(ctx context.Context, companyID string) ([]JWTToken, error) {
+
+	stored, err := ListJWTTokensByCompanyID(ctx, companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return liveSessions(ctx, stored)
+}
+
+//companySettings - The settings of the company, the defaults when it
+//does not exist anymore
+func companySettings(ctx context.Context, companyID string) (*CompanySettings, error) {
+	company, err := FindCompanyByID(ctx, companyID)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidInput) {
+		return new(CompanySettings), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &company.Settings, nil
+}
+
+//liveSessions - The sessions of stored that can be used, the others are
+//ended
+func liveSessions(ctx context.Context, stored []JWTToken) ([]JWTToken, error) {
+
+	settings := make(map[string]*CompanySettings)
+	now := time.Now()
+
+	sessions := []JWTToken{}
+	for i := range stored {
+		s, ok := settings[stored[i].CompanyID]
+		if !ok {
+			var err error
+			s, err = companySettings(ctx, stored[i].CompanyID)
+			if err != nil {
+				return nil, err
+			}
+			settings[stored[i].CompanyID] = s
+		}
+
+		live, err := isSessionLive(ctx, &stored[i])
+		if err != nil {
+			return nil, err
+		}
+		if live && !IsSessionIdle(&stored[i], s, now) {
+			sessions = append(sessions, stored[i])
+			continue
+		}
+		err = EndSession(ctx, &stored[i])
+		if err != nil {
+			log.Printf("The dead session [%s] could not be removed: [%s]", stored[i].ID.Hex(), err)
+		}
+	}
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Session.StartedAt < sessions[j].Session.StartedAt
+	})
+	return sessions, nil
+}
+
+/*
+FindSession - The current session token of the session. ErrNotFound when
+the session ended.
+*/
+func FindSession(ctx context.Context, sessionID string) (*JWTToken, error) {
+
+	sessions, err := ListJWTTokensByFamilyID(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) > 0 {
+		return &sessions[len(sessions)-1], nil
+	}
+
+	return FindJWTTokenByID(ctx, sessionID)
+}
+
+/*
+TouchSession - Save the last use of the session. It is only written once
+every SessionTouchInterval. A session that was ended in the meantime is
+not saved again.
+*/
+func TouchSession(ctx context.Context, session *JWTToken) error {
+	return touchSession(ctx, session, SessionTouchInterval)
+}
+
+func touchSession(ctx context.Context, session *JWTToken, interval int64) error {
+
+	now := time.Now().Unix()
+	if now-session.Session.LastUsedAt < interval {
+		return nil
+	}
+
+	session.Session.LastUsedAt = now
+	err := SaveJWTToken(ctx, session)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+/*
+KeepSessionAlive - A request of the session. A session idle for longer
+than the IdleTimeout of the company is ended with ErrSessionIdle, the use
+of the others is saved.
+*/
+func KeepSessionAlive(ctx context.Context, session *JWTToken, company *Company) error {
+
+	if IsSessionIdle(session, &company.Settings, time.Now()) {
+		log.Printf("The session [%s] was idle since %d, ending it", session.SessionID(), session.lastUsed())
+		err := EndSession(ctx, session)
+		if err != nil {
+			return err
+		}
+		return ErrSessionIdle
+	}
+
+	idle := int64(company.Settings.GetIdleTimeout() / time.Second)
+	return touchSession(ctx, session, touchInterval(idle))
+}
+
+//EndSession - Revoke the session token and the refresh token family of
+//its login
+func EndSession(ctx context.Context, session *JWTToken) error {
+	err := RevokeJWTToken(ctx, session)
+	if err != nil {
+		return err
+	}
+	return RevokeTokenFamily(ctx, session.FamilyID)
+}
+
+/*
+AdmitSession - Make room for a new login of the user. A login on a device
+that has a session already replaces it. Over the MaxSessions of the
+company the oldest sessions are ended, or ErrSessionLimit is returned
+when the company rejects the login instead.
+*/
+func AdmitSession(ctx context.Context, user *User, company *Company, info SessionInfo) error {
+
+	sessions, err := ListUserSessions(ctx, user.ID.Hex(), company.ID.Hex())
+	if err != nil {
+		return err
+	}
+
+	var kept []JWTToken
+	for i := range sessions {
+		if utf8.RuneCountInString(info.DeviceID) > 0 && sessions[i].Session.DeviceID == info.DeviceID {
+			log.Printf("The user [%s] logged in on the device [%s] again, ending its session", user.ID.Hex(), info.DeviceID)
+			err = EndSession(ctx, &sessions[i])
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		kept = append(kept, sessions[i])
+	}
+
+	max := int(company.Settings.MaxSessions)
+	if max <= 0 || len(kept) < max {
+		return nil
+	}
+
+	if company.Settings.SessionLimit == SessionLimitReject {
+		log.Printf("The user [%s] has %d sessions already, the login is rejected", user.ID.Hex(), len(kept))
+		return ErrSessionLimit
+	}
+
+	for i := 0; i <= len(kept)-max; i++ {
+		log.Printf("Ending the oldest session [%s] of the user [%s]", kept[i].ID.Hex(), user.ID.Hex())
+		err = EndSession(ctx, &kept[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//NewSessionInfo - The SessionInfo of a login starting now
+func NewSessionInfo(deviceID string, ipAddress string, userAgent string) SessionInfo {
+	now := time.Now().Unix()
+	return SessionInfo{DeviceID: deviceID, IPAddress: ipAddress, UserAgent: userAgent, StartedAt: now, LastUsedAt: now}
+}
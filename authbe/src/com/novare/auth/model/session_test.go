@@ -0,0 +1,203 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+//startTestSession - Store a session of the user on the device
+func startTestSession(t *testing.T, user *User, company *Company, deviceID string, startedAt int64) *JWTToken {
+	ctx := context.Background()
+
+	refresh, _, err := CreateRefreshToken(ctx, user, company)
+	if err != nil {
+		t.Errorf("The refresh token could not be created: [%s]", err)
+		return nil
+	}
+
+	session := NewJWTToken(user.ID.Hex(), company.ID.Hex())
+	session.FamilyID = refresh.FamilyID
+	session.Session = NewSessionInfo(deviceID, "10.0.0.1", "lane")
+	session.Session.StartedAt = startedAt
+	session.Payload.SetExpiration(5)
+	session.EncodeJWT()
+	err = InsertJWTToken(ctx, session)
+	if err != nil {
+		t.Errorf("The session could not be saved: [%s]", err)
+	}
+	return session
+}
+
+func TestAdmitSession(t *testing.T) {
+	ctx := context.Background()
+
+	user := NewUser()
+	company := NewCompany()
+	company.Settings.MaxSessions = 2
+
+	pc := startTestSession(t, user, company, "BACKOFFICE", 1)
+	lane := startTestSession(t, user, company, "LANE1", 2)
+
+	//The same device replaces its session
+	err := AdmitSession(ctx, user, company, NewSessionInfo("LANE1", "", ""))
+	if err != nil {
+		t.Errorf("The login on the same lane should be admitted: [%s]", err)
+	}
+
+	sessions, err := ListUserSessions(ctx, user.ID.Hex(), company.ID.Hex())
+	if err != nil || len(sessions) != 1 || sessions[0].ID != pc.ID {
+		t.Errorf("Only the back-office session should be left: %d [%v]", len(sessions), err)
+	}
+
+	revoked, _ := IsTokenRevoked(ctx, lane.Payload.ID)
+	if !revoked {
+		t.Errorf("The replaced session should be revoked")
+	}
+
+	startTestSession(t, user, company, "LANE2", 3)
+
+	//A company rejecting the login over the limit
+	company.Settings.SessionLimit = SessionLimitReject
+	err = AdmitSession(ctx, user, company, NewSessionInfo("LANE3", "", ""))
+	if err != ErrSessionLimit {
+		t.Errorf("The third session should be rejected: [%v]", err)
+	}
+
+	//The default evicts the oldest
+	company.Settings.SessionLimit = ""
+	err = AdmitSession(ctx, user, company, NewSessionInfo("LANE3", "", ""))
+	if err != nil {
+		t.Errorf("The third session should be admitted: [%s]", err)
+	}
+
+	sessions, _ = ListUserSessions(ctx, user.ID.Hex(), company.ID.Hex())
+	if len(sessions) != 1 || sessions[0].Session.DeviceID != "LANE2" {
+		t.Errorf("The back-office session should be evicted: %+v", sessions)
+	}
+
+	for i := range sessions {
+		EndSession(ctx, &sessions[i])
+	}
+}
+
+func TestSessionSettings(t *testing.T) {
+	settings := CompanySettings{MaxSessions: -1}
+	if err := settings.validate(); err == nil {
+		t.Errorf("A negative maximum of sessions should be rejected")
+	}
+
+	settings = CompanySettings{SessionLimit: "kickEveryone"}
+	if err := settings.validate(); err == nil {
+		t.Errorf("An unknown session limit should be rejected")
+	}
+
+	settings = CompanySettings{IdleTimeout: -1}
+	if err := settings.validate(); err == nil {
+		t.Errorf("A negative idle timeout should be rejected")
+	}
+}
+
+func TestKeepSessionAlive(t *testing.T) {
+	ctx := context.Background()
+
+	user := NewUser()
+	company := NewCompany()
+	company.Settings.IdleTimeout = 10
+	session := startTestSession(t, user, company, "LANE1", 1)
+
+	//Used within the idle timeout, the use is saved
+	now := time.Now()
+	session.Session.LastUsedAt = now.Add(-9 * time.Minute).Unix()
+	err := KeepSessionAlive(ctx, session, company)
+	if err != nil || session.Session.LastUsedAt < now.Unix() {
+		t.Errorf("The session in use should be kept: [%v]", err)
+	}
+
+	//The last use is saved every minute, the timeout allows for it
+	session.Session.LastUsedAt = now.Add(-10*time.Minute - 30*time.Second).Unix()
+	if IsSessionIdle(session, &company.Settings, now) {
+		t.Errorf("The session should not be idle before the touch interval passed")
+	}
+
+	//Without an idle timeout the session never idles
+	session.Session.LastUsedAt = 1
+	if IsSessionIdle(session, &CompanySettings{}, now) {
+		t.Errorf("A company without idle timeout should not idle the sessions")
+	}
+
+	err = KeepSessionAlive(ctx, session, company)
+	if err != ErrSessionIdle {
+		t.Errorf("The idle session should be ended: [%v]", err)
+	}
+
+	_, err = FindSession(ctx, session.SessionID())
+	if err != ErrNotFound {
+		t.Errorf("The idle session should not be found: [%v]", err)
+	}
+
+	revoked, _ := IsTokenRevoked(ctx, session.Payload.ID)
+	if !revoked {
+		t.Errorf("The idle session should be revoked")
+	}
+}
+
+func TestTouchSession(t *testing.T) {
+	ctx := context.Background()
+
+	user := NewUser()
+	company := NewCompany()
+	session := startTestSession(t, user, company, "LANE1", 1)
+
+	session.Session.LastUsedAt = 1
+	err := TouchSession(ctx, session)
+	if err != nil {
+		t.Errorf("The last use could not be saved: [%s]", err)
+	}
+
+	found, err := FindSession(ctx, session.SessionID())
+	if err != nil || found.ID != session.ID || found.Session.LastUsedAt <= 1 {
+		t.Errorf("The session was not touched: %+v [%v]", found, err)
+	}
+
+	EndSession(ctx, session)
+	_, err = FindSession(ctx, session.SessionID())
+	if err != ErrNotFound {
+		t.Errorf("The ended session should not be found: [%v]", err)
+	}
+
+	//An ended session is not saved again
+	session.Session.LastUsedAt = 1
+	err = TouchSession(ctx, session)
+	if err != nil {
+		t.Errorf("Touching an ended session should not fail: [%s]", err)
+	}
+	_, err = FindJWTTokenByID(ctx, session.ID.Hex())
+	if err != ErrNotFound {
+		t.Errorf("The ended session was saved again: [%v]", err)
+	}
+}
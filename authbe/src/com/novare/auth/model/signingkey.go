@@ -0,0 +1,584 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	//AlgRS256 - RSASSA-PKCS1-v1_5 with SHA-256
+	AlgRS256 string = "RS256"
+	//AlgES256 - ECDSA with the P-256 curve and SHA-256
+	AlgES256 string = "ES256"
+	//AlgHS256 - HMAC with SHA-256, only used by the tokens signed with a
+	//per token secret
+	AlgHS256 string = "HS256"
+
+	//SigningKeySite - The company tokens are signed with the site key
+	SigningKeySite string = "site"
+	//SigningKeyCompany - The company has its own signing key
+	SigningKeyCompany string = "company"
+)
+
+//SigningAlgorithm - The algorithm of the keys created when the company
+//does not ask for one. It must be AlgRS256 or AlgES256.
+var SigningAlgorithm = AlgRS256
+
+//ErrInvalidSignature - The token signature does not match its content
+var ErrInvalidSignature = errors.New("InvalidSignature")
+
+//encryptedKeyPrefix - Marks a PrivateKey sealed with the key encryption key
+const encryptedKeyPrefix = "AES256GCM:"
+
+//keyEncryption - The private keys are sealed with it before they are
+//stored. Without it they are stored as plain PEM.
+var keyEncryption cipher.AEAD
+
+//SetKeyEncryptionKey - The base64 encoded 32 byte AES key the private
+//signing keys are encrypted with in the database. It is set at startup.
+func SetKeyEncryptionKey(encoded string) error {
+
+	secret, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(secret) != 32 {
+		return NewInputError("InvalidKeyEncryptionKey")
+	}
+
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	keyEncryption = aead
+	return nil
+}
+
+/*
+SigningKey - An asymmetric key the tokens are signed with. The site key
+has no CompanyID, the companies that ask for their own key get one each.
+The public half is published in the JWKS so the tokens can be verified
+without calling the edgeauth.
+*/
+type SigningKey struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	KeyID      string             `json:"kid"`       //The kid header of the tokens signed with the key
+	CompanyID  string             `json:"companyID"` //Empty for the site key
+	Algorithm  string             `json:"alg"`       //AlgRS256 or AlgES256
+	PrivateKey string             `json:"-"`         //PKCS #8 PEM, sealed when there is a key encryption key
+	CreatedAt  int64              `json:"createdAt"`
+
+	Version     int    `json:"version"`     //Increases with every key of the company and algorithm
+	Status      string `json:"status"`      //KeyStatusNext, KeyStatusActive, KeyStatusPrevious or KeyStatusRetired
+	ActivatedAt int64  `json:"activatedAt"` //When it started signing
+	RetiresAt   int64  `json:"retiresAt"`   //When a previous key stops verifying
+
+	signer crypto.Signer //The parsed PrivateKey
+}
+
+//NewSigningKey - Generate a key for the company, an empty companyID is
+//the site key
+func NewSigningKey(companyID string, algorithm string) (*SigningKey, error) {
+
+	var signer crypto.Signer
+	var err error
+	switch algorithm {
+	case AlgRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, NewInputError("InvalidSigningAlgorithm")
+	}
+	if err != nil {
+		log.Printf("The %s key could not be generated: [%s]", algorithm, err)
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+
+	key := new(SigningKey)
+	key.ID = primitive.NewObjectID()
+	key.CompanyID = companyID
+	key.Algorithm = algorithm
+	key.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	key.CreatedAt = time.Now().Unix()
+	key.signer = signer
+	key.KeyID = key.JWK().Thumbprint()
+
+	err = key.sealPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+//IsEncrypted - The PrivateKey is sealed with the key encryption key
+func (key *SigningKey) IsEncrypted() bool {
+	return strings.HasPrefix(key.PrivateKey, encryptedKeyPrefix)
+}
+
+//sealPrivateKey - Encrypt the PEM with the key encryption key. The ID of
+//the key is authenticated with it, so a sealed key can not be copied to
+//another row.
+func (key *SigningKey) sealPrivateKey() error {
+	if keyEncryption == nil || key.IsEncrypted() {
+		return nil
+	}
+
+	nonce := make([]byte, keyEncryption.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+
+	sealed := keyEncryption.Seal(nonce, nonce, []byte(key.PrivateKey), []byte(key.ID.Hex()))
+	key.PrivateKey = encryptedKeyPrefix + base64.StdEncoding.EncodeToString(sealed)
+	return nil
+}
+
+//privateKeyPEM - The PEM of the private key, decrypted if it is sealed
+func (key *SigningKey) privateKeyPEM() ([]byte, error) {
+	if !key.IsEncrypted() {
+		return []byte(key.PrivateKey), nil
+	}
+
+	if keyEncryption == nil {
+		log.Printf("The signing key [%s] is encrypted and there is no key encryption key", key.KeyID)
+		return nil, NewInputError("KeyEncryptionKeyRequired")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(key.PrivateKey, encryptedKeyPrefix))
+	size := keyEncryption.NonceSize()
+	if err != nil || len(sealed) < size {
+		return nil, NewInputError("InvalidSigningKey")
+	}
+
+	plain, err := keyEncryption.Open(nil, sealed[:size], sealed[size:], []byte(key.ID.Hex()))
+	if err != nil {
+		log.Printf("The signing key [%s] could not be decrypted: [%s]", key.KeyID, err)
+		return nil, NewInputError("InvalidSigningKey")
+	}
+	return plain, nil
+}
+
+//getSigner - The parsed private key
+func (key *SigningKey) getSigner() (crypto.Signer, error) {
+	if key.signer != nil {
+		return key.signer, nil
+	}
+
+	plain, err := key.privateKeyPEM()
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(plain)
+	if block == nil {
+		log.Printf("The signing key [%s] is not PEM encoded", key.KeyID)
+		return nil, NewInputError("InvalidSigningKey")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		log.Printf("The signing key [%s] could not be parsed: [%s]", key.KeyID, err)
+		return nil, err
+	}
+
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, NewInputError("InvalidSigningKey")
+	}
+	key.signer = signer
+	return signer, nil
+}
+
+//Sign - The JWS signature of the signing input. ES256 signatures are the
+//32 byte R and S values one after the other, as RFC 7518 requires.
+func (key *SigningKey) Sign(input []byte) ([]byte, error) {
+
+	signer, err := key.getSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(input)
+	switch priv := signer.(type) {
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, hash[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, priv, hash[:])
+		if err != nil {
+			return nil, err
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	}
+
+	return nil, NewInputError("InvalidSigningKey")
+}
+
+//Verify - Check the JWS signature of the signing input
+func (key *SigningKey) Verify(input []byte, sig []byte) error {
+
+	signer, err := key.getSigner()
+	if err != nil {
+		return err
+	}
+
+	return verifySignature(signer.Public(), input, sig)
+}
+
+//verifySignature - Check an RS256 or ES256 signature with the public key
+func verifySignature(publicKey crypto.PublicKey, input []byte, sig []byte) error {
+
+	hash := sha256.Sum256(input)
+	switch pub := publicKey.(type) {
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], sig) != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if len(sig) != 64 {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, hash[:], r, s) {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+
+	return NewInputError("InvalidSigningKey")
+}
+
+//JSONWebKey - The public half of a SigningKey as RFC 7517 describes it
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+	N         string `json:"n,omitempty"`   //RSA modulus
+	E         string `json:"e,omitempty"`   //RSA exponent
+	Curve     string `json:"crv,omitempty"` //EC curve
+	X         string `json:"x,omitempty"`   //EC X coordinate
+	Y         string `json:"y,omitempty"`   //EC Y coordinate
+}
+
+//JSONWebKeySet - The document served at /.well-known/jwks.json
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+//JWK - The public key to publish. It is empty if the private key can
+//not be parsed.
+func (key *SigningKey) JWK() JSONWebKey {
+
+	var jwk JSONWebKey
+	signer, err := key.getSigner()
+	if err != nil {
+		return jwk
+	}
+
+	b64 := base64.RawURLEncoding
+	switch pub := signer.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = b64.EncodeToString(pub.N.Bytes())
+		jwk.E = b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		jwk.KeyType = "EC"
+		jwk.Curve = pub.Curve.Params().Name
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		x := make([]byte, size)
+		y := make([]byte, size)
+		jwk.X = b64.EncodeToString(pub.X.FillBytes(x))
+		jwk.Y = b64.EncodeToString(pub.Y.FillBytes(y))
+	}
+	jwk.Use = "sig"
+	jwk.Algorithm = key.Algorithm
+	jwk.KeyID = key.KeyID
+	return jwk
+}
+
+//Thumbprint - The RFC 7638 thumbprint of the key, used as its kid
+func (jwk JSONWebKey) Thumbprint() string {
+
+	//The required members only, in lexicographic order
+	var members interface{}
+	switch jwk.KeyType {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y}
+	}
+
+	buf, _ := json.Marshal(members)
+	hash := sha256.Sum256(buf)
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+//PublicKey - Parse the public key out of a JSONWebKey
+func (jwk JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+
+	b64 := base64.RawURLEncoding
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := b64.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if jwk.Curve != elliptic.P256().Params().Name {
+			return nil, NewInputError("UnsupportedCurve")
+		}
+		x, err := b64.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+
+	return nil, NewInputError("UnsupportedKeyType")
+}
+
+//keyCacheTTL - How long a key read from the database is trusted. The key
+//material never changes but its status does, possibly in another process.
+var keyCacheTTL = time.Minute
+
+type cachedKey struct {
+	key    *SigningKey
+	loaded time.Time
+}
+
+//keyCache - The verification of every request would otherwise read and
+//parse the key again. The cached keys are copies that are never modified,
+//saving a key replaces its entry.
+var keyCache = struct {
+	sync.Mutex
+	byKeyID map[string]cachedKey
+}{byKeyID: make(map[string]cachedKey)}
+
+//cacheSigningKey - Cache a copy, the caller may keep changing the key
+func cacheSigningKey(key *SigningKey) {
+	//Parsed before it is shared
+	key.getSigner()
+	shared := *key
+	keyCache.Lock()
+	keyCache.byKeyID[key.KeyID] = cachedKey{key: &shared, loaded: time.Now()}
+	keyCache.Unlock()
+}
+
+//forgetSigningKeys - Drop the cached keys, they belong to the previous
+//store
+func forgetSigningKeys() {
+	keyCache.Lock()
+	keyCache.byKeyID = make(map[string]cachedKey)
+	keyCache.Unlock()
+	Keys.forget()
+}
+
+//InsertSigningKey ...
+func InsertSigningKey(ctx context.Context, key *SigningKey) error {
+	err := mStore.SigningKeys().Insert(ctx, key, key.ID.Hex())
+	if err == nil {
+		cacheSigningKey(key)
+	}
+	return err
+}
+
+//SaveSigningKey - Save a key whose status changed. The key must not be
+//shared, i.e. not come out of FindSigningKeyByKeyID.
+func SaveSigningKey(ctx context.Context, key *SigningKey) error {
+	err := mStore.SigningKeys().Save(ctx, key, key.ID.Hex())
+	if err == nil {
+		cacheSigningKey(key)
+	}
+	return err
+}
+
+//FindSigningKeyByKeyID - The key a token names in its kid header. The key
+//returned is shared and must not be modified.
+func FindSigningKeyByKeyID(ctx context.Context, keyID string) (*SigningKey, error) {
+
+	keyCache.Lock()
+	cached, ok := keyCache.byKeyID[keyID]
+	keyCache.Unlock()
+	if ok && time.Since(cached.loaded) < keyCacheTTL {
+		return cached.key, nil
+	}
+
+	key := new(SigningKey)
+	err := mStore.SigningKeys().FindByKeyID(ctx, key, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheSigningKey(key)
+	return key, nil
+}
+
+/*
+EncryptSigningKeys - Seal the keys stored as plain PEM, the ones created
+before there was a key encryption key. It returns how many were sealed.
+*/
+func EncryptSigningKeys(ctx context.Context) (int, error) {
+
+	if keyEncryption == nil {
+		return 0, NewInputError("KeyEncryptionKeyRequired")
+	}
+
+	keys, err := ListSigningKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := range keys {
+		key := &keys[i]
+		if key.IsEncrypted() {
+			continue
+		}
+
+		err = key.sealPrivateKey()
+		if err == nil {
+			err = SaveSigningKey(ctx, key)
+		}
+		if err != nil {
+			log.Printf("The signing key [%s] could not be encrypted: [%s]", key.KeyID, err)
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+//ListSigningKeys - Every key, the site key and the company keys
+func ListSigningKeys(ctx context.Context) ([]SigningKey, error) {
+	var keys []SigningKey
+	err := mStore.SigningKeys().List(ctx, &keys)
+	return keys, err
+}
+
+//ListSigningKeysByCompanyID - The keys of the company, an empty companyID
+//lists the site keys
+func ListSigningKeysByCompanyID(ctx context.Context, companyID string) ([]SigningKey, error) {
+	var keys []SigningKey
+	err := mStore.SigningKeys().ListByCompanyID(ctx, &keys, companyID)
+	return keys, err
+}
+
+/*
+GetSigningKey - The key the company tokens are signed with. A nil company
+or one without Settings.SigningKey set to SigningKeyCompany uses the site
+key. The KeyManager creates the key on first use with the company
+SigningAlgorithm or the default one.
+*/
+func GetSigningKey(ctx context.Context, company *Company) (*SigningKey, error) {
+
+	companyID := ""
+	algorithm := SigningAlgorithm
+	if company != nil {
+		if company.Settings.SigningKey == SigningKeyCompany {
+			companyID = company.ID.Hex()
+		}
+		if company.Settings.SigningAlgorithm != "" {
+			algorithm = company.Settings.SigningAlgorithm
+		}
+	}
+
+	return Keys.ActiveKey(ctx, companyID, algorithm)
+}
+
+//GetJSONWebKeySet - The public keys of every signing key that is not
+//retired. The next keys are published before they sign anything.
+func GetJSONWebKeySet(ctx context.Context) (*JSONWebKeySet, error) {
+
+	keys, err := ListSigningKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	set := new(JSONWebKeySet)
+	set.Keys = []JSONWebKey{}
+	for i := range keys {
+		if !keys[i].CanVerify(now) {
+			continue
+		}
+		set.Keys = append(set.Keys, keys[i].JWK())
+	}
+	return set, nil
+}
@@ -0,0 +1,287 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestSigningKeySignVerify(t *testing.T) {
+
+	for _, alg := range []string{AlgRS256, AlgES256} {
+		key, err := NewSigningKey("", alg)
+		if err != nil {
+			t.Errorf("The %s key could not be created: [%s]", alg, err)
+			continue
+		}
+
+		jwt := NewJWTToken("USERID", "COMPANYID")
+		jwt.Payload.Subject = "1234567890"
+		encoded, err := jwt.SignJWT(key)
+		if err != nil {
+			t.Errorf("The %s token could not be signed: [%s]", alg, err)
+			continue
+		}
+
+		parsed := NewJWTToken("", "")
+		err = parsed.ParseJWT(encoded)
+		if err != nil {
+			t.Errorf("The %s token could not be parsed: [%s]", alg, err)
+			continue
+		}
+
+		if parsed.Header.KeyID != key.KeyID || parsed.Header.Algo != alg {
+			t.Errorf("The header is invalid: %+v", parsed.Header)
+		}
+
+		err = parsed.VerifyJWT(key)
+		if err != nil {
+			t.Errorf("The %s token did not verify: [%s]", alg, err)
+		}
+
+		//Change the payload, keep the signature
+		parsed.Payload.Subject = "0987654321"
+		tampered, _ := parsed.encodeSigningInput()
+		err = parsed.ParseJWT(tampered + "." + parsed.Signature)
+		if err != nil {
+			t.Errorf("The tampered token could not be parsed: [%s]", err)
+			continue
+		}
+
+		if parsed.VerifyJWT(key) != ErrInvalidSignature {
+			t.Errorf("The tampered %s token was verified", alg)
+		}
+	}
+}
+
+func TestSigningKeyJWK(t *testing.T) {
+
+	for _, alg := range []string{AlgRS256, AlgES256} {
+		key, err := NewSigningKey("", alg)
+		if err != nil {
+			t.Errorf("The %s key could not be created: [%s]", alg, err)
+			continue
+		}
+
+		//A key read back from the database has no parsed signer
+		stored := *key
+		stored.signer = nil
+		jwk := stored.JWK()
+		if jwk.KeyID != key.KeyID || jwk.Thumbprint() != key.KeyID {
+			t.Errorf("The kid [%s] is not the thumbprint [%s]", jwk.KeyID, jwk.Thumbprint())
+		}
+
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			t.Errorf("The public key could not be parsed: [%s]", err)
+			continue
+		}
+
+		switch p := pub.(type) {
+		case *rsa.PublicKey:
+			if !p.Equal(key.signer.Public()) {
+				t.Errorf("The RSA public key does not match")
+			}
+		case *ecdsa.PublicKey:
+			if !p.Equal(key.signer.Public()) {
+				t.Errorf("The EC public key does not match")
+			}
+		}
+
+		//A site verifies the tokens with the JWK alone
+		encoded, _ := NewJWTToken("USER", "COMPANY").SignJWT(key)
+		parsed := NewJWTToken("", "")
+		parsed.ParseJWT(encoded)
+		if err = parsed.VerifyWithJWK(jwk); err != nil {
+			t.Errorf("The %s token does not verify with the JWK: [%s]", alg, err)
+		}
+		parsed.Payload.Subject = "SOMEONE"
+		parsed.signingInput, _ = parsed.encodeSigningInput()
+		if parsed.VerifyWithJWK(jwk) != ErrInvalidSignature {
+			t.Errorf("The changed %s token should not verify", alg)
+		}
+	}
+}
+
+func TestGetSigningKey(t *testing.T) {
+
+	ctx := context.Background()
+
+	site, err := GetSigningKey(ctx, nil)
+	if err != nil {
+		t.Errorf("The site key could not be created: [%s]", err)
+		return
+	}
+
+	company := NewCompany()
+	key, err := GetSigningKey(ctx, company)
+	if err != nil || key.KeyID != site.KeyID {
+		t.Errorf("The company should use the site key: [%v]", err)
+	}
+
+	company.Name = "SIGNING KEY COMPANY"
+	company.UniqueID = "SIGNINGKEYCOMPANY"
+	company.Settings.SigningKey = SigningKeyCompany
+	company.Settings.SigningAlgorithm = AlgES256
+	err = InsertCompany(ctx, company)
+	if err != nil {
+		t.Errorf("The company could not be inserted: [%s]", err)
+		return
+	}
+	defer RemoveCompanyByID(ctx, company.ID.Hex())
+
+	key, err = GetSigningKey(ctx, company)
+	if err != nil {
+		t.Errorf("The company key could not be created: [%s]", err)
+		return
+	}
+
+	if key.KeyID == site.KeyID || key.CompanyID != company.ID.Hex() || key.Algorithm != AlgES256 {
+		t.Errorf("The company key is invalid: [%s] [%s] [%s]", key.KeyID, key.CompanyID, key.Algorithm)
+	}
+
+	again, err := GetSigningKey(ctx, company)
+	if err != nil || again.KeyID != key.KeyID {
+		t.Errorf("The company key should be reused")
+	}
+
+	jwt := NewJWTToken("USERID", company.ID.Hex())
+	encoded, err := IssueJWT(ctx, jwt, company)
+	if err != nil {
+		t.Errorf("The token could not be issued: [%s]", err)
+		return
+	}
+
+	parsed := NewJWTToken("", "")
+	parsed.ParseJWT(encoded)
+	err = VerifyJWTSignature(ctx, parsed)
+	if err != nil {
+		t.Errorf("The token did not verify: [%s]", err)
+	}
+
+	//The key of the company does not verify the tokens of another one
+	parsed.Payload.Company = "ANOTHERCOMPANY"
+	if VerifyJWTSignature(ctx, parsed) != ErrInvalidSignature {
+		t.Errorf("A token of another company was verified with the company key")
+	}
+
+	parsed.Header.KeyID = "UNKNOWN"
+	if VerifyJWTSignature(ctx, parsed) != ErrInvalidSignature {
+		t.Errorf("A token with an unknown kid was verified")
+	}
+
+	set, err := GetJSONWebKeySet(ctx)
+	if err != nil || len(set.Keys) < 2 {
+		t.Errorf("The key set is invalid: [%v]", err)
+	}
+
+	company.Settings.SigningAlgorithm = "none"
+	if InsertCompany(ctx, company) == nil {
+		t.Errorf("The company was inserted with an invalid signing algorithm")
+	}
+}
+
+func TestSigningKeyEncryption(t *testing.T) {
+
+	ctx := context.Background()
+
+	//A key created before there was a key encryption key
+	legacy, err := NewSigningKey("", AlgES256)
+	if err != nil || legacy.IsEncrypted() {
+		t.Errorf("The key should be stored as plain PEM: [%v]", err)
+		return
+	}
+
+	if SetKeyEncryptionKey("TOOSHORT") == nil {
+		t.Errorf("An invalid key encryption key was accepted")
+	}
+
+	secret := base64.StdEncoding.EncodeToString([]byte("0123456789ABCDEF0123456789ABCDEF"))
+	err = SetKeyEncryptionKey(secret)
+	if err != nil {
+		t.Errorf("The key encryption key was not accepted: [%s]", err)
+		return
+	}
+
+	key, err := NewSigningKey("", AlgES256)
+	if err != nil {
+		t.Errorf("The key could not be created: [%s]", err)
+		return
+	}
+
+	if !key.IsEncrypted() || strings.Contains(key.PrivateKey, "PRIVATE KEY") {
+		t.Errorf("The private key is not encrypted: [%s]", key.PrivateKey)
+		return
+	}
+
+	//A copy read back from the database has to decrypt the key
+	stored := *key
+	stored.signer = nil
+	sig, err := stored.Sign([]byte("INPUT"))
+	if err != nil || key.Verify([]byte("INPUT"), sig) != nil {
+		t.Errorf("The encrypted key could not sign: [%v]", err)
+	}
+
+	//The sealed key is bound to its row
+	moved := *key
+	moved.signer = nil
+	moved.ID = legacy.ID
+	if _, err = moved.Sign([]byte("INPUT")); err == nil {
+		t.Errorf("A sealed key copied to another row was decrypted")
+	}
+
+	//The legacy keys are sealed at startup
+	err = InsertSigningKey(ctx, legacy)
+	if err != nil {
+		t.Errorf("The legacy key could not be inserted: [%s]", err)
+		return
+	}
+
+	count, err := EncryptSigningKeys(ctx)
+	if err != nil || count == 0 {
+		t.Errorf("The legacy keys were not encrypted: %d [%v]", count, err)
+	}
+
+	keys, _ := ListSigningKeys(ctx)
+	for _, k := range keys {
+		if !k.IsEncrypted() {
+			t.Errorf("The key [%s] is still stored as plain PEM", k.KeyID)
+		}
+	}
+
+	//The stored keys stay sealed, the tests that follow keep the key
+	aead := keyEncryption
+	keyEncryption = nil
+	stored.signer = nil
+	if _, err = stored.getSigner(); err == nil {
+		t.Errorf("The key was decrypted without the key encryption key")
+	}
+	keyEncryption = aead
+}
@@ -0,0 +1,114 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"com/novare/dbs"
+)
+
+/*
+modelStore - The store with each collection bound to its entity. The
+repositories only take the entity they hold, handing one the wrong type
+does not compile.
+*/
+type modelStore struct {
+	dbs.Store
+}
+
+//mStore - All the model functions go through the store. MongoDB remains
+//the default so existing deployments keep working unchanged.
+var mStore = modelStore{dbs.NewMongoStore(AuthRelayDatabaseName)}
+
+//SetStore - Select the persistence backend. It must be called at startup
+//before any request is served.
+func SetStore(store dbs.Store) {
+	mStore = modelStore{store}
+	forgetSigningKeys()
+}
+
+func (s modelStore) Users() dbs.UserRepository[User] {
+	return dbs.NewUserRepository[User](s.Store)
+}
+
+func (s modelStore) Roles() dbs.RoleRepository[Role] {
+	return dbs.NewRoleRepository[Role](s.Store)
+}
+
+func (s modelStore) Permissions() dbs.PermissionRepository[Permission] {
+	return dbs.NewPermissionRepository[Permission](s.Store)
+}
+
+func (s modelStore) Companies() dbs.CompanyRepository[Company] {
+	return dbs.NewCompanyRepository[Company](s.Store)
+}
+
+func (s modelStore) JWTs() dbs.JWTRepository[JWTToken] {
+	return dbs.NewJWTRepository[JWTToken](s.Store)
+}
+
+func (s modelStore) Config() dbs.ConfigRepository[Config] {
+	return dbs.NewConfigRepository[Config](s.Store)
+}
+
+func (s modelStore) SigningKeys() dbs.SigningKeyRepository[SigningKey] {
+	return dbs.NewSigningKeyRepository[SigningKey](s.Store)
+}
+
+func (s modelStore) RefreshTokens() dbs.RefreshTokenRepository[RefreshToken] {
+	return dbs.NewRefreshTokenRepository[RefreshToken](s.Store)
+}
+
+func (s modelStore) RevokedTokens() dbs.RevokedTokenRepository[RevokedToken] {
+	return dbs.NewRevokedTokenRepository[RevokedToken](s.Store)
+}
+
+func (s modelStore) OAuthClients() dbs.OAuthClientRepository[OAuthClient] {
+	return dbs.NewOAuthClientRepository[OAuthClient](s.Store)
+}
+
+func (s modelStore) AuthorizationCodes() dbs.AuthorizationCodeRepository[AuthorizationCode] {
+	return dbs.NewAuthorizationCodeRepository[AuthorizationCode](s.Store)
+}
+
+/*
+saveRevision - Optimistic concurrency for the Save functions. The revision
+is incremented and the entity is only saved if the stored one still has
+the revision it was read with, otherwise ErrConflict is returned and the
+revision is restored.
+*/
+func saveRevision(revision *int64, save func(expected int64) error) error {
+	expected := *revision
+	*revision = expected + 1
+	err := save(expected)
+	if err != nil {
+		*revision = expected
+	}
+	return err
+}
+
+//GetStore - The persistence backend currently in use
+func GetStore() dbs.Store {
+	return mStore.Store
+}
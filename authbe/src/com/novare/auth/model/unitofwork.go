@@ -0,0 +1,96 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+//RollbackTimeout - How long the compensations of a failed UnitOfWork can
+//take. They get their own context so a request that timed out still
+//cleans up after itself.
+var RollbackTimeout = 30 * time.Second
+
+type compensation struct {
+	name string
+	undo func(ctx context.Context) error
+}
+
+/*
+UnitOfWork - A sequence of steps that must all succeed. The stores do not
+share transactions, so every step comes with a compensation that undoes
+it. When a step fails, Rollback runs the compensations of the completed
+steps in the reverse order.
+*/
+type UnitOfWork struct {
+	done []compensation
+}
+
+//NewUnitOfWork - An empty unit of work
+func NewUnitOfWork() *UnitOfWork {
+	return new(UnitOfWork)
+}
+
+//Do - Run a step. The undo function is only recorded when do succeeds,
+//it can be nil when there is nothing to undo.
+func (uow *UnitOfWork) Do(ctx context.Context, name string, do func(ctx context.Context) error, undo func(ctx context.Context) error) error {
+	err := do(ctx)
+	if err != nil {
+		log.Printf("The step [%s] failed: [%s]", name, err)
+		return err
+	}
+
+	if undo != nil {
+		uow.done = append(uow.done, compensation{name: name, undo: undo})
+	}
+	return nil
+}
+
+/*
+Rollback - Undo the completed steps, the last one first. Every
+compensation runs even when an earlier one fails, the first error is
+returned so the caller can report the orphaned data.
+*/
+func (uow *UnitOfWork) Rollback() error {
+	ctx, cancel := context.WithTimeout(context.Background(), RollbackTimeout)
+	defer cancel()
+
+	var first error
+	for i := len(uow.done) - 1; i >= 0; i-- {
+		c := uow.done[i]
+		err := c.undo(ctx)
+		if err != nil {
+			log.Printf("The step [%s] could not be undone: [%s]", c.name, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+
+	uow.done = nil
+	return first
+}
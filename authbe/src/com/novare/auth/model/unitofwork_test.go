@@ -0,0 +1,77 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestUnitOfWorkRollback(t *testing.T) {
+	ctx := context.Background()
+
+	var undone []string
+	uow := NewUnitOfWork()
+	for _, name := range []string{"first", "second", "third"} {
+		n := name
+		err := uow.Do(ctx, n, func(ctx context.Context) error {
+			return nil
+		}, func(ctx context.Context) error {
+			undone = append(undone, n)
+			if n == "second" {
+				return errors.New("UndoFailed")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Errorf("The step [%s] should not have failed: [%s]", n, err)
+		}
+	}
+
+	err := uow.Do(ctx, "failing", func(ctx context.Context) error {
+		return errors.New("Failed")
+	}, func(ctx context.Context) error {
+		undone = append(undone, "failing")
+		return nil
+	})
+	if err == nil {
+		t.Errorf("The failing step should have returned its error")
+	}
+
+	err = uow.Rollback()
+	if err == nil {
+		t.Errorf("The failed compensation should have been reported")
+	}
+
+	if len(undone) != 3 || undone[0] != "third" || undone[1] != "second" || undone[2] != "first" {
+		t.Errorf("The completed steps should have been undone in reverse order: %v", undone)
+	}
+
+	err = uow.Rollback()
+	if err != nil || len(undone) != 3 {
+		t.Errorf("A second rollback should not undo anything: %v [%v]", undone, err)
+	}
+}
@@ -27,11 +27,13 @@ SOFTWARE.
 import (
 	"com/novare/dbs"
 	"com/novare/utils"
+	"context"
 	"errors"
 	"log"
+	"sort"
 	"unicode/utf8"
 
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 const (
@@ -43,35 +45,34 @@ const (
 	UserStatePasswordReset string = "passwordReset"
 )
 
-//For now all will point to the same database, in the future
-//that might change
-var mDBUser = dbs.NewMongoDB(AuthRelayDatabaseName, "Users")
-
 //User - Define the User structure
 type User struct {
-	ID             bson.ObjectId `json:"id" bson:"_id"` //This is required if we are going to use Mongo
-	Username       string        `json:"username"`      //Username
-	HashedPassword []byte        `json:"-"`             //The never include this in the JSON requests
-	Name           string        `json:"name"`          //The user's name/full name
-	Permissions    []Permission  `json:"permissions"`   //All the permissions assigned to the user. Note that permissions can go cross companies
-	CompanyID      string        `json:"companyID"`     //The companyID that created this user
-	Roles          []string      `json:"roles"`         //The Roles this user belongs to. Don't necessarily need a role
-	IsThing        bool          `json:"isThing"`       //This is for the devices/things that need approval
-	Secret         string        `json:"-"`             //This is the secret that should be kept with the user
-	UserStatus     string        `json:"userStatus"`    //Possible status are Enabled/Disabled/PasswordReset
+	ID             primitive.ObjectID `json:"id" bson:"_id"` //This is required if we are going to use Mongo
+	Username       string             `json:"username"`      //Username
+	HashedPassword []byte             `json:"-"`             //The never include this in the JSON requests
+	Name           string             `json:"name"`          //The user's name/full name
+	Permissions    []Permission       `json:"permissions"`   //All the permissions assigned to the user. Note that permissions can go cross companies
+	CompanyID      string             `json:"companyID"`     //The companyID that created this user
+	Roles          []string           `json:"roles"`         //The Roles this user belongs to. Don't necessarily need a role
+	IsThing        bool               `json:"isThing"`       //This is for the devices/things that need approval
+	Secret         string             `json:"-"`             //This is the secret that should be kept with the user
+	UserStatus     string             `json:"userStatus"`    //Possible status are Enabled/Disabled/PasswordReset
+	Revision       int64              `json:"revision"`      //Incremented by every save, see SaveUser
+	Scope          []string           `json:"-" bson:"-"`    //The scope of the session the user acts in, nil for every permission. Never stored
+	FamilyID       string             `json:"-" bson:"-"`    //The refresh token family of that session. Never stored
 }
 
 //SetPassword -  Will set the user's password
 func (user *User) SetPassword(pass string) error {
 
 	if !utils.IsSecurePassword(pass) {
-		return errors.New("UnsecurePassword")
+		return NewInputError("UnsecurePassword")
 	}
 
 	hPass, ok := utils.GetPassword(pass, user.ID.Hex())
 	if !ok {
 		log.Printf("The password was not correctly generated!")
-		return errors.New("InvalidPassword")
+		return NewInputError("InvalidPassword")
 	}
 
 	user.HashedPassword = hPass
@@ -84,14 +85,14 @@ func (user *User) IsPasswordMatch(pass string) bool {
 	return utils.IsValidPassword(pass, user.ID.Hex(), user.HashedPassword)
 }
 
-func isRoleGranted(roles []string, permission string) bool {
+func isRoleGranted(ctx context.Context, roles []string, permission string) bool {
 	//-------------------------------------------------
 	//Roles contain the IDs. We don't want to
 	//keep a reference or we will have to update
 	//the users everytime the permissions are updated
 	//-------------------------------------------------
 	for i := range roles {
-		rl, err := FindRoleByID(roles[i])
+		rl, err := FindRoleByID(ctx, roles[i])
 		if err != nil {
 			log.Printf("The Role for ID:[%s] was not found!", roles[i])
 			continue
@@ -105,10 +106,16 @@ func isRoleGranted(roles []string, permission string) bool {
 }
 
 //IsGranted will return true if the permission is granted to the role or false otherwise
-func (user *User) IsGranted(permission string) bool {
+func (user *User) IsGranted(ctx context.Context, permission string) bool {
+
+	//A session an app started for the user is limited to its scope
+	if user.Scope != nil && !isInScope(user.Scope, permission) {
+		log.Printf("The permission:[%s] is outside of the session scope of the user:[%s]", permission, user.ID.Hex())
+		return false
+	}
 
 	//First let's check the role
-	if isRoleGranted(user.Roles, permission) {
+	if isRoleGranted(ctx, user.Roles, permission) {
 		return true
 	}
 
@@ -132,10 +139,66 @@ func (user *User) IsGranted(permission string) bool {
 	return false
 }
 
+func isInScope(scope []string, permission string) bool {
+	for _, s := range scope {
+		if s == permission {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+GrantedPermissions - The names of the permissions IsGranted approves, the
+user's own and the ones of its roles, sorted. The superuser is granted
+every permission of the company. A session scope keeps only the ones in
+it.
+*/
+func (user *User) GrantedPermissions(ctx context.Context) ([]string, error) {
+
+	granted := make(map[string]bool)
+	if user.Username == "superuser" {
+		perms, err := ListPermissionsByCompanyID(ctx, user.CompanyID)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range perms {
+			granted[p.Permission] = true
+		}
+	}
+
+	for _, p := range user.Permissions {
+		granted[p.Permission] = true
+	}
+
+	for _, roleID := range user.Roles {
+		role, err := FindRoleByID(ctx, roleID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range role.Permissions {
+			granted[p.Permission] = true
+		}
+	}
+
+	names := make([]string, 0, len(granted))
+	for name := range granted {
+		if user.Scope != nil && !isInScope(user.Scope, name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
 //AddPermission to role
-func (user *User) AddPermission(permission Permission) {
+func (user *User) AddPermission(ctx context.Context, permission Permission) {
 
-	if user.IsGranted(permission.Permission) {
+	if user.IsGranted(ctx, permission.Permission) {
 		log.Printf("The permission has already been grated.")
 		return
 	}
@@ -208,7 +271,7 @@ func (user *User) ClearRoles() {
 //NewUser ...
 func NewUser() *User {
 	user := new(User)
-	user.ID = bson.NewObjectId()
+	user.ID = primitive.NewObjectID()
 	user.CompanyID = ""
 	//Default to enabled.
 	user.UserStatus = UserStateEnable
@@ -229,74 +292,109 @@ func isValidUser(user *User) bool {
 	return true
 }
 
-//SaveUser - Wrapper to the database.
-func SaveUser(user *User) error {
+//SaveUser - Wrapper to the database. ErrConflict when the user was
+//changed since it was read.
+func SaveUser(ctx context.Context, user *User) error {
 
 	if !isValidUser(user) {
-		return errors.New("InvalidUser")
+		return NewInputError("InvalidUser")
 	}
 
-	return mDBUser.Update(user, bson.M{"_id": user.ID})
+	return saveRevision(&user.Revision, func(expected int64) error {
+		return mStore.Users().SaveRevision(ctx, user, user.ID.Hex(), expected)
+	})
 }
 
 //InsertUser - Insert a user to the database
-func InsertUser(user *User) error {
+func InsertUser(ctx context.Context, user *User) error {
 
 	if !isValidUser(user) {
-		return errors.New("InvalidUser")
+		return NewInputError("InvalidUser")
 	}
 
-	return mDBUser.Insert(user, bson.M{"_id": user.ID})
+	return mStore.Users().Insert(ctx, user, user.ID.Hex())
 }
 
 //FindUserByID - Given an ID find the user
-func FindUserByID(ID string) (*User, error) {
+func FindUserByID(ctx context.Context, ID string) (*User, error) {
 
-	if !bson.IsObjectIdHex(ID) {
-		return nil, errors.New("InvalidID")
+	if !primitive.IsValidObjectID(ID) {
+		return nil, NewInputError("InvalidID")
 	}
 
 	user := NewUser()
-	err := mDBUser.Find(user, bson.M{"_id": bson.ObjectIdHex(ID)})
+	err := mStore.Users().FindByID(ctx, user, ID)
 	return user, err
 }
 
 //FindUserByUsernameCompanyID - The master account is always marked as Superuser
-func FindUserByUsernameCompanyID(username string, companyID string) (*User, error) {
+func FindUserByUsernameCompanyID(ctx context.Context, username string, companyID string) (*User, error) {
 
 	if utf8.RuneCountInString(username) == 0 {
-		return nil, errors.New("InvalidUsername")
+		return nil, NewInputError("InvalidUsername")
 	}
 
 	if utf8.RuneCountInString(companyID) == 0 {
-		return nil, errors.New("InvalidCompanyID")
+		return nil, NewInputError("InvalidCompanyID")
 	}
 
 	//Given a username and a company ID, check if it already exists
 	user := NewUser()
-	err := mDBUser.Find(user, bson.M{"$and": []bson.M{{"username": username}, {"companyid": companyID}}})
+	err := mStore.Users().FindByUsernameCompanyID(ctx, user, username, companyID)
 	return user, err
 }
 
 //IsUsernameDefined - This is just a convenience method.
-func IsUsernameDefined(username string, companyID string) bool {
-	_, err := FindUserByUsernameCompanyID(username, companyID)
+func IsUsernameDefined(ctx context.Context, username string, companyID string) bool {
+	_, err := FindUserByUsernameCompanyID(ctx, username, companyID)
 	return err == nil
 }
 
 //RemoveUserByID ...
-func RemoveUserByID(ID string) error {
+func RemoveUserByID(ctx context.Context, ID string) error {
 
-	if !bson.IsObjectIdHex(ID) {
-		return errors.New("InvalidID")
+	if !primitive.IsValidObjectID(ID) {
+		return NewInputError("InvalidID")
 	}
 
-	return mDBUser.Remove(bson.M{"_id": bson.ObjectIdHex(ID)})
+	return mStore.Users().RemoveByID(ctx, ID)
 }
 
 //ListUsersByCompanyID ...
-func ListUsersByCompanyID(companyID string) ([]User, error) {
+func ListUsersByCompanyID(ctx context.Context, companyID string) ([]User, error) {
 	var users []User
-	err := mDBUser.List(&users, bson.M{"companyid": companyID})
+	err := mStore.Users().ListByCompanyID(ctx, &users, companyID)
 	return users, err
 }
+
+//userSortFields - The fields the user lists can be sorted by
+var userSortFields = map[string]string{
+	"name":       "name",
+	"username":   "username",
+	"userStatus": "userstatus",
+	"isThing":    "isthing",
+}
+
+//QueryUsersByCompanyID - One page of the company's users and the number of
+//users matching the query. The name prefix applies to the user's name.
+func QueryUsersByCompanyID(ctx context.Context, companyID string, query UserQuery) ([]User, int64, error) {
+	opts, err := query.toOptions(userSortFields, "name")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	filter := dbs.Filter{}
+	if utf8.RuneCountInString(query.Username) > 0 {
+		filter["username"] = query.Username
+	}
+	if query.IsThing != nil {
+		filter["isthing"] = *query.IsThing
+	}
+	if utf8.RuneCountInString(query.Status) > 0 {
+		filter["userstatus"] = query.Status
+	}
+
+	var users []User
+	total, err := mStore.Users().QueryByCompanyID(ctx, &users, companyID, filter, opts)
+	return users, total, err
+}
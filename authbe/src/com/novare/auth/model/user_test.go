@@ -1,9 +1,10 @@
 package model
 
 import (
+	"context"
 	"testing"
 
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 /*
@@ -31,8 +32,9 @@ SOFTWARE.
 */
 
 func TestUserFunctions(t *testing.T) {
+	ctx := context.Background()
 
-	ID := bson.NewObjectId().Hex()
+	ID := primitive.NewObjectID().Hex()
 
 	perm := NewPermission()
 	perm.CompanyID = ID
@@ -40,33 +42,33 @@ func TestUserFunctions(t *testing.T) {
 	perm.Permission = "MY_PERMISSION"
 
 	user := NewUser()
-	user.AddPermission(*perm)
+	user.AddPermission(ctx, *perm)
 
 	perm1 := NewPermission()
 	perm1.CompanyID = ID
 	perm1.Description = "Permission2"
 	perm1.Permission = "MY_PERM_2"
-	user.AddPermission(*perm1)
+	user.AddPermission(ctx, *perm1)
 
 	user.CompanyID = ID
-	err := SaveUser(user)
+	err := SaveUser(ctx, user)
 	if err == nil {
 		t.Errorf("Invalid user! Error: [%s]", err)
 		return
 	}
 
-	err = InsertUser(user)
+	err = InsertUser(ctx, user)
 	if err != nil {
 		t.Errorf("The user could not be inserted: [%s]", err)
 		return
 	}
 
-	if !user.IsGranted(perm.Permission) {
+	if !user.IsGranted(ctx, perm.Permission) {
 		t.Errorf("The permission: [%s] was not granted", perm.Permission)
 		return
 	}
 
-	if !user.IsGranted(perm1.Permission) {
+	if !user.IsGranted(ctx, perm1.Permission) {
 		t.Errorf("The permission: [%s] was not granted", perm1.Permission)
 		return
 	}
@@ -87,13 +89,13 @@ func TestUserFunctions(t *testing.T) {
 		return
 	}
 
-	user, err = FindUserByID(user.ID.Hex())
+	user, err = FindUserByID(ctx, user.ID.Hex())
 	if err != nil {
 		t.Errorf("The user ID was not found! Error: [%s]", err)
 		return
 	}
 
-	users, err := ListUsersByCompanyID(ID)
+	users, err := ListUsersByCompanyID(ctx, ID)
 	if err != nil {
 		t.Errorf("Invalid users: [%s]", err)
 	}
@@ -101,7 +103,7 @@ func TestUserFunctions(t *testing.T) {
 	role := NewRole()
 	role.AddPermission(*perm1)
 	user.AddRole(role.ID.Hex())
-	if !user.IsGranted("MY_PERM_2") {
+	if !user.IsGranted(ctx, "MY_PERM_2") {
 		t.Errorf("The permission 2 should have been approved but, it was not")
 		return
 	}
@@ -118,7 +120,7 @@ func TestUserFunctions(t *testing.T) {
 	}
 
 	for i := range users {
-		err = RemoveUserByID(users[i].ID.Hex())
+		err = RemoveUserByID(ctx, users[i].ID.Hex())
 		if err != nil {
 			t.Errorf("The user could not be removed! Error:[%s]", err)
 		}
@@ -127,6 +129,7 @@ func TestUserFunctions(t *testing.T) {
 }
 
 func TestRemovingPermissionsAndRoles(t *testing.T) {
+	ctx := context.Background()
 
 	ID := "MYCOMPANY"
 
@@ -136,13 +139,13 @@ func TestRemovingPermissionsAndRoles(t *testing.T) {
 	perm.Permission = "MY_PERMISSION"
 
 	user := NewUser()
-	user.AddPermission(*perm)
+	user.AddPermission(ctx, *perm)
 
 	perm1 := NewPermission()
 	perm1.CompanyID = ID
 	perm1.Description = "Permission2"
 	perm1.Permission = "MY_PERM_2"
-	user.AddPermission(*perm1)
+	user.AddPermission(ctx, *perm1)
 
 	role := NewRole()
 	role.AddPermission(*perm1)
@@ -163,3 +166,61 @@ func TestRemovingPermissionsAndRoles(t *testing.T) {
 	user.ClearRoles()
 
 }
+
+func TestQueryUsersByCompanyID(t *testing.T) {
+	ctx := context.Background()
+
+	companyID := primitive.NewObjectID().Hex()
+	for _, name := range []string{"Maria", "Mark", "Ann", "Device"} {
+		user := NewUser()
+		user.Username = name + "@company"
+		user.Name = name
+		user.CompanyID = companyID
+		user.IsThing = name == "Device"
+		err := InsertUser(ctx, user)
+		if err != nil {
+			t.Errorf("The user [%s] could not be inserted: [%s]", name, err)
+			return
+		}
+	}
+
+	var query UserQuery
+	query.NamePrefix = "Ma"
+	query.SortBy = "name"
+	query.Descending = true
+	users, total, err := QueryUsersByCompanyID(ctx, companyID, query)
+	if err != nil || total != 2 {
+		t.Errorf("Two users have a name starting with Ma, total:[%d] error:[%v]", total, err)
+		return
+	}
+
+	if users[0].Name != "Mark" || users[1].Name != "Maria" {
+		t.Errorf("The users were not sorted by name in descending order: [%s] [%s]", users[0].Name, users[1].Name)
+	}
+
+	isThing := true
+	query = UserQuery{IsThing: &isThing}
+	users, total, err = QueryUsersByCompanyID(ctx, companyID, query)
+	if err != nil || total != 1 || users[0].Name != "Device" {
+		t.Errorf("Only the device should be returned, total:[%d] error:[%v]", total, err)
+	}
+
+	query = UserQuery{Username: "Ann@company"}
+	query.Skip = 1
+	users, total, err = QueryUsersByCompanyID(ctx, companyID, query)
+	if err != nil || total != 1 || len(users) != 0 {
+		t.Errorf("The total must not depend on the page, total:[%d] users:[%d] error:[%v]", total, len(users), err)
+	}
+
+	query = UserQuery{}
+	query.SortBy = "password"
+	_, _, err = QueryUsersByCompanyID(ctx, companyID, query)
+	if err == nil {
+		t.Errorf("Sorting by a field that is not exposed should fail")
+	}
+
+	users, _ = ListUsersByCompanyID(ctx, companyID)
+	for i := range users {
+		RemoveUserByID(ctx, users[i].ID.Hex())
+	}
+}
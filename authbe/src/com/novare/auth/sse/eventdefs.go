@@ -33,6 +33,10 @@ const (
 	EventRoleUpdate string = "RoleUpdate"
 	//EventCompanyUpdate ...
 	EventCompanyUpdate string = "CompanyUpdate"
+	//EventKeyRotation - The signing keys changed, fetch the JWKS again
+	EventKeyRotation string = "KeyRotation"
+	//EventTokenRevoked - A token was added to the revocation list
+	EventTokenRevoked string = "TokenRevoked"
 	//EventAll ...
 	EventAll string = "AllEvents"
 )
@@ -0,0 +1,355 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package dbs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+BoltDB - Embedded single file database. It is meant for sites that cannot
+run a MongoDB instance. Every collection is a bucket and every document is
+stored BSON encoded under its ID.
+*/
+type BoltDB struct {
+	db *bbolt.DB
+}
+
+//boltCollection - A bucket in the BoltDB file. The unique indexes are
+//only read and changed inside write transactions, bbolt serializes them.
+type boltCollection struct {
+	bolt    *BoltDB
+	bucket  []byte
+	indexes []Index
+}
+
+//boltStore - The store backed by the BoltDB, it must be closed
+//to release the file lock
+type boltStore struct {
+	*CollectionStore
+	bolt *BoltDB
+}
+
+//Close - Release the database file
+func (store *boltStore) Close() error {
+	return store.bolt.db.Close()
+}
+
+/*
+NewBoltStore - Open (or create) the database file at path and return a
+Store backed by it.
+*/
+func NewBoltStore(path string) (Store, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		log.Printf("The database file [%s] could not be opened: [%s]", path, err)
+		return nil, unavailable(err)
+	}
+
+	bolt := &BoltDB{db: db}
+	store := new(boltStore)
+	store.bolt = bolt
+	store.CollectionStore = NewCollectionStore(func(collName string) Collection {
+		return &boltCollection{bolt: bolt, bucket: []byte(collName)}
+	})
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range Collections {
+			_, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		log.Printf("The buckets could not be created in [%s]: [%s]", path, err)
+		return nil, unavailable(err)
+	}
+
+	err = store.EnsureIndexes(context.Background())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+//bkt - The bucket for the collection, created on demand
+func (c *boltCollection) bkt(tx *bbolt.Tx) (*bbolt.Bucket, error) {
+	if tx.Writable() {
+		return tx.CreateBucketIfNotExists(c.bucket)
+	}
+
+	b := tx.Bucket(c.bucket)
+	if b == nil {
+		return nil, ErrNotFound
+	}
+	return b, nil
+}
+
+//first - The key of the first document matching the filter
+func first(b *bbolt.Bucket, filter Filter) ([]byte, []byte) {
+	cursor := b.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		doc, err := decodeDocument(v)
+		if err != nil {
+			log.Printf("Skipping a document that could not be decoded [%s]", err)
+			continue
+		}
+		if isMatch(doc, filter) {
+			return k, v
+		}
+	}
+	return nil, nil
+}
+
+//hasDuplicate - True if a document other than the one stored under skipKey
+//has the same values for one of the unique indexes
+func (c *boltCollection) hasDuplicate(b *bbolt.Bucket, raw []byte, skipKey []byte) bool {
+	if len(c.indexes) == 0 {
+		return false
+	}
+
+	doc, err := decodeDocument(raw)
+	if err != nil {
+		return false
+	}
+
+	cursor := b.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		if string(k) == string(skipKey) {
+			continue
+		}
+		other, err := decodeDocument(v)
+		if err != nil {
+			continue
+		}
+		if isDuplicate(c.indexes, doc, other) {
+			return true
+		}
+	}
+	return false
+}
+
+//Insert - Add a new document
+func (c *boltCollection) Insert(ctx context.Context, obj interface{}, filter Filter) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+
+	raw, ID, err := encodeDocument(obj)
+	if err != nil {
+		log.Printf("The document could not be encoded [%s]", err)
+		return ErrInvalidInput
+	}
+
+	err = c.bolt.db.Update(func(tx *bbolt.Tx) error {
+		b, err := c.bkt(tx)
+		if err != nil {
+			return err
+		}
+
+		if b.Get([]byte(ID)) != nil || c.hasDuplicate(b, raw, nil) {
+			return ErrDuplicate
+		}
+
+		return b.Put([]byte(ID), raw)
+	})
+	return classify(err)
+}
+
+//Find - The first document matching the filter
+func (c *boltCollection) Find(ctx context.Context, resObj interface{}, filter Filter) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+
+	var raw []byte
+	err := c.bolt.db.View(func(tx *bbolt.Tx) error {
+		b, err := c.bkt(tx)
+		if err != nil {
+			return err
+		}
+
+		_, v := first(b, filter)
+		if v == nil {
+			return ErrNotFound
+		}
+		raw = append(raw, v...)
+		return nil
+	})
+	if err != nil {
+		return classify(err)
+	}
+
+	err = bson.Unmarshal(raw, resObj)
+	if err != nil {
+		return &Error{Kind: ErrInvalidInput, Err: err}
+	}
+	return nil
+}
+
+//Update - Replace the first document matching the filter
+func (c *boltCollection) Update(ctx context.Context, obj interface{}, filter Filter) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+
+	raw, ID, err := encodeDocument(obj)
+	if err != nil {
+		log.Printf("The document could not be encoded [%s]", err)
+		return ErrInvalidInput
+	}
+
+	err = c.bolt.db.Update(func(tx *bbolt.Tx) error {
+		b, err := c.bkt(tx)
+		if err != nil {
+			return err
+		}
+
+		k, _ := first(b, filter)
+		if k == nil {
+			return ErrNotFound
+		}
+
+		if c.hasDuplicate(b, raw, k) {
+			return ErrDuplicate
+		}
+
+		if string(k) != ID {
+			err = b.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		return b.Put([]byte(ID), raw)
+	})
+	return classify(err)
+}
+
+//List - All the documents matching the filter
+func (c *boltCollection) List(ctx context.Context, objs interface{}, filter Filter) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+
+	var docs [][]byte
+	err := c.bolt.db.View(func(tx *bbolt.Tx) error {
+		b, err := c.bkt(tx)
+		if err != nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			doc, err := decodeDocument(v)
+			if err != nil {
+				return nil
+			}
+			if isMatch(doc, filter) {
+				docs = append(docs, append([]byte(nil), v...))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Object - Finding error [%s]", err)
+		return unavailable(err)
+	}
+
+	return appendDocuments(objs, docs)
+}
+
+//Query - One page of the documents matching the filter and the total count
+func (c *boltCollection) Query(ctx context.Context, objs interface{}, filter Filter, opts ListOptions) (int64, error) {
+	if err := ctxErr(ctx); err != nil {
+		return 0, err
+	}
+
+	var docs []storedDocument
+	err := c.bolt.db.View(func(tx *bbolt.Tx) error {
+		b, err := c.bkt(tx)
+		if err != nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			doc, err := decodeDocument(v)
+			if err != nil {
+				return nil
+			}
+			if isMatch(doc, filter) {
+				docs = append(docs, storedDocument{append([]byte(nil), v...), doc})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Object - Finding error [%s]", err)
+		return 0, unavailable(err)
+	}
+
+	page, total := pageDocuments(docs, opts)
+	return total, appendDocuments(objs, page)
+}
+
+//EnsureIndex - Only the unique indexes matter, they are checked on every
+//Insert and Update. Nothing is stored in the file.
+func (c *boltCollection) EnsureIndex(ctx context.Context, index Index) error {
+	return c.bolt.db.Update(func(tx *bbolt.Tx) error {
+		c.indexes = addIndex(c.indexes, index)
+		return nil
+	})
+}
+
+//Remove - Remove the first document matching the filter
+func (c *boltCollection) Remove(ctx context.Context, filter Filter) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+
+	err := c.bolt.db.Update(func(tx *bbolt.Tx) error {
+		b, err := c.bkt(tx)
+		if err != nil {
+			return ErrNotFound
+		}
+
+		k, _ := first(b, filter)
+		if k == nil {
+			return ErrNotFound
+		}
+
+		return b.Delete(k)
+	})
+	return classify(err)
+}
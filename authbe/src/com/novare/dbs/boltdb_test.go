@@ -0,0 +1,220 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package dbs
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type boltTestUser struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Username  string
+	CompanyID string
+}
+
+func openTestBoltStore(t *testing.T) (Store, func()) {
+	dir, err := ioutil.TempDir("", "edgeauth")
+	if err != nil {
+		t.Fatalf("The temporary directory could not be created: [%s]", err)
+	}
+
+	store, err := NewBoltStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("The bolt store could not be opened: [%s]", err)
+	}
+
+	return store, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestBoltStoreUsers(t *testing.T) {
+
+	store, cleanup := openTestBoltStore(t)
+	defer cleanup()
+
+	users := NewUserRepository[boltTestUser](store)
+
+	var user boltTestUser
+	user.ID = primitive.NewObjectID()
+	user.Username = "superuser"
+	user.CompanyID = "COMPANY"
+
+	err := users.Insert(context.Background(), &user, user.ID.Hex())
+	if err != nil {
+		t.Errorf("The insert operation failed with error: [%s]", err)
+		return
+	}
+
+	err = users.Insert(context.Background(), &user, user.ID.Hex())
+	if err != ErrDuplicate {
+		t.Errorf("Inserting the same ID twice should have failed with ErrDuplicate: [%v]", err)
+	}
+
+	twin := user
+	twin.ID = primitive.NewObjectID()
+	err = users.Insert(context.Background(), &twin, twin.ID.Hex())
+	if err != ErrDuplicate {
+		t.Errorf("The username must be unique in the company: [%v]", err)
+	}
+
+	var found boltTestUser
+	err = users.FindByUsernameCompanyID(context.Background(), &found, "superuser", "COMPANY")
+	if err != nil {
+		t.Errorf("The user was not found: [%s]", err)
+		return
+	}
+
+	if found.ID != user.ID {
+		t.Errorf("The IDs do not match: %s != %s", found.ID.Hex(), user.ID.Hex())
+	}
+
+	err = users.FindByUsernameCompanyID(context.Background(), &found, "superuser", "OTHER")
+	if err == nil {
+		t.Errorf("The user should not have been found for another company")
+	}
+
+	user.Username = "changed"
+	err = users.Save(context.Background(), &user, user.ID.Hex())
+	if err != nil {
+		t.Errorf("The update operation failed with error: [%s]", err)
+	}
+
+	var lst []boltTestUser
+	err = users.ListByCompanyID(context.Background(), &lst, "COMPANY")
+	if err != nil {
+		t.Errorf("Listing the users failed: [%s]", err)
+		return
+	}
+
+	if len(lst) != 1 || lst[0].Username != "changed" {
+		t.Errorf("The list returned is not valid: %v", lst)
+	}
+
+	err = users.RemoveByID(context.Background(), user.ID.Hex())
+	if err != nil {
+		t.Errorf("The remove operation failed with error: [%s]", err)
+	}
+
+	err = users.FindByID(context.Background(), &found, user.ID.Hex())
+	if err == nil {
+		t.Errorf("The user should have been removed")
+	}
+}
+
+func TestBoltStoreConfig(t *testing.T) {
+
+	store, cleanup := openTestBoltStore(t)
+	defer cleanup()
+
+	type config struct {
+		ID     primitive.ObjectID `bson:"_id"`
+		RowID  int
+		Secret string
+	}
+
+	configs := NewConfigRepository[config](store)
+
+	cfg := config{primitive.NewObjectID(), 1, "SECRET"}
+	err := configs.Save(context.Background(), &cfg, cfg.RowID)
+	if err != nil {
+		t.Errorf("The configuration was not saved: [%s]", err)
+		return
+	}
+
+	cfg.Secret = "UPDATED"
+	err = configs.Save(context.Background(), &cfg, cfg.RowID)
+	if err != nil {
+		t.Errorf("The configuration was not updated: [%s]", err)
+	}
+
+	var found config
+	err = configs.FindByRowID(context.Background(), &found, 1)
+	if err != nil || found.Secret != "UPDATED" {
+		t.Errorf("The configuration retrieved is not valid: [%v] [%v]", found, err)
+	}
+}
+
+func TestBoltStoreQueryUsers(t *testing.T) {
+
+	store, cleanup := openTestBoltStore(t)
+	defer cleanup()
+
+	users := NewUserRepository[boltTestUser](store)
+
+	for _, name := range []string{"carol", "alice", "bob", "alan", "dave"} {
+		var user boltTestUser
+		user.ID = primitive.NewObjectID()
+		user.Username = name
+		user.CompanyID = "COMPANY"
+		err := users.Insert(context.Background(), &user, user.ID.Hex())
+		if err != nil {
+			t.Errorf("The user [%s] was not inserted: [%s]", name, err)
+			return
+		}
+	}
+
+	var lst []boltTestUser
+	opts := ListOptions{Skip: 1, Limit: 2, Sort: []SortKey{{Field: "username"}}}
+	total, err := users.QueryByCompanyID(context.Background(), &lst, "COMPANY", nil, opts)
+	if err != nil || total != 5 {
+		t.Errorf("The query should count every user of the company: [%d] [%v]", total, err)
+		return
+	}
+
+	if len(lst) != 2 || lst[0].Username != "alice" || lst[1].Username != "bob" {
+		t.Errorf("The second page of the sorted users is not valid: %v", lst)
+	}
+
+	opts = ListOptions{Sort: []SortKey{{Field: "username", Descending: true}}, Prefix: map[string]string{"username": "al"}}
+	total, err = users.QueryByCompanyID(context.Background(), &lst, "COMPANY", nil, opts)
+	if err != nil || total != 2 || len(lst) != 2 || lst[0].Username != "alice" {
+		t.Errorf("Only the users starting with al should be returned, in descending order: %v [%v]", lst, err)
+	}
+
+	total, err = users.QueryByCompanyID(context.Background(), &lst, "COMPANY", Filter{"username": "dave"}, ListOptions{Skip: 1})
+	if err != nil || total != 1 || len(lst) != 0 {
+		t.Errorf("Skipping past the only match should return an empty page: %v [%d] [%v]", lst, total, err)
+	}
+}
+
+func TestOpenStoreUnsupported(t *testing.T) {
+	for _, dbType := range []string{"bbolt", "Bolt", "postgres"} {
+		os.Setenv("AUTH_DB_TYPE", dbType)
+		store, err := OpenStore("test")
+		if !errors.Is(err, ErrInvalidInput) || store != nil {
+			t.Errorf("The AUTH_DB_TYPE [%s] should be rejected: [%v]", dbType, err)
+		}
+	}
+	os.Unsetenv("AUTH_DB_TYPE")
+}
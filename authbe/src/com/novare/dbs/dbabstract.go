@@ -25,12 +25,17 @@ SOFTWARE.
 package dbs
 
 import (
+	"context"
 	"errors"
 	"log"
 	"os"
+	"strings"
+	"sync"
 	"unicode/utf8"
 
-	"gopkg.in/mgo.v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 /*
@@ -39,195 +44,316 @@ I am not worried about making this too generic for now
 */
 type MongoDB struct {
 	//Private stuff
-	dbURL      string
-	mgoSession *mgo.Session
+	conn *mongoConn
 
 	//Public stuff
 	DBName   string
 	CollName string //Collection name
 }
 
+/*
+mongoConn - The client is safe for concurrent use and keeps its own
+connection pool, so every collection of a store shares the same one.
+*/
+type mongoConn struct {
+	lock   sync.Mutex
+	dbURL  string
+	client *mongo.Client
+}
+
 /*
 NewMongoDB ...
 Constructor for the MongoDB instance.
 Create a new instance of MongoDB
 */
 func NewMongoDB(dbName string, collName string) *MongoDB {
-	mongo := new(MongoDB)
-	mongo.DBName = dbName
-	//We will not support passing the URL initially
-	//If there is an use case later on, we can reconsider.
-	mongo.dbURL = ""
-	mongo.CollName = collName
-	return mongo
+	return newMongoDB(new(mongoConn), dbName, collName)
 }
 
-/*Initialize -
+func newMongoDB(conn *mongoConn, dbName string, collName string) *MongoDB {
+	db := new(MongoDB)
+	db.DBName = dbName
+	db.CollName = collName
+	db.conn = conn
+	return db
+}
+
+/*getMongoURL -
 Look for the environment variable AUTH_DB_URL
 If that is found use otherwise, assume it is for testing purposes only
-and connect straight to localhost.
+and connect straight to localhost. The URL is a standard connection string,
+so the pool size, read preference and write concern can be set there, e.g.
+mongodb://host:27017/?maxPoolSize=20&readPreference=primaryPreferred&w=majority
 */
-func (mongo *MongoDB) Initialize() error {
-	mgoURL := os.Getenv("AUTH_DB_URL")
-	if utf8.RuneCountInString(mgoURL) == 0 {
+func getMongoURL() string {
+	dbURL := os.Getenv("AUTH_DB_URL")
+	if utf8.RuneCountInString(dbURL) == 0 {
 		log.Printf("The AUTH_DB_URL parameter is not defined, defaulting to localhost.")
-		mongo.dbURL = "localhost"
-	} else {
-		mongo.dbURL = mgoURL
+		dbURL = "localhost"
 	}
 
-	if utf8.RuneCountInString(mongo.CollName) == 0 {
+	//Plain host names used to be accepted, keep accepting them
+	if !strings.HasPrefix(dbURL, "mongodb://") && !strings.HasPrefix(dbURL, "mongodb+srv://") {
+		dbURL = "mongodb://" + dbURL
+	}
+
+	return dbURL
+}
+
+/*Initialize -
+Connect to the database defined by AUTH_DB_URL.
+*/
+func (db *MongoDB) Initialize(ctx context.Context) error {
+	if utf8.RuneCountInString(db.CollName) == 0 {
 		log.Printf("The collection name must be defined or the abstraction will not work!")
-		return errors.New("InvalidCollectionName")
+		return &Error{Kind: ErrInvalidInput, Err: errors.New("InvalidCollectionName")}
 	}
+
 	//Let the called decide what to do next
-	return mongo.dialDatabase()
+	_, err := db.conn.getClient(ctx)
+	return err
 }
 
-func (mongo *MongoDB) dialDatabase() error {
-	tmp, err := mgo.Dial(mongo.dbURL)
+func (conn *mongoConn) dialDatabase(ctx context.Context) error {
+	conn.dbURL = getMongoURL()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(conn.dbURL))
 	if err != nil {
-		log.Printf("The database connection failed")
-		return errors.New("InvalidDBConnection")
+		log.Printf("The database connection failed [%s]", err)
+		return unavailable(err)
 	}
-	mongo.mgoSession = tmp
+	conn.client = client
 	return nil
 }
 
-/*getMongoSession - This function will copy the mongoDB session*/
-func (mongo *MongoDB) getMongoSession() (*mgo.Session, error) {
-	var sess *mgo.Session
-	if mongo.mgoSession != nil {
-		//log.Printf("The session is existent, trying and copying the session now")
-		sess = mongo.mgoSession.Copy()
-		return sess, nil
+/*getClient - The client is created once and reused afterwards*/
+func (conn *mongoConn) getClient(ctx context.Context) (*mongo.Client, error) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	if conn.client != nil {
+		return conn.client, nil
 	}
 
-	err := mongo.dialDatabase()
+	err := conn.dialDatabase(ctx)
 	if err != nil {
 		log.Printf("The connection to the database failed %s", err)
-		return nil, errors.New("InvalidDbConnection")
+		return nil, err
 	}
 
-	sess = mongo.mgoSession.Copy()
-	return sess, nil
+	return conn.client, nil
 }
 
-//Insert an object into the database, expecting a pointer
-func (mongo *MongoDB) Insert(obj interface{}, condition interface{}) error {
+//close - Disconnect the client if it was ever connected
+func (conn *mongoConn) close(ctx context.Context) error {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	if conn.client == nil {
+		return nil
+	}
 
-	mongoCon, err := mongo.getMongoSession()
+	err := conn.client.Disconnect(ctx)
+	conn.client = nil
+	return err
+}
+
+func (db *MongoDB) collection(ctx context.Context) (*mongo.Collection, error) {
+	client, err := db.conn.getClient(ctx)
 	if err != nil {
-		log.Printf("The Database session has not been found! ERROR: [%s]", err)
-		return errors.New("GenericDatabaseFailure")
+		return nil, err
+	}
+	return client.Database(db.DBName).Collection(db.CollName), nil
+}
+
+//mongoError - Translate the driver errors to the dbs errors
+func mongoError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return ErrNotFound
+	case mongo.IsDuplicateKeyError(err):
+		return ErrDuplicate
 	}
-	defer mongoCon.Close()
+	return unavailable(err)
+}
+
+//The driver does not accept a nil filter
+func toFilter(condition interface{}) interface{} {
+	if condition == nil {
+		return bson.M{}
+	}
+	return condition
+}
 
-	// Optional. Switch the session to a monotonic behavior.
-	mongoCon.SetMode(mgo.Monotonic, true)
+//Insert an object into the database, expecting a pointer
+func (db *MongoDB) Insert(ctx context.Context, obj interface{}, condition interface{}) error {
 
-	c := mongoCon.DB(mongo.DBName).C(mongo.CollName)
+	c, err := db.collection(ctx)
+	if err != nil {
+		log.Printf("The Database session has not been found! ERROR: [%s]", err)
+		return err
+	}
 
-	cnt, err := mongoCon.DB(mongo.DBName).C(mongo.CollName).Find(condition).Count()
-	if err != nil && cnt > 0 {
-		return errors.New("ConditionFailed")
+	cnt, err := c.CountDocuments(ctx, toFilter(condition))
+	if err != nil {
+		log.Printf("The insert condition could not be checked: [%s]", err)
+		return mongoError(err)
 	}
 
-	// Insert the customer into the database
-	err = c.Insert(obj)
+	if cnt > 0 {
+		return ErrDuplicate
+	}
 
+	// Insert the customer into the database. The unique indexes still
+	// catch the documents inserted concurrently after the count.
+	_, err = c.InsertOne(ctx, obj)
 	if err != nil {
 		log.Printf("Database insertion failed with ERROR: [%s]", err)
-		return errors.New("GenericDatabaseFailure")
+		return mongoError(err)
 	}
 
 	return nil
 }
 
 //Find - Find a customer or return with an error
-func (mongo *MongoDB) Find(resObj interface{}, condition interface{}) error {
+func (db *MongoDB) Find(ctx context.Context, resObj interface{}, condition interface{}) error {
 
-	sess, err := mongo.getMongoSession()
+	c, err := db.collection(ctx)
 	if err != nil {
 		log.Printf("Connection is not valid ")
-		return errors.New("Invalid database connection")
+		return err
 	}
-	defer sess.Close()
 
-	mgoCursor := sess.DB(mongo.DBName).C(mongo.CollName)
-	err = mgoCursor.Find(condition).One(resObj)
+	err = c.FindOne(ctx, toFilter(condition)).Decode(resObj)
 	if err != nil {
 		log.Printf("Error retrieving data from datasase [%s]", err)
-		return errors.New("Notfound")
+		return mongoError(err)
 	}
 
 	return nil
 }
 
 //Update -
-func (mongo *MongoDB) Update(obj interface{}, condition interface{}) error {
-	sess, err := mongo.getMongoSession()
+func (db *MongoDB) Update(ctx context.Context, obj interface{}, condition interface{}) error {
+	c, err := db.collection(ctx)
 	if err != nil {
 		log.Printf("Generic Database failure [%s]", err)
-		return errors.New("GenericDatabaseFailure")
+		return err
 	}
-	defer sess.Close()
-
-	// Optional. Switch the session to a monotonic behavior.
-	sess.SetMode(mgo.Monotonic, true)
-
-	c := sess.DB(mongo.DBName).C(mongo.CollName)
 
 	// Update the customer into the database
-	err = c.Update(condition, obj)
+	res, err := c.ReplaceOne(ctx, toFilter(condition), obj)
+	if err == nil && res.MatchedCount == 0 {
+		err = mongo.ErrNoDocuments
+	}
 
 	if err != nil {
 		log.Printf("Update failed! (%s) ", err)
-		return errors.New("NotFound")
+		return mongoError(err)
 	}
 
 	return nil
 }
 
 //List - List all for customer
-func (mongo *MongoDB) List(objs interface{}, condition interface{}) error {
+func (db *MongoDB) List(ctx context.Context, objs interface{}, condition interface{}) error {
 
-	sess, err := mongo.getMongoSession()
+	c, err := db.collection(ctx)
 	if err != nil {
 		log.Printf("The DB has not been found [%s]", err)
-		return errors.New("GenericDatabaseFailure")
+		return err
 	}
-	defer sess.Close()
 
-	// Optional. Switch the session to a monotonic behavior.
-	sess.SetMode(mgo.Monotonic, true)
-
-	c := sess.DB(mongo.DBName).C(mongo.CollName)
+	cursor, err := c.Find(ctx, toFilter(condition))
+	if err == nil {
+		err = cursor.All(ctx, objs)
+	}
 
-	err = c.Find(condition).All(objs)
 	if err != nil {
 		log.Printf("Object - Finding error [%s]", err)
-		return errors.New("NotFound")
+		return mongoError(err)
+	}
+
+	return nil
+}
+
+//EnsureIndex - Create the index on keys unless it already exists
+func (db *MongoDB) EnsureIndex(ctx context.Context, keys bson.D, unique bool) error {
+
+	c, err := db.collection(ctx)
+	if err != nil {
+		log.Printf("The DB has not been found [%s]", err)
+		return err
+	}
+
+	model := mongo.IndexModel{Keys: keys, Options: options.Index().SetUnique(unique)}
+	_, err = c.Indexes().CreateOne(ctx, model)
+	if err != nil {
+		log.Printf("The index could not be created [%s]", err)
+		return mongoError(err)
 	}
 
 	return nil
 }
 
+/*
+Query - One page of the documents matching the condition, in the order
+given by sort, and the total number of documents matching the condition.
+A limit of 0 means no limit.
+*/
+func (db *MongoDB) Query(ctx context.Context, objs interface{}, condition interface{}, skip int64, limit int64, sort bson.D) (int64, error) {
+
+	c, err := db.collection(ctx)
+	if err != nil {
+		log.Printf("The DB has not been found [%s]", err)
+		return 0, err
+	}
+
+	total, err := c.CountDocuments(ctx, toFilter(condition))
+	if err != nil {
+		log.Printf("Counting the documents failed [%s]", err)
+		return 0, mongoError(err)
+	}
+
+	opts := options.Find().SetSkip(skip)
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	if len(sort) > 0 {
+		opts.SetSort(sort)
+	}
+
+	cursor, err := c.Find(ctx, toFilter(condition), opts)
+	if err == nil {
+		err = cursor.All(ctx, objs)
+	}
+
+	if err != nil {
+		log.Printf("Object - Finding error [%s]", err)
+		return 0, mongoError(err)
+	}
+
+	return total, nil
+}
+
 //Remove - Find a customer or return with an error
-func (mongo *MongoDB) Remove(condition interface{}) error {
+func (db *MongoDB) Remove(ctx context.Context, condition interface{}) error {
 
-	sess, err := mongo.getMongoSession()
+	c, err := db.collection(ctx)
 	if err != nil {
 		log.Printf("Connection is not valid ")
-		return errors.New("Invalid database connection")
+		return err
+	}
+
+	res, err := c.DeleteOne(ctx, toFilter(condition))
+	if err == nil && res.DeletedCount == 0 {
+		err = mongo.ErrNoDocuments
 	}
-	defer sess.Close()
 
-	mgoCursor := sess.DB(mongo.DBName).C(mongo.CollName)
-	err = mgoCursor.Remove(condition)
 	if err != nil {
 		log.Printf("Error removing data from database [%s]", err)
-		return errors.New("Not found")
+		return mongoError(err)
 	}
 
 	return nil
@@ -24,10 +24,12 @@ SOFTWARE.
 package dbs
 
 import (
+	"context"
 	"testing"
 	"unicode/utf8"
 
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 const database = "TESTDB"
@@ -37,7 +39,7 @@ func TestDBAbstraction(t *testing.T) {
 	db := NewMongoDB(database, "MyCollection")
 
 	type testStruct struct {
-		ID     bson.ObjectId `json:"id" bson:"_id"`
+		ID     primitive.ObjectID `json:"id" bson:"_id"`
 		Value1 string
 		Value2 string
 		Value3 string
@@ -45,26 +47,26 @@ func TestDBAbstraction(t *testing.T) {
 	}
 
 	var test testStruct
-	test.ID = bson.NewObjectId()
+	test.ID = primitive.NewObjectID()
 	test.Value1 = "Value 1"
 	test.Value2 = "Value 2"
 	test.Value3 = "Value 3"
 	test.Value4 = "Value 4"
 
-	err := db.Initialize()
+	err := db.Initialize(context.Background())
 	if err != nil {
 		t.Errorf("The following error occurred: [%s]", err)
 		return
 	}
 
-	err = db.Insert(&test, bson.M{"_id": test.ID})
+	err = db.Insert(context.Background(), &test, bson.M{"_id": test.ID})
 	if err != nil {
 		t.Errorf("The insert operation failed with error: [%s]", err)
 		return
 	}
 
 	var test2 testStruct
-	err = db.Find(&test2, bson.M{"_id": test.ID})
+	err = db.Find(context.Background(), &test2, bson.M{"_id": test.ID})
 	if err != nil {
 		t.Errorf("The record with ID:[%s] was not found", test.ID.Hex())
 		return
@@ -76,7 +78,7 @@ func TestDBAbstraction(t *testing.T) {
 	}
 
 	var lst []testStruct
-	err = db.List(&lst, nil)
+	err = db.List(context.Background(), &lst, nil)
 	if err != nil {
 		t.Errorf("There was an error retrieving all the records from the database: [%s]", err)
 		return
@@ -98,7 +100,7 @@ func TestDBAbstraction(t *testing.T) {
 
 	for i := range lst {
 		t1 := lst[i]
-		err = db.Remove(bson.M{"_id": t1.ID})
+		err = db.Remove(context.Background(), bson.M{"_id": t1.ID})
 		if err != nil {
 			t.Errorf("The following error occurred: %s", err)
 		}
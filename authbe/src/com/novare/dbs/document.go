@@ -0,0 +1,268 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package dbs
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//The embedded backends keep every document BSON encoded, the same way
+//MongoDB would, so the field names used in a Filter are the same for
+//every backend.
+
+//normalizeValue - Make values decoded from BSON comparable with the
+//values used in a Filter
+func normalizeValue(v interface{}) interface{} {
+	switch tv := v.(type) {
+	case primitive.ObjectID:
+		return tv.Hex()
+	case int:
+		return int64(tv)
+	case int32:
+		return int64(tv)
+	}
+	return v
+}
+
+//decodeDocument - Decode a raw document so it can be matched
+func decodeDocument(raw []byte) (bson.M, error) {
+	doc := bson.M{}
+	err := bson.Unmarshal(raw, &doc)
+	return doc, err
+}
+
+//isMatch - True if the document satisfies every condition in the filter
+func isMatch(doc bson.M, filter Filter) bool {
+	for k, v := range filter {
+		stored, ok := doc[k]
+		if !ok {
+			return false
+		}
+		if !reflect.DeepEqual(normalizeValue(stored), normalizeValue(v)) {
+			return false
+		}
+	}
+	return true
+}
+
+//hasPrefixes - True if every prefixed field of the document is a string
+//starting with its prefix
+func hasPrefixes(doc bson.M, prefix map[string]string) bool {
+	for k, p := range prefix {
+		s, ok := doc[k].(string)
+		if !ok || !strings.HasPrefix(s, p) {
+			return false
+		}
+	}
+	return true
+}
+
+//compareValues - Order two stored values. Missing values sort first.
+func compareValues(a interface{}, b interface{}) int {
+	a = normalizeValue(a)
+	b = normalizeValue(b)
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+
+	switch ta := a.(type) {
+	case string:
+		if tb, ok := b.(string); ok {
+			return strings.Compare(ta, tb)
+		}
+	case int64:
+		if tb, ok := b.(int64); ok {
+			switch {
+			case ta < tb:
+				return -1
+			case ta > tb:
+				return 1
+			}
+			return 0
+		}
+	case float64:
+		if tb, ok := b.(float64); ok {
+			switch {
+			case ta < tb:
+				return -1
+			case ta > tb:
+				return 1
+			}
+			return 0
+		}
+	case bool:
+		if tb, ok := b.(bool); ok {
+			switch {
+			case ta == tb:
+				return 0
+			case !ta:
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+//storedDocument - A raw document and its decoded form
+type storedDocument struct {
+	raw []byte
+	doc bson.M
+}
+
+/*
+pageDocuments - Apply the prefixes, the sort order and the page in
+ListOptions to the documents that already matched the filter. The total
+is the number of documents before the page is applied.
+*/
+func pageDocuments(docs []storedDocument, opts ListOptions) ([][]byte, int64) {
+	var selected []storedDocument
+	for i := range docs {
+		if hasPrefixes(docs[i].doc, opts.Prefix) {
+			selected = append(selected, docs[i])
+		}
+	}
+
+	if len(opts.Sort) > 0 {
+		sort.SliceStable(selected, func(i, j int) bool {
+			for _, key := range opts.Sort {
+				c := compareValues(selected[i].doc[key.Field], selected[j].doc[key.Field])
+				if c == 0 {
+					continue
+				}
+				if key.Descending {
+					return c > 0
+				}
+				return c < 0
+			}
+			return false
+		})
+	}
+
+	total := int64(len(selected))
+	start := opts.Skip
+	if start < 0 {
+		start = 0
+	}
+	if start > total {
+		start = total
+	}
+	end := total
+	if opts.Limit > 0 && start+opts.Limit < total {
+		end = start + opts.Limit
+	}
+
+	var page [][]byte
+	for i := start; i < end; i++ {
+		page = append(page, selected[i].raw)
+	}
+	return page, total
+}
+
+//isDuplicate - True if both documents have the same values for every
+//field of one of the unique indexes
+func isDuplicate(indexes []Index, doc bson.M, other bson.M) bool {
+	for _, index := range indexes {
+		if !index.Unique || len(index.Fields) == 0 {
+			continue
+		}
+
+		same := true
+		for _, field := range index.Fields {
+			if !reflect.DeepEqual(normalizeValue(doc[field]), normalizeValue(other[field])) {
+				same = false
+				break
+			}
+		}
+		if same {
+			return true
+		}
+	}
+	return false
+}
+
+//addIndex - Add the index to the list unless it is already there
+func addIndex(indexes []Index, index Index) []Index {
+	for i := range indexes {
+		if reflect.DeepEqual(indexes[i], index) {
+			return indexes
+		}
+	}
+	return append(indexes, index)
+}
+
+//encodeDocument - Encode the object and extract the key it is stored under
+func encodeDocument(obj interface{}) ([]byte, string, error) {
+	raw, err := bson.Marshal(obj)
+	if err != nil {
+		return nil, "", err
+	}
+
+	doc, err := decodeDocument(raw)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ID, ok := normalizeValue(doc["_id"]).(string)
+	if !ok || len(ID) == 0 {
+		return nil, "", &Error{Kind: ErrInvalidInput, Err: errors.New("InvalidDocumentID")}
+	}
+
+	return raw, ID, nil
+}
+
+//appendDocuments - Decode the raw documents into the slice objs points to
+func appendDocuments(objs interface{}, docs [][]byte) error {
+	ptr := reflect.ValueOf(objs)
+	if ptr.Kind() != reflect.Ptr || ptr.Elem().Kind() != reflect.Slice {
+		return &Error{Kind: ErrInvalidInput, Err: errors.New("InvalidListArgument")}
+	}
+
+	slice := ptr.Elem()
+	slice.Set(slice.Slice(0, 0))
+	for i := range docs {
+		elem := reflect.New(slice.Type().Elem())
+		err := bson.Unmarshal(docs[i], elem.Interface())
+		if err != nil {
+			return &Error{Kind: ErrInvalidInput, Err: err}
+		}
+		slice.Set(reflect.Append(slice, elem.Elem()))
+	}
+
+	return nil
+}
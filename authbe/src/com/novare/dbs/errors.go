@@ -0,0 +1,94 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package dbs
+
+import (
+	"context"
+	"errors"
+)
+
+//The errors every backend returns. Callers compare with errors.Is, the
+//backends may wrap them in an *Error to keep the cause.
+var (
+	//ErrNotFound - No document matched the filter
+	ErrNotFound = errors.New("NotFound")
+
+	//ErrDuplicate - Returned by Insert and Update when the document would
+	//break a unique index, e.g. a second company with the same uniqueid.
+	ErrDuplicate = errors.New("DuplicateKey")
+
+	//ErrConflict - The document changed since it was read
+	ErrConflict = errors.New("Conflict")
+
+	//ErrUnavailable - The database could not be reached or did not answer
+	//before the deadline
+	ErrUnavailable = errors.New("DatabaseUnavailable")
+
+	//ErrInvalidInput - The document or the arguments cannot be stored
+	ErrInvalidInput = errors.New("InvalidInput")
+)
+
+/*
+Error - One of the errors above together with what caused it. errors.Is
+matches the Kind, so callers never need to look at the cause.
+*/
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+//Unwrap - Lets errors.Is and errors.As find the Kind
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+//unavailable - Classify a driver or context error. Both a cancelled
+//request and a database that is down end up here.
+func unavailable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: ErrUnavailable, Err: err}
+}
+
+//classify - Keep the errors that already have a Kind, anything else
+//comes from the driver and is classified as ErrUnavailable
+func classify(err error) error {
+	for _, kind := range []error{ErrNotFound, ErrDuplicate, ErrConflict, ErrUnavailable, ErrInvalidInput} {
+		if errors.Is(err, kind) {
+			return err
+		}
+	}
+	return unavailable(err)
+}
+
+//ctxErr - The context error classified as ErrUnavailable
+func ctxErr(ctx context.Context) error {
+	return unavailable(ctx.Err())
+}
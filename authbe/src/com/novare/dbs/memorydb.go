@@ -0,0 +1,256 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package dbs
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+MemoryDB - Keeps every collection in memory. Nothing survives a restart,
+so it is only meant for tests and demos. The documents are kept BSON
+encoded, that way callers never share memory with the stored copy.
+*/
+type MemoryDB struct {
+	lock sync.RWMutex
+}
+
+//memoryCollection - The documents in insertion order
+type memoryCollection struct {
+	db      *MemoryDB
+	keys    []string
+	docs    map[string][]byte
+	indexes []Index
+}
+
+//NewMemoryStore - Create an empty Store that lives in memory. The
+//unique indexes are enforced from the start.
+func NewMemoryStore() Store {
+	mem := new(MemoryDB)
+	store := NewCollectionStore(func(collName string) Collection {
+		return &memoryCollection{db: mem, docs: make(map[string][]byte)}
+	})
+	store.EnsureIndexes(context.Background())
+	return store
+}
+
+//hasDuplicate - True if a document other than skipID has the same values
+//for one of the unique indexes
+func (c *memoryCollection) hasDuplicate(raw []byte, skipID string) bool {
+	doc, err := decodeDocument(raw)
+	if err != nil {
+		return false
+	}
+
+	for _, ID := range c.keys {
+		if ID == skipID {
+			continue
+		}
+		other, err := decodeDocument(c.docs[ID])
+		if err != nil {
+			continue
+		}
+		if isDuplicate(c.indexes, doc, other) {
+			return true
+		}
+	}
+	return false
+}
+
+//first - The index of the first document matching the filter
+func (c *memoryCollection) first(filter Filter) int {
+	for i := range c.keys {
+		doc, err := decodeDocument(c.docs[c.keys[i]])
+		if err != nil {
+			log.Printf("Skipping a document that could not be decoded [%s]", err)
+			continue
+		}
+		if isMatch(doc, filter) {
+			return i
+		}
+	}
+	return -1
+}
+
+//Insert - Add a new document
+func (c *memoryCollection) Insert(ctx context.Context, obj interface{}, filter Filter) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+
+	raw, ID, err := encodeDocument(obj)
+	if err != nil {
+		log.Printf("The document could not be encoded [%s]", err)
+		return ErrInvalidInput
+	}
+
+	c.db.lock.Lock()
+	defer c.db.lock.Unlock()
+
+	if _, ok := c.docs[ID]; ok || c.hasDuplicate(raw, "") {
+		return ErrDuplicate
+	}
+
+	c.keys = append(c.keys, ID)
+	c.docs[ID] = raw
+	return nil
+}
+
+//Find - The first document matching the filter
+func (c *memoryCollection) Find(ctx context.Context, resObj interface{}, filter Filter) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+
+	c.db.lock.RLock()
+	idx := c.first(filter)
+	var raw []byte
+	if idx >= 0 {
+		raw = c.docs[c.keys[idx]]
+	}
+	c.db.lock.RUnlock()
+
+	if raw == nil {
+		return ErrNotFound
+	}
+
+	err := bson.Unmarshal(raw, resObj)
+	if err != nil {
+		return &Error{Kind: ErrInvalidInput, Err: err}
+	}
+	return nil
+}
+
+//Update - Replace the first document matching the filter
+func (c *memoryCollection) Update(ctx context.Context, obj interface{}, filter Filter) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+
+	raw, ID, err := encodeDocument(obj)
+	if err != nil {
+		log.Printf("The document could not be encoded [%s]", err)
+		return ErrInvalidInput
+	}
+
+	c.db.lock.Lock()
+	defer c.db.lock.Unlock()
+
+	idx := c.first(filter)
+	if idx < 0 {
+		return ErrNotFound
+	}
+
+	if c.hasDuplicate(raw, c.keys[idx]) {
+		return ErrDuplicate
+	}
+
+	delete(c.docs, c.keys[idx])
+	c.keys[idx] = ID
+	c.docs[ID] = raw
+	return nil
+}
+
+//List - All the documents matching the filter
+func (c *memoryCollection) List(ctx context.Context, objs interface{}, filter Filter) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+
+	var docs [][]byte
+
+	c.db.lock.RLock()
+	for i := range c.keys {
+		raw := c.docs[c.keys[i]]
+		doc, err := decodeDocument(raw)
+		if err != nil {
+			continue
+		}
+		if isMatch(doc, filter) {
+			docs = append(docs, raw)
+		}
+	}
+	c.db.lock.RUnlock()
+
+	return appendDocuments(objs, docs)
+}
+
+//Query - One page of the documents matching the filter and the total count
+func (c *memoryCollection) Query(ctx context.Context, objs interface{}, filter Filter, opts ListOptions) (int64, error) {
+	if err := ctxErr(ctx); err != nil {
+		return 0, err
+	}
+
+	var docs []storedDocument
+
+	c.db.lock.RLock()
+	for i := range c.keys {
+		raw := c.docs[c.keys[i]]
+		doc, err := decodeDocument(raw)
+		if err != nil {
+			continue
+		}
+		if isMatch(doc, filter) {
+			docs = append(docs, storedDocument{raw, doc})
+		}
+	}
+	c.db.lock.RUnlock()
+
+	page, total := pageDocuments(docs, opts)
+	return total, appendDocuments(objs, page)
+}
+
+//EnsureIndex - Only the unique indexes matter, they are checked on
+//every Insert and Update
+func (c *memoryCollection) EnsureIndex(ctx context.Context, index Index) error {
+	c.db.lock.Lock()
+	defer c.db.lock.Unlock()
+
+	c.indexes = addIndex(c.indexes, index)
+	return nil
+}
+
+//Remove - Remove the first document matching the filter
+func (c *memoryCollection) Remove(ctx context.Context, filter Filter) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+
+	c.db.lock.Lock()
+	defer c.db.lock.Unlock()
+
+	idx := c.first(filter)
+	if idx < 0 {
+		return ErrNotFound
+	}
+
+	delete(c.docs, c.keys[idx])
+	c.keys = append(c.keys[0:idx], c.keys[idx+1:]...)
+	return nil
+}
@@ -0,0 +1,258 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package dbs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMemoryStoreCompanies(t *testing.T) {
+
+	type company struct {
+		ID           primitive.ObjectID `bson:"_id"`
+		UniqueID     string
+		GroupOwnerID string
+	}
+
+	store := NewMemoryStore()
+	defer store.Close()
+
+	companies := NewCompanyRepository[company](store)
+
+	owner := company{primitive.NewObjectID(), "OWNER", ""}
+	member := company{primitive.NewObjectID(), "MEMBER", owner.ID.Hex()}
+
+	for _, c := range []company{owner, member} {
+		c := c
+		err := companies.Insert(context.Background(), &c, c.ID.Hex())
+		if err != nil {
+			t.Errorf("The company [%s] was not inserted: [%s]", c.UniqueID, err)
+			return
+		}
+	}
+
+	var found company
+	err := companies.FindByUniqueID(context.Background(), &found, "MEMBER")
+	if err != nil || found.ID != member.ID {
+		t.Errorf("The company was not found by its unique ID: [%v]", err)
+	}
+
+	//Changing the copy must not change what is stored
+	found.UniqueID = "CHANGED"
+	err = companies.FindByID(context.Background(), &found, member.ID.Hex())
+	if err != nil || found.UniqueID != "MEMBER" {
+		t.Errorf("The stored document should not share memory with the caller")
+	}
+
+	var lst []company
+	err = companies.ListByGroupOwnerID(context.Background(), &lst, owner.ID.Hex())
+	if err != nil || len(lst) != 1 || lst[0].ID != member.ID {
+		t.Errorf("The group should contain only the member: [%v] [%v]", lst, err)
+	}
+
+	err = companies.List(context.Background(), &lst)
+	if err != nil || len(lst) != 2 || lst[0].ID != owner.ID {
+		t.Errorf("All the companies should be listed in insertion order: [%v] [%v]", lst, err)
+	}
+
+	err = companies.RemoveByID(context.Background(), owner.ID.Hex())
+	if err != nil {
+		t.Errorf("The company was not removed: [%s]", err)
+	}
+
+	err = companies.RemoveByID(context.Background(), owner.ID.Hex())
+	if err == nil {
+		t.Errorf("Removing the company twice should fail")
+	}
+}
+
+func TestMemoryStoreQueryRoles(t *testing.T) {
+
+	type role struct {
+		ID          primitive.ObjectID `bson:"_id"`
+		Description string
+		CompanyID   string
+	}
+
+	store := NewMemoryStore()
+	defer store.Close()
+
+	roles := NewRoleRepository[role](store)
+
+	for i, desc := range []string{"Manager", "Cashier", "Maintenance", "Auditor"} {
+		companyID := "COMPANY"
+		if i == 3 {
+			companyID = "OTHER"
+		}
+		r := role{primitive.NewObjectID(), desc, companyID}
+		err := roles.Insert(context.Background(), &r, r.ID.Hex())
+		if err != nil {
+			t.Errorf("The role [%s] was not inserted: [%s]", desc, err)
+			return
+		}
+	}
+
+	var lst []role
+	opts := ListOptions{Limit: 1, Prefix: map[string]string{"description": "Ma"}, Sort: []SortKey{{Field: "description"}}}
+	total, err := roles.QueryByCompanyID(context.Background(), &lst, "COMPANY", nil, opts)
+	if err != nil || total != 2 {
+		t.Errorf("Two roles of the company start with Ma: [%d] [%v]", total, err)
+		return
+	}
+
+	if len(lst) != 1 || lst[0].Description != "Maintenance" {
+		t.Errorf("The first page should only contain Maintenance: %v", lst)
+	}
+
+	//Without a sort key the insertion order is kept
+	total, err = roles.QueryByCompanyID(context.Background(), &lst, "COMPANY", Filter{"companyid": "OTHER"}, ListOptions{})
+	if err != nil || total != 3 || lst[0].Description != "Manager" {
+		t.Errorf("The filter must not replace the company ID: %v [%d] [%v]", lst, total, err)
+	}
+}
+
+func TestMemoryStoreUniqueIndexes(t *testing.T) {
+
+	type user struct {
+		ID        primitive.ObjectID `bson:"_id"`
+		Username  string
+		CompanyID string
+	}
+
+	store := NewMemoryStore()
+	defer store.Close()
+
+	users := NewUserRepository[user](store)
+
+	first := user{primitive.NewObjectID(), "superuser", "COMPANY"}
+	other := user{primitive.NewObjectID(), "superuser", "OTHER"}
+	for _, u := range []user{first, other} {
+		u := u
+		err := users.Insert(context.Background(), &u, u.ID.Hex())
+		if err != nil {
+			t.Errorf("The same username in another company must be accepted: [%s]", err)
+			return
+		}
+	}
+
+	twin := user{primitive.NewObjectID(), "superuser", "COMPANY"}
+	err := users.Insert(context.Background(), &twin, twin.ID.Hex())
+	if err != ErrDuplicate {
+		t.Errorf("A second superuser in the company should fail with ErrDuplicate: [%v]", err)
+	}
+
+	//Saving the user unchanged is not a duplicate of itself
+	err = users.Save(context.Background(), &first, first.ID.Hex())
+	if err != nil {
+		t.Errorf("Saving the user again failed: [%s]", err)
+	}
+
+	other.CompanyID = "COMPANY"
+	err = users.Save(context.Background(), &other, other.ID.Hex())
+	if err != ErrDuplicate {
+		t.Errorf("Moving the user to a company with the same username should fail: [%v]", err)
+	}
+
+	//Indexes can be ensured more than once
+	err = store.EnsureIndexes(context.Background())
+	if err != nil {
+		t.Errorf("Ensuring the indexes again failed: [%s]", err)
+	}
+}
+
+func TestMemoryStoreErrors(t *testing.T) {
+
+	type user struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+
+	store := NewMemoryStore()
+	defer store.Close()
+
+	users := NewUserRepository[user](store)
+
+	var found user
+	err := users.FindByID(context.Background(), &found, primitive.NewObjectID().Hex())
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("A missing user should be ErrNotFound: [%v]", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = users.FindByID(ctx, &found, primitive.NewObjectID().Hex())
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("A cancelled request should be ErrUnavailable: [%v]", err)
+	}
+
+	//The typed repositories only take slices, the backend still checks
+	var notASlice user
+	err = store.Collection(CollUsers).List(context.Background(), &notASlice, Filter{"companyid": "COMPANY"})
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Listing into a struct should be ErrInvalidInput: [%v]", err)
+	}
+}
+
+func TestMemoryStoreSaveRevision(t *testing.T) {
+
+	type role struct {
+		ID        primitive.ObjectID `bson:"_id"`
+		CompanyID string
+		Revision  int64
+	}
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+	defer store.Close()
+
+	roles := NewRoleRepository[role](store)
+
+	r := role{primitive.NewObjectID(), "COMPANY", 0}
+	err := roles.Insert(ctx, &r, r.ID.Hex())
+	if err != nil {
+		t.Errorf("The role was not inserted: [%s]", err)
+		return
+	}
+
+	r.Revision = 1
+	err = roles.SaveRevision(ctx, &r, r.ID.Hex(), 0)
+	if err != nil {
+		t.Errorf("The role should have been saved: [%s]", err)
+	}
+
+	//A second writer that read revision 0 loses
+	r.Revision = 1
+	err = roles.SaveRevision(ctx, &r, r.ID.Hex(), 0)
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("A stale revision should be a conflict: [%v]", err)
+	}
+
+	err = roles.SaveRevision(ctx, &r, primitive.NewObjectID().Hex(), 0)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("A missing role should not be found: [%v]", err)
+	}
+}
@@ -0,0 +1,128 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package dbs
+
+import (
+	"context"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//mongoCollection - Adapts MongoDB to the Collection interface
+type mongoCollection struct {
+	db *MongoDB
+}
+
+//mongoStore - All the collections share the same client
+type mongoStore struct {
+	*CollectionStore
+	conn *mongoConn
+}
+
+//Close - Disconnect from the database
+func (store *mongoStore) Close() error {
+	return store.conn.close(context.Background())
+}
+
+//toCondition - Translate the filter to a mongo condition. The IDs are
+//stored as ObjectIds, so the hex representation must be converted.
+func toCondition(filter Filter) bson.M {
+	condition := bson.M{}
+	for k, v := range filter {
+		if id, ok := v.(string); ok && k == "_id" {
+			oid, err := primitive.ObjectIDFromHex(id)
+			if err == nil {
+				condition[k] = oid
+				continue
+			}
+		}
+		condition[k] = v
+	}
+	return condition
+}
+
+func (c *mongoCollection) Insert(ctx context.Context, obj interface{}, filter Filter) error {
+	return c.db.Insert(ctx, obj, toCondition(filter))
+}
+
+func (c *mongoCollection) Find(ctx context.Context, resObj interface{}, filter Filter) error {
+	return c.db.Find(ctx, resObj, toCondition(filter))
+}
+
+func (c *mongoCollection) Update(ctx context.Context, obj interface{}, filter Filter) error {
+	return c.db.Update(ctx, obj, toCondition(filter))
+}
+
+func (c *mongoCollection) List(ctx context.Context, objs interface{}, filter Filter) error {
+	return c.db.List(ctx, objs, toCondition(filter))
+}
+
+//Query - The prefixes become anchored regular expressions. The ID is
+//always the last sort key so the pages are stable.
+func (c *mongoCollection) Query(ctx context.Context, objs interface{}, filter Filter, opts ListOptions) (int64, error) {
+	condition := toCondition(filter)
+	for k, p := range opts.Prefix {
+		condition[k] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(p)}
+	}
+
+	sort := bson.D{}
+	for _, key := range opts.Sort {
+		order := 1
+		if key.Descending {
+			order = -1
+		}
+		sort = append(sort, bson.E{Key: key.Field, Value: order})
+	}
+	sort = append(sort, bson.E{Key: "_id", Value: 1})
+
+	return c.db.Query(ctx, objs, condition, opts.Skip, opts.Limit, sort)
+}
+
+func (c *mongoCollection) EnsureIndex(ctx context.Context, index Index) error {
+	keys := bson.D{}
+	for _, field := range index.Fields {
+		keys = append(keys, bson.E{Key: field, Value: 1})
+	}
+	return c.db.EnsureIndex(ctx, keys, index.Unique)
+}
+
+func (c *mongoCollection) Remove(ctx context.Context, filter Filter) error {
+	return c.db.Remove(ctx, toCondition(filter))
+}
+
+/*
+NewMongoStore - Create a Store backed by MongoDB. Every collection lives
+in the database dbName. The connection is only made on first use.
+*/
+func NewMongoStore(dbName string) Store {
+	store := new(mongoStore)
+	store.conn = new(mongoConn)
+	store.CollectionStore = NewCollectionStore(func(collName string) Collection {
+		return &mongoCollection{newMongoDB(store.conn, dbName, collName)}
+	})
+	return store
+}
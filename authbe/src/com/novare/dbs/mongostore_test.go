@@ -0,0 +1,49 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package dbs
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMongoConditionFromFilter(t *testing.T) {
+
+	ID := primitive.NewObjectID()
+	condition := toCondition(Filter{"_id": ID.Hex(), "companyid": ID.Hex()})
+
+	if condition["_id"] != ID {
+		t.Errorf("The _id should have been converted to an ObjectId: [%v]", condition["_id"])
+	}
+
+	if condition["companyid"] != ID.Hex() {
+		t.Errorf("Only the _id should be converted, companyid:[%v]", condition["companyid"])
+	}
+
+	condition = toCondition(Filter{"_id": "NOTANOBJECTID"})
+	if condition["_id"] != "NOTANOBJECTID" {
+		t.Errorf("An invalid hex should be left untouched: [%v]", condition["_id"])
+	}
+}
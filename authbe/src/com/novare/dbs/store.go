@@ -0,0 +1,515 @@
+/*
+MIT License
+
+Copyright (c) 2020 Clerley Silveira
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package dbs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	//CollUsers - The collection holding the users
+	CollUsers string = "Users"
+	//CollRoles - The collection holding the roles
+	CollRoles string = "Roles"
+	//CollPermissions - The collection holding the permissions
+	CollPermissions string = "Permissions"
+	//CollCompanies - The collection holding the companies
+	CollCompanies string = "Companies"
+	//CollJWTs - The collection holding the session tokens
+	CollJWTs string = "JWTs"
+	//CollConfig - The collection holding the site configuration
+	CollConfig string = "Config"
+	//CollSigningKeys - The collection holding the token signing keys
+	CollSigningKeys string = "SigningKeys"
+	//CollRefreshTokens - The collection holding the refresh tokens
+	CollRefreshTokens string = "RefreshTokens"
+	//CollRevokedTokens - The collection holding the revocation list
+	CollRevokedTokens string = "RevokedTokens"
+	//CollOAuthClients - The collection holding the registered OAuth clients
+	CollOAuthClients string = "OAuthClients"
+	//CollAuthorizationCodes - The collection holding the authorization codes
+	CollAuthorizationCodes string = "AuthorizationCodes"
+)
+
+//Index - An index on the stored field names. A unique index rejects two
+//documents with the same values for all of its fields.
+type Index struct {
+	Fields []string
+	Unique bool
+}
+
+/*
+Indexes - The indexes every collection needs. The unique ones keep
+concurrent requests from creating twins, the others back the lookups
+the repositories perform.
+*/
+var Indexes = map[string][]Index{
+	CollUsers: {
+		{Fields: []string{"username", "companyid"}, Unique: true},
+		{Fields: []string{"companyid"}},
+	},
+	CollRoles: {
+		{Fields: []string{"companyid"}},
+	},
+	CollPermissions: {
+		{Fields: []string{"companyid"}},
+	},
+	CollCompanies: {
+		{Fields: []string{"uniqueid"}, Unique: true},
+		{Fields: []string{"groupownerid"}},
+	},
+	CollJWTs: {
+		{Fields: []string{"signature"}, Unique: true},
+		{Fields: []string{"userid", "companyid"}},
+		{Fields: []string{"familyid"}},
+	},
+	CollConfig: {
+		{Fields: []string{"rowid"}, Unique: true},
+	},
+	CollSigningKeys: {
+		{Fields: []string{"keyid"}, Unique: true},
+		{Fields: []string{"companyid"}},
+	},
+	CollRefreshTokens: {
+		{Fields: []string{"tokenhash"}, Unique: true},
+		{Fields: []string{"familyid"}},
+	},
+	CollRevokedTokens: {
+		{Fields: []string{"tokenid"}, Unique: true},
+		{Fields: []string{"companyid"}},
+	},
+	CollOAuthClients: {
+		{Fields: []string{"companyid"}},
+	},
+	CollAuthorizationCodes: {
+		{Fields: []string{"codehash"}, Unique: true},
+	},
+}
+
+/*
+Filter - Equality conditions on the stored field names. All the conditions
+must match for a document to be selected. Field names follow the stored
+(lower case) names, e.g. "companyid" or "username".
+*/
+type Filter map[string]interface{}
+
+//SortKey - Sort on a stored field name, ascending unless Descending is set
+type SortKey struct {
+	Field      string
+	Descending bool
+}
+
+/*
+ListOptions - The page, order and prefix conditions of a Query. Skip and
+Limit select the page (a Limit of 0 returns everything after Skip). Prefix
+maps stored field names to the prefix their string value must start with.
+*/
+type ListOptions struct {
+	Skip   int64
+	Limit  int64
+	Sort   []SortKey
+	Prefix map[string]string
+}
+
+/*
+Collection - The minimal set of operations a backend must provide for
+each collection. The typed repositories below are built on top of it, so
+a new backend only has to know how to store and match documents.
+*/
+type Collection interface {
+	Insert(ctx context.Context, obj interface{}, filter Filter) error
+	Find(ctx context.Context, resObj interface{}, filter Filter) error
+	Update(ctx context.Context, obj interface{}, filter Filter) error
+	List(ctx context.Context, objs interface{}, filter Filter) error
+	Query(ctx context.Context, objs interface{}, filter Filter, opts ListOptions) (int64, error)
+	Remove(ctx context.Context, filter Filter) error
+	EnsureIndex(ctx context.Context, index Index) error
+}
+
+/*
+Store - Gives access to the collection of every entity the auth system
+needs. The repositories below bind a collection to the type of its
+entity, so the model package never hands a repository the wrong type and
+the backend can be picked at startup.
+*/
+type Store interface {
+	Collection(name string) Collection
+	EnsureIndexes(ctx context.Context) error
+	Close() error
+}
+
+//Collections - Every collection a Store holds
+var Collections = []string{
+	CollUsers,
+	CollRoles,
+	CollPermissions,
+	CollCompanies,
+	CollJWTs,
+	CollConfig,
+	CollSigningKeys,
+	CollRefreshTokens,
+	CollRevokedTokens,
+	CollOAuthClients,
+	CollAuthorizationCodes,
+}
+
+/*
+OpenStore - Look for the environment variable AUTH_DB_TYPE to decide which
+backend to use. "bolt" selects the embedded single file database stored at
+AUTH_DB_FILE (defaults to <dbName>.db in the working directory), "mongo"
+or no value selects MongoDB. Any other value is an ErrInvalidInput, so a
+misspelt AUTH_DB_TYPE stops the startup instead of connecting to MongoDB.
+*/
+func OpenStore(dbName string) (Store, error) {
+	dbType := os.Getenv("AUTH_DB_TYPE")
+	switch dbType {
+	case "bolt":
+		path := os.Getenv("AUTH_DB_FILE")
+		if utf8.RuneCountInString(path) == 0 {
+			path = dbName + ".db"
+		}
+		log.Printf("Using the embedded database file: [%s]", path)
+		return NewBoltStore(path)
+	case "", "mongo":
+		return NewMongoStore(dbName), nil
+	}
+
+	log.Printf("The AUTH_DB_TYPE [%s] is not supported, use bolt or mongo", dbType)
+	return nil, &Error{Kind: ErrInvalidInput, Err: fmt.Errorf("the AUTH_DB_TYPE [%s] is not supported", dbType)}
+}
+
+/*
+CollectionStore - A Store built on top of one Collection per entity.
+Backends create their collections and hand them to NewCollectionStore.
+*/
+type CollectionStore struct {
+	colls map[string]Collection
+}
+
+/*
+NewCollectionStore - Build a Store out of a function that opens a
+collection by name.
+*/
+func NewCollectionStore(open func(collName string) Collection) *CollectionStore {
+	store := new(CollectionStore)
+	store.colls = make(map[string]Collection)
+	for _, name := range Collections {
+		store.colls[name] = open(name)
+	}
+	return store
+}
+
+//Collection - The collection of the name, one of the Collections
+func (store *CollectionStore) Collection(name string) Collection {
+	return store.colls[name]
+}
+
+//EnsureIndexes - Create the Indexes of every collection. It is safe to
+//call it on every startup, existing indexes are left alone.
+func (store *CollectionStore) EnsureIndexes(ctx context.Context) error {
+	for name, coll := range store.colls {
+		for _, index := range Indexes[name] {
+			err := coll.EnsureIndex(ctx, index)
+			if err != nil {
+				log.Printf("The index %v could not be created on [%s]: [%s]", index.Fields, name, err)
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//Close - Nothing to release by default
+func (store *CollectionStore) Close() error {
+	return nil
+}
+
+/*
+Repository - The operations every entity keyed by its ID shares. T is the
+entity stored in the collection, e.g. model.User; the documents are
+always read and written as a *T or a *[]T.
+*/
+type Repository[T any] struct {
+	coll Collection
+}
+
+//Insert ...
+func (r Repository[T]) Insert(ctx context.Context, doc *T, ID string) error {
+	return r.coll.Insert(ctx, doc, Filter{"_id": ID})
+}
+
+//Save ...
+func (r Repository[T]) Save(ctx context.Context, doc *T, ID string) error {
+	return r.coll.Update(ctx, doc, Filter{"_id": ID})
+}
+
+/*
+SaveRevision - Save the document only when the stored one still has the
+given revision. ErrConflict means it was changed in the meantime,
+ErrNotFound that it is gone.
+*/
+func (r Repository[T]) SaveRevision(ctx context.Context, doc *T, ID string, revision int64) error {
+	err := r.coll.Update(ctx, doc, Filter{"_id": ID, "revision": revision})
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	var stored bson.M
+	ferr := r.coll.Find(ctx, &stored, Filter{"_id": ID})
+	if ferr == nil {
+		return ErrConflict
+	}
+	return ferr
+}
+
+//FindByID ...
+func (r Repository[T]) FindByID(ctx context.Context, doc *T, ID string) error {
+	return r.coll.Find(ctx, doc, Filter{"_id": ID})
+}
+
+//RemoveByID ...
+func (r Repository[T]) RemoveByID(ctx context.Context, ID string) error {
+	return r.coll.Remove(ctx, Filter{"_id": ID})
+}
+
+//ListByCompanyID ...
+func (r Repository[T]) ListByCompanyID(ctx context.Context, docs *[]T, companyID string) error {
+	return r.coll.List(ctx, docs, Filter{"companyid": companyID})
+}
+
+//QueryByCompanyID - One page of the company's documents and how many
+//documents match in total
+func (r Repository[T]) QueryByCompanyID(ctx context.Context, docs *[]T, companyID string, filter Filter, opts ListOptions) (int64, error) {
+	condition := Filter{}
+	for k, v := range filter {
+		condition[k] = v
+	}
+	condition["companyid"] = companyID
+	return r.coll.Query(ctx, docs, condition, opts)
+}
+
+//UserRepository - Persistence for the users
+type UserRepository[T any] struct {
+	Repository[T]
+}
+
+//NewUserRepository - The users of the store, stored as T
+func NewUserRepository[T any](store Store) UserRepository[T] {
+	return UserRepository[T]{Repository[T]{store.Collection(CollUsers)}}
+}
+
+//FindByUsernameCompanyID ...
+func (r UserRepository[T]) FindByUsernameCompanyID(ctx context.Context, user *T, username string, companyID string) error {
+	return r.coll.Find(ctx, user, Filter{"username": username, "companyid": companyID})
+}
+
+//RoleRepository - Persistence for the roles
+type RoleRepository[T any] struct {
+	Repository[T]
+}
+
+//NewRoleRepository - The roles of the store, stored as T
+func NewRoleRepository[T any](store Store) RoleRepository[T] {
+	return RoleRepository[T]{Repository[T]{store.Collection(CollRoles)}}
+}
+
+//PermissionRepository - Persistence for the permissions
+type PermissionRepository[T any] struct {
+	Repository[T]
+}
+
+//NewPermissionRepository - The permissions of the store, stored as T
+func NewPermissionRepository[T any](store Store) PermissionRepository[T] {
+	return PermissionRepository[T]{Repository[T]{store.Collection(CollPermissions)}}
+}
+
+//CompanyRepository - Persistence for the companies
+type CompanyRepository[T any] struct {
+	Repository[T]
+}
+
+//NewCompanyRepository - The companies of the store, stored as T
+func NewCompanyRepository[T any](store Store) CompanyRepository[T] {
+	return CompanyRepository[T]{Repository[T]{store.Collection(CollCompanies)}}
+}
+
+//FindByUniqueID ...
+func (r CompanyRepository[T]) FindByUniqueID(ctx context.Context, company *T, uniqueID string) error {
+	return r.coll.Find(ctx, company, Filter{"uniqueid": uniqueID})
+}
+
+//List ...
+func (r CompanyRepository[T]) List(ctx context.Context, companies *[]T) error {
+	return r.coll.List(ctx, companies, Filter{})
+}
+
+//ListByGroupOwnerID ...
+func (r CompanyRepository[T]) ListByGroupOwnerID(ctx context.Context, companies *[]T, groupOwnerID string) error {
+	return r.coll.List(ctx, companies, Filter{"groupownerid": groupOwnerID})
+}
+
+//JWTRepository - Persistence for the session tokens
+type JWTRepository[T any] struct {
+	Repository[T]
+}
+
+//NewJWTRepository - The session tokens of the store, stored as T
+func NewJWTRepository[T any](store Store) JWTRepository[T] {
+	return JWTRepository[T]{Repository[T]{store.Collection(CollJWTs)}}
+}
+
+//FindBySignature ...
+func (r JWTRepository[T]) FindBySignature(ctx context.Context, jwt *T, signature string) error {
+	return r.coll.Find(ctx, jwt, Filter{"signature": signature})
+}
+
+//FindByUserIDCompanyID ...
+func (r JWTRepository[T]) FindByUserIDCompanyID(ctx context.Context, jwt *T, userID string, companyID string) error {
+	return r.coll.Find(ctx, jwt, Filter{"userid": userID, "companyid": companyID})
+}
+
+//ListByUserIDCompanyID ...
+func (r JWTRepository[T]) ListByUserIDCompanyID(ctx context.Context, jwts *[]T, userID string, companyID string) error {
+	return r.coll.List(ctx, jwts, Filter{"userid": userID, "companyid": companyID})
+}
+
+//ListByFamilyID ...
+func (r JWTRepository[T]) ListByFamilyID(ctx context.Context, jwts *[]T, familyID string) error {
+	return r.coll.List(ctx, jwts, Filter{"familyid": familyID})
+}
+
+//ConfigRepository - Persistence for the site configuration, keyed by its
+//row ID instead of an ID
+type ConfigRepository[T any] struct {
+	coll Collection
+}
+
+//NewConfigRepository - The site configuration of the store, stored as T
+func NewConfigRepository[T any](store Store) ConfigRepository[T] {
+	return ConfigRepository[T]{store.Collection(CollConfig)}
+}
+
+//Save - Update the configuration or insert it if it is the first one
+func (r ConfigRepository[T]) Save(ctx context.Context, cfg *T, rowID int) error {
+	err := r.coll.Update(ctx, cfg, Filter{"rowid": rowID})
+	if errors.Is(err, ErrNotFound) {
+		err = r.coll.Insert(ctx, cfg, Filter{"rowid": rowID})
+	}
+	return err
+}
+
+//FindByRowID ...
+func (r ConfigRepository[T]) FindByRowID(ctx context.Context, cfg *T, rowID int) error {
+	return r.coll.Find(ctx, cfg, Filter{"rowid": rowID})
+}
+
+//SigningKeyRepository - Persistence for the token signing keys
+type SigningKeyRepository[T any] struct {
+	Repository[T]
+}
+
+//NewSigningKeyRepository - The signing keys of the store, stored as T
+func NewSigningKeyRepository[T any](store Store) SigningKeyRepository[T] {
+	return SigningKeyRepository[T]{Repository[T]{store.Collection(CollSigningKeys)}}
+}
+
+//FindByKeyID ...
+func (r SigningKeyRepository[T]) FindByKeyID(ctx context.Context, key *T, keyID string) error {
+	return r.coll.Find(ctx, key, Filter{"keyid": keyID})
+}
+
+//List ...
+func (r SigningKeyRepository[T]) List(ctx context.Context, keys *[]T) error {
+	return r.coll.List(ctx, keys, Filter{})
+}
+
+//RefreshTokenRepository - Persistence for the refresh tokens
+type RefreshTokenRepository[T any] struct {
+	Repository[T]
+}
+
+//NewRefreshTokenRepository - The refresh tokens of the store, stored as T
+func NewRefreshTokenRepository[T any](store Store) RefreshTokenRepository[T] {
+	return RefreshTokenRepository[T]{Repository[T]{store.Collection(CollRefreshTokens)}}
+}
+
+//FindByTokenHash ...
+func (r RefreshTokenRepository[T]) FindByTokenHash(ctx context.Context, token *T, tokenHash string) error {
+	return r.coll.Find(ctx, token, Filter{"tokenhash": tokenHash})
+}
+
+//ListByFamilyID ...
+func (r RefreshTokenRepository[T]) ListByFamilyID(ctx context.Context, tokens *[]T, familyID string) error {
+	return r.coll.List(ctx, tokens, Filter{"familyid": familyID})
+}
+
+//RevokedTokenRepository - Persistence for the revocation list
+type RevokedTokenRepository[T any] struct {
+	Repository[T]
+}
+
+//NewRevokedTokenRepository - The revocation list of the store, stored as T
+func NewRevokedTokenRepository[T any](store Store) RevokedTokenRepository[T] {
+	return RevokedTokenRepository[T]{Repository[T]{store.Collection(CollRevokedTokens)}}
+}
+
+//FindByTokenID ...
+func (r RevokedTokenRepository[T]) FindByTokenID(ctx context.Context, revoked *T, tokenID string) error {
+	return r.coll.Find(ctx, revoked, Filter{"tokenid": tokenID})
+}
+
+//OAuthClientRepository - Persistence for the registered OAuth clients
+type OAuthClientRepository[T any] struct {
+	Repository[T]
+}
+
+//NewOAuthClientRepository - The OAuth clients of the store, stored as T
+func NewOAuthClientRepository[T any](store Store) OAuthClientRepository[T] {
+	return OAuthClientRepository[T]{Repository[T]{store.Collection(CollOAuthClients)}}
+}
+
+//AuthorizationCodeRepository - Persistence for the authorization codes
+type AuthorizationCodeRepository[T any] struct {
+	Repository[T]
+}
+
+//NewAuthorizationCodeRepository - The authorization codes of the store,
+//stored as T
+func NewAuthorizationCodeRepository[T any](store Store) AuthorizationCodeRepository[T] {
+	return AuthorizationCodeRepository[T]{Repository[T]{store.Collection(CollAuthorizationCodes)}}
+}
+
+//FindByCodeHash ...
+func (r AuthorizationCodeRepository[T]) FindByCodeHash(ctx context.Context, code *T, codeHash string) error {
+	return r.coll.Find(ctx, code, Filter{"codehash": codeHash})
+}